@@ -0,0 +1,94 @@
+package reviewapps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/google/go-github/v60/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/rs/zerolog"
+)
+
+// configured returns whether or not access is limited at all.
+func (c AccessConfig) configured() bool {
+	return len(c.Installations) > 0 || len(c.Allow) > 0 || len(c.Deny) > 0
+}
+
+// denied returns why the given repository of the given installation isn't permitted to get review
+// apps, or an empty string if it is.
+func (c AccessConfig) denied(installationID int64, repo string) string {
+	if len(c.Installations) > 0 && !contains(c.Installations, installationID) {
+		return fmt.Sprintf("installation %d is not permitted to get review apps", installationID)
+	}
+	if matchesAccessPattern(c.Deny, repo) {
+		return fmt.Sprintf("repository %s is denied review apps", repo)
+	}
+	if len(c.Allow) > 0 && !matchesAccessPattern(c.Allow, repo) {
+		return fmt.Sprintf("repository %s is not allowed to get review apps", repo)
+	}
+	return ""
+}
+
+// matchesAccessPattern returns whether or not the given repository, i.e. "owner/name", or its owner
+// matches any of the given patterns. GitHub names aren't case-sensitive.
+func matchesAccessPattern(patterns []string, repo string) bool {
+	repo = strings.ToLower(repo)
+	owner, _, _ := strings.Cut(repo, "/")
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		name := repo
+		if !strings.Contains(pattern, "/") {
+			name = owner
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// accessEvent holds the fields of webhook events access is limited by.
+type accessEvent struct {
+	Repo         *github.Repository   `json:"repository"`
+	Installation *github.Installation `json:"installation"`
+}
+
+// deniedEvent returns why the given event isn't permitted to be handled, or an empty string if it
+// is. Events without a repository, like installation events, are always permitted.
+func (c AccessConfig) deniedEvent(payload []byte) string {
+	var event accessEvent
+	if err := json.Unmarshal(payload, &event); err != nil || event.Repo.GetFullName() == "" {
+		// Invalid events are left to the handlers to report.
+		return ""
+	}
+	return c.denied(event.Installation.GetID(), event.Repo.GetFullName())
+}
+
+// accessHandler skips events of repositories that aren't permitted to get review apps before they
+// reach its handler.
+type accessHandler struct {
+	githubapp.EventHandler
+	access AccessConfig
+}
+
+func (h *accessHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	if reason := h.access.deniedEvent(payload); reason != "" {
+		zerolog.Ctx(ctx).Info().Str("github_event_type", eventType).Str("tracking_id", deliveryID).Str("reason", reason).Msg("skipping event of repository that isn't permitted")
+		return nil
+	}
+	return h.EventHandler.Handle(ctx, eventType, deliveryID, payload)
+}
+
+// triage implements triager.
+func (h *accessHandler) triage(eventType string, payload []byte) (string, error) {
+	if reason := h.access.deniedEvent(payload); reason != "" {
+		return reason, nil
+	}
+	if t, ok := h.EventHandler.(triager); ok {
+		return t.triage(eventType, payload)
+	}
+	return "", nil
+}
@@ -0,0 +1,225 @@
+package reviewapps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-github/v60/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/rs/zerolog"
+)
+
+// adoptedApp is an app that is (or would be, in a dry run) adopted as review app.
+type adoptedApp struct {
+	AppID       string `json:"app_id"`
+	AppName     string `json:"app_name"`
+	PullRequest int    `json:"pull_request"`
+	// ReviewApp is the name of the review app the app is renamed to.
+	ReviewApp string `json:"review_app"`
+}
+
+// notAdoptedApp is an app matching the pattern that isn't adopted.
+type notAdoptedApp struct {
+	AppID   string `json:"app_id"`
+	AppName string `json:"app_name"`
+	Reason  string `json:"reason"`
+}
+
+// adoptResult is the body of responses of the "/admin/adopt" endpoint.
+type adoptResult struct {
+	DryRun  bool            `json:"dry_run"`
+	Adopted []adoptedApp    `json:"adopted"`
+	Skipped []notAdoptedApp `json:"skipped"`
+}
+
+// adopter adopts apps created manually or by scripts before review apps were used as the review
+// apps of their pull requests.
+type adopter struct {
+	prs        *PRHandler
+	adminToken string
+}
+
+// ServeHTTP adopts the apps whose name matches the "pattern" query parameter as review apps of the
+// repository given as the "repo" query parameter, i.e. "owner/name". The pattern's first group must
+// match the number of the app's pull request. With "dry_run=true", nothing is changed. Requests must
+// be POSTs authorized with the admin token as bearer token.
+func (a *adopter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, a.adminToken) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	owner, name, ok := strings.Cut(r.URL.Query().Get("repo"), "/")
+	if !ok {
+		http.Error(w, "the repo query parameter must be of the form owner/name", http.StatusBadRequest)
+		return
+	}
+	pattern, err := regexp.Compile(r.URL.Query().Get("pattern"))
+	if err != nil || pattern.NumSubexp() < 1 {
+		http.Error(w, "the pattern query parameter must be a regular expression whose first group matches the pull request number", http.StatusBadRequest)
+		return
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	ctx := r.Context()
+	logger := zerolog.Ctx(ctx).With().Str("component", "adopt").Str(githubapp.LogKeyRepositoryOwner, owner).Str(githubapp.LogKeyRepositoryName, name).Logger()
+	ctx = logger.WithContext(ctx)
+
+	result, err := a.adopt(ctx, owner, name, pattern, dryRun)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to adopt apps")
+		status := http.StatusInternalServerError
+		if isGitHubNotFound(err) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	logger.Info().Int("adopted", len(result.Adopted)).Int("skipped", len(result.Skipped)).Bool("dry_run", dryRun).Msg("adopted apps")
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// adopt adopts all apps matching the given pattern as review apps of the given repository.
+func (a *adopter) adopt(ctx context.Context, owner, name string, pattern *regexp.Regexp, dryRun bool) (*adoptResult, error) {
+	installationID, client, err := a.prs.repoInstallation(ctx, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	repo, _, err := client.Repositories.Get(ctx, owner, name)
+	if err != nil {
+		return nil, githubError(err, "failed to get repository")
+	}
+	cfg, err := a.prs.config.ForRepo(repo.GetFullName())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get review app configuration: %w", err)
+	}
+	apps, err := listApps(ctx, a.prs.do)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &adoptResult{DryRun: dryRun, Adopted: []adoptedApp{}, Skipped: []notAdoptedApp{}}
+	for _, app := range apps {
+		m := pattern.FindStringSubmatch(app.GetSpec().GetName())
+		if m == nil {
+			continue
+		}
+		skip := func(format string, args ...any) {
+			result.Skipped = append(result.Skipped, notAdoptedApp{AppID: app.GetID(), AppName: app.GetSpec().GetName(), Reason: fmt.Sprintf(format, args...)})
+		}
+		if repo, number, ok := pullRequestOf(app.GetSpec()); ok {
+			skip("the app is already the review app of %s#%d", repo, number)
+			continue
+		}
+		if isOwned(app.GetSpec()) {
+			skip("the app is already managed by review apps")
+			continue
+		}
+		number, err := strconv.Atoi(m[1])
+		if err != nil {
+			skip("%q is not a pull request number", m[1])
+			continue
+		}
+
+		pr, _, err := client.PullRequests.Get(ctx, owner, name, number)
+		if err != nil {
+			if isGitHubNotFound(err) {
+				skip("pull request #%d doesn't exist", number)
+				continue
+			}
+			return nil, githubError(err, "failed to get pull request")
+		}
+		if pr.GetState() != "open" {
+			skip("pull request #%d is not open", number)
+			continue
+		}
+		if repo.GetID() != pr.GetHead().GetRepo().GetID() {
+			skip("pull request #%d is from a forked repository", number)
+			continue
+		}
+
+		ra, err := a.prs.newReviewApp(ctx, installationID, repo, pr, cfg)
+		if err != nil {
+			return nil, err
+		}
+		ra.logger = zerolog.Ctx(ctx).With().Int(githubapp.LogKeyPRNum, number).Str("app_name", ra.appName).Logger()
+		deployment, _, err := a.prs.liveDeployment(ctx, ra)
+		if err != nil {
+			return nil, err
+		}
+		if deployment != nil {
+			skip("pull request #%d already has a review app", number)
+			continue
+		}
+
+		result.Adopted = append(result.Adopted, adoptedApp{AppID: app.GetID(), AppName: app.GetSpec().GetName(), PullRequest: number, ReviewApp: ra.appName})
+		if dryRun {
+			continue
+		}
+		if err := a.prs.adoptApp(ctx, ra, app); err != nil {
+			return nil, fmt.Errorf("failed to adopt app %s: %w", app.GetID(), err)
+		}
+	}
+	return result, nil
+}
+
+// adoptApp renames the given app to the review app's name, marks it as owned by the review app and
+// records it as the review app's deployment. The deployment is waited for in the background, so adopting many apps
+// doesn't block.
+func (h *PRHandler) adoptApp(ctx context.Context, ra *reviewApp, app *godo.App) error {
+	ra.logger.Info().Str("app_id", app.GetID()).Str("adopted_app_name", app.GetSpec().GetName()).Msg("adopting app")
+	spec := app.GetSpec()
+	spec.Name = ra.appName
+	markOwned(spec)
+	markPullRequest(spec, ra.repo.GetFullName(), ra.number)
+	if ra.spec != "" {
+		markAppSpec(spec, ra.spec)
+	}
+
+	updated, _, err := h.do.Apps.Update(ctx, app.GetID(), &godo.AppUpdateRequest{Spec: spec})
+	if err != nil {
+		return doSpecError(err, "failed to update app")
+	}
+
+	var (
+		ghDeployment *github.Deployment
+		ds           []*godo.Deployment
+	)
+	err = parallel(func() error {
+		var err error
+		ghDeployment, err = h.createGitHubDeployment(ctx, ra, deploymentPayload{AppID: app.GetID(), SpecHash: specHash(updated.GetSpec())})
+		return err
+	}, func() error {
+		var err error
+		ds, _, err = h.do.Apps.ListDeployments(ctx, app.GetID(), &godo.ListOptions{})
+		if err != nil {
+			return doError(err, "failed to list deployments")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(ds) == 0 {
+		return errorf(ErrorKindDOAPI, "app %s has no deployments", app.GetID())
+	}
+
+	ctx = ra.logger.WithContext(context.WithoutCancel(ctx))
+	go func() {
+		if err := h.waitAndPropagate(ctx, ra, app.GetID(), ds[0].GetID(), ghDeployment.GetID()); err != nil {
+			ra.logger.Error().Err(err).Msg("failed to propagate deployment status of adopted app")
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,189 @@
+package reviewapps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v60/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/rs/zerolog"
+)
+
+// previewStatusNotDeployed is the status of pull requests that have no review app (yet).
+const previewStatusNotDeployed = "not_deployed"
+
+// previewResponse is the body of responses of the preview endpoint.
+type previewResponse struct {
+	Repo        string `json:"repo"`
+	PullRequest int    `json:"pull_request"`
+	AppName     string `json:"app_name"`
+	AppID       string `json:"app_id,omitempty"`
+	// Status is the state of the latest GitHub deployment status of the review app, like "success",
+	// or "not_deployed".
+	Status    string     `json:"status"`
+	URL       string     `json:"url,omitempty"`
+	SHA       string     `json:"sha,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	// Protection is why the review app is protected from being torn down when idle, if it is.
+	Protection *protection `json:"protection,omitempty"`
+}
+
+// previewAPI serves the preview URL and status of review apps as JSON for editor extensions and
+// other tools. Requests must be authorized with the API token as bearer token.
+type previewAPI struct {
+	prs         *PRHandler
+	token       string
+	corsOrigins []string
+}
+
+func newPreviewAPI(prs *PRHandler, config HTTPConfig) *previewAPI {
+	return &previewAPI{prs: prs, token: config.APIToken, corsOrigins: config.CORSOrigins}
+}
+
+// ServeHTTP serves "GET /api/v1/repos/{owner}/{repo}/pulls/{number}/preview", including CORS
+// preflight requests.
+func (a *previewAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.cors(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !authorized(r, a.token) {
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	owner, name := r.PathValue("owner"), r.PathValue("repo")
+	number, err := strconv.Atoi(r.PathValue("number"))
+	if err != nil || number <= 0 {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid pull request number %q", r.PathValue("number")))
+		return
+	}
+
+	ctx := r.Context()
+	logger := zerolog.Ctx(ctx).With().Str("component", "api").Str(githubapp.LogKeyRepositoryOwner, owner).Str(githubapp.LogKeyRepositoryName, name).Int(githubapp.LogKeyPRNum, number).Logger()
+	ctx = logger.WithContext(ctx)
+
+	preview, err := a.preview(ctx, owner, name, number)
+	if err != nil {
+		if isGitHubNotFound(err) {
+			writeAPIError(w, http.StatusNotFound, "repository or pull request not found")
+			return
+		}
+		logger.Error().Err(err).Msg("failed to get preview of pull request")
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(preview)
+}
+
+// cors sets the CORS headers of the given request's response if its origin is allowed. All
+// origins are allowed if none are configured.
+func (a *previewAPI) cors(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	switch {
+	case len(a.corsOrigins) == 0:
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	case slices.Contains(a.corsOrigins, origin):
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	default:
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+	w.Header().Set("Access-Control-Max-Age", "3600")
+}
+
+// preview returns the preview of the given pull request.
+func (a *previewAPI) preview(ctx context.Context, owner, name string, number int) (*previewResponse, error) {
+	installationID, client, err := a.prs.repoInstallation(ctx, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	repo, _, err := client.Repositories.Get(ctx, owner, name)
+	if err != nil {
+		return nil, githubError(err, "failed to get repository")
+	}
+	pr, _, err := client.PullRequests.Get(ctx, owner, name, number)
+	if err != nil {
+		return nil, githubError(err, "failed to get pull request")
+	}
+	cfg, err := a.prs.config.ForRepo(repo.GetFullName())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get review app configuration: %w", err)
+	}
+	ra, err := a.prs.newReviewApp(ctx, installationID, repo, pr, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &previewResponse{
+		Repo:        repo.GetFullName(),
+		PullRequest: number,
+		AppName:     ra.appName,
+		Status:      previewStatusNotDeployed,
+	}
+	deployment, payload, err := a.prs.latestDeployment(ctx, ra)
+	if err != nil || deployment == nil {
+		return preview, err
+	}
+	preview.AppID = payload.AppID
+	preview.SHA = deployment.GetSHA()
+	preview.Protection = a.prs.protectionOf(ra)
+
+	status, err := latestDeploymentStatus(ctx, client, owner, name, deployment.GetID())
+	if err != nil {
+		return nil, err
+	}
+	if status == nil {
+		// Deployments without statuses haven't been picked up yet.
+		preview.Status = "pending"
+		preview.UpdatedAt = deployment.UpdatedAt.GetTime()
+		return preview, nil
+	}
+	preview.Status = status.GetState()
+	preview.URL = status.GetEnvironmentURL()
+	preview.UpdatedAt = status.UpdatedAt.GetTime()
+	return preview, nil
+}
+
+// writeAPIError responds with the given status and error message as JSON.
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{Error: msg})
+}
+
+// repoInstallation returns the ID of the installation of the given repository and a client of it.
+func (h *PRHandler) repoInstallation(ctx context.Context, owner, name string) (int64, *github.Client, error) {
+	appClient, err := h.cc.NewAppClient()
+	if err != nil {
+		return 0, nil, githubError(err, "failed to create app client")
+	}
+	installation, _, err := appClient.Apps.FindRepositoryInstallation(ctx, owner, name)
+	if err != nil {
+		return 0, nil, githubError(err, "failed to find installation of repository")
+	}
+	client, err := h.cc.NewInstallationClient(installation.GetID())
+	if err != nil {
+		return 0, nil, githubError(err, "failed to create installation client")
+	}
+	return installation.GetID(), client, nil
+}
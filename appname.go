@@ -0,0 +1,250 @@
+package reviewapps
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/digitalocean/godo"
+)
+
+const (
+	// appNameMaxLength is the maximum length of App Platform app names.
+	appNameMaxLength = 32
+	// appNameHashLength is the length of the hash in shortened app names.
+	appNameHashLength = 8
+
+	// pullRequestMarkerKey is the app-wide environment variable recording the pull request of a
+	// review app as "<owner>/<repo>#<number>", as shortened app names can't be mapped back to it.
+	pullRequestMarkerKey = "REVIEW_APP_PULL_REQUEST"
+)
+
+// The built-in naming strategies.
+const (
+	namingSlug       = "slug"
+	namingHash       = "hash"
+	namingSequential = "sequential"
+)
+
+// namingPrefixPattern matches valid prefixes of app names. They're short enough to leave room for
+// hashes and pull request numbers.
+var namingPrefixPattern = regexp.MustCompile(`^[a-z][a-z0-9-]{0,10}[a-z0-9]$|^[a-z]$`)
+
+// Namer names the apps of review apps, which name their GitHub environments as well. Names must be
+// valid app names and unique across all repositories. Names of apps of pull requests must end in
+// "-<number>", so they can be mapped back to their pull request.
+type Namer interface {
+	// PullRequestAppName returns the name of the app of the given pull request.
+	PullRequestAppName(owner, repo string, number int) string
+	// BranchAppName returns the name of the app of the given branch.
+	BranchAppName(owner, repo, branch string) string
+}
+
+// legacyNamer is implemented by Namers whose names changed, so apps named by earlier versions are
+// still recognized as the apps of their pull requests and cleaned up once those are closed.
+type legacyNamer interface {
+	// legacyPullRequestAppName returns the name earlier versions gave the app of the given pull
+	// request.
+	legacyPullRequestAppName(owner, repo string, number int) string
+}
+
+// slugNamer names apps after their repository, like "<owner>-<repo>-<number>".
+type slugNamer struct{}
+
+func (slugNamer) PullRequestAppName(owner, repo string, number int) string {
+	return prAppName(owner, repo, number)
+}
+
+func (slugNamer) legacyPullRequestAppName(owner, repo string, number int) string {
+	// Names of owners with dashes weren't hashed, so they could collide.
+	return appName(owner+"-"+repo, owner+"/"+repo, "-"+strconv.Itoa(number))
+}
+
+func (slugNamer) BranchAppName(owner, repo, branch string) string {
+	return branchAppName(owner, repo, branch)
+}
+
+// hashNamer names apps after a hash of their repository, like "<prefix>-<hash>-<number>", so names
+// have a fixed length and don't reveal the repository.
+type hashNamer struct {
+	prefix string
+}
+
+func (n hashNamer) PullRequestAppName(owner, repo string, number int) string {
+	return n.prefix + "-" + nameHash(owner+"/"+repo) + "-" + strconv.Itoa(number)
+}
+
+func (n hashNamer) BranchAppName(owner, repo, branch string) string {
+	return n.prefix + "-" + nameHash(owner+"/"+repo+"@"+branch)
+}
+
+// sequentialNamer names apps of pull requests after their number only, like "<prefix>-<number>".
+// The prefix must be unique per repository.
+type sequentialNamer struct {
+	prefix string
+}
+
+func (n sequentialNamer) PullRequestAppName(owner, repo string, number int) string {
+	return n.prefix + "-" + strconv.Itoa(number)
+}
+
+func (n sequentialNamer) BranchAppName(owner, repo, branch string) string {
+	// The infix keeps branches named like numbers from colliding with pull requests.
+	return appName(n.prefix+"-branch-"+branch, owner+"/"+repo+"@"+branch, "")
+}
+
+// nameHash returns the hash of the given key used in app names.
+func nameHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])[:appNameHashLength]
+}
+
+// prAppName returns the name of the app of the given pull request. It's "<owner>-<repo>-<number>"
+// if that is a valid app name and the owner has no dash. Otherwise, the name of the repository is
+// truncated if needed and followed by a hash of it, so the names of different repositories don't
+// collide once truncated, or if they're only split differently, like "a-b/c" and "a/b-c". The hash
+// only covers the repository, so all app names of a repository share a prefix and end in the number.
+func prAppName(owner, repo string, number int) string {
+	if strings.Contains(owner, "-") {
+		return hashedAppName(owner+"-"+repo, owner+"/"+repo, "-"+strconv.Itoa(number))
+	}
+	return appName(owner+"-"+repo, owner+"/"+repo, "-"+strconv.Itoa(number))
+}
+
+// branchAppName returns the name of the app of the given branch, like prAppName. The hash of
+// shortened names covers the branch as well, as the slug of the branch might be truncated, too.
+func branchAppName(owner, repo, branch string) string {
+	slug := slugify(branch)
+	if strings.Contains(owner, "-") {
+		return hashedAppName(owner+"-"+repo+"-"+slug, owner+"/"+repo+"@"+branch, "")
+	}
+	return appName(owner+"-"+repo+"-"+slug, owner+"/"+repo+"@"+branch, "")
+}
+
+// appName returns the given readable name followed by the given suffix if it's a valid app name.
+// Otherwise, the readable name is truncated to make room for a hash of the given key.
+func appName(readable, key, suffix string) string {
+	if name := slugify(readable) + suffix; appNamePattern.MatchString(name) {
+		return name
+	}
+	return hashedAppName(readable, key, suffix)
+}
+
+// hashedAppName returns the given readable name, truncated to make room, followed by a hash of the
+// given key and the given suffix.
+func hashedAppName(readable, key, suffix string) string {
+	readable = slugify(readable)
+	hash := nameHash(key)
+	// App names have to start with a letter.
+	readable = strings.TrimLeft(readable, "0123456789-")
+	if n := appNameMaxLength - len(hash) - len(suffix) - 1; len(readable) > n {
+		readable = strings.TrimRight(readable[:n], "-")
+	}
+	if readable == "" {
+		return "ra-" + hash + suffix
+	}
+	return readable + "-" + hash + suffix
+}
+
+// slugify lowercases the given name and replaces everything but letters and digits with dashes.
+func slugify(name string) string {
+	return strings.Trim(branchSlugPattern.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// parsePRAppName returns the number of the pull request of the given repository the given name is
+// the app name of by the given namer, if any.
+func parsePRAppName(namer Namer, owner, repo, name string) (int, bool) {
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return 0, false
+	}
+	number, err := strconv.Atoi(name[i+1:])
+	if err != nil || number <= 0 || !isPRAppName(namer, owner, repo, number, name) {
+		return 0, false
+	}
+	return number, true
+}
+
+// isPRAppName returns whether or not the given name is the app name of the given pull request by the
+// given namer, now or in earlier versions.
+func isPRAppName(namer Namer, owner, repo string, number int, name string) bool {
+	if namer.PullRequestAppName(owner, repo, number) == name {
+		return true
+	}
+	l, ok := namer.(legacyNamer)
+	return ok && l.legacyPullRequestAppName(owner, repo, number) == name
+}
+
+// legacy returns the review app as named by earlier versions, or nil if its name didn't change.
+func (ra *reviewApp) legacy() *reviewApp {
+	l, ok := ra.namer.(legacyNamer)
+	if !ok || ra.number == 0 {
+		return nil
+	}
+	name := l.legacyPullRequestAppName(ra.owner, ra.name, ra.number)
+	if name == ra.appName {
+		return nil
+	}
+	legacy := *ra
+	legacy.appName = name
+	return &legacy
+}
+
+// markPullRequest records the given pull request in the given spec.
+func markPullRequest(spec *godo.AppSpec, repo string, number int) {
+	setAppEnv(spec, &godo.AppVariableDefinition{
+		Key:   pullRequestMarkerKey,
+		Value: fmt.Sprintf("%s#%d", repo, number),
+		Scope: godo.AppVariableScope_RunTime,
+		Type:  godo.AppVariableType_General,
+	})
+}
+
+// pullRequestOf returns the repository and number of the pull request recorded in the given spec,
+// if any.
+func pullRequestOf(spec *godo.AppSpec) (string, int, bool) {
+	for _, env := range spec.GetEnvs() {
+		if env.Key != pullRequestMarkerKey {
+			continue
+		}
+		repo, n, ok := strings.Cut(env.Value, "#")
+		number, err := strconv.Atoi(n)
+		if !ok || err != nil {
+			return "", 0, false
+		}
+		return repo, number, true
+	}
+	return "", 0, false
+}
+
+// namer returns the Namer of the naming strategy of the given configuration, preferring the ones
+// registered with Builder.WithNamer.
+func (h *PRHandler) namer(cfg ReviewAppConfig) Namer {
+	strategy := cfg.Naming.GetStrategy()
+	if n, ok := h.namers[strategy]; ok {
+		return n
+	}
+	switch strategy {
+	case namingSlug:
+		return slugNamer{}
+	case namingHash:
+		return hashNamer{prefix: cfg.Naming.GetPrefix()}
+	case namingSequential:
+		return sequentialNamer{prefix: cfg.Naming.Prefix}
+	default:
+		// Unknown strategies are refused when the server is built.
+		return slugNamer{}
+	}
+}
+
+// repoNamer returns the Namer of the given repository.
+func (h *PRHandler) repoNamer(repo string) Namer {
+	cfg, err := h.config.ForRepo(repo)
+	if err != nil {
+		cfg = h.config.ReviewApps
+	}
+	return h.namer(cfg)
+}
@@ -0,0 +1,257 @@
+package reviewapps
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAppName(t *testing.T) {
+	tests := []struct {
+		name     string
+		readable string
+		key      string
+		suffix   string
+		want     string
+	}{{
+		name:     "valid",
+		readable: "acme-web",
+		key:      "acme/web",
+		suffix:   "-42",
+		want:     "acme-web-42",
+	}, {
+		name:     "slugged",
+		readable: "Acme_Web.io",
+		key:      "Acme/Web.io",
+		suffix:   "-42",
+		want:     "acme-web-io-42",
+	}, {
+		name:     "too long",
+		readable: "acme-a-very-long-repository-name",
+		key:      "acme/a-very-long-repository-name",
+		suffix:   "-42",
+		want:     "acme-a-very-long-rep-" + nameHash("acme/a-very-long-repository-name") + "-42",
+	}, {
+		name:     "truncated at dash",
+		readable: "acme-a-very-long-ab-cdefghijklmn",
+		key:      "acme/a-very-long-ab-cdefghijklmn",
+		suffix:   "-42",
+		want:     "acme-a-very-long-ab-" + nameHash("acme/a-very-long-ab-cdefghijklmn") + "-42",
+	}, {
+		name:     "leading digit",
+		readable: "42acme-web",
+		key:      "42acme/web",
+		suffix:   "-1",
+		want:     "acme-web-" + nameHash("42acme/web") + "-1",
+	}, {
+		name:     "only digits",
+		readable: "42",
+		key:      "42/42",
+		suffix:   "-1",
+		want:     "ra-" + nameHash("42/42") + "-1",
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := appName(tt.readable, tt.key, tt.suffix)
+			if got != tt.want {
+				t.Errorf("appName() = %q, want %q", got, tt.want)
+			}
+			if !appNamePattern.MatchString(got) {
+				t.Errorf("appName() = %q, which is not a valid app name", got)
+			}
+		})
+	}
+}
+
+func TestPRAppName(t *testing.T) {
+	tests := []struct {
+		name   string
+		owner  string
+		repo   string
+		number int
+		want   string
+	}{{
+		name:   "short",
+		owner:  "acme",
+		repo:   "web",
+		number: 42,
+		want:   "acme-web-42",
+	}, {
+		name:   "dash in repository",
+		owner:  "acme",
+		repo:   "my-web",
+		number: 42,
+		want:   "acme-my-web-42",
+	}, {
+		name:   "dash in owner",
+		owner:  "acme-corp",
+		repo:   "web",
+		number: 42,
+		want:   "acme-corp-web-" + nameHash("acme-corp/web") + "-42",
+	}, {
+		name:   "too long",
+		owner:  "acme",
+		repo:   "a-very-long-repository-name",
+		number: 12345,
+		want:   "acme-a-very-long-" + nameHash("acme/a-very-long-repository-name") + "-12345",
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := prAppName(tt.owner, tt.repo, tt.number)
+			if got != tt.want {
+				t.Errorf("prAppName() = %q, want %q", got, tt.want)
+			}
+			if len(got) > appNameMaxLength || !appNamePattern.MatchString(got) {
+				t.Errorf("prAppName() = %q, which is not a valid app name", got)
+			}
+		})
+	}
+}
+
+func TestPRAppNameSplits(t *testing.T) {
+	// Repositories whose names only differ in where owner and repository are split must not share
+	// app names.
+	if a, b := prAppName("a-b", "c", 1), prAppName("a", "b-c", 1); a == b {
+		t.Errorf("prAppName() = %q for both a-b/c and a/b-c", a)
+	}
+	if a, b := branchAppName("a-b", "c", "main"), branchAppName("a", "b-c", "main"); a == b {
+		t.Errorf("branchAppName() = %q for both a-b/c and a/b-c", a)
+	}
+}
+
+func TestBranchAppName(t *testing.T) {
+	tests := []struct {
+		name   string
+		owner  string
+		repo   string
+		branch string
+		want   string
+	}{{
+		name:   "short",
+		owner:  "acme",
+		repo:   "web",
+		branch: "Release/1.2",
+		want:   "acme-web-release-1-2",
+	}, {
+		name:   "too long",
+		owner:  "acme",
+		repo:   "web",
+		branch: "feature/a-very-long-branch-name",
+		want:   "acme-web-feature-a-very-" + nameHash("acme/web@feature/a-very-long-branch-name"),
+	}, {
+		name:   "dash in owner",
+		owner:  "acme-corp",
+		repo:   "web",
+		branch: "main",
+		want:   "acme-corp-web-main-" + nameHash("acme-corp/web@main"),
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := branchAppName(tt.owner, tt.repo, tt.branch)
+			if got != tt.want {
+				t.Errorf("branchAppName() = %q, want %q", got, tt.want)
+			}
+			if len(got) > appNameMaxLength || !appNamePattern.MatchString(got) {
+				t.Errorf("branchAppName() = %q, which is not a valid app name", got)
+			}
+		})
+	}
+}
+
+func TestHashNamer(t *testing.T) {
+	n := hashNamer{prefix: "ra"}
+	if got, want := n.PullRequestAppName("acme", "web", 42), "ra-"+nameHash("acme/web")+"-42"; got != want {
+		t.Errorf("PullRequestAppName() = %q, want %q", got, want)
+	}
+	if got, want := n.BranchAppName("acme", "web", "main"), "ra-"+nameHash("acme/web@main"); got != want {
+		t.Errorf("BranchAppName() = %q, want %q", got, want)
+	}
+	// Names don't reveal the repository and have a fixed length.
+	for _, repo := range []string{"web", "a-very-long-repository-name-that-goes-on"} {
+		got := n.PullRequestAppName("acme", repo, 1)
+		if strings.Contains(got, "acme") || len(got) != len("ra-")+appNameHashLength+len("-1") {
+			t.Errorf("PullRequestAppName() = %q for %s", got, repo)
+		}
+	}
+}
+
+func TestSequentialNamer(t *testing.T) {
+	n := sequentialNamer{prefix: "web"}
+	if got, want := n.PullRequestAppName("acme", "web", 42), "web-42"; got != want {
+		t.Errorf("PullRequestAppName() = %q, want %q", got, want)
+	}
+	if got, want := n.BranchAppName("acme", "web", "main"), "web-branch-main"; got != want {
+		t.Errorf("BranchAppName() = %q, want %q", got, want)
+	}
+	// Branches named like numbers don't collide with pull requests.
+	if got := n.BranchAppName("acme", "web", "42"); got == n.PullRequestAppName("acme", "web", 42) {
+		t.Errorf("BranchAppName() = %q, which is the name of pull request #42", got)
+	}
+	if got := n.BranchAppName("acme", "web", "feature/a-very-long-branch-name"); len(got) > appNameMaxLength || !appNamePattern.MatchString(got) {
+		t.Errorf("BranchAppName() = %q, which is not a valid app name", got)
+	}
+}
+
+func TestParsePRAppName(t *testing.T) {
+	namers := map[string]Namer{
+		"slug":       slugNamer{},
+		"hash":       hashNamer{prefix: "ra"},
+		"sequential": sequentialNamer{prefix: "web"},
+		"spec":       specNamer{Namer: slugNamer{}, spec: "api"},
+	}
+	repos := []struct{ owner, repo string }{
+		{"acme", "web"},
+		{"acme-corp", "web"},
+		{"acme", "a-very-long-repository-name"},
+	}
+	for name, namer := range namers {
+		for _, r := range repos {
+			for _, number := range []int{1, 42, 12345} {
+				app := namer.PullRequestAppName(r.owner, r.repo, number)
+				if got, ok := parsePRAppName(namer, r.owner, r.repo, app); !ok || got != number {
+					t.Errorf("%s: parsePRAppName(%q) = %d, %t, want %d, true", name, app, got, ok, number)
+				}
+			}
+		}
+	}
+
+	tests := []struct {
+		name  string
+		namer Namer
+		app   string
+		want  int
+		ok    bool
+	}{{
+		name:  "legacy name",
+		namer: slugNamer{},
+		app:   "acme-corp-web-42",
+		want:  42,
+		ok:    true,
+	}, {
+		name:  "other repository",
+		namer: slugNamer{},
+		app:   "acme-api-42",
+	}, {
+		name:  "branch",
+		namer: slugNamer{},
+		app:   "acme-corp-web-main",
+	}, {
+		name:  "no number",
+		namer: sequentialNamer{prefix: "web"},
+		app:   "web",
+	}, {
+		name:  "zero",
+		namer: sequentialNamer{prefix: "web"},
+		app:   "web-0",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parsePRAppName(tt.namer, "acme-corp", "web", tt.app)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("parsePRAppName(%q) = %d, %t, want %d, %t", tt.app, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package reviewapps
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/rs/zerolog"
+)
+
+// DatabaseBackups dumps the dev databases of review apps to Spaces before they're deleted, so
+// accidentally useful preview data can be recovered for a while.
+type DatabaseBackups struct {
+	do     *godo.Client
+	spaces *SpacesClient
+	config BackupConfig
+	// sealer encrypts the backups, if configured.
+	sealer *sealer
+}
+
+// NewDatabaseBackups returns a new DatabaseBackups.
+func NewDatabaseBackups(do *godo.Client, config BackupConfig) *DatabaseBackups {
+	return &DatabaseBackups{
+		do:     do,
+		spaces: NewSpacesClient(config.Spaces),
+		config: config,
+	}
+}
+
+// Backup dumps all dev databases of the given app to Spaces. Only PostgreSQL databases are
+// supported.
+func (b *DatabaseBackups) Backup(ctx context.Context, appID, appName string) error {
+	logger := zerolog.Ctx(ctx)
+
+	app, _, err := b.do.Apps.Get(ctx, appID)
+	if err != nil {
+		return doError(err, "failed to get app")
+	}
+
+	var databases []*godo.AppDatabaseSpec
+	for _, db := range app.GetSpec().GetDatabases() {
+		if db.GetProduction() {
+			// Production databases outlive the app anyway.
+			continue
+		}
+		if db.GetEngine() != godo.AppDatabaseSpecEngine_PG {
+			logger.Warn().Msgf("skipping backup of database %q with unsupported engine %s", db.GetName(), db.GetEngine())
+			continue
+		}
+		databases = append(databases, db)
+	}
+	if len(databases) == 0 {
+		return nil
+	}
+
+	details, _, err := b.do.Apps.GetAppDatabaseConnectionDetails(ctx, appID)
+	if err != nil {
+		return doError(err, "failed to get database connection details")
+	}
+	urls := make(map[string]string, len(details))
+	for _, d := range details {
+		urls[d.ComponentName] = d.DatabaseURL
+	}
+
+	now := time.Now().UTC().Format("20060102T150405Z")
+	for _, db := range databases {
+		dbURL, ok := urls[db.GetName()]
+		if !ok {
+			return fmt.Errorf("no connection details for database %q", db.GetName())
+		}
+		env, err := pgEnv(dbURL)
+		if err != nil {
+			return fmt.Errorf("invalid connection details for database %q: %w", db.GetName(), err)
+		}
+
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, b.config.GetPGDump(), "--format=custom", "--no-owner")
+		cmd.Env = env
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to dump database %q: %w: %s", db.GetName(), err, strings.TrimSpace(stderr.String()))
+		}
+
+		key := path.Join(b.config.GetPrefix(), appName, fmt.Sprintf("%s-%s.dump", db.GetName(), now))
+		dump := stdout.Bytes()
+		if b.sealer != nil {
+			// Dumps contain whatever credentials the app stored in its database.
+			dump, err = b.sealer.seal(dump)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt dump of database %q: %w", db.GetName(), err)
+			}
+			key += ".enc"
+		}
+		if err := b.spaces.Put(ctx, key, dump); err != nil {
+			return fmt.Errorf("failed to upload dump of database %q: %w", db.GetName(), err)
+		}
+		logger.Info().Str("key", key).Msgf("backed up database %q", db.GetName())
+	}
+	return nil
+}
+
+// pgEnv returns the environment passing the given database URL to PostgreSQL's tools. Unlike its
+// arguments, a process's environment isn't visible to other users, so neither is the password.
+func pgEnv(dbURL string) ([]string, error) {
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		// The error contains the URL and its password.
+		return nil, errors.New("failed to parse database URL")
+	}
+	if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+		return nil, fmt.Errorf("unsupported database URL scheme %q", u.Scheme)
+	}
+	env := []string{
+		"PATH=" + os.Getenv("PATH"),
+		"PGHOST=" + u.Hostname(),
+		"PGDATABASE=" + strings.TrimPrefix(u.Path, "/"),
+		"PGUSER=" + u.User.Username(),
+	}
+	if port := u.Port(); port != "" {
+		env = append(env, "PGPORT="+port)
+	}
+	if password, ok := u.User.Password(); ok {
+		env = append(env, "PGPASSWORD="+password)
+	}
+	if mode := u.Query().Get("sslmode"); mode != "" {
+		env = append(env, "PGSSLMODE="+mode)
+	}
+	return env, nil
+}
+
+// Run periodically deletes backups older than the configured retention until the context is done.
+func (b *DatabaseBackups) Run(ctx context.Context) {
+	logger := zerolog.Ctx(ctx).With().Str("component", "database_backups").Logger()
+
+	t := time.NewTicker(time.Hour)
+	defer t.Stop()
+	for {
+		if err := b.prune(ctx); err != nil {
+			logger.Error().Err(err).Msg("failed to prune database backups")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// prune deletes all backups older than the configured retention.
+func (b *DatabaseBackups) prune(ctx context.Context) error {
+	objects, err := b.spaces.List(ctx, b.config.GetPrefix()+"/")
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if time.Since(obj.LastModified) < b.config.GetRetention() {
+			continue
+		}
+		if err := b.spaces.Delete(ctx, obj.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
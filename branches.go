@@ -0,0 +1,165 @@
+package reviewapps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v60/github"
+	"github.com/palantir/go-githubapp/githubapp"
+)
+
+const (
+	// actionPush is the policy action of pushes to branches with an app.
+	actionPush = "push"
+
+	branchRefPrefix = "refs/heads/"
+)
+
+// branchSlugPattern matches runs of characters that aren't allowed in app names.
+var branchSlugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// BranchHandler maintains always-on apps of long-lived branches, like "develop" or "release/*", in
+// response to push events. Their app specs are transformed just like the ones of review apps.
+type BranchHandler struct {
+	prs *PRHandler
+}
+
+// NewBranchHandler returns a new BranchHandler managing apps with the given PRHandler.
+func NewBranchHandler(prs *PRHandler) *BranchHandler {
+	return &BranchHandler{prs: prs}
+}
+
+func (h *BranchHandler) Handles() []string {
+	return []string{"push"}
+}
+
+// triagePush returns the configuration and branch of the given event, or why the event is skipped,
+// without calling any APIs.
+func (h *BranchHandler) triagePush(event *github.PushEvent) (ReviewAppConfig, string, string, error) {
+	branch, ok := strings.CutPrefix(event.GetRef(), branchRefPrefix)
+	if !ok {
+		return ReviewAppConfig{}, "", "pushes to tags are not handled", nil
+	}
+	if err := validatePushEvent(event); err != nil {
+		return ReviewAppConfig{}, "", "", err
+	}
+
+	cfg, err := h.prs.config.ForRepo(event.GetRepo().GetFullName())
+	if err != nil {
+		return ReviewAppConfig{}, "", "", fmt.Errorf("failed to get review app configuration: %w", err)
+	}
+	if !matchesBranch(cfg.Branches, branch) {
+		return ReviewAppConfig{}, "", fmt.Sprintf("branch %q has no app", branch), nil
+	}
+	return branchConfig(cfg), branch, "", nil
+}
+
+// triage implements triager.
+func (h *BranchHandler) triage(eventType string, payload []byte) (string, error) {
+	var event github.PushEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return "", errorf(ErrorKindInvalidEvent, "failed to parse push event: %w", err)
+	}
+	_, _, skip, err := h.triagePush(&event)
+	return skip, err
+}
+
+func (h *BranchHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	var event github.PushEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return errorf(ErrorKindInvalidEvent, "failed to parse push event: %w", err)
+	}
+
+	repo := pushRepository(event.GetRepo())
+	installationID := githubapp.GetInstallationIDFromEvent(&event)
+	ctx, logger := githubapp.PrepareRepoContext(ctx, installationID, repo)
+	logger = logger.With().Str("ref", event.GetRef()).Str("tracking_id", deliveryID).Logger()
+
+	cfg, branch, skip, err := h.triagePush(&event)
+	if err != nil {
+		return skipNotActionable(ctx, eventType, err)
+	}
+	if skip != "" {
+		// Most branches don't have an app, so this isn't worth more than a debug log.
+		logger.Debug().Str("reason", skip).Msg("skipping push event")
+		return nil
+	}
+
+	// The branch's app is managed just like the review app of a pull request that's never merged.
+	pr := &github.PullRequest{
+		Head: &github.PullRequestBranch{Ref: ptr(branch), SHA: ptr(event.GetAfter()), Repo: repo},
+		Base: &github.PullRequestBranch{Ref: ptr(branch), Repo: repo},
+	}
+	if !event.GetDeleted() {
+		decision, err := h.prs.decide(ctx, actionPush, repo, pr)
+		if err != nil {
+			return err
+		}
+		if !decision.Allow {
+			logger.Info().Str("reason", decision.Reason).Msg("skipping push denied by policy")
+			return nil
+		}
+	}
+
+	ra, err := h.prs.newReviewApp(ctx, installationID, repo, pr, cfg)
+	if err != nil {
+		return err
+	}
+	ra.appName = ra.namer.BranchAppName(repo.GetOwner().GetLogin(), repo.GetName(), branch)
+	ra.logger = logger.With().Str("app_name", ra.appName).Logger()
+	ctx = ra.logger.WithContext(ctx)
+
+	if event.GetDeleted() {
+		return h.prs.teardown(ctx, ra, "the branch was deleted")
+	}
+
+	ghDeployment, _, err := h.prs.latestDeployment(ctx, ra)
+	if err != nil {
+		return err
+	}
+	if ghDeployment == nil {
+		return h.prs.create(ctx, ra, 0)
+	}
+	return h.prs.redeploy(ctx, ra, 0, "the branch was pushed")
+}
+
+// matchesBranch returns whether or not the given branch matches any of the given patterns.
+func matchesBranch(patterns []string, branch string) bool {
+	for _, p := range patterns {
+		// The patterns are validated when reading the configuration.
+		if re, err := globRegexp(p, true); err == nil && re.MatchString(branch) {
+			return true
+		}
+	}
+	return false
+}
+
+// branchConfig returns the given configuration without the features that only make sense for pull
+// requests.
+func branchConfig(cfg ReviewAppConfig) ReviewAppConfig {
+	cfg.Task = false
+	cfg.TestMerge.Enabled = false
+	cfg.Companions = false
+	cfg.Directives.Enabled = false
+	cfg.Refresh.Enabled = false
+	return cfg
+}
+
+// pushRepository converts the repository of a push event into a regular repository.
+func pushRepository(repo *github.PushEventRepository) *github.Repository {
+	if repo == nil {
+		return nil
+	}
+	r := &github.Repository{
+		ID:       repo.ID,
+		Name:     repo.Name,
+		FullName: repo.FullName,
+	}
+	if repo.GetOwner().GetLogin() != "" {
+		r.Owner = &github.User{Login: repo.Owner.Login}
+	}
+	return r
+}
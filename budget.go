@@ -0,0 +1,313 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// budgetPolicyQueue creates new review apps once other review apps are torn down.
+	budgetPolicyQueue = "queue"
+	// budgetPolicyEvict tears down the review app that was idle for the longest to make room.
+	budgetPolicyEvict = "evict"
+)
+
+// budget tracks the review apps being created and the ones waiting for room under the caps on
+// concurrently running review apps.
+type budget struct {
+	// reserving serializes reservations, so concurrent creations can't exceed the caps together.
+	// It's held while idle review apps are evicted, unlike the mutex guarding the fields.
+	reserving sync.Mutex
+
+	mu sync.Mutex
+	// creating are the repositories of the apps being created by name, which might not be listed
+	// yet.
+	creating map[string]string
+	// queued are the review apps waiting for room, oldest first.
+	queued []*reviewApp
+}
+
+func newBudget() *budget {
+	return &budget{creating: make(map[string]string)}
+}
+
+// enqueue queues the given review app, keeping the position of review apps that are queued already.
+func (b *budget) enqueue(ra *reviewApp) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, queued := range b.queued {
+		if queued.storeKey() == ra.storeKey() {
+			b.queued[i] = ra
+			return
+		}
+	}
+	b.queued = append(b.queued, ra)
+}
+
+// unqueue removes the given review app from the queue, if it's queued.
+func (b *budget) unqueue(ra *reviewApp) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.queued = slices.DeleteFunc(b.queued, func(queued *reviewApp) bool { return queued.storeKey() == ra.storeKey() })
+}
+
+// next returns the review app that is queued for the longest, or nil if none is.
+func (b *budget) next() *reviewApp {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.queued) == 0 {
+		return nil
+	}
+	return b.queued[0]
+}
+
+// budgeted returns whether or not the review app is subject to a cap on concurrently running
+// review apps.
+func (h *PRHandler) budgeted(ra *reviewApp) bool {
+	return h.config.MaxApps > 0 || ra.cfg.Budget.MaxApps > 0
+}
+
+// reserveBudget makes sure that creating the review app's app doesn't exceed the caps on
+// concurrently running review apps, evicting idle review apps if the policy says so. It returns a
+// function to call once the app was created. Review apps that don't fit are queued and fail with
+// ErrBudgetExceeded.
+func (h *PRHandler) reserveBudget(ctx context.Context, ra *reviewApp) (func(), error) {
+	if !h.budgeted(ra) {
+		return func() {}, nil
+	}
+	h.budget.reserving.Lock()
+	defer h.budget.reserving.Unlock()
+
+	apps, err := listApps(ctx, h.do)
+	if err != nil {
+		return nil, err
+	}
+	running := make(map[string]*godo.App)
+	for _, app := range apps {
+		if _, _, ok := pullRequestOf(app.GetSpec()); ok && isOwned(app.GetSpec()) {
+			running[app.GetSpec().GetName()] = app
+		}
+	}
+	repo := ra.repo.GetFullName()
+	count := func(repo string) int {
+		h.budget.mu.Lock()
+		defer h.budget.mu.Unlock()
+		n := 0
+		for name, app := range running {
+			if r, _, _ := pullRequestOf(app.GetSpec()); name != ra.appName && (repo == "" || r == repo) {
+				n++
+			}
+		}
+		for name, r := range h.budget.creating {
+			if _, ok := running[name]; !ok && name != ra.appName && (repo == "" || r == repo) {
+				n++
+			}
+		}
+		return n
+	}
+
+	for {
+		// The cap of the repository is checked first, as evicting within it makes room globally, too.
+		var scope, reason string
+		if max := ra.cfg.Budget.MaxApps; max > 0 && count(repo) >= max {
+			scope, reason = repo, fmt.Sprintf("%s has %d review apps running, its maximum", repo, max)
+		} else if max := h.config.MaxApps; max > 0 && count("") >= max {
+			reason = fmt.Sprintf("%d review apps are running, the maximum of all repositories", max)
+		}
+		if reason == "" {
+			break
+		}
+
+		if ra.cfg.Budget.GetPolicy() == budgetPolicyEvict {
+			evicted, err := h.evict(ctx, ra, running, scope)
+			if err != nil {
+				return nil, err
+			}
+			if evicted != "" {
+				delete(running, evicted)
+				continue
+			}
+			reason += " and none of them can be evicted"
+		}
+
+		ra.logger.Info().Str("reason", reason).Msg("queueing review app as the budget is exhausted")
+		h.budget.enqueue(ra)
+		body := fmt.Sprintf("The review app is queued, as %s. It's created once other review apps are torn down.", reason)
+		if err := h.comment(ctx, ra, commentKindBudget, body); err != nil {
+			ra.logger.Warn().Err(err).Msg("failed to comment on queued review app")
+		}
+		return nil, errorf(ErrorKindBudgetExceeded, "the review app is queued, as %s", reason)
+	}
+
+	h.budget.mu.Lock()
+	h.budget.creating[ra.appName] = repo
+	h.budget.mu.Unlock()
+	return func() {
+		h.budget.mu.Lock()
+		defer h.budget.mu.Unlock()
+		delete(h.budget.creating, ra.appName)
+	}, nil
+}
+
+// evict tears down the running review app, of the given repository or of any if it's empty, that
+// was idle for the longest to make room for the given review app, and returns its name. Protected
+// review apps, and the other review apps of its pull request, are never evicted. It returns an empty
+// name if no review app can be evicted. The teardown takes the turn of the evicted review app.
+func (h *PRHandler) evict(ctx context.Context, ra *reviewApp, running map[string]*godo.App, repo string) (string, error) {
+	type candidate struct {
+		app       *godo.App
+		idleSince time.Time
+	}
+	var candidates []candidate
+	for name, app := range running {
+		r, number, _ := pullRequestOf(app.GetSpec())
+		if name == ra.appName || (repo != "" && r != repo) {
+			continue
+		}
+		if r == ra.repo.GetFullName() && number == ra.number {
+			// The other app specs of the pull request wait for the turn held to make room.
+			continue
+		}
+		idleSince := app.GetLastDeploymentCreatedAt()
+		if idleSince.IsZero() {
+			idleSince = app.GetCreatedAt()
+		}
+		candidates = append(candidates, candidate{app: app, idleSince: idleSince})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].idleSince.Before(candidates[j].idleSince) })
+
+	for _, c := range candidates {
+		victim, err := h.reviewAppOf(ctx, c.app)
+		if err != nil {
+			ra.logger.Warn().Err(err).Str("app_name", c.app.GetSpec().GetName()).Msg("not evicting review app whose pull request can't be looked up")
+			continue
+		}
+		// Apps of closed pull requests are orphaned and make room first.
+		if victim.pr.GetState() == "open" {
+			if h.protectionOf(victim) != nil {
+				continue
+			}
+			if h.gateway != nil && h.gateway.lastVisited(victim.storeKey(), c.idleSince).After(time.Now().Add(-time.Hour)) {
+				// Review apps visited within the last hour aren't idle.
+				continue
+			}
+		}
+
+		reason := fmt.Sprintf("it was idle for the longest while %s#%d needed room", ra.repo.GetFullName(), ra.number)
+		// Evicting supersedes the victim's deployment in flight like other teardowns do.
+		victimCtx, done, _, err := h.turns.take(ctx, victim.repo.GetFullName(), victim.number, turnSuperseding)
+		if err != nil {
+			return "", err
+		}
+		err = h.teardown(victimCtx, victim, reason)
+		done()
+		if err != nil {
+			return "", fmt.Errorf("failed to evict review app %s: %w", victim.appName, err)
+		}
+		body := fmt.Sprintf("The review app was torn down to make room for the one of %s#%d, as it was idle for the longest. Comment `%s` to recreate it.", ra.repo.GetFullName(), ra.number, commandDeploy)
+		if err := h.comment(ctx, victim, commentKindBudget, body); err != nil {
+			victim.logger.Warn().Err(err).Msg("failed to comment on evicted review app")
+		}
+		return victim.appName, nil
+	}
+	return "", nil
+}
+
+// reviewAppOf returns the review app of the given app of a review app, looking up its pull request.
+func (h *PRHandler) reviewAppOf(ctx context.Context, app *godo.App) (*reviewApp, error) {
+	spec := app.GetSpec()
+	repo, number, _ := pullRequestOf(spec)
+	owner, name, _ := strings.Cut(repo, "/")
+	installationID, client, err := h.repoInstallation(ctx, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	r, _, err := client.Repositories.Get(ctx, owner, name)
+	if err != nil {
+		return nil, githubError(err, "failed to get repository")
+	}
+	pr, _, err := client.PullRequests.Get(ctx, owner, name, number)
+	if err != nil {
+		return nil, githubError(err, fmt.Sprintf("failed to get pull request #%d", number))
+	}
+	cfg, err := h.config.ForRepo(repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get review app configuration: %w", err)
+	}
+	ra, err := h.newReviewApp(ctx, installationID, r, pr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if s := appSpecOf(spec); s != "" {
+		ra = ra.forSpec(s)
+	}
+	if ra.appName != spec.GetName() {
+		return nil, fmt.Errorf("app %s isn't named like the review app %s of its pull request", spec.GetName(), ra.appName)
+	}
+	ra.logger = ra.logger.With().Str("app_name", ra.appName).Logger()
+	return ra, nil
+}
+
+// budgetFreed creates the queued review apps in the background once review apps were torn down.
+func (h *PRHandler) budgetFreed() {
+	if h.budget.next() == nil {
+		return
+	}
+	go h.dequeue()
+}
+
+// dequeue creates the queued review apps, oldest first, until they don't fit anymore.
+func (h *PRHandler) dequeue() {
+	for {
+		ra := h.budget.next()
+		if ra == nil {
+			return
+		}
+		err := h.createQueued(ra)
+		if errors.Is(err, ErrBudgetExceeded) {
+			return
+		}
+		if err != nil {
+			ra.logger.Error().Err(err).Msg("failed to create queued review app")
+		}
+		h.budget.unqueue(ra)
+	}
+}
+
+// createQueued creates the given queued review app, unless its pull request was closed in the
+// meantime.
+func (h *PRHandler) createQueued(ra *reviewApp) error {
+	ctx, cancel := context.WithTimeout(ra.logger.WithContext(context.Background()), time.Hour)
+	defer cancel()
+	ctx, done, ok, err := h.turns.take(ctx, ra.repo.GetFullName(), ra.number, turnQueued)
+	if err != nil || !ok {
+		return err
+	}
+	defer done()
+
+	pr, _, err := ra.client.PullRequests.Get(ctx, ra.owner, ra.name, ra.number)
+	if err != nil {
+		return githubError(err, "failed to get pull request")
+	}
+	if pr.GetState() != "open" {
+		zerolog.Ctx(ctx).Info().Msg("not creating queued review app of closed pull request")
+		return nil
+	}
+	// Pushes while the review app was queued were queued, too, so the latest head is created.
+	ra.pr = pr
+	ra.logger.Info().Msg("creating queued review app")
+	if err := h.create(ctx, ra, 0); err != nil {
+		return err
+	}
+	h.skips.clear(ra.repo.GetFullName(), ra.number)
+	return h.comment(ctx, ra, commentKindBudget, "The review app was created from the queue, as other review apps were torn down.")
+}
@@ -0,0 +1,262 @@
+package reviewapps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// bulkDestroy tears down the matching review apps.
+	bulkDestroy = "destroy"
+	// bulkRedeploy redeploys the matching review apps.
+	bulkRedeploy = "redeploy"
+)
+
+// bulkFilter selects the review apps a bulk operation acts upon. Review apps must match all of the
+// set fields.
+type bulkFilter struct {
+	// repo is the repository of the review apps, i.e. "owner/name".
+	repo string
+	// olderThan is how long ago the apps of the review apps must have been created.
+	olderThan time.Duration
+	// failed only selects review apps whose latest deployment failed.
+	failed bool
+}
+
+// empty returns whether or not the filter selects all review apps.
+func (f bulkFilter) empty() bool {
+	return f.repo == "" && f.olderThan == 0 && !f.failed
+}
+
+// bulkApp is a review app selected, or skipped, by a bulk operation.
+type bulkApp struct {
+	Repo        string `json:"repo"`
+	PullRequest int    `json:"pull_request"`
+	AppName     string `json:"app_name"`
+	AppID       string `json:"app_id"`
+	// Reason is why the review app was skipped or failed, if it was.
+	Reason string `json:"reason,omitempty"`
+}
+
+// bulkResult is the body of responses of the "/admin/apps/{action}" endpoints.
+type bulkResult struct {
+	Action string `json:"action"`
+	DryRun bool   `json:"dry_run"`
+	// Apps are the review apps that were, or would be in a dry run, acted upon.
+	Apps []bulkApp `json:"apps"`
+	// Skipped are the matching review apps that weren't acted upon, with the reason why.
+	Skipped []bulkApp `json:"skipped"`
+	// Failed are the review apps that failed to be acted upon, with the error.
+	Failed []bulkApp `json:"failed"`
+}
+
+// bulkOperations tears down or redeploys all review apps matching a filter at once, so operators
+// can manage fleets of review apps from scripts and runbooks.
+type bulkOperations struct {
+	prs        *PRHandler
+	adminToken string
+}
+
+// ServeHTTP runs the bulk operation named by the "action" path value, i.e. "destroy" or
+// "redeploy", on the review apps of open pull requests matching the "repo", "older_than" and
+// "failed" query parameters. With "dry_run=true", nothing is changed. Teardowns are done before
+// responding, redeploys are started in the background, as they take as long as deployments do.
+// Requests must be POSTs authorized with the admin token as bearer token.
+func (b *bulkOperations) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, b.adminToken) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	action := r.PathValue("action")
+	if action != bulkDestroy && action != bulkRedeploy {
+		http.Error(w, fmt.Sprintf("unknown action %q", action), http.StatusNotFound)
+		return
+	}
+	filter, err := parseBulkFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if action == bulkDestroy && filter.empty() {
+		// Tearing down everything is too easy to do by accident.
+		http.Error(w, "destroying review apps requires the repo, older_than or failed query parameter", http.StatusBadRequest)
+		return
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	ctx := r.Context()
+	logger := zerolog.Ctx(ctx).With().Str("component", "bulk").Str("action", action).Logger()
+	ctx = logger.WithContext(ctx)
+
+	result, err := b.run(ctx, action, filter, dryRun)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to run bulk operation")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.Info().Int("apps", len(result.Apps)).Int("skipped", len(result.Skipped)).Int("failed", len(result.Failed)).Bool("dry_run", dryRun).Msg("ran bulk operation")
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// parseBulkFilter parses the filter of the given request.
+func parseBulkFilter(r *http.Request) (bulkFilter, error) {
+	var f bulkFilter
+	query := r.URL.Query()
+	if f.repo = query.Get("repo"); f.repo != "" {
+		if owner, name, ok := strings.Cut(f.repo, "/"); !ok || owner == "" || name == "" {
+			return f, errors.New("the repo query parameter must be of the form owner/name")
+		}
+	}
+	if s := query.Get("older_than"); s != "" {
+		d, err := parseAge(s)
+		if err != nil || d <= 0 {
+			return f, errors.New("the older_than query parameter must be a positive duration like 12h or 7d")
+		}
+		f.olderThan = d
+	}
+	f.failed = query.Get("failed") == "true"
+	return f, nil
+}
+
+// parseAge parses the given duration, which may also be a whole amount of days like "7d".
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// run runs the given bulk operation on all review apps matching the given filter.
+func (b *bulkOperations) run(ctx context.Context, action string, filter bulkFilter, dryRun bool) (*bulkResult, error) {
+	ras, err := b.prs.openReviewApps(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &bulkResult{Action: action, DryRun: dryRun, Apps: []bulkApp{}, Skipped: []bulkApp{}, Failed: []bulkApp{}}
+	now := time.Now()
+	for _, ra := range ras {
+		if filter.repo != "" && !strings.EqualFold(ra.repo.GetFullName(), filter.repo) {
+			continue
+		}
+		appID, ok, err := b.matches(ctx, ra, filter, now)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		app := bulkApp{Repo: ra.repo.GetFullName(), PullRequest: ra.number, AppName: ra.appName, AppID: appID}
+		if action == bulkDestroy {
+			if p := b.prs.protectionOf(ra); p != nil {
+				app.Reason = fmt.Sprintf("it's protected: %s", p.Reason)
+				result.Skipped = append(result.Skipped, app)
+				continue
+			}
+		}
+		if dryRun {
+			result.Apps = append(result.Apps, app)
+			continue
+		}
+
+		switch action {
+		case bulkDestroy:
+			if err := b.destroy(ctx, ra); err != nil {
+				ra.logger.Error().Err(err).Msg("failed to tear down review app in bulk")
+				app.Reason = err.Error()
+				result.Failed = append(result.Failed, app)
+				continue
+			}
+		case bulkRedeploy:
+			go b.redeploy(ra, now.Unix())
+		}
+		result.Apps = append(result.Apps, app)
+	}
+	return result, nil
+}
+
+// matches returns the app of the given review app and whether or not it matches the given filter
+// at the given time. Review apps without a live app never match.
+func (b *bulkOperations) matches(ctx context.Context, ra *reviewApp, filter bulkFilter, now time.Time) (string, bool, error) {
+	deployment, payload, err := b.prs.liveDeployment(ctx, ra)
+	if err != nil || deployment == nil || payload.AppID == "" {
+		return "", false, err
+	}
+	if filter.failed {
+		status, err := latestDeploymentStatus(ctx, ra.client, ra.owner, ra.name, deployment.GetID())
+		if err != nil {
+			return "", false, err
+		}
+		if state := status.GetState(); state != deploymentStateError && state != deploymentStateFailure {
+			return "", false, nil
+		}
+	}
+	if filter.olderThan > 0 {
+		app, resp, err := b.prs.do.Apps.Get(ctx, payload.AppID)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			// Torn down, or never created successfully.
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, doError(err, "failed to get app")
+		}
+		if now.Sub(app.GetCreatedAt()) < filter.olderThan {
+			return "", false, nil
+		}
+	}
+	return payload.AppID, true, nil
+}
+
+// destroy tears down the given review app in its turn and tells its pull request.
+func (b *bulkOperations) destroy(ctx context.Context, ra *reviewApp) error {
+	ctx, done, _, err := b.prs.turns.take(ctx, ra.repo.GetFullName(), ra.number, turnQueued)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	if err := b.prs.teardown(ctx, ra, "an operator tore it down in bulk"); err != nil {
+		return err
+	}
+	body := fmt.Sprintf("The review app was torn down by an operator. Comment `%s` to recreate it.", commandDeploy)
+	if err := b.prs.comment(ctx, ra, commentKindBulk, body); err != nil {
+		ra.logger.Warn().Err(err).Msg("failed to comment on review app torn down in bulk")
+	}
+	return nil
+}
+
+// redeploy redeploys the given review app in its turn in the background. The attempt distinguishes
+// the redeploys of different bulk operations of the same commit.
+func (b *bulkOperations) redeploy(ra *reviewApp, attempt int64) {
+	ctx, cancel := context.WithTimeout(ra.logger.WithContext(context.Background()), time.Hour)
+	defer cancel()
+	ctx, done, _, err := b.prs.turns.take(ctx, ra.repo.GetFullName(), ra.number, turnQueued)
+	if err != nil {
+		ra.logger.Error().Err(err).Msg("failed to redeploy review app in bulk")
+		return
+	}
+	defer done()
+
+	if err := b.prs.redeploy(ctx, ra, attempt, "an operator redeployed it in bulk"); err != nil {
+		ra.logger.Error().Err(err).Msg("failed to redeploy review app in bulk")
+	}
+}
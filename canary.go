@@ -0,0 +1,135 @@
+package reviewapps
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/rs/zerolog"
+	"sigs.k8s.io/yaml"
+)
+
+// canaryAppName is the name of the canary's app.
+const canaryAppName = "reviewapps-canary"
+
+// Canary deploys and deletes a known-good app spec end-to-end on a schedule, exercising the GitHub
+// and DigitalOcean credentials as well as App Platform itself, and exports the outcome as metrics.
+type Canary struct {
+	prs      *PRHandler
+	config   CanaryConfig
+	schedule *cronSchedule
+}
+
+// NewCanary returns a new Canary as configured.
+func NewCanary(prs *PRHandler, config CanaryConfig) (*Canary, error) {
+	s, err := parseCron(config.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	return &Canary{prs: prs, config: config, schedule: s}, nil
+}
+
+// Run runs the canary on the schedule until the context is done.
+func (c *Canary) Run(ctx context.Context) {
+	logger := zerolog.Ctx(ctx).With().Str("component", "canary").Logger()
+	ctx = logger.WithContext(ctx)
+
+	for {
+		next := c.schedule.Next(time.Now().UTC())
+		if next.IsZero() {
+			logger.Error().Msg("canary schedule never matches")
+			return
+		}
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		started := time.Now()
+		err := c.run(ctx)
+		canaryDurationSeconds.Set(time.Since(started).Seconds())
+		if err != nil {
+			canaryRunsTotal.Add("failed", 1)
+			logger.Error().Err(err).Msg("canary failed")
+			continue
+		}
+		canaryRunsTotal.Add("succeeded", 1)
+		canaryLastSuccessTimestamp.Set(time.Now().Unix())
+		logger.Info().Dur("duration", time.Since(started)).Msg("canary succeeded")
+	}
+}
+
+// run deploys the canary's app spec until it's live and deletes the app again.
+func (c *Canary) run(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.config.GetTimeout())
+	defer cancel()
+
+	owner, name, _ := strings.Cut(c.config.Repo, "/")
+	_, client, err := c.prs.repoInstallation(ctx, owner, name)
+	if err != nil {
+		return err
+	}
+	repo, _, err := client.Repositories.Get(ctx, owner, name)
+	if err != nil {
+		return githubError(err, "failed to get repository")
+	}
+	content, err := fileContent(ctx, client, owner, name, c.config.GetSpec(), repo.GetDefaultBranch())
+	if err != nil {
+		return err
+	}
+	var spec godo.AppSpec
+	if err := yaml.Unmarshal(content, &spec); err != nil {
+		return errorf(ErrorKindSpecInvalid, "failed to parse canary app spec: %w", err)
+	}
+	spec.Name = canaryAppName
+	markOwned(&spec)
+
+	// A previous run might have been interrupted before it deleted its app.
+	leftover, err := findAppByName(ctx, c.prs.do, canaryAppName)
+	if err != nil {
+		return err
+	}
+	if leftover != nil && isOwned(leftover.GetSpec()) {
+		if _, err := c.prs.do.Apps.Delete(ctx, leftover.GetID()); err != nil {
+			return doError(err, "failed to delete leftover canary app")
+		}
+	}
+
+	app, _, err := c.prs.do.Apps.Create(ctx, &godo.AppCreateRequest{Spec: &spec})
+	if err != nil {
+		return doSpecError(err, "failed to create canary app")
+	}
+	defer func() {
+		// The app is deleted even if the run timed out.
+		if _, err := c.prs.do.Apps.Delete(context.WithoutCancel(ctx), app.GetID()); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Str("app_id", app.GetID()).Msg("failed to delete canary app")
+		}
+	}()
+
+	ds, _, err := c.prs.do.Apps.ListDeployments(ctx, app.GetID(), &godo.ListOptions{})
+	if err != nil {
+		return doError(err, "failed to list deployments")
+	}
+	if len(ds) == 0 {
+		return errorf(ErrorKindDOAPI, "app %s has no deployments", app.GetID())
+	}
+	d, err := c.prs.waitForDeploymentTerminal(ctx, app.GetID(), ds[0].GetID())
+	if err != nil {
+		return fmt.Errorf("failed to wait for deployment to finish: %w", err)
+	}
+	if d.GetPhase() != godo.DeploymentPhase_Active {
+		return errorf(ErrorKindDOAPI, "canary deployment %s finished in phase %s", d.GetID(), d.GetPhase())
+	}
+	if len(spec.Services) > 0 || len(spec.StaticSites) > 0 {
+		if _, err := c.prs.waitForAppLiveURL(ctx, app.GetID()); err != nil {
+			return fmt.Errorf("failed to wait for app to have a live URL: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package reviewapps
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-github/v60/github"
+)
+
+const (
+	// checkRunLogLines is the amount of log lines of each component attached to check runs.
+	checkRunLogLines = 20
+	// maxCheckRunText is the maximum length of the text of check run outputs GitHub accepts.
+	maxCheckRunText = 65535
+)
+
+// startCheckRun creates a queued check run of the given deployment on the pull request's head, if
+// configured. It returns the check run's ID, or 0 if there is none. Check runs only report on
+// deployments, so failing to create one is logged instead of failing the deployment.
+func (h *PRHandler) startCheckRun(ctx context.Context, ra *reviewApp, appID, deploymentID string) int64 {
+	if !ra.cfg.CheckRuns.Enabled || ra.number == 0 {
+		return 0
+	}
+	run, _, err := ra.client.Checks.CreateCheckRun(ctx, ra.owner, ra.name, github.CreateCheckRunOptions{
+		Name:       ra.cfg.CheckRuns.GetName(),
+		HeadSHA:    ra.pr.GetHead().GetSHA(),
+		ExternalID: ptr(deploymentID),
+		DetailsURL: ptr(deploymentDashboardURL(appID, deploymentID)),
+		Status:     ptr("queued"),
+		Output: &github.CheckRunOutput{
+			Title:   ptr("Queued"),
+			Summary: ptr(fmt.Sprintf("Deployment `%s` of review app `%s` is queued.", deploymentID, ra.appName)),
+		},
+	})
+	if err != nil {
+		ra.logger.Warn().Err(githubError(err, "failed to create check run")).Msg("failed to report deployment as check run")
+		return 0
+	}
+	return run.GetID()
+}
+
+// progressCheckRun marks the given check run as in progress once its deployment is building or
+// deploying in the given phase.
+func (h *PRHandler) progressCheckRun(ctx context.Context, ra *reviewApp, id int64, phase godo.DeploymentPhase) {
+	if id == 0 || (phase != godo.DeploymentPhase_Building && phase != godo.DeploymentPhase_Deploying) {
+		return
+	}
+	title := map[godo.DeploymentPhase]string{godo.DeploymentPhase_Building: "Building", godo.DeploymentPhase_Deploying: "Deploying"}[phase]
+	_, _, err := ra.client.Checks.UpdateCheckRun(ctx, ra.owner, ra.name, id, github.UpdateCheckRunOptions{
+		Name:   ra.cfg.CheckRuns.GetName(),
+		Status: ptr("in_progress"),
+		Output: &github.CheckRunOutput{
+			Title:   ptr(title),
+			Summary: ptr(fmt.Sprintf("Review app `%s` is %s.", ra.appName, strings.ToLower(title))),
+		},
+	})
+	if err != nil {
+		ra.logger.Warn().Err(githubError(err, "failed to update check run")).Msg("failed to report deployment progress as check run")
+	}
+}
+
+// supersedeCheckRun completes the given check run as skipped once a newer event superseded its
+// deployment.
+func (h *PRHandler) supersedeCheckRun(ctx context.Context, ra *reviewApp, id int64) {
+	if id == 0 {
+		return
+	}
+	_, _, err := ra.client.Checks.UpdateCheckRun(ctx, ra.owner, ra.name, id, github.UpdateCheckRunOptions{
+		Name:       ra.cfg.CheckRuns.GetName(),
+		Status:     ptr("completed"),
+		Conclusion: ptr("skipped"),
+		Output: &github.CheckRunOutput{
+			Title:   ptr("Superseded"),
+			Summary: ptr(fmt.Sprintf("The deployment of review app `%s` was superseded.", ra.appName)),
+		},
+	})
+	if err != nil {
+		ra.logger.Warn().Err(githubError(err, "failed to complete check run")).Msg("failed to report superseded deployment as check run")
+	}
+}
+
+// completeCheckRun completes the check run of the given finished deployment with its outcome, the
+// app's live URL and the tails of its components' logs. The check run is looked up, as detached
+// deployments are completed long after it was created.
+func (h *PRHandler) completeCheckRun(ctx context.Context, ra *reviewApp, appID string, d *godo.Deployment, app *godo.App) {
+	if !ra.cfg.CheckRuns.Enabled || ra.number == 0 {
+		return
+	}
+	run, err := h.findCheckRun(ctx, ra, d.GetID())
+	if err != nil {
+		ra.logger.Warn().Err(err).Msg("failed to find check run of deployment")
+		return
+	}
+	if run == nil {
+		// The check run failed to be created, or the pull request's head moved on.
+		return
+	}
+
+	succeeded := d.GetPhase() == godo.DeploymentPhase_Active
+	conclusion, title := "success", "Deployed"
+	summary := fmt.Sprintf("Review app `%s` is live at %s.", ra.appName, app.GetLiveURL())
+	if !succeeded {
+		conclusion, title = "failure", "Deployment failed"
+		summary = fmt.Sprintf("Deployment `%s` of review app `%s` finished in phase `%s`.", d.GetID(), ra.appName, d.GetPhase())
+	}
+	failedJobs := failedPostDeployJobs(d)
+	if succeeded && len(failedJobs) > 0 {
+		conclusion, title = "failure", "Post-deploy jobs failed"
+		summary = fmt.Sprintf("Review app `%s` is live at %s, but post-deploy jobs `%s` failed.", ra.appName, app.GetLiveURL(), strings.Join(failedJobs, "`, `"))
+	}
+
+	var text strings.Builder
+	if !succeeded {
+		// Only the logs of the failing components tell what went wrong.
+		text.WriteString(failureLogs(ctx, h.do, appID, d, ra.cfg.FailureLogs.GetLines()))
+	} else {
+		godo.ForEachAppSpecComponent(d.GetSpec(), func(c godo.AppBuildableComponentSpec) error {
+			if len(failedJobs) > 0 && !slices.Contains(failedJobs, c.GetName()) {
+				// Only the logs of the failed jobs tell what went wrong.
+				return nil
+			}
+			logs, err := fetchLogTail(ctx, h.do, appID, d.GetID(), c.GetName(), godo.AppLogTypeDeploy, checkRunLogLines)
+			if err != nil || logs == "" {
+				return nil
+			}
+			fmt.Fprintf(&text, "### Logs of `%s`\n\n```\n%s\n```\n\n", c.GetName(), logs)
+			return nil
+		})
+	}
+	output := &github.CheckRunOutput{Title: ptr(title), Summary: ptr(summary)}
+	if text.Len() > 0 {
+		s := text.String()
+		if len(s) > maxCheckRunText {
+			s = s[:maxCheckRunText]
+		}
+		output.Text = ptr(s)
+	}
+
+	_, _, err = ra.client.Checks.UpdateCheckRun(ctx, ra.owner, ra.name, run.GetID(), github.UpdateCheckRunOptions{
+		Name:       ra.cfg.CheckRuns.GetName(),
+		Status:     ptr("completed"),
+		Conclusion: ptr(conclusion),
+		Output:     output,
+	})
+	if err != nil {
+		ra.logger.Warn().Err(githubError(err, "failed to complete check run")).Msg("failed to report deployment outcome as check run")
+	}
+}
+
+// findCheckRun finds the check run of the given deployment on the pull request's head. It returns
+// nil if there is none.
+func (h *PRHandler) findCheckRun(ctx context.Context, ra *reviewApp, deploymentID string) (*github.CheckRun, error) {
+	runs, _, err := ra.client.Checks.ListCheckRunsForRef(ctx, ra.owner, ra.name, ra.pr.GetHead().GetSHA(), &github.ListCheckRunsOptions{
+		CheckName: ptr(ra.cfg.CheckRuns.GetName()),
+	})
+	if err != nil {
+		return nil, githubError(err, "failed to list check runs")
+	}
+	for _, run := range runs.CheckRuns {
+		if run.GetExternalID() == deploymentID {
+			return run, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,158 @@
+package reviewapps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/go-github/v60/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/rs/zerolog"
+)
+
+// actionRerequested is the policy action of re-run checks.
+const actionRerequested = "rerequested"
+
+// CheckSuiteHandler redeploys review apps when all checks of their pull request are re-run, as
+// many users expect the preview to be refreshed alongside CI.
+type CheckSuiteHandler struct {
+	prs *PRHandler
+}
+
+// NewCheckSuiteHandler returns a new CheckSuiteHandler managing the review apps of the given
+// PRHandler.
+func NewCheckSuiteHandler(prs *PRHandler) *CheckSuiteHandler {
+	return &CheckSuiteHandler{prs: prs}
+}
+
+func (h *CheckSuiteHandler) Handles() []string {
+	return []string{"check_suite"}
+}
+
+// triageCheckSuite returns the configuration of the given event, or why the event is skipped,
+// without calling any APIs.
+func (h *CheckSuiteHandler) triageCheckSuite(event *github.CheckSuiteEvent) (ReviewAppConfig, string, error) {
+	if event.GetAction() != actionRerequested {
+		return ReviewAppConfig{}, fmt.Sprintf("action %q is not handled", event.GetAction()), nil
+	}
+	if err := validateCheckSuiteEvent(event); err != nil {
+		return ReviewAppConfig{}, "", err
+	}
+	if len(event.GetCheckSuite().PullRequests) == 0 {
+		return ReviewAppConfig{}, "the check suite belongs to no pull request", nil
+	}
+
+	cfg, err := h.prs.config.ForRepo(event.GetRepo().GetFullName())
+	if err != nil {
+		return ReviewAppConfig{}, "", fmt.Errorf("failed to get review app configuration: %w", err)
+	}
+	if !cfg.RerunRedeploys {
+		return ReviewAppConfig{}, "redeploys on re-run checks are disabled", nil
+	}
+	if cfg.Task {
+		return ReviewAppConfig{}, "task previews are not redeployed", nil
+	}
+	return cfg, "", nil
+}
+
+// triage implements triager.
+func (h *CheckSuiteHandler) triage(eventType string, payload []byte) (string, error) {
+	var event github.CheckSuiteEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return "", errorf(ErrorKindInvalidEvent, "failed to parse check suite event: %w", err)
+	}
+	_, skip, err := h.triageCheckSuite(&event)
+	return skip, err
+}
+
+func (h *CheckSuiteHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	var event github.CheckSuiteEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return errorf(ErrorKindInvalidEvent, "failed to parse check suite event: %w", err)
+	}
+
+	repo := event.GetRepo()
+	installationID := githubapp.GetInstallationIDFromEvent(&event)
+	ctx, logger := githubapp.PrepareRepoContext(ctx, installationID, repo)
+	logger = logger.With().Str("tracking_id", deliveryID).Str("sha", event.GetCheckSuite().GetHeadSHA()).Logger()
+
+	cfg, skip, err := h.triageCheckSuite(&event)
+	if err != nil {
+		return skipNotActionable(ctx, eventType, err)
+	}
+	if skip != "" {
+		logger.Debug().Str("reason", skip).Msg("skipping check suite event")
+		return nil
+	}
+
+	client, err := h.prs.cc.NewInstallationClient(installationID)
+	if err != nil {
+		return githubError(err, "failed to create installation client")
+	}
+	for _, suitePR := range event.GetCheckSuite().PullRequests {
+		prCtx, prLogger := githubapp.PreparePRContext(ctx, installationID, repo, suitePR.GetNumber())
+		prLogger = prLogger.With().Str("tracking_id", deliveryID).Logger()
+		if err := h.redeploy(prCtx, client, &event, suitePR.GetNumber(), cfg, prLogger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redeploy redeploys the review app of the given pull request of the check suite, if it's still at
+// the check suite's commit.
+func (h *CheckSuiteHandler) redeploy(ctx context.Context, client *github.Client, event *github.CheckSuiteEvent, number int, cfg ReviewAppConfig, logger zerolog.Logger) error {
+	repo := event.GetRepo()
+	pr, _, err := client.PullRequests.Get(ctx, repo.GetOwner().GetLogin(), repo.GetName(), number)
+	if err != nil {
+		return githubError(err, "failed to get pull request")
+	}
+	if err := checkFields(pullRequestChecks(pr)...); err != nil {
+		return skipNotActionable(ctx, "check_suite", err)
+	}
+
+	switch {
+	case pr.GetState() != "open":
+		logger.Debug().Msg("skipping re-run checks of a closed pull request")
+		return nil
+	case pr.GetHead().GetSHA() != event.GetCheckSuite().GetHeadSHA():
+		logger.Debug().Msg("skipping re-run checks of an outdated commit")
+		return nil
+	case repo.GetID() != pr.GetHead().GetRepo().GetID():
+		logger.Debug().Msg("skipping re-run checks of a pull request of a forked repository")
+		return nil
+	case hasAnyLabel(pr, cfg.TeardownLabels):
+		logger.Debug().Msg("skipping re-run checks of a pull request with a teardown label")
+		return nil
+	}
+
+	decision, err := h.prs.decide(ctx, actionRerequested, repo, pr)
+	if err != nil {
+		return err
+	}
+	if !decision.Allow {
+		logger.Info().Str("reason", decision.Reason).Msg("skipping re-run checks denied by policy")
+		return nil
+	}
+
+	ra, err := h.prs.newReviewApp(ctx, event.GetInstallation().GetID(), repo, pr, cfg)
+	if err != nil {
+		return err
+	}
+	if ra.directives.disabled() {
+		logger.Debug().Msg("skipping re-run checks of a pull request with its review app disabled")
+		return nil
+	}
+	ra.logger = logger.With().Str("app_name", ra.appName).Logger()
+	ctx = withDebugCapture(ra.logger.WithContext(ctx), ra)
+
+	// Every re-run updates the check suite, which makes retried deliveries of the same re-run
+	// idempotent while allowing checks to be re-run multiple times.
+	attempt := event.GetCheckSuite().GetUpdatedAt().Unix()
+	ctx, done, _, err := h.prs.turns.take(ctx, ra.repo.GetFullName(), ra.number, turnQueued)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return h.prs.redeploy(ctx, ra, attempt, "its checks were re-run")
+}
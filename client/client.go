@@ -0,0 +1,304 @@
+// Package client is a typed client of the status, admin and preview APIs of the review apps
+// service, as defined by the OpenAPI definition served under "/openapi.yaml", so internal tools
+// can integrate with the service without reverse-engineering its routes.
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxErrorBody is the maximum length of error responses read into errors.
+const maxErrorBody = 4 << 10
+
+// Error is a response of the service with an unexpected status.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("review apps API responded with %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound returns whether or not the given error is a 404 response of the service.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+// Client talks to the APIs of a review apps service.
+type Client struct {
+	baseURL string
+	// HTTPClient sends the requests. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// AdminToken authorizes requests to the admin API.
+	AdminToken string
+	// APIToken authorizes requests to the preview API and the event stream, which also accepts the
+	// AdminToken if it's empty.
+	APIToken string
+}
+
+// New returns a new client of the service at the given base URL, e.g. "https://reviewapps.example.com".
+func New(baseURL string) *Client {
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Readiness returns the outcome of the service's readiness checks. Failing checks aren't an error.
+func (c *Client) Readiness(ctx context.Context) (*Readiness, error) {
+	var r Readiness
+	if err := c.do(ctx, http.MethodGet, "/readyz", nil, "", &r, http.StatusOK, http.StatusServiceUnavailable); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Status lists why the latest events of pull requests were skipped, newest first, optionally
+// filtered by repository, i.e. "owner/name", and pull request.
+func (c *Client) Status(ctx context.Context, repo string, number int) (*Status, error) {
+	var s Status
+	if err := c.do(ctx, http.MethodGet, "/status", filters(repo, number), c.AdminToken, &s, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// StreamEvents calls the given function with the lifecycle events of review apps, optionally
+// filtered by repository, i.e. "owner/name", and pull request, until the context is done, the
+// stream ends or the function returns an error.
+func (c *Client) StreamEvents(ctx context.Context, repo string, number int, fn func(Event) error) error {
+	token := c.APIToken
+	if token == "" {
+		token = c.AdminToken
+	}
+	resp, err := c.send(ctx, http.MethodGet, "/events", filters(repo, number), token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			// Event names are part of the data and comments are keepalives.
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
+
+// CollectGarbage deletes the GitHub deployments of the closed pull requests of the given
+// repository, i.e. "owner/name".
+func (c *Client) CollectGarbage(ctx context.Context, repo string) (*GCResult, error) {
+	var r GCResult
+	if err := c.do(ctx, http.MethodPost, "/admin/gc", url.Values{"repo": {repo}}, c.AdminToken, &r, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Adopt adopts the apps whose names match the given pattern as review apps of the given
+// repository, i.e. "owner/name". The pattern's first group must match the number of the app's pull
+// request. Dry runs only report what would be adopted.
+func (c *Client) Adopt(ctx context.Context, repo, pattern string, dryRun bool) (*AdoptResult, error) {
+	query := url.Values{"repo": {repo}, "pattern": {pattern}}
+	if dryRun {
+		query.Set("dry_run", "true")
+	}
+	var r AdoptResult
+	if err := c.do(ctx, http.MethodPost, "/admin/adopt", query, c.AdminToken, &r, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Inventory returns all review apps of open pull requests, including their live specs.
+func (c *Client) Inventory(ctx context.Context) (*Inventory, error) {
+	var inv Inventory
+	if err := c.do(ctx, http.MethodGet, "/admin/inventory", nil, c.AdminToken, &inv, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// Queue returns the work in progress of the service.
+func (c *Client) Queue(ctx context.Context) (*Queue, error) {
+	var q Queue
+	if err := c.do(ctx, http.MethodGet, "/admin/queue", nil, c.AdminToken, &q, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// Destroy tears down the review apps matching the given filter, which must have at least one field
+// set. Protected review apps are skipped. Dry runs only report what would be torn down.
+func (c *Client) Destroy(ctx context.Context, filter BulkFilter, dryRun bool) (*BulkResult, error) {
+	return c.bulk(ctx, "destroy", filter, dryRun)
+}
+
+// Redeploy starts redeploying the review apps matching the given filter in the background. Dry runs
+// only report what would be redeployed.
+func (c *Client) Redeploy(ctx context.Context, filter BulkFilter, dryRun bool) (*BulkResult, error) {
+	return c.bulk(ctx, "redeploy", filter, dryRun)
+}
+
+// bulk runs the given bulk operation.
+func (c *Client) bulk(ctx context.Context, action string, filter BulkFilter, dryRun bool) (*BulkResult, error) {
+	query := filters(filter.Repo, 0)
+	if filter.OlderThan > 0 {
+		query.Set("older_than", filter.OlderThan.String())
+	}
+	if filter.Failed {
+		query.Set("failed", "true")
+	}
+	if dryRun {
+		query.Set("dry_run", "true")
+	}
+	var r BulkResult
+	if err := c.do(ctx, http.MethodPost, "/admin/apps/"+action, query, c.AdminToken, &r, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// StartDebugCapture starts capturing the DigitalOcean API requests made on behalf of the given
+// pull request of the given repository, i.e. "owner/name".
+func (c *Client) StartDebugCapture(ctx context.Context, repo string, number int) error {
+	return c.do(ctx, http.MethodPost, debugPath(repo, number), nil, c.AdminToken, nil, http.StatusNoContent)
+}
+
+// DebugCapture returns the captured DigitalOcean API requests of the given pull request of the
+// given repository, i.e. "owner/name", oldest first.
+func (c *Client) DebugCapture(ctx context.Context, repo string, number int) (*DebugCapture, error) {
+	var capture DebugCapture
+	if err := c.do(ctx, http.MethodGet, debugPath(repo, number), nil, c.AdminToken, &capture, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &capture, nil
+}
+
+// StopDebugCapture stops capturing and drops the captured requests of the given pull request of
+// the given repository, i.e. "owner/name".
+func (c *Client) StopDebugCapture(ctx context.Context, repo string, number int) error {
+	return c.do(ctx, http.MethodDelete, debugPath(repo, number), nil, c.AdminToken, nil, http.StatusNoContent)
+}
+
+// Preview returns the preview URL and status of the review app of the given pull request of the
+// given repository, i.e. "owner/name".
+func (c *Client) Preview(ctx context.Context, repo string, number int) (*Preview, error) {
+	var p Preview
+	path := fmt.Sprintf("/api/v1/repos/%s/pulls/%d/preview", escapeRepo(repo), number)
+	if err := c.do(ctx, http.MethodGet, path, nil, c.APIToken, &p, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// do sends a request and decodes the response into the given value, if any, if its status is one
+// of the expected ones.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, token string, v any, expected ...int) error {
+	resp, err := c.send(ctx, method, path, query, token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	ok := false
+	for _, status := range expected {
+		ok = ok || resp.StatusCode == status
+	}
+	if !ok {
+		return responseError(resp)
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response of %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// send sends a request authorized with the given token, if any.
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, token string) (*http.Response, error) {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send %s %s: %w", method, path, err)
+	}
+	return resp, nil
+}
+
+// responseError returns the error of the given response. The preview API responds with JSON
+// errors, the others with plain text.
+func responseError(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	msg := strings.TrimSpace(string(b))
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(b, &body) == nil && body.Error != "" {
+		msg = body.Error
+	}
+	return &Error{StatusCode: resp.StatusCode, Message: msg}
+}
+
+// filters returns the query parameters filtering by the given repository and pull request.
+func filters(repo string, number int) url.Values {
+	query := url.Values{}
+	if repo != "" {
+		query.Set("repo", repo)
+	}
+	if number != 0 {
+		query.Set("pr", strconv.Itoa(number))
+	}
+	return query
+}
+
+// debugPath returns the path of the debug captures of the given pull request.
+func debugPath(repo string, number int) string {
+	return fmt.Sprintf("/admin/debug/%s/%d", escapeRepo(repo), number)
+}
+
+// escapeRepo escapes the owner and name of the given repository for use in paths.
+func escapeRepo(repo string) string {
+	owner, name, _ := strings.Cut(repo, "/")
+	return url.PathEscape(owner) + "/" + url.PathEscape(name)
+}
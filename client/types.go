@@ -0,0 +1,205 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// Readiness is the outcome of the readiness checks of the service.
+type Readiness struct {
+	Ready bool `json:"ready"`
+	// Checks are the outcomes of the checks by name, i.e. "ok" or why the check failed.
+	Checks    map[string]string `json:"checks"`
+	CheckedAt time.Time         `json:"checked_at"`
+}
+
+// Status lists why the latest events of pull requests were skipped.
+type Status struct {
+	Skipped []Skip `json:"skipped"`
+}
+
+// Skip records why the latest event of a pull request was skipped.
+type Skip struct {
+	Repo        string    `json:"repo"`
+	PullRequest int       `json:"pull_request"`
+	Action      string    `json:"action"`
+	Reason      string    `json:"reason"`
+	TrackingID  string    `json:"tracking_id"`
+	Time        time.Time `json:"time"`
+}
+
+// Event is a lifecycle event of a review app, like "deployment_succeeded".
+type Event struct {
+	Type string `json:"type"`
+	Repo string `json:"repo"`
+	// PullRequest is the number of the pull request, or 0 for apps of branches.
+	PullRequest  int       `json:"pull_request"`
+	AppName      string    `json:"app_name"`
+	AppID        string    `json:"app_id,omitempty"`
+	DeploymentID string    `json:"deployment_id,omitempty"`
+	LiveURL      string    `json:"live_url,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// GCResult is the outcome of collecting the garbage of a repository.
+type GCResult struct {
+	Repo string `json:"repo"`
+	// Environments are the environments whose deployments were deleted.
+	Environments []string `json:"environments"`
+	Deployments  int      `json:"deployments"`
+	// Kept are the environments of closed pull requests that were kept, with the reason why.
+	Kept map[string]string `json:"kept,omitempty"`
+}
+
+// AdoptResult lists the apps that were, or would be in a dry run, adopted as review apps.
+type AdoptResult struct {
+	DryRun  bool         `json:"dry_run"`
+	Adopted []AdoptedApp `json:"adopted"`
+	Skipped []SkippedApp `json:"skipped"`
+}
+
+// AdoptedApp is an app adopted as review app.
+type AdoptedApp struct {
+	AppID       string `json:"app_id"`
+	AppName     string `json:"app_name"`
+	PullRequest int    `json:"pull_request"`
+	// ReviewApp is the name of the review app the app is renamed to.
+	ReviewApp string `json:"review_app"`
+}
+
+// SkippedApp is an app matching the adoption pattern that isn't adopted.
+type SkippedApp struct {
+	AppID   string `json:"app_id"`
+	AppName string `json:"app_name"`
+	Reason  string `json:"reason"`
+}
+
+// BulkFilter selects the review apps of open pull requests a bulk operation acts upon. Review apps
+// must match all of the set fields.
+type BulkFilter struct {
+	// Repo is the repository of the review apps, i.e. "owner/name".
+	Repo string
+	// OlderThan is how long ago the apps of the review apps must have been created.
+	OlderThan time.Duration
+	// Failed only selects review apps whose latest deployment failed.
+	Failed bool
+}
+
+// BulkResult lists the review apps a bulk operation acted upon, or would have in a dry run.
+type BulkResult struct {
+	Action  string    `json:"action"`
+	DryRun  bool      `json:"dry_run"`
+	Apps    []BulkApp `json:"apps"`
+	Skipped []BulkApp `json:"skipped"`
+	Failed  []BulkApp `json:"failed"`
+}
+
+// BulkApp is a review app selected by a bulk operation.
+type BulkApp struct {
+	Repo        string `json:"repo"`
+	PullRequest int    `json:"pull_request"`
+	AppName     string `json:"app_name"`
+	AppID       string `json:"app_id"`
+	// Reason is why the review app was skipped or failed, if it was.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Inventory lists all review apps of open pull requests.
+type Inventory struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Apps        []InventoryApp `json:"apps"`
+}
+
+// InventoryApp is a review app of the inventory.
+type InventoryApp struct {
+	Repo        string        `json:"repo"`
+	PullRequest int           `json:"pull_request"`
+	Branch      string        `json:"branch"`
+	AppName     string        `json:"app_name"`
+	AppID       string        `json:"app_id"`
+	LiveURL     string        `json:"live_url,omitempty"`
+	SHA         string        `json:"sha"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+	Spec        *godo.AppSpec `json:"spec"`
+	Protection  *Protection   `json:"protection,omitempty"`
+}
+
+// Protection is why a review app is protected from being torn down when idle.
+type Protection struct {
+	// By is the user who protected the review app, if known.
+	By     string     `json:"by,omitempty"`
+	Reason string     `json:"reason"`
+	Since  *time.Time `json:"since,omitempty"`
+}
+
+// Queue is the work in progress of the service.
+type Queue struct {
+	GeneratedAt time.Time          `json:"generated_at"`
+	Events      []QueuedEvent      `json:"events"`
+	Deployments []QueuedDeployment `json:"deployments"`
+	Scheduled   []QueuedRun        `json:"scheduled"`
+}
+
+// QueuedEvent is a webhook event that is being handled.
+type QueuedEvent struct {
+	TrackingID  string    `json:"tracking_id"`
+	Event       string    `json:"event"`
+	Repo        string    `json:"repo,omitempty"`
+	PullRequest int       `json:"pull_request,omitempty"`
+	Since       time.Time `json:"since"`
+}
+
+// QueuedDeployment is a deployment of a review app that is being waited for.
+type QueuedDeployment struct {
+	Repo         string    `json:"repo"`
+	PullRequest  int       `json:"pull_request,omitempty"`
+	AppName      string    `json:"app_name"`
+	AppID        string    `json:"app_id"`
+	DeploymentID string    `json:"deployment_id"`
+	Since        time.Time `json:"since"`
+}
+
+// QueuedRun is the next run of a scheduled job.
+type QueuedRun struct {
+	Job  string    `json:"job"`
+	Next time.Time `json:"next"`
+}
+
+// DebugCapture holds the captured DigitalOcean API requests made on behalf of a pull request.
+type DebugCapture struct {
+	Repo        string `json:"repo"`
+	PullRequest int    `json:"pull_request"`
+	// Since is when capturing was enabled.
+	Since     time.Time       `json:"since"`
+	Exchanges []DebugExchange `json:"exchanges"`
+}
+
+// DebugExchange is a sanitized request to the DigitalOcean API and its response.
+type DebugExchange struct {
+	Time       time.Time       `json:"time"`
+	Method     string          `json:"method"`
+	URL        string          `json:"url"`
+	Request    json.RawMessage `json:"request,omitempty"`
+	StatusCode int             `json:"status_code,omitempty"`
+	Response   json.RawMessage `json:"response,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Duration   string          `json:"duration"`
+}
+
+// Preview is the preview URL and status of the review app of a pull request.
+type Preview struct {
+	Repo        string `json:"repo"`
+	PullRequest int    `json:"pull_request"`
+	AppName     string `json:"app_name"`
+	AppID       string `json:"app_id,omitempty"`
+	// Status is the state of the latest GitHub deployment status of the review app, like
+	// "success", or "pending" or "not_deployed".
+	Status     string      `json:"status"`
+	URL        string      `json:"url,omitempty"`
+	SHA        string      `json:"sha,omitempty"`
+	UpdatedAt  *time.Time  `json:"updated_at,omitempty"`
+	Protection *Protection `json:"protection,omitempty"`
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.internal.digitalocean.com/mthoemmes/reviewapps/client"
+)
+
+// adminCommands are the bulk commands of the "admin" subcommand by name.
+var adminCommands = map[string]func(*client.Client, context.Context, client.BulkFilter, bool) (*client.BulkResult, error){
+	"destroy":  (*client.Client).Destroy,
+	"redeploy": (*client.Client).Redeploy,
+}
+
+// admin runs the "admin" subcommand with the given arguments. It runs bulk operations on review
+// apps through the admin API of a running service.
+func admin(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: reviewapps admin destroy|redeploy [flags]")
+	}
+	verb := args[0]
+	run, ok := adminCommands[verb]
+	if !ok {
+		return fmt.Errorf("unknown admin command %q, must be destroy or redeploy", args[0])
+	}
+
+	fs := flag.NewFlagSet("admin "+args[0], flag.ExitOnError)
+	url := fs.String("url", envOr("REVIEWAPPS_URL", "http://localhost:8080"), "base URL of the service, defaults to $REVIEWAPPS_URL")
+	token := fs.String("token", os.Getenv("REVIEWAPPS_ADMIN_TOKEN"), "admin token of the service, defaults to $REVIEWAPPS_ADMIN_TOKEN")
+	repo := fs.String("repo", "", "only review apps of this repository, i.e. owner/name")
+	olderThan := fs.String("older-than", "", "only review apps whose app was created longer ago than this, e.g. 12h or 7d")
+	failed := fs.Bool("failed", false, "only review apps whose latest deployment failed")
+	dryRun := fs.Bool("dry-run", false, "only print what would be done")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	fs.Parse(args[1:])
+
+	filter := client.BulkFilter{Repo: *repo, Failed: *failed}
+	if *olderThan != "" {
+		d, err := parseAge(*olderThan)
+		if err != nil || d <= 0 {
+			return errors.New("--older-than must be a positive duration like 12h or 7d")
+		}
+		filter.OlderThan = d
+	}
+
+	c := client.New(*url)
+	c.AdminToken = *token
+	result, err := run(c, context.Background(), filter, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+	} else {
+		// Redeploys are started in the background.
+		done := map[string]string{"destroy": "destroyed", "redeploy": "redeploying"}[verb]
+		if *dryRun {
+			done = "would " + verb
+		}
+		for _, app := range result.Apps {
+			fmt.Printf("%s %s#%d (%s)\n", done, app.Repo, app.PullRequest, app.AppName)
+		}
+		for _, app := range result.Skipped {
+			fmt.Printf("skipped %s#%d (%s): %s\n", app.Repo, app.PullRequest, app.AppName, app.Reason)
+		}
+		for _, app := range result.Failed {
+			fmt.Printf("failed to %s %s#%d (%s): %s\n", verb, app.Repo, app.PullRequest, app.AppName, app.Reason)
+		}
+	}
+	if len(result.Failed) > 0 {
+		return fmt.Errorf("failed to %s %d review apps", verb, len(result.Failed))
+	}
+	return nil
+}
+
+// parseAge parses the given duration, which may also be a whole amount of days like "7d".
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// envOr returns the value of the given environment variable, or the given default if it's unset.
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
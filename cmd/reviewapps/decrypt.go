@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.internal.digitalocean.com/mthoemmes/reviewapps"
+)
+
+// decrypt runs the "decrypt" subcommand with the given arguments. It decrypts data encrypted at
+// rest, like database backups, from stdin to stdout.
+func decrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	configPath := fs.String("config", "config.yml", "path of the configuration file")
+	fs.Parse(args)
+
+	config, err := reviewapps.ReadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if config.Encryption.Key == "" {
+		return fmt.Errorf("no encryption key is configured")
+	}
+
+	sealed, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read encrypted data: %w", err)
+	}
+	data, err := reviewapps.Decrypt(config.Encryption.Key, sealed)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.internal.digitalocean.com/mthoemmes/reviewapps"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		if err := simulate(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		if err := admin(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "decrypt" {
+		if err := decrypt(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	flags := flag.NewFlagSet("reviewapps", flag.ExitOnError)
+	configPath := flags.String("config", "config.yml", "path of the configuration file, the default configuration is used if it doesn't exist")
+	generateConfig := flags.Bool("generate-config", false, "print the default configuration and exit")
+	flags.Parse(os.Args[1:])
+	if *generateConfig {
+		os.Stdout.Write(reviewapps.DefaultConfig())
+		return
+	}
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		panic(err)
+	}
+
+	logger := zerolog.New(reviewapps.RedactingWriter(os.Stdout)).With().Timestamp().Logger()
+	zerolog.DefaultContextLogger = &logger
+
+	srv, err := reviewapps.NewBuilder(config).Build()
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to create server")
+	}
+
+	if err := srv.Run(logger.WithContext(context.Background())); err != nil {
+		logger.Fatal().Err(err).Msg("failed to run server")
+	}
+}
+
+// loadConfig loads the configuration from the REVIEWAPPS_CONFIG environment variable if it's set,
+// or from the file at the given path. The default configuration is used if neither exists, so
+// containers can be configured entirely by the REVIEWAPPS_* environment variables.
+func loadConfig(path string) (*reviewapps.Config, error) {
+	if config, ok := os.LookupEnv("REVIEWAPPS_CONFIG"); ok {
+		return reviewapps.ParseConfig([]byte(config))
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return reviewapps.ParseConfig(reviewapps.DefaultConfig())
+	}
+	return reviewapps.ReadConfig(path)
+}
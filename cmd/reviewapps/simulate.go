@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.internal.digitalocean.com/mthoemmes/reviewapps"
+)
+
+// simulate runs the "simulate" subcommand with the given arguments.
+func simulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	configPath := fs.String("config", "config.yml", "path of the configuration file")
+	scenario := fs.String("scenario", reviewapps.ScenarioPROpened, "scenario to simulate: pr-opened, pr-reopened, pr-synchronized, pr-labeled, pr-unlabeled, pr-closed, comment, push or checks-rerun")
+	repo := fs.String("repo", "", "full name of the repository, i.e. owner/name")
+	pr := fs.Int("pr", 1, "number of the pull request")
+	author := fs.String("author", "octocat", "author of the pull request")
+	branch := fs.String("branch", "feature", "branch of the pull request")
+	labels := fs.String("labels", "", "comma-separated labels of the pull request")
+	label := fs.String("label", "", "label added in the pr-labeled and removed in the pr-unlabeled scenario")
+	comment := fs.String("comment", "", "comment posted in the comment scenario")
+	files := fs.String("files", "", "comma-separated files changed by the pull request")
+	specPath := fs.String("spec", ".do/app.yaml", "path of the app spec on the pull request's branch")
+	failDO := fs.Int("fail-do", 0, "percentage of App Platform requests failing with injected errors")
+	delayDeployments := fs.Duration("delay-deployments", 0, "how long deployments are held in their first phase")
+	dropStatuses := fs.Int("drop-statuses", 0, "percentage of GitHub deployment statuses silently dropped")
+	seed := fs.Int64("seed", 1, "seed of the choice of requests hit by injected faults")
+	verbose := fs.Bool("v", false, "log what the handlers do")
+	fs.Parse(args)
+
+	if *repo == "" {
+		return fmt.Errorf("--repo is required")
+	}
+	config, err := reviewapps.ReadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	spec, err := os.ReadFile(*specPath)
+	if err != nil {
+		return fmt.Errorf("failed to read app spec: %w", err)
+	}
+
+	level := zerolog.Disabled
+	if *verbose {
+		level = zerolog.DebugLevel
+	}
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).Level(level).With().Timestamp().Logger()
+
+	return reviewapps.NewBuilder(config).Simulate(logger.WithContext(context.Background()), reviewapps.Scenario{
+		Name:        *scenario,
+		Repo:        *repo,
+		PullRequest: *pr,
+		Author:      *author,
+		Branch:      *branch,
+		Labels:      splitList(*labels),
+		Label:       *label,
+		Comment:     *comment,
+		Files:       splitList(*files),
+		Spec:        spec,
+		Faults: reviewapps.Faults{
+			DOErrorPercentage:    *failDO,
+			DeploymentDelay:      *delayDeployments,
+			StatusDropPercentage: *dropStatuses,
+			Seed:                 *seed,
+		},
+	}, os.Stdout)
+}
+
+// splitList splits the given comma-separated list.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
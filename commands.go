@@ -0,0 +1,416 @@
+package reviewapps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-github/v60/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	commandResetDB  = "/reset-db"
+	commandDeploy   = "/deploy"
+	commandRedeploy = "/redeploy"
+	commandTeardown = "/teardown"
+	commandPromote  = "/promote"
+	commandKeep     = "/keep"
+	// commandProtect protects the review app from being torn down when idle for the reason
+	// following it, e.g. "/protect demo for ACME on Friday".
+	commandProtect   = "/protect"
+	commandUnprotect = "/unprotect"
+	// commandScale temporarily scales the review app up or back down, e.g.
+	// "/scale up apps-s-2vcpu-4gb 3 2h" or "/scale down".
+	commandScale = "/scale"
+
+	reactionAccepted = "+1"
+	reactionDenied   = "-1"
+)
+
+// CommandHandler executes commands posted as comments on pull requests, like "/reset-db", so
+// reviewers can explicitly control the lifecycle of review apps.
+type CommandHandler struct {
+	prs *PRHandler
+}
+
+// NewCommandHandler returns a new CommandHandler managing the review apps of the given PRHandler.
+func NewCommandHandler(prs *PRHandler) *CommandHandler {
+	return &CommandHandler{prs: prs}
+}
+
+func (h *CommandHandler) Handles() []string {
+	return []string{"issue_comment"}
+}
+
+func (h *CommandHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	var event github.IssueCommentEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return errorf(ErrorKindInvalidEvent, "failed to parse issue comment event: %w", err)
+	}
+
+	repo := event.GetRepo()
+	installationID := githubapp.GetInstallationIDFromEvent(&event)
+	ctx, logger := githubapp.PreparePRContext(ctx, installationID, repo, event.GetIssue().GetNumber())
+	logger = logger.With().Str("tracking_id", deliveryID).Logger()
+
+	command, skip, err := triageComment(&event)
+	if err != nil {
+		return skipNotActionable(ctx, eventType, err)
+	}
+	if skip != "" {
+		// Most comments aren't commands, so this isn't worth more than a debug log.
+		logger.Debug().Str("reason", skip).Msg("skipping issue comment event")
+		return nil
+	}
+	logger = logger.With().Str("command", command).Logger()
+
+	client, err := h.prs.cc.NewInstallationClient(installationID)
+	if err != nil {
+		return githubError(err, "failed to create installation client")
+	}
+
+	_, inline := parseInlineSpec(event.GetComment().GetBody())
+	inline = inline && command == commandDeploy
+
+	commenter := event.GetComment().GetUser().GetLogin()
+	permission, _, err := client.Repositories.GetPermissionLevel(ctx, repo.GetOwner().GetLogin(), repo.GetName(), commenter)
+	if err != nil {
+		return githubError(err, "failed to get permission level of commenter")
+	}
+	switch permission.GetPermission() {
+	case "admin", "maintain":
+	case "write":
+		if inline || command == commandPromote {
+			// Arbitrary app specs and changes beyond the review app are reserved to maintainers.
+			logger.Warn().Str("commenter", commenter).Msg("ignoring command of user without maintain access")
+			return h.react(ctx, client, &event, reactionDenied)
+		}
+	default:
+		logger.Warn().Str("commenter", commenter).Msg("ignoring command of user without write access")
+		return h.react(ctx, client, &event, reactionDenied)
+	}
+
+	pr, _, err := client.PullRequests.Get(ctx, repo.GetOwner().GetLogin(), repo.GetName(), event.GetIssue().GetNumber())
+	if err != nil {
+		return githubError(err, "failed to get pull request")
+	}
+	if err := checkFields(pullRequestChecks(pr)...); err != nil {
+		return skipNotActionable(ctx, eventType, err)
+	}
+	cfg, err := h.prs.config.ForRepo(repo.GetFullName())
+	if err != nil {
+		return fmt.Errorf("failed to get review app configuration: %w", err)
+	}
+	if isFork(repo, pr) && (!cfg.Forks.Enabled || command == commandPromote) {
+		// "/deploy" approves the head of forked pull requests, but it's never promoted.
+		logger.Warn().Msg("pull requests of forked repositories are not allowed")
+		return h.react(ctx, client, &event, reactionDenied)
+	}
+	ra, err := h.prs.newReviewApp(ctx, installationID, repo, pr, cfg)
+	if err != nil {
+		return err
+	}
+	ra.logger = logger.With().Str("app_name", ra.appName).Logger()
+	ctx = withDebugCapture(ra.logger.WithContext(ctx), ra)
+
+	var (
+		p *promotion
+		s *scaleUp
+	)
+	switch command {
+	case commandDeploy:
+		prepare := h.prepareCommand
+		if inline {
+			prepare = h.prepareInlineSpec
+		}
+		if ok, err := prepare(ctx, client, &event, ra); err != nil || !ok {
+			return err
+		}
+	case commandResetDB, commandRedeploy, commandTeardown, commandKeep, commandProtect, commandUnprotect:
+		if ok, err := h.prepareCommand(ctx, client, &event, ra); err != nil || !ok {
+			return err
+		}
+	case commandPromote:
+		if p, err = h.preparePromotion(ctx, client, &event, ra); err != nil || p == nil {
+			return err
+		}
+	case commandScale:
+		ok, up, err := h.prepareScale(ctx, client, &event, ra)
+		if err != nil || !ok {
+			return err
+		}
+		if up != nil {
+			up.By = commenter
+		}
+		s = up
+	}
+
+	if err := h.react(ctx, client, &event, reactionAccepted); err != nil {
+		return err
+	}
+
+	kind := turnQueued
+	if command == commandTeardown {
+		kind = turnSuperseding
+	}
+	ctx, done, _, err := h.prs.turns.take(ctx, ra.repo.GetFullName(), ra.number, kind)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	apps, _, err := h.prs.reviewApps(ctx, ra)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, app := range apps {
+		errs = append(errs, h.execute(ctx, &event, command, commenter, app, p, s))
+	}
+	return errors.Join(errs...)
+}
+
+// execute executes the given command of the given event on the given one of the pull request's
+// review apps.
+func (h *CommandHandler) execute(ctx context.Context, event *github.IssueCommentEvent, command, commenter string, ra *reviewApp, p *promotion, s *scaleUp) error {
+	// The comment's ID makes retried deliveries of the same command idempotent while allowing the
+	// same command to be run multiple times.
+	attempt := event.GetComment().GetID()
+	switch command {
+	case commandResetDB:
+		// Deployments without a rebuild rerun all pre- and post-deploy jobs, which is where
+		// migrations and seeds live.
+		return h.prs.resetDB(ctx, ra, attempt, fmt.Sprintf("%s requested %s", commenter, commandResetDB))
+	case commandDeploy:
+		// Creating updates an existing app to the inline spec.
+		return h.prs.create(ctx, ra, attempt)
+	case commandRedeploy:
+		ra.forceBuild = true
+		return h.prs.redeploy(ctx, ra, attempt, fmt.Sprintf("%s requested %s", commenter, commandRedeploy))
+	case commandTeardown:
+		h.prs.skips.clear(ra.repo.GetFullName(), ra.number)
+		return h.prs.teardown(ctx, ra, fmt.Sprintf("%s requested %s", commenter, commandTeardown))
+	case commandPromote:
+		return h.promote(ctx, ra, p, commenter, attempt)
+	case commandKeep:
+		return h.prs.keep(ctx, ra, commenter)
+	case commandProtect:
+		return h.prs.protect(ctx, ra, commenter, commandArgs(event.GetComment().GetBody()))
+	case commandUnprotect:
+		return h.prs.unprotect(ctx, ra, commenter)
+	case commandScale:
+		if s == nil {
+			return h.prs.scaleDown(ctx, ra, attempt, fmt.Sprintf("%s requested %s %s", commenter, commandScale, scaleDownArg))
+		}
+		return h.prs.scale(ctx, ra, s, attempt)
+	}
+	return nil
+}
+
+// triageComment returns the command of the given event, or why the event is skipped, without
+// calling any APIs.
+func triageComment(event *github.IssueCommentEvent) (string, string, error) {
+	if event.GetAction() != "created" {
+		return "", fmt.Sprintf("action %q is not handled", event.GetAction()), nil
+	}
+	if !event.GetIssue().IsPullRequest() {
+		return "", "comments on issues are not handled", nil
+	}
+	command := parseCommand(event.GetComment().GetBody())
+	switch command {
+	case commandResetDB, commandDeploy, commandRedeploy, commandTeardown, commandPromote, commandKeep, commandProtect, commandUnprotect, commandScale:
+	default:
+		// Not a command, or not one we know about.
+		return "", "the comment is not a known command", nil
+	}
+	if err := validateIssueCommentEvent(event); err != nil {
+		return "", "", err
+	}
+	return command, "", nil
+}
+
+// triage implements triager.
+func (h *CommandHandler) triage(eventType string, payload []byte) (string, error) {
+	var event github.IssueCommentEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return "", errorf(ErrorKindInvalidEvent, "failed to parse issue comment event: %w", err)
+	}
+	_, skip, err := triageComment(&event)
+	return skip, err
+}
+
+// prepareInlineSpec validates and policy-checks the app spec of a "/deploy" command and sets it as
+// the review app's spec. It returns false and reports why on the pull request if the spec must not
+// be deployed.
+func (h *CommandHandler) prepareInlineSpec(ctx context.Context, client *github.Client, event *github.IssueCommentEvent, ra *reviewApp) (bool, error) {
+	deny := func(msg string) (bool, error) {
+		ra.logger.Info().Msg(msg)
+		if err := h.comment(ctx, client, event, commandDeploy, fmt.Sprintf("%s: %s.", commandDeploy, msg)); err != nil {
+			return false, err
+		}
+		return false, h.react(ctx, client, event, reactionDenied)
+	}
+
+	if !ra.cfg.InlineSpecs {
+		return deny("inline app specs are disabled for this repository")
+	}
+	if ra.cfg.Spec.Apps != "" {
+		return deny("inline app specs can't be deployed to repositories with several app specs")
+	}
+
+	decision, err := h.prs.decide(ctx, commandDeploy, ra.repo, ra.pr)
+	if err != nil {
+		return false, err
+	}
+	if !decision.Allow {
+		return deny(fmt.Sprintf("denied by policy: %s", decision.Reason))
+	}
+
+	raw, _ := parseInlineSpec(event.GetComment().GetBody())
+	var spec godo.AppSpec
+	if err := yaml.UnmarshalStrict(raw, &spec); err != nil {
+		return deny(fmt.Sprintf("failed to parse app spec: %v", err))
+	}
+	ra.inlineSpec = raw
+
+	// Validate the spec as it would be deployed before touching the review app. Nothing is
+	// provisioned for it yet, as the spec might still be rejected.
+	if err := h.prs.transformSpec(ctx, ra, &spec, true); err != nil {
+		return false, err
+	}
+	if _, _, err := h.prs.do.Apps.Propose(ctx, &godo.AppProposeRequest{Spec: &spec}); err != nil {
+		err = doSpecError(err, "invalid app spec")
+		if errors.Is(err, ErrSpecInvalid) {
+			return deny(err.Error())
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// prepareCommand checks a "/deploy" command without inline spec or a command acting upon the
+// existing review app, like "/redeploy". It returns false and reports why on the pull request if the command must not be run.
+func (h *CommandHandler) prepareCommand(ctx context.Context, client *github.Client, event *github.IssueCommentEvent, ra *reviewApp) (bool, error) {
+	command := parseCommand(event.GetComment().GetBody())
+	deny := func(msg string) (bool, error) {
+		ra.logger.Info().Msg(msg)
+		if err := h.comment(ctx, client, event, command, fmt.Sprintf("%s: %s.", command, msg)); err != nil {
+			return false, err
+		}
+		return false, h.react(ctx, client, event, reactionDenied)
+	}
+
+	if command != commandDeploy {
+		deployment, _, err := h.prs.liveDeployment(ctx, ra)
+		if err != nil {
+			return false, err
+		}
+		if deployment == nil {
+			return deny("the pull request has no review app")
+		}
+		return true, nil
+	}
+
+	if hasAnyLabel(ra.pr, ra.cfg.TeardownLabels) {
+		return deny("the pull request carries a teardown label")
+	}
+	repoCfg, err := h.prs.repoConfig(ctx, ra)
+	if err != nil {
+		return false, err
+	}
+	if repoCfg.disabled() {
+		return deny(repoConfigDisabledReason)
+	}
+	if ra.directives.disabled() {
+		return deny("the pull request's directives disable its review app")
+	}
+	decision, err := h.prs.decide(ctx, commandDeploy, ra.repo, ra.pr)
+	if err != nil {
+		return false, err
+	}
+	if !decision.Allow {
+		return deny(fmt.Sprintf("denied by policy: %s", decision.Reason))
+	}
+	return true, nil
+}
+
+// prepareScale checks a "/scale" command. It returns the requested scale-up, or nil for
+// "/scale down", and false and reports why on the pull request if the command must not be run.
+func (h *CommandHandler) prepareScale(ctx context.Context, client *github.Client, event *github.IssueCommentEvent, ra *reviewApp) (bool, *scaleUp, error) {
+	deny := func(msg string) (bool, *scaleUp, error) {
+		ra.logger.Info().Msg(msg)
+		if err := h.comment(ctx, client, event, commandScale, fmt.Sprintf("%s: %s.", commandScale, msg)); err != nil {
+			return false, nil, err
+		}
+		return false, nil, h.react(ctx, client, event, reactionDenied)
+	}
+
+	if !ra.cfg.Scale.Enabled {
+		return deny("scaling is disabled for this repository")
+	}
+	args := strings.Fields(commandArgs(event.GetComment().GetBody()))
+	if len(args) == 0 || (args[0] != scaleUpArg && args[0] != scaleDownArg) {
+		return deny(fmt.Sprintf("expected `%s %s [instance size] [instance count] [duration]` or `%s %s`", commandScale, scaleUpArg, commandScale, scaleDownArg))
+	}
+	if ok, err := h.prepareCommand(ctx, client, event, ra); err != nil || !ok {
+		return false, nil, err
+	}
+	if args[0] == scaleDownArg {
+		return true, nil, nil
+	}
+	s, err := parseScaleUp(args[1:], ra.cfg.Scale, time.Now())
+	if err != nil {
+		return deny(err.Error())
+	}
+	return true, s, nil
+}
+
+// inlineSpecPattern matches the first fenced YAML block of a comment.
+var inlineSpecPattern = regexp.MustCompile("(?s)```ya?ml[ \\t]*\\r?\\n(.*?)```")
+
+// parseInlineSpec returns the content of the first fenced YAML block of the given comment body.
+func parseInlineSpec(body string) ([]byte, bool) {
+	match := inlineSpecPattern.FindStringSubmatch(body)
+	if match == nil {
+		return nil, false
+	}
+	return []byte(match[1]), true
+}
+
+// comment replies to the given command with the given body on the pull request of the command.
+func (h *CommandHandler) comment(ctx context.Context, client *github.Client, event *github.IssueCommentEvent, command, body string) error {
+	target := commentTarget{owner: event.GetRepo().GetOwner().GetLogin(), name: event.GetRepo().GetName(), number: event.GetIssue().GetNumber()}
+	return h.prs.comments.comment(ctx, client, target, commandCommentKind(command), body)
+}
+
+// react reacts to the command's comment with the given reaction.
+func (h *CommandHandler) react(ctx context.Context, client *github.Client, event *github.IssueCommentEvent, reaction string) error {
+	_, _, err := client.Reactions.CreateIssueCommentReaction(ctx, event.GetRepo().GetOwner().GetLogin(), event.GetRepo().GetName(), event.GetComment().GetID(), reaction)
+	if err != nil {
+		return githubError(err, "failed to react to command")
+	}
+	return nil
+}
+
+// parseCommand returns the command in the first line of the given comment body, if any.
+func parseCommand(body string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(body), "\n")
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return ""
+	}
+	return fields[0]
+}
+
+// commandArgs returns the rest of the first line of the given comment body following its command.
+func commandArgs(body string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(body), "\n")
+	return strings.TrimSpace(strings.TrimPrefix(line, parseCommand(body)))
+}
@@ -0,0 +1,267 @@
+package reviewapps
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v60/github"
+	"github.com/rs/zerolog"
+)
+
+// commentKind identifies the purpose of a comment of the bot. A pull request has at most one
+// comment of every kind, which is updated instead of commenting anew.
+type commentKind string
+
+const (
+	commentKindPreflight  commentKind = "preflight"
+	commentKindSkip       commentKind = "skip"
+	commentKindTask       commentKind = "task"
+	commentKindTestMerge  commentKind = "test-merge"
+	commentKindDrift      commentKind = "drift"
+	commentKindRegion     commentKind = "region"
+	commentKindSpec       commentKind = "spec"
+	commentKindStatus     commentKind = "status"
+	commentKindExpiry     commentKind = "expiry"
+	commentKindStack      commentKind = "stack"
+	commentKindVisits     commentKind = "visits"
+	commentKindProtection commentKind = "protection"
+	commentKindScale      commentKind = "scale"
+	commentKindBudget     commentKind = "budget"
+	commentKindFailure    commentKind = "failure"
+	commentKindBulk       commentKind = "bulk"
+)
+
+// commandCommentKind returns the kind of the replies to the given command.
+func commandCommentKind(command string) commentKind {
+	return commentKind("command-" + strings.TrimPrefix(command, "/"))
+}
+
+// marker returns the hidden marker identifying comments of the kind.
+func (k commentKind) marker() string {
+	return fmt.Sprintf("<!-- reviewapps:%s -->", k)
+}
+
+// comment comments the given body of the given kind on the pull request of the review app.
+func (h *PRHandler) comment(ctx context.Context, ra *reviewApp, kind commentKind, body string) error {
+	return h.comments.comment(ctx, ra.client, commentTarget{owner: ra.owner, name: ra.name, number: ra.number}, kind, body)
+}
+
+// commenter comments on pull requests. Comments of the same kind replace each other, rapid updates
+// are batched and every pull request gets a limited amount of comment writes per hour, so noisy
+// deployments don't spam the timeline. Writes that exceed the limits are deferred and only the
+// latest body of every kind is eventually written.
+type commenter struct {
+	config CommentsConfig
+
+	mu  sync.Mutex
+	prs map[string]*prComments
+}
+
+// prComments is the comment state of a single pull request.
+type prComments struct {
+	// writeMu serializes the writes to the pull request, so there's never more than one comment of
+	// the same kind.
+	writeMu sync.Mutex
+
+	// The fields below are guarded by the commenter's mutex.
+	writes  []time.Time
+	last    map[commentKind]time.Time
+	ids     map[commentKind]int64
+	bodies  map[commentKind]string
+	pending map[commentKind]*pendingComment
+}
+
+// pendingComment is a deferred comment write.
+type pendingComment struct {
+	client *github.Client
+	logger zerolog.Logger
+	body   string
+}
+
+// newCommenter returns a new commenter with the given limits.
+func newCommenter(config CommentsConfig) *commenter {
+	return &commenter{config: config, prs: make(map[string]*prComments)}
+}
+
+// commentTarget identifies a pull request to comment on.
+type commentTarget struct {
+	owner, name string
+	number      int
+}
+
+func (t commentTarget) String() string {
+	return fmt.Sprintf("%s/%s#%d", t.owner, t.name, t.number)
+}
+
+// comment comments the given body of the given kind on the given pull request, replacing the
+// previous comment of the same kind. Errors of deferred writes are logged instead of returned.
+func (c *commenter) comment(ctx context.Context, client *github.Client, target commentTarget, kind commentKind, body string) error {
+	c.mu.Lock()
+	pc, ok := c.prs[target.String()]
+	if !ok {
+		pc = &prComments{
+			last:    make(map[commentKind]time.Time),
+			ids:     make(map[commentKind]int64),
+			bodies:  make(map[commentKind]string),
+			pending: make(map[commentKind]*pendingComment),
+		}
+		c.prs[target.String()] = pc
+	}
+	if pc.bodies[kind] == body && pc.pending[kind] == nil {
+		// Repeating the same comment is pointless.
+		c.mu.Unlock()
+		return nil
+	}
+
+	if delay := c.delay(pc, kind, time.Now()); delay > 0 {
+		if _, scheduled := pc.pending[kind]; !scheduled {
+			time.AfterFunc(delay, func() { c.flush(target, kind) })
+		}
+		pc.pending[kind] = &pendingComment{client: client, logger: *zerolog.Ctx(ctx), body: body}
+		c.mu.Unlock()
+		zerolog.Ctx(ctx).Debug().Str("kind", string(kind)).Dur("delay", delay).Msg("deferring comment")
+		return nil
+	}
+	// A newer comment supersedes a pending one whose timer hasn't fired yet.
+	delete(pc.pending, kind)
+	c.recordWrite(pc, kind, body, time.Now())
+	c.mu.Unlock()
+
+	return c.write(ctx, client, pc, target, kind, body)
+}
+
+// delay returns how long a write of a comment of the given kind has to be deferred to stay within
+// the limits. It must be called with the mutex held.
+func (c *commenter) delay(pc *prComments, kind commentKind, now time.Time) time.Duration {
+	var delay time.Duration
+	if last, ok := pc.last[kind]; ok {
+		delay = last.Add(c.config.GetMinInterval()).Sub(now)
+	}
+
+	window := now.Add(-time.Hour)
+	recent := pc.writes[:0]
+	for _, t := range pc.writes {
+		if t.After(window) {
+			recent = append(recent, t)
+		}
+	}
+	pc.writes = recent
+	if len(recent) >= c.config.GetMaxPerHour() {
+		if d := recent[0].Add(time.Hour).Sub(now); d > delay {
+			delay = d
+		}
+	}
+	return delay
+}
+
+// recordWrite records a write of the given comment. It must be called with the mutex held.
+func (c *commenter) recordWrite(pc *prComments, kind commentKind, body string, now time.Time) {
+	pc.writes = append(pc.writes, now)
+	pc.last[kind] = now
+	pc.bodies[kind] = body
+}
+
+// flush writes the pending comment of the given kind, or defers it again if it still exceeds the
+// limits.
+func (c *commenter) flush(target commentTarget, kind commentKind) {
+	c.mu.Lock()
+	pc := c.prs[target.String()]
+	p := pc.pending[kind]
+	if p == nil {
+		// The comment has been written in the meantime.
+		c.mu.Unlock()
+		return
+	}
+	if delay := c.delay(pc, kind, time.Now()); delay > 0 {
+		time.AfterFunc(delay, func() { c.flush(target, kind) })
+		c.mu.Unlock()
+		return
+	}
+	delete(pc.pending, kind)
+	c.recordWrite(pc, kind, p.body, time.Now())
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(p.logger.WithContext(context.Background()), 30*time.Second)
+	defer cancel()
+	if err := c.write(ctx, p.client, pc, target, kind, p.body); err != nil {
+		p.logger.Error().Err(err).Str("kind", string(kind)).Msg("failed to write deferred comment")
+	}
+}
+
+// write updates the comment of the given kind with the given body, or creates it if there is none.
+// Failed writes are forgotten, so the same body is written again next time.
+func (c *commenter) write(ctx context.Context, client *github.Client, pc *prComments, target commentTarget, kind commentKind, body string) error {
+	pc.writeMu.Lock()
+	defer pc.writeMu.Unlock()
+
+	id, err := c.upsert(ctx, client, pc, target, kind, body)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		delete(pc.bodies, kind)
+		return err
+	}
+	pc.ids[kind] = id
+	return nil
+}
+
+// upsert updates the comment of the given kind with the given body, or creates it if there is
+// none, and returns its ID. It must be called with the write mutex held.
+func (c *commenter) upsert(ctx context.Context, client *github.Client, pc *prComments, target commentTarget, kind commentKind, body string) (int64, error) {
+	c.mu.Lock()
+	id, known := pc.ids[kind]
+	c.mu.Unlock()
+
+	if !known {
+		var err error
+		id, err = findComment(ctx, client, target, kind)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	comment := &github.IssueComment{Body: ptr(body + "\n\n" + kind.marker())}
+	if id != 0 {
+		_, resp, err := client.Issues.EditComment(ctx, target.owner, target.name, id, comment)
+		if err == nil {
+			return id, nil
+		}
+		if resp == nil || resp.StatusCode != http.StatusNotFound {
+			return 0, githubError(err, "failed to update comment")
+		}
+		// The comment was deleted in the meantime.
+	}
+
+	created, _, err := client.Issues.CreateComment(ctx, target.owner, target.name, target.number, comment)
+	if err != nil {
+		return 0, githubError(err, "failed to comment on pull request")
+	}
+	return created.GetID(), nil
+}
+
+// findComment returns the ID of the latest comment of the bot of the given kind on the given pull
+// request, or 0 if there is none.
+func findComment(ctx context.Context, client *github.Client, target commentTarget, kind commentKind) (int64, error) {
+	var id int64
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := client.Issues.ListComments(ctx, target.owner, target.name, target.number, opts)
+		if err != nil {
+			return 0, githubError(err, "failed to list comments")
+		}
+		for _, comment := range comments {
+			// Users could copy the marker, but the bot can't update their comments anyway.
+			if comment.GetUser().GetType() == "Bot" && strings.Contains(comment.GetBody(), kind.marker()) {
+				id = comment.GetID()
+			}
+		}
+		if resp.NextPage == 0 {
+			return id, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
@@ -0,0 +1,56 @@
+package reviewapps
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/google/go-github/v60/github"
+)
+
+// dependsOnRegexp matches companion pull request references like "Depends-on: org/backend#42".
+var dependsOnRegexp = regexp.MustCompile(`(?mi)^depends-on:\s*([\w.-]+)/([\w.-]+)#(\d+)\s*$`)
+
+// companionRef references a companion pull request in another repository.
+type companionRef struct {
+	Owner  string
+	Repo   string
+	Number int
+}
+
+// parseCompanionRefs parses all companion pull request references from the given pull request
+// body.
+func parseCompanionRefs(body string) []companionRef {
+	var refs []companionRef
+	for _, m := range dependsOnRegexp.FindAllStringSubmatch(body, -1) {
+		num, err := strconv.Atoi(m[3])
+		if err != nil {
+			// Can't happen as the regex only matches digits.
+			continue
+		}
+		refs = append(refs, companionRef{Owner: m[1], Repo: m[2], Number: num})
+	}
+	return refs
+}
+
+// companionSources returns sources that point all components sourced from the repositories of
+// the companion pull requests referenced in the given body to the companion pull requests' head
+// branches.
+func companionSources(ctx context.Context, client *github.Client, body string) ([]SourceConfig, error) {
+	var sources []SourceConfig
+	for _, ref := range parseCompanionRefs(body) {
+		pr, _, err := client.PullRequests.Get(ctx, ref.Owner, ref.Repo, ref.Number)
+		if err != nil {
+			return nil, githubError(err, fmt.Sprintf("failed to get companion pull request %s/%s#%d", ref.Owner, ref.Repo, ref.Number))
+		}
+		if pr.GetBase().GetRepo().GetID() != pr.GetHead().GetRepo().GetID() {
+			return nil, fmt.Errorf("companion pull request %s/%s#%d is from a forked repository", ref.Owner, ref.Repo, ref.Number)
+		}
+		sources = append(sources, SourceConfig{
+			Repo:   pr.GetBase().GetRepo().GetFullName(),
+			Branch: pr.GetHead().GetRef(),
+		})
+	}
+	return sources, nil
+}
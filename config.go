@@ -1,10 +1,16 @@
-package main
+package reviewapps
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/rs/zerolog"
 	"gopkg.in/yaml.v2"
 )
 
@@ -12,28 +18,1880 @@ type Config struct {
 	Server       HTTPConfig         `yaml:"server"`
 	Github       githubapp.Config   `yaml:"github"`
 	DigitalOcean DigitalOceanConfig `yaml:"do"`
+	ReviewApps   ReviewAppConfig    `yaml:"review_apps"`
+	// Repos holds per-repository overrides of ReviewApps, keyed by the full name of the repository,
+	// i.e. "owner/name". Only the fields present in an override are changed.
+	Repos    map[string]map[string]interface{} `yaml:"repos"`
+	Plugins  []PluginConfig                    `yaml:"plugins"`
+	WarmPool WarmPoolConfig                    `yaml:"warm_pool"`
+	Backups  BackupConfig                      `yaml:"backups"`
+	// RefreshSchedule is the cron expression, in UTC, on which review apps with refreshes enabled
+	// are redeployed. Refreshes are disabled if empty.
+	RefreshSchedule string `yaml:"refresh_schedule"`
+	// RefreshWorkers is the maximum amount of review apps refreshed concurrently. Defaults to 4.
+	RefreshWorkers int `yaml:"refresh_workers"`
+	// GCSchedule is the cron expression, in UTC, on which stale GitHub deployments and environments
+	// of closed pull requests are deleted. Scheduled garbage collection is disabled if empty.
+	GCSchedule string `yaml:"gc_schedule"`
+	// OrphanSchedule is the cron expression, in UTC, on which apps of closed pull requests and of
+	// repositories the GitHub App was uninstalled from are deleted. Orphaned apps are kept if empty.
+	OrphanSchedule string `yaml:"orphan_schedule"`
+	// DriftSchedule is the cron expression, in UTC, on which review apps with drift detection
+	// enabled are checked for changes made outside of review apps. Drift detection is disabled if
+	// empty.
+	DriftSchedule string `yaml:"drift_schedule"`
+	// ReconcileSchedule is the cron expression, in UTC, on which the status of detached
+	// deployments is propagated once they finished and leftover DNS records of domains are swept.
+	// It's required if deployments are detached.
+	ReconcileSchedule string `yaml:"reconcile_schedule"`
+	// ExpirySchedule is the cron expression, in UTC, on which review apps are torn down once their
+	// TTL passed. Review apps never expire if empty.
+	ExpirySchedule string `yaml:"expiry_schedule"`
+	// ReminderSchedule is the cron expression, in UTC, on which pull requests waiting for approval
+	// are reminded about and escalated. Nobody is reminded if empty.
+	ReminderSchedule string `yaml:"reminder_schedule"`
+	// Canary configures a scheduled deployment monitoring the service itself.
+	Canary CanaryConfig `yaml:"canary"`
+	// Encryption configures the encryption of sensitive data stored at rest.
+	Encryption EncryptionConfig `yaml:"encryption"`
+	// Telemetry configures reporting anonymous usage statistics.
+	Telemetry TelemetryConfig `yaml:"telemetry"`
+	// Comments limits how often the bot comments on pull requests.
+	Comments CommentsConfig `yaml:"comments"`
+	// GithubClient configures the timeouts of requests to the GitHub API.
+	GithubClient GithubClientConfig `yaml:"github_client"`
+	// Retry configures retrying transient failures of requests to the GitHub and DigitalOcean APIs.
+	Retry RetryConfig `yaml:"retry"`
+	// DOWebhooks configures App Platform alerts notifying the bot about finished deployments.
+	DOWebhooks DOWebhooksConfig `yaml:"do_webhooks"`
+	// Gateway configures fronting the previews of review apps to count their visits.
+	Gateway GatewayConfig `yaml:"gateway"`
+	// Secrets are named secrets the configured environment variables of review apps can be set
+	// to. Values of the form "env:NAME" are read from the service's environment and values of the
+	// form "file:PATH" from a file when the configuration is read.
+	Secrets map[string]string `yaml:"secrets"`
+	// Tiers are named bundles of sizing, component pruning and database policies review apps can
+	// be deployed with, selected per repository with ReviewAppConfig.Tier or per pull request
+	// with a "preview:<tier>" label.
+	Tiers map[string]TierConfig `yaml:"tiers"`
+	// Notifications are the destinations repositories may route notifications about their review
+	// apps to.
+	Notifications NotificationsConfig `yaml:"notifications"`
+	// Rollouts limit features to a subset of repositories, keyed by feature, e.g. "forks".
+	Rollouts map[string]RolloutConfig `yaml:"rollouts"`
+	// Polling configures how deployments are polled until they finish.
+	Polling PollingConfig `yaml:"polling"`
+	// StateStore configures where the apps of review apps are recorded.
+	StateStore StateStoreConfig `yaml:"state_store"`
+	// Access limits which installations, organizations and repositories get review apps.
+	Access AccessConfig `yaml:"access"`
+	// Invalidation configures the endpoints called with the preview URLs of torn down review apps.
+	Invalidation InvalidationConfig `yaml:"invalidation"`
+	// Deliveries configures persisting webhook deliveries until they're handled.
+	Deliveries DeliveriesConfig `yaml:"deliveries"`
+	// MaxApps is the maximum amount of concurrently running review apps of all repositories'
+	// pull requests. Unlimited if zero. What happens to new review apps once it's hit is decided by
+	// the budget policy of their repository.
+	MaxApps int `yaml:"max_apps"`
+	// Throttling configures holding back background work while the DigitalOcean API fails a lot.
+	Throttling ThrottlingConfig `yaml:"throttling"`
+}
+
+// ThrottlingConfig configures holding back background work, like reconciler sweeps, scheduled
+// redeploys, drift detection and the collection of orphaned apps, while the error rate of requests
+// to the DigitalOcean API is high, so the deploys of pull requests get the remaining capacity.
+type ThrottlingConfig struct {
+	// ErrorRate is the fraction of requests within the window that failed with a 5xx, a 429 or a
+	// network error at which background work is held back. Disabled if zero.
+	ErrorRate float64 `yaml:"error_rate"`
+	// RecoveryRate is the fraction of failed requests within the window at which background work
+	// resumes. Defaults to half the error rate.
+	RecoveryRate float64 `yaml:"recovery_rate"`
+	// Window is how far back requests are counted. Defaults to 5 minutes.
+	Window time.Duration `yaml:"window"`
+	// MinRequests is the minimum amount of requests within the window for the error rate to hold
+	// back background work. Defaults to 20.
+	MinRequests int `yaml:"min_requests"`
+}
+
+// GetRecoveryRate returns the configured recovery rate or the default if none is configured.
+func (c ThrottlingConfig) GetRecoveryRate() float64 {
+	if c.RecoveryRate == 0 {
+		return c.ErrorRate / 2
+	}
+	return c.RecoveryRate
+}
+
+// GetWindow returns the configured window or the default if none is configured.
+func (c ThrottlingConfig) GetWindow() time.Duration {
+	if c.Window == 0 {
+		return 5 * time.Minute
+	}
+	return c.Window
+}
+
+// GetMinRequests returns the configured minimum amount of requests or the default if none is
+// configured.
+func (c ThrottlingConfig) GetMinRequests() int {
+	if c.MinRequests == 0 {
+		return 20
+	}
+	return c.MinRequests
+}
+
+// DeliveriesConfig configures persisting webhook deliveries in the state store before they're
+// handled, so crashes and outages of the GitHub and DigitalOcean APIs don't drop events. Persisted
+// deliveries are retried on such failures and replayed once the service restarts.
+type DeliveriesConfig struct {
+	// Persist persists webhook deliveries. It requires a state store other than "memory".
+	Persist bool `yaml:"persist"`
+	// MaxAttempts is the maximum amount of attempts of handling a delivery, including the first one
+	// and those before restarts. Defaults to 5.
+	MaxAttempts int `yaml:"max_attempts"`
+	// Backoff is the backoff after the first failed attempt, doubling with every further attempt.
+	// Defaults to 1 minute.
+	Backoff time.Duration `yaml:"backoff"`
+}
+
+// GetMaxAttempts returns the configured maximum amount of attempts or the default if none is
+// configured.
+func (c DeliveriesConfig) GetMaxAttempts() int {
+	if c.MaxAttempts == 0 {
+		return 5
+	}
+	return c.MaxAttempts
+}
+
+// GetBackoff returns the configured backoff or the default if none is configured.
+func (c DeliveriesConfig) GetBackoff() time.Duration {
+	if c.Backoff == 0 {
+		return time.Minute
+	}
+	return c.Backoff
+}
+
+// InvalidationConfig configures endpoints that are called with the preview URLs of review apps once
+// they're torn down, so external systems like CDNs, DNS caches or SSO providers stop serving or
+// trusting dead preview hosts.
+type InvalidationConfig struct {
+	Endpoints []InvalidationEndpoint `yaml:"endpoints"`
+}
+
+// InvalidationEndpoint is an endpoint the preview URLs of torn down review apps are POSTed to as
+// JSON.
+type InvalidationEndpoint struct {
+	// Name identifies the endpoint in logs and metrics.
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Headers are sent with every request, like an Authorization header. Values of the form
+	// "env:NAME" and "file:PATH" are resolved like secrets when the configuration is read.
+	Headers map[string]string `yaml:"headers"`
+}
+
+// AccessConfig limits which installations of the GitHub App, organizations and repositories get
+// review apps, so installing it on a large organization doesn't deploy every repository with an
+// app spec. Events of everything else are skipped. Everything is permitted if it's empty.
+type AccessConfig struct {
+	// Installations are the IDs of the installations whose repositories are permitted. All
+	// installations are permitted if it's empty.
+	Installations []int64 `yaml:"installations"`
+	// Allow are glob patterns of the full names of the permitted repositories, like "myorg/*", or
+	// of the names of the permitted organizations and users, like "myorg". All repositories are
+	// permitted if it's empty.
+	Allow []string `yaml:"allow"`
+	// Deny are glob patterns of repositories and organizations like Allow, which aren't permitted
+	// even if they're allowed.
+	Deny []string `yaml:"deny"`
+}
+
+// PollingConfig configures how deployments are polled until they finish.
+type PollingConfig struct {
+	// Adaptive tunes the poll interval and timeout per repository by the durations of its recent
+	// deployments, so repositories with slow builds aren't polled needlessly often.
+	Adaptive bool `yaml:"adaptive"`
+	// MinInterval is the shortest poll interval. Defaults to 2 seconds.
+	MinInterval time.Duration `yaml:"min_interval"`
+	// MaxInterval is the longest poll interval of adaptive polling. Defaults to 30 seconds.
+	MaxInterval time.Duration `yaml:"max_interval"`
+	// TimeoutFactor times out deployments of adaptive polling after this many times the longest
+	// recent deployment of the repository. Defaults to 3.
+	TimeoutFactor int `yaml:"timeout_factor"`
+	// MinTimeout is the shortest timeout of adaptive polling. Defaults to 10 minutes.
+	MinTimeout time.Duration `yaml:"min_timeout"`
+	// DeploymentTimeout is how long deployments may take unless adaptive polling derives a timeout
+	// from recent deployments. Deployments exceeding it are canceled. Defaults to 30 minutes.
+	DeploymentTimeout time.Duration `yaml:"deployment_timeout"`
+	// LiveURLTimeout is how long apps may take to get a live URL once their deployment is active.
+	// Defaults to 5 minutes.
+	LiveURLTimeout time.Duration `yaml:"live_url_timeout"`
+	// JobsTimeout is how long POST_DEPLOY jobs may take to finish once their deployment is active.
+	// Defaults to 10 minutes.
+	JobsTimeout time.Duration `yaml:"jobs_timeout"`
+}
+
+// GetMinInterval returns the configured minimum interval or the default if none is configured.
+func (c PollingConfig) GetMinInterval() time.Duration {
+	if c.MinInterval == 0 {
+		return 2 * time.Second
+	}
+	return c.MinInterval
+}
+
+// GetMaxInterval returns the configured maximum interval or the default if none is configured.
+func (c PollingConfig) GetMaxInterval() time.Duration {
+	if c.MaxInterval == 0 {
+		return 30 * time.Second
+	}
+	return c.MaxInterval
+}
+
+// GetTimeoutFactor returns the configured timeout factor or the default if none is configured.
+func (c PollingConfig) GetTimeoutFactor() int {
+	if c.TimeoutFactor == 0 {
+		return 3
+	}
+	return c.TimeoutFactor
+}
+
+// GetMinTimeout returns the configured minimum timeout or the default if none is configured.
+func (c PollingConfig) GetMinTimeout() time.Duration {
+	if c.MinTimeout == 0 {
+		return 10 * time.Minute
+	}
+	return c.MinTimeout
+}
+
+// GetDeploymentTimeout returns the configured deployment timeout or the default if none is
+// configured.
+func (c PollingConfig) GetDeploymentTimeout() time.Duration {
+	if c.DeploymentTimeout == 0 {
+		return 30 * time.Minute
+	}
+	return c.DeploymentTimeout
+}
+
+// GetJobsTimeout returns the configured jobs timeout or the default if none is configured.
+func (c PollingConfig) GetJobsTimeout() time.Duration {
+	if c.JobsTimeout == 0 {
+		return 10 * time.Minute
+	}
+	return c.JobsTimeout
+}
+
+// GetLiveURLTimeout returns the configured live URL timeout or the default if none is configured.
+func (c PollingConfig) GetLiveURLTimeout() time.Duration {
+	if c.LiveURLTimeout == 0 {
+		return 5 * time.Minute
+	}
+	return c.LiveURLTimeout
+}
+
+const (
+	stateStoreMemory = "memory"
+	stateStoreSpaces = "spaces"
+)
+
+// StateStoreConfig configures where the apps of review apps are recorded, in addition to the
+// payloads of their GitHub deployments.
+type StateStoreConfig struct {
+	// Type is either "memory" or "spaces". Defaults to "memory". SQL databases require a binary
+	// embedding the service with a driver, see Builder.WithStateStore.
+	Type string `yaml:"type"`
+	// Spaces is the bucket the apps are recorded in if the type is "spaces".
+	Spaces SpacesConfig `yaml:"spaces"`
+	// Prefix is the prefix of all objects in the bucket. Defaults to "reviewapps-state/".
+	Prefix string `yaml:"prefix"`
+}
+
+// GetType returns the configured type or the default if none is configured.
+func (c StateStoreConfig) GetType() string {
+	if c.Type == "" {
+		return stateStoreMemory
+	}
+	return c.Type
+}
+
+// GetPrefix returns the configured prefix or the default if none is configured.
+func (c StateStoreConfig) GetPrefix() string {
+	if c.Prefix == "" {
+		return "reviewapps-state/"
+	}
+	return c.Prefix
+}
+
+// CommentsConfig limits how often the bot comments on pull requests. Writes exceeding the limits
+// are deferred and batched.
+type CommentsConfig struct {
+	// MinInterval is the minimum time between two writes of the same comment. Defaults to 10
+	// seconds.
+	MinInterval time.Duration `yaml:"min_interval"`
+	// MaxPerHour is the maximum amount of comment writes per pull request and hour. Defaults to 20.
+	MaxPerHour int `yaml:"max_per_hour"`
+}
+
+// GetMinInterval returns the configured minimum interval or the default if none is configured.
+func (c CommentsConfig) GetMinInterval() time.Duration {
+	if c.MinInterval == 0 {
+		return 10 * time.Second
+	}
+	return c.MinInterval
+}
+
+// GetMaxPerHour returns the configured maximum or the default if none is configured.
+func (c CommentsConfig) GetMaxPerHour() int {
+	if c.MaxPerHour == 0 {
+		return 20
+	}
+	return c.MaxPerHour
+}
+
+// TelemetryConfig configures reporting anonymous usage statistics. Telemetry is off by default.
+type TelemetryConfig struct {
+	// Enabled enables reporting usage statistics.
+	Enabled bool `yaml:"enabled"`
+	// Endpoint is the URL reports are POSTed to.
+	Endpoint string `yaml:"endpoint"`
+	// Interval is how often usage is reported. Defaults to 24 hours.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// GetInterval returns the configured interval or the default if none is configured.
+func (c TelemetryConfig) GetInterval() time.Duration {
+	if c.Interval == 0 {
+		return 24 * time.Hour
+	}
+	return c.Interval
+}
+
+// DOWebhooksConfig configures App Platform alerts notifying the bot about finished deployments, so
+// deployments are polled less often.
+type DOWebhooksConfig struct {
+	// URL is the public URL of the bot's "/do-webhook" endpoint. Alerts are disabled if it's empty.
+	URL string `yaml:"url"`
+	// Secret signs the webhook URLs of the apps.
+	Secret string `yaml:"secret"`
+	// PollInterval is how often deployments are still polled in case alerts get lost. Defaults to
+	// 1 minute.
+	PollInterval time.Duration `yaml:"poll_interval"`
+}
+
+// GetPollInterval returns the configured poll interval or the default if none is configured.
+func (c DOWebhooksConfig) GetPollInterval() time.Duration {
+	if c.PollInterval == 0 {
+		return time.Minute
+	}
+	return c.PollInterval
+}
+
+// GatewayConfig configures fronting the previews of review apps with the bot, so their visits are
+// counted.
+type GatewayConfig struct {
+	// URL is the public URL of the bot previews are linked through. Previews link to the live URLs
+	// of review apps directly if it's empty.
+	URL string `yaml:"url"`
+}
+
+// GithubClientConfig configures the timeouts of requests to the GitHub API. Fetching contents and
+// creating deployments can take a lot longer than other calls, so they get their own deadlines.
+type GithubClientConfig struct {
+	// Timeout is the timeout of all requests but the ones below. Defaults to 3 seconds.
+	Timeout time.Duration `yaml:"timeout"`
+	// ContentsTimeout is the timeout of fetching the contents of files and workflow artifacts,
+	// e.g. app specs. Defaults to 30 seconds.
+	ContentsTimeout time.Duration `yaml:"contents_timeout"`
+	// DeploymentsTimeout is the timeout of creating deployments, which might merge the default
+	// branch first. Defaults to 15 seconds.
+	DeploymentsTimeout time.Duration `yaml:"deployments_timeout"`
+}
+
+// GetTimeout returns the configured timeout or the default if none is configured.
+func (c GithubClientConfig) GetTimeout() time.Duration {
+	if c.Timeout == 0 {
+		return 3 * time.Second
+	}
+	return c.Timeout
+}
+
+// GetContentsTimeout returns the configured contents timeout or the default if none is configured.
+func (c GithubClientConfig) GetContentsTimeout() time.Duration {
+	if c.ContentsTimeout == 0 {
+		return 30 * time.Second
+	}
+	return c.ContentsTimeout
+}
+
+// GetDeploymentsTimeout returns the configured deployments timeout or the default if none is
+// configured.
+func (c GithubClientConfig) GetDeploymentsTimeout() time.Duration {
+	if c.DeploymentsTimeout == 0 {
+		return 15 * time.Second
+	}
+	return c.DeploymentsTimeout
+}
+
+// RetryConfig configures retrying requests to the GitHub and DigitalOcean APIs that failed
+// transiently, like 502s or exhausted rate limits.
+type RetryConfig struct {
+	// MaxAttempts is the maximum amount of attempts of a request, including the first one.
+	// Defaults to 4. 1 disables retries.
+	MaxAttempts int `yaml:"max_attempts"`
+	// InitialBackoff is the backoff after the first attempt, doubling with every further attempt.
+	// Defaults to 500 milliseconds.
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	// MaxBackoff is the longest backoff. Requests whose rate limit resets later aren't retried.
+	// Defaults to 30 seconds.
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+	// Jitter is the fraction backoffs are randomly shortened or lengthened by. Defaults to 0.2.
+	Jitter *float64 `yaml:"jitter"`
+}
+
+// GetMaxAttempts returns the configured maximum amount of attempts or the default if none is
+// configured.
+func (c RetryConfig) GetMaxAttempts() int {
+	if c.MaxAttempts == 0 {
+		return 4
+	}
+	return c.MaxAttempts
+}
+
+// GetInitialBackoff returns the configured initial backoff or the default if none is configured.
+func (c RetryConfig) GetInitialBackoff() time.Duration {
+	if c.InitialBackoff == 0 {
+		return 500 * time.Millisecond
+	}
+	return c.InitialBackoff
+}
+
+// GetMaxBackoff returns the configured maximum backoff or the default if none is configured.
+func (c RetryConfig) GetMaxBackoff() time.Duration {
+	if c.MaxBackoff == 0 {
+		return 30 * time.Second
+	}
+	return c.MaxBackoff
+}
+
+// GetJitter returns the configured jitter or the default if none is configured.
+func (c RetryConfig) GetJitter() float64 {
+	if c.Jitter == nil {
+		return 0.2
+	}
+	return *c.Jitter
+}
+
+// maxDuration returns the longest a request whose attempts take at most the given duration each
+// takes including its retries.
+func (c RetryConfig) maxDuration(attempt time.Duration) time.Duration {
+	n := time.Duration(c.GetMaxAttempts())
+	return n*attempt + (n-1)*time.Duration(float64(c.GetMaxBackoff())*(1+c.GetJitter()))
+}
+
+// CheckRunsConfig configures reporting the progress of deployments as check runs, so branch
+// protection can require review apps to deploy successfully.
+type CheckRunsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Name is the name of the check run. Defaults to "Review app".
+	Name string `yaml:"name"`
+}
+
+// FailureLogsConfig configures attaching the tails of the logs of the components that failed to
+// failed deployments, so they can be debugged without access to the DigitalOcean console.
+type FailureLogsConfig struct {
+	// Comment comments on the pull request with the excerpts whenever a deployment fails.
+	Comment bool `yaml:"comment"`
+	// Lines is the amount of log lines attached per component. Defaults to 20.
+	Lines int `yaml:"lines"`
+}
+
+// GetLines returns the configured amount of lines or the default if none is configured.
+func (c FailureLogsConfig) GetLines() int {
+	if c.Lines == 0 {
+		return checkRunLogLines
+	}
+	return c.Lines
+}
+
+// GetName returns the configured name or the default if none is configured.
+func (c CheckRunsConfig) GetName() string {
+	if c.Name == "" {
+		return "Review app"
+	}
+	return c.Name
+}
+
+// DomainConfig configures the domains of review apps.
+type DomainConfig struct {
+	// Zone is a domain managed by DigitalOcean DNS review apps get a subdomain of, named after
+	// their app, like "myorg-frontend-42.preview.example.com". App Platform creates its DNS
+	// record and certificate. Review apps get no domain if it's empty.
+	Zone string `yaml:"zone"`
+}
+
+// domainZones returns the zones of the domains of review apps, globally and of all repositories.
+// GetRefreshWorkers returns the configured amount of refresh workers or the default if none is
+// configured.
+func (c *Config) GetRefreshWorkers() int {
+	if c.RefreshWorkers == 0 {
+		return 4
+	}
+	return c.RefreshWorkers
+}
+
+func (c *Config) domainZones() []string {
+	var zones []string
+	add := func(zone string) {
+		if zone != "" && !contains(zones, zone) {
+			zones = append(zones, zone)
+		}
+	}
+	add(c.ReviewApps.Domain.Zone)
+	for repo := range c.Repos {
+		if rc, err := c.ForRepo(repo); err == nil {
+			add(rc.Domain.Zone)
+		}
+	}
+	return zones
+}
+
+// DownscaleConfig configures running review apps at a fraction of the scale of their app spec.
+type DownscaleConfig struct {
+	// Enabled runs a single instance of every service, worker and job without autoscaling.
+	Enabled bool `yaml:"enabled"`
+	// InstanceSizes maps the instance sizes of the app spec to the smaller sizes review apps
+	// run on instead, e.g. "apps-d-1vcpu-1gb" to "apps-s-1vcpu-0.5gb". Unmapped sizes are kept.
+	InstanceSizes map[string]string `yaml:"instance_sizes"`
+}
+
+// TierConfig configures a tier of review apps.
+type TierConfig struct {
+	// InstanceSizeSlug is the instance size of all services, workers and jobs of the tier, which
+	// run a single instance without autoscaling. Sizes are kept if empty.
+	InstanceSizeSlug string `yaml:"instance_size_slug"`
+	// Components are the names of the components deployed in the tier. All others are pruned.
+	// All components are deployed if empty.
+	Components []string `yaml:"components"`
+	// Databases is the database policy of the tier, see DatabasesConfig.Policy. The policy of the
+	// repository applies if empty.
+	Databases string `yaml:"databases"`
+}
+
+// NamingConfig configures how review apps and their GitHub environments are named.
+type NamingConfig struct {
+	// Strategy is "slug" to name apps after their repository, like "<owner>-<repo>-<number>", the
+	// default, "hash" to name them after a hash of their repository, like "<prefix>-<hash>-<number>",
+	// "sequential" to name them after their pull request's number only, like "<prefix>-<number>",
+	// or the name of a strategy registered with Builder.WithNamer.
+	Strategy string `yaml:"strategy"`
+	// Prefix prefixes the names of the "hash" strategy, defaulting to "ra", and the "sequential"
+	// strategy, which requires a prefix unique to the repository.
+	Prefix string `yaml:"prefix"`
+}
+
+// GetStrategy returns the configured naming strategy or the default if none is configured.
+func (c NamingConfig) GetStrategy() string {
+	if c.Strategy == "" {
+		return namingSlug
+	}
+	return c.Strategy
+}
+
+// GetPrefix returns the configured prefix of the "hash" strategy or the default if none is
+// configured.
+func (c NamingConfig) GetPrefix() string {
+	if c.Prefix == "" {
+		return "ra"
+	}
+	return c.Prefix
+}
+
+// DatabasesConfig configures how review apps treat the managed databases of their app spec.
+type DatabasesConfig struct {
+	// Policy is "keep" to deploy the databases as they are, the default, "dev" to replace managed
+	// databases with dev databases, "none" to deploy no databases at all or "ephemeral" to
+	// provision a database cluster per review app and managed database, deleted on teardown.
+	Policy string `yaml:"policy"`
+	// Region is the region ephemeral database clusters are provisioned in, like "nyc3".
+	Region string `yaml:"region"`
+	// SizeSlug is the size of ephemeral database clusters. Defaults to "db-s-1vcpu-1gb".
+	SizeSlug string `yaml:"size_slug"`
+	// Timeout is how long to wait for ephemeral database clusters to come online. Defaults to 15
+	// minutes.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// GetPolicy returns the configured database policy or the default if none is configured.
+func (c DatabasesConfig) GetPolicy() string {
+	if c.Policy == "" {
+		return databasesKeep
+	}
+	return c.Policy
+}
+
+// GetSizeSlug returns the configured size of ephemeral database clusters or the default if none
+// is configured.
+func (c DatabasesConfig) GetSizeSlug() string {
+	if c.SizeSlug == "" {
+		return "db-s-1vcpu-1gb"
+	}
+	return c.SizeSlug
+}
+
+// GetTimeout returns the configured timeout of provisioning ephemeral database clusters or the
+// default if none is configured.
+func (c DatabasesConfig) GetTimeout() time.Duration {
+	if c.Timeout == 0 {
+		return 15 * time.Minute
+	}
+	return c.Timeout
+}
+
+// EnvConfig configures an environment variable set on every component of review apps.
+type EnvConfig struct {
+	// Value is the variable's value, templated with the review app's Repo, Owner, Name, Number,
+	// Branch, SHA and AppName, e.g. "https://pr-{{ .Number }}.preview.example.com".
+	Value string `yaml:"value"`
+	// Secret is the name of the service's secret the variable is set to as a SECRET. Secrets are
+	// never set for forked pull requests.
+	Secret string `yaml:"secret"`
+	// Scope is "RUN_TIME", "BUILD_TIME" or "RUN_AND_BUILD_TIME", the default.
+	Scope string `yaml:"scope"`
+}
+
+// GetScope returns the configured scope or the default if none is configured.
+func (c EnvConfig) GetScope() string {
+	if c.Scope == "" {
+		return "RUN_AND_BUILD_TIME"
+	}
+	return c.Scope
+}
+
+// LintConfig configures linting fetched app specs for unpinned images, missing health checks,
+// plaintext secrets and deprecated fields. Findings never keep review apps from being deployed.
+type LintConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Name is the name of the check run. Defaults to "App spec lint".
+	Name string `yaml:"name"`
+	// Rules overrides the annotation level of rules by name, i.e. "notice", "warning", "failure"
+	// or "off" to disable the rule. Findings at the "failure" level fail the check run.
+	Rules map[string]string `yaml:"rules"`
+}
+
+// GetName returns the configured name or the default if none is configured.
+func (c LintConfig) GetName() string {
+	if c.Name == "" {
+		return "App spec lint"
+	}
+	return c.Name
+}
+
+// CanaryConfig configures the canary, which deploys and deletes a known-good app spec end-to-end on
+// a schedule, so broken credentials or App Platform regressions are noticed before users do.
+type CanaryConfig struct {
+	// Schedule is the cron expression, in UTC, on which the canary runs. The canary is disabled if
+	// empty.
+	Schedule string `yaml:"schedule"`
+	// Repo is the full name of the repository the canary's app spec is read from, i.e.
+	// "owner/name". The app must be installed on it.
+	Repo string `yaml:"repo"`
+	// Spec is the location of the canary's app spec in the repository's default branch. Defaults to
+	// ".do/canary.yaml".
+	Spec string `yaml:"spec"`
+	// Timeout is how long a run may take until it's considered failed. Defaults to 15 minutes.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// GetSpec returns the configured spec location or the default if none is configured.
+func (c CanaryConfig) GetSpec() string {
+	if c.Spec == "" {
+		return ".do/canary.yaml"
+	}
+	return c.Spec
+}
+
+// GetTimeout returns the configured timeout or the default if none is configured.
+func (c CanaryConfig) GetTimeout() time.Duration {
+	if c.Timeout == 0 {
+		return 15 * time.Minute
+	}
+	return c.Timeout
+}
+
+// NotificationsConfig configures the destinations repositories may route notifications about their
+// review apps to. Repositories can only refer to these destinations by name, so they can't send
+// notifications anywhere else.
+type NotificationsConfig struct {
+	// Destinations are the allowed destinations, keyed by the name repositories refer to them by.
+	Destinations map[string]NotificationDestination `yaml:"destinations"`
+}
+
+// NotificationDestination is a destination of notifications about review apps. Exactly one of its
+// fields must be set.
+type NotificationDestination struct {
+	// SlackWebhook is the URL of a Slack incoming webhook App Platform sends alerts about
+	// finished deployments to.
+	SlackWebhook string `yaml:"slack_webhook"`
+	// SlackChannel is the channel of the Slack webhook.
+	SlackChannel string `yaml:"slack_channel"`
+	// Email is an email address App Platform sends alerts about finished deployments to. It must
+	// belong to a member of the team.
+	Email string `yaml:"email"`
+	// Webhook is a URL the lifecycle events of review apps are POSTed to as JSON.
+	Webhook string `yaml:"webhook"`
+}
+
+// EncryptionConfig configures the encryption of sensitive data stored at rest, like database
+// backups.
+type EncryptionConfig struct {
+	// Key is the base64 encoded 32 byte key data is encrypted with. Data is stored unencrypted if
+	// empty.
+	Key string `yaml:"key"`
+}
+
+// SpacesConfig configures access to a DigitalOcean Spaces bucket.
+type SpacesConfig struct {
+	// Endpoint is the endpoint of the Spaces API. Defaults to the endpoint of Region.
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+}
+
+// BackupConfig configures where dev databases of review apps are backed up to before they're
+// deleted. Backups are enabled per repository via ReviewAppConfig.BackupDatabases.
+type BackupConfig struct {
+	Spaces SpacesConfig `yaml:"spaces"`
+	// Prefix is the prefix of all backups in the bucket. Defaults to "reviewapps-backups".
+	Prefix string `yaml:"prefix"`
+	// Retention is how long backups are kept. Defaults to 7 days.
+	Retention time.Duration `yaml:"retention"`
+	// PGDump is the path to the pg_dump binary. Defaults to "pg_dump".
+	PGDump string `yaml:"pg_dump"`
+}
+
+// GetPrefix returns the configured prefix or the default if none is configured.
+func (c BackupConfig) GetPrefix() string {
+	if c.Prefix == "" {
+		return "reviewapps-backups"
+	}
+	return c.Prefix
+}
+
+// GetRetention returns the configured retention or the default if none is configured.
+func (c BackupConfig) GetRetention() time.Duration {
+	if c.Retention == 0 {
+		return 7 * 24 * time.Hour
+	}
+	return c.Retention
+}
+
+// GetPGDump returns the configured pg_dump binary or the default if none is configured.
+func (c BackupConfig) GetPGDump() string {
+	if c.PGDump == "" {
+		return "pg_dump"
+	}
+	return c.PGDump
+}
+
+// WarmPoolConfig configures the pool of pre-created apps that are updated rather than created from
+// scratch when a new pull request is opened.
+type WarmPoolConfig struct {
+	// Size is the amount of apps kept in the pool. The pool is disabled if zero.
+	Size int `yaml:"size"`
+	// Region is the region of the pool's apps. Only pull requests whose app spec has no or the same
+	// region are served from the pool.
+	Region string `yaml:"region"`
+	// NamePrefix is the prefix of the names of the pool's apps. Defaults to "reviewapps-pool".
+	NamePrefix string `yaml:"name_prefix"`
+}
+
+// GetNamePrefix returns the configured name prefix or the default if none is configured.
+func (c WarmPoolConfig) GetNamePrefix() string {
+	if c.NamePrefix == "" {
+		return "reviewapps-pool"
+	}
+	return c.NamePrefix
+}
+
+// ForRepo returns the ReviewAppConfig of the given repository, which is ReviewApps with the
+// repository's overrides applied.
+func (c *Config) ForRepo(repo string) (ReviewAppConfig, error) {
+	cfg, err := mergeReviewAppConfig(c.ReviewApps, c.Repos[repo])
+	if err != nil {
+		return ReviewAppConfig{}, err
+	}
+	applyRollouts(&cfg, c.Rollouts, repo)
+	return cfg, nil
 }
 
 type HTTPConfig struct {
 	Address string `yaml:"address"`
 	Port    int    `yaml:"port"`
+	// AdminToken authorizes requests to the admin endpoints under "/admin/", which are disabled if
+	// it's empty.
+	AdminToken string `yaml:"admin_token"`
+	// APIToken authorizes requests to the API under "/api/", which is disabled if it's empty.
+	APIToken string `yaml:"api_token"`
+	// CORSOrigins are the origins allowed to call the API from browsers. All origins are allowed
+	// if it's empty.
+	CORSOrigins []string `yaml:"cors_origins"`
 }
 
 type DigitalOceanConfig struct {
 	Token string `yaml:"token"`
+	// TokenFile is the path of a file holding the token, like a mounted secret, read on startup.
+	// Mutually exclusive with Token. Without either, the DO_TOKEN environment variable is used.
+	TokenFile string `yaml:"token_file"`
+	// AppLimit is the account's limit of apps, which isn't exposed by the API. New review apps
+	// aren't attempted to be created once it's reached. Unchecked if zero.
+	AppLimit int `yaml:"app_limit"`
 }
 
-func ReadConfig(path string) (*Config, error) {
-	var c Config
+// PluginConfig configures an out-of-process plugin.
+type PluginConfig struct {
+	Name string `yaml:"name"`
+	// Command is the executable and its arguments used to start the plugin.
+	Command []string `yaml:"command"`
+}
+
+// ReviewAppConfig configures how review apps are created for pull requests.
+type ReviewAppConfig struct {
+	Bots BotConfig `yaml:"bots"`
+	// TeardownLabels are labels that cause the review app of a pull request to be torn down when
+	// added, for example "stale" or "wontfix" as set by a stale-bot. The pull request itself stays
+	// open and won't get a new review app while it carries any of these labels.
+	TeardownLabels []string `yaml:"teardown_labels"`
+	// Sources point components of the app spec to different repositories or branches than the
+	// ones defined in the app spec.
+	Sources []SourceConfig `yaml:"sources"`
+	// Companions enables deploying the head branches of companion pull requests in other
+	// repositories, referenced as "Depends-on: owner/name#42" in the pull request's body, into the
+	// same review app.
+	Companions bool `yaml:"companions"`
+	// BackupDatabases enables backing up dev databases of review apps before they're deleted.
+	BackupDatabases bool `yaml:"backup_databases"`
+	// Task turns review apps into one-shot task previews. Only the jobs of the app spec are deployed,
+	// their outcome and logs are reported on the pull request and the app is deleted afterwards.
+	Task bool `yaml:"task"`
+	// Refresh configures scheduled redeploys of long-lived review apps.
+	Refresh RefreshConfig `yaml:"refresh"`
+	// TestMerge configures deploying GitHub's test merge commit instead of the pull request's head.
+	TestMerge TestMergeConfig `yaml:"test_merge"`
+	// Spec configures where the app spec comes from if it isn't committed to the repository.
+	Spec SpecConfig `yaml:"spec"`
+	// InlineSpecs allows maintainers to deploy an app spec posted in a "/deploy" comment.
+	InlineSpecs bool `yaml:"inline_specs"`
+	// PartialRedeploys skips redeploys for pushes that don't affect the source directory of any
+	// component.
+	PartialRedeploys bool `yaml:"partial_redeploys"`
+	// Paths filters the changed files of pushes that can affect the review app. Configuring any
+	// filter skips redeploys like PartialRedeploys does.
+	Paths PathsConfig `yaml:"paths"`
+	// SkipComments is the lowest log level of skipped events that are explained in a comment on
+	// the pull request, e.g. "info". Skipped events aren't commented on by default.
+	SkipComments string `yaml:"skip_comments"`
+	// Directives configures directives in the front matter of pull request descriptions.
+	Directives DirectivesConfig `yaml:"directives"`
+	// Branches are patterns of long-lived branches, like "develop" or "release/*", that get an
+	// always-on app updated on every push.
+	Branches []string `yaml:"branches"`
+	// Promotion configures the app review apps are promoted to with "/promote".
+	Promotion PromotionConfig `yaml:"promotion"`
+	// Domain configures giving review apps a subdomain of their own.
+	Domain DomainConfig `yaml:"domain"`
+	// Databases configures how review apps treat the managed databases of their app spec.
+	Databases DatabasesConfig `yaml:"databases"`
+	// Naming configures how review apps are named. Changing it renames review apps, which are
+	// recreated under their new name.
+	Naming NamingConfig `yaml:"naming"`
+	// Downscale keeps review apps from running at production scale.
+	Downscale DownscaleConfig `yaml:"downscale"`
+	// Tier is the name of the tier review apps are deployed with unless their pull request selects
+	// another one with a label. The app spec is deployed as is if it's empty.
+	Tier string `yaml:"tier"`
+	// Env are environment variables set on every component of review apps, keyed by their name.
+	// They replace variables of the same name defined by the app spec. The variables of a
+	// repository's overrides are added to the global ones.
+	Env map[string]EnvConfig `yaml:"env"`
+	// Annotate sets environment variables linking apps back to their pull request, branch and
+	// commit.
+	Annotate bool `yaml:"annotate"`
+	// StatusComment keeps a comment on the pull request up to date with the review app's status
+	// and live URL, in addition to its GitHub deployments.
+	StatusComment bool `yaml:"status_comment"`
+	// Drift configures detecting changes made to review apps outside of review apps.
+	Drift DriftConfig `yaml:"drift"`
+	// Stacks configures review apps of stacked pull requests, whose base branch is the head branch
+	// of another pull request.
+	Stacks StacksConfig `yaml:"stacks"`
+	// Expiry configures tearing down idle review apps.
+	Expiry ExpiryConfig `yaml:"expiry"`
+	// Protection configures protecting review apps from being torn down when idle.
+	Protection ProtectionConfig `yaml:"protection"`
+	// Budget caps the amount of concurrently running review apps of the repository.
+	Budget BudgetConfig `yaml:"budget"`
+	// Scale configures temporarily scaling up review apps with "/scale up".
+	Scale ScaleConfig `yaml:"scale"`
+	// Features controls which app-level features of app specs are deployed.
+	Features FeaturesConfig `yaml:"features"`
+	// Platform pins the build platform of review apps, so previews build like production does.
+	Platform PlatformConfig `yaml:"platform"`
+	// RerunRedeploys redeploys review apps when all checks of their pull request's head are re-run.
+	RerunRedeploys bool `yaml:"rerun_redeploys"`
+	// CheckRuns reports the progress of deployments as check runs on the pull request's head.
+	CheckRuns CheckRunsConfig `yaml:"check_runs"`
+	// FailureLogs configures the excerpts of logs attached to reports of failed deployments.
+	FailureLogs FailureLogsConfig `yaml:"failure_logs"`
+	// Lint reports violations of lint rules by fetched app specs as a check run on the pull
+	// request's head.
+	Lint LintConfig `yaml:"lint"`
+	// Deployments configures the GitHub deployments recording review apps.
+	Deployments DeploymentsConfig `yaml:"deployments"`
+	// DeployOnLabel only creates review apps for pull requests carrying this label, and tears them
+	// down when it's removed. Review apps are created for all pull requests if it's empty.
+	DeployOnLabel string `yaml:"deploy_on_label"`
+	// OnDemand only creates review apps when requested with "/deploy", instead of for every pull
+	// request. Pushes keep redeploying existing review apps.
+	OnDemand bool `yaml:"on_demand"`
+	// Wait is how the bot waits for deployments to finish. With "block", the default, it polls
+	// deployments until they finished. With "detach", it marks them as in progress and leaves
+	// propagating their status to the reconciler.
+	Wait string `yaml:"wait"`
+	// FallbackRegions are the regions review apps are created in, in order, if the region of their
+	// app spec lacks capacity.
+	FallbackRegions []string `yaml:"fallback_regions"`
+	// Forks configures review apps of pull requests from forked repositories, which are rejected
+	// by default.
+	Forks ForksConfig `yaml:"forks"`
+
+	// excluded are the features that aren't rolled out to the repository.
+	excluded map[string]bool
+}
+
+// GetWait returns the configured wait strategy or "block" if none is configured.
+func (c ReviewAppConfig) GetWait() string {
+	if c.Wait == "" {
+		return waitBlock
+	}
+	return c.Wait
+}
+
+// ForksConfig configures review apps of pull requests from forked repositories. Their code is
+// untrusted, so every commit has to be approved by a user with write access before it's deployed
+// and their app specs are sanitized.
+type ForksConfig struct {
+	// Enabled allows review apps of pull requests from forked repositories.
+	Enabled bool `yaml:"enabled"`
+	// ApprovalLabel is the label approving the head of a forked pull request for deployment.
+	// Defaults to "safe-to-deploy". It's removed from pull requests again when new commits are
+	// pushed.
+	ApprovalLabel string `yaml:"approval_label"`
+	// InstanceSizeSlug is the instance size used for all components of review apps of forked pull
+	// requests. Defaults to the smallest available size.
+	InstanceSizeSlug string `yaml:"instance_size_slug"`
+	// Reminders configures reminding about pull requests whose head is waiting for approval.
+	Reminders RemindersConfig `yaml:"reminders"`
+}
+
+// RemindersConfig configures reminding about pull requests waiting for approval on the reminder
+// schedule, so they don't silently stall without a review app.
+type RemindersConfig struct {
+	// Interval is how long a pull request waits for approval before it's reminded about, and
+	// between reminders. Nobody is reminded if zero.
+	Interval time.Duration `yaml:"interval"`
+	// EscalateAfter is how long a pull request waits for approval before it's escalated to the
+	// users and teams to escalate to. Pull requests are never escalated if zero.
+	EscalateAfter time.Duration `yaml:"escalate_after"`
+	// EscalateTo are the users and teams, like "my-org/my-team", mentioned on escalated pull
+	// requests.
+	EscalateTo []string `yaml:"escalate_to"`
+}
 
+// GetApprovalLabel returns the configured approval label or "safe-to-deploy" if none is
+// configured.
+func (c ForksConfig) GetApprovalLabel() string {
+	if c.ApprovalLabel == "" {
+		return "safe-to-deploy"
+	}
+	return c.ApprovalLabel
+}
+
+// GetInstanceSizeSlug returns the configured instance size or the smallest available size if
+// none is configured.
+func (c ForksConfig) GetInstanceSizeSlug() string {
+	if c.InstanceSizeSlug == "" {
+		return "apps-s-1vcpu-0.5gb"
+	}
+	return c.InstanceSizeSlug
+}
+
+// FeaturesConfig controls which app-level features, i.e. the "features" of the app spec, review apps
+// are deployed with, to give controlled access to platform features in previews.
+type FeaturesConfig struct {
+	// Enabled strips all features of app specs that are neither allowed nor forced.
+	Enabled bool `yaml:"enabled"`
+	// Allowed are the features that are kept if the app spec has them, e.g. "buildpack-stack=ubuntu-22".
+	Allowed []string `yaml:"allowed"`
+	// Forced are the features that every review app is deployed with.
+	Forced []string `yaml:"forced"`
+}
+
+// PlatformConfig pins the platform settings exposed in app specs, like the stack buildpacks run
+// on, so previews don't drift from production whenever App Platform changes its defaults.
+type PlatformConfig struct {
+	// BuildpackStack is the stack buildpacks run on, e.g. "ubuntu-22", replacing whichever stack the
+	// app spec selects.
+	BuildpackStack string `yaml:"buildpack_stack"`
+	// MatchApp is the name of an app, usually production, whose stack review apps are built on.
+	// Review apps are built on the default stack if it doesn't select one. Takes precedence over
+	// BuildpackStack.
+	MatchApp string `yaml:"match_app"`
+}
+
+// DeploymentsConfig configures the GitHub deployments recording review apps and promotions, whose
+// interplay with branch protection differs between organizations.
+type DeploymentsConfig struct {
+	// AutoMerge merges the default branch into the deployed ref first if it's behind, as GitHub's
+	// auto_merge option does.
+	AutoMerge bool `yaml:"auto_merge"`
+	// RequiredContexts are the status checks that must have passed on the deployed ref. All checks
+	// are skipped if empty.
+	RequiredContexts []string `yaml:"required_contexts"`
+	// Payload are additional fields of the deployment payload, e.g. for other tools consuming the
+	// deployments. They can't override the fields used by review apps.
+	Payload map[string]string `yaml:"payload"`
+	// PayloadVersion is the version of the schema of the payloads written, so bots can be upgraded
+	// gradually while older ones still read the payloads. Defaults to the latest version.
+	PayloadVersion *int `yaml:"payload_version"`
+}
+
+// GetPayloadVersion returns the configured payload version or the latest if none is configured.
+func (c DeploymentsConfig) GetPayloadVersion() int {
+	if c.PayloadVersion == nil {
+		return deploymentPayloadVersion
+	}
+	return *c.PayloadVersion
+}
+
+// DriftConfig configures detecting changes made to review apps outside of review apps, like manual
+// edits in the control panel. The schedule itself is configured globally.
+type DriftConfig struct {
+	// Enabled enables flagging drifted review apps on their pull request.
+	Enabled bool `yaml:"enabled"`
+	// Revert reverts the changes on the next deploy instead of redeploying the drifted spec.
+	Revert bool `yaml:"revert"`
+}
+
+// ProtectionConfig configures protecting review apps from being torn down when idle, e.g. while
+// they're demoed.
+type ProtectionConfig struct {
+	// Label protects the review apps of the pull requests carrying it. "/protect" adds it.
+	// Defaults to "protected".
+	Label string `yaml:"label"`
+}
+
+// GetLabel returns the configured protection label or the default if none is configured.
+func (c ProtectionConfig) GetLabel() string {
+	if c.Label == "" {
+		return "protected"
+	}
+	return c.Label
+}
+
+// PathsConfig filters the files changed by pushes that can affect review apps, e.g. in monorepos,
+// with globs like those of the ignore file.
+type PathsConfig struct {
+	// Include are the globs of the files affecting the review app. Defaults to the source
+	// directories and Dockerfiles of the app spec's components sourced from the repository.
+	Include []string `yaml:"include"`
+	// Exclude are the globs of the files never affecting the review app, even within source
+	// directories, e.g. "*.md" or "docs/".
+	Exclude []string `yaml:"exclude"`
+}
+
+// configured returns whether or not any path filter is configured.
+func (c PathsConfig) configured() bool {
+	return len(c.Include)+len(c.Exclude) > 0
+}
+
+// ScaleConfig configures temporarily scaling up the services and workers of review apps with
+// "/scale up", e.g. for load tests or demos. Scale-ups are reverted once they expire or with
+// "/scale down".
+type ScaleConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// InstanceSizes are the instance sizes review apps may be scaled up to. The first one is used
+	// if "/scale up" names none.
+	InstanceSizes []string `yaml:"instance_sizes"`
+	// MaxInstanceCount is the maximum number of instances per component. Defaults to 3.
+	MaxInstanceCount int `yaml:"max_instance_count"`
+	// Duration is how long scale-ups last if "/scale up" names no duration. Defaults to 1 hour.
+	Duration time.Duration `yaml:"duration"`
+	// MaxDuration is the maximum duration of scale-ups. Defaults to 8 hours.
+	MaxDuration time.Duration `yaml:"max_duration"`
+}
+
+// GetMaxInstanceCount returns the configured maximum instance count or the default if none is
+// configured.
+func (c ScaleConfig) GetMaxInstanceCount() int {
+	if c.MaxInstanceCount == 0 {
+		return 3
+	}
+	return c.MaxInstanceCount
+}
+
+// GetDuration returns the configured scale-up duration or the default if none is configured.
+func (c ScaleConfig) GetDuration() time.Duration {
+	if c.Duration == 0 {
+		return time.Hour
+	}
+	return c.Duration
+}
+
+// GetMaxDuration returns the configured maximum scale-up duration or the default if none is
+// configured.
+func (c ScaleConfig) GetMaxDuration() time.Duration {
+	if c.MaxDuration == 0 {
+		return 8 * time.Hour
+	}
+	return c.MaxDuration
+}
+
+// StacksConfig configures review apps of stacked pull requests.
+type StacksConfig struct {
+	// Mode is "top" to only deploy the top of stacks or "link" to deploy all of their pull
+	// requests and link their previews on each of them. Stacked pull requests are deployed like
+	// any other if it's empty.
+	Mode string `yaml:"mode"`
+}
+
+// BudgetConfig caps the amount of concurrently running review apps of a repository, and decides
+// what happens to new review apps once the cap of the repository or of all repositories is hit.
+type BudgetConfig struct {
+	// MaxApps is the maximum amount of review apps of the repository's pull requests. Unlimited if
+	// zero.
+	MaxApps int `yaml:"max_apps"`
+	// Policy is what happens to new review apps once a cap is hit: "queue" creates them once other
+	// review apps are torn down, "evict" tears down the review app that was idle for the longest to
+	// make room. Defaults to "queue".
+	Policy string `yaml:"policy"`
+}
+
+// GetPolicy returns the configured policy or "queue" if none is configured.
+func (c BudgetConfig) GetPolicy() string {
+	if c.Policy == "" {
+		return budgetPolicyQueue
+	}
+	return c.Policy
+}
+
+// ExpiryConfig configures tearing down idle review apps on the expiry schedule.
+type ExpiryConfig struct {
+	// TTL tears down review apps that haven't been deployed or kept with "/keep" for this long,
+	// e.g. "72h". The TTL of the repository's configuration takes precedence. Review apps never
+	// expire if it's zero.
+	TTL time.Duration `yaml:"ttl"`
+	// Unvisited tears down review apps whose preview wasn't visited through the gateway for this
+	// long, e.g. "48h". Review apps never expire for lack of visits if it's zero or the gateway
+	// isn't configured.
+	Unvisited time.Duration `yaml:"unvisited"`
+	// Warning is how long before the teardown a warning is commented on the pull request.
+	// Defaults to 24 hours.
+	Warning time.Duration `yaml:"warning"`
+}
+
+// GetWarning returns the configured warning period or the default if none is configured.
+func (c ExpiryConfig) GetWarning() time.Duration {
+	if c.Warning == 0 {
+		return 24 * time.Hour
+	}
+	return c.Warning
+}
+
+// PromotionConfig configures the app the spec of review apps is applied to with "/promote".
+type PromotionConfig struct {
+	// AppID is the ID of the app to promote to. Promotions are disabled if empty.
+	AppID string `yaml:"app_id"`
+	// Environment is the GitHub environment promotions are recorded in. Defaults to "staging".
+	Environment string `yaml:"environment"`
+}
+
+// GetEnvironment returns the configured environment or the default if none is configured.
+func (c PromotionConfig) GetEnvironment() string {
+	if c.Environment == "" {
+		return "staging"
+	}
+	return c.Environment
+}
+
+// DirectivesConfig configures directives in the front matter of pull request descriptions, which
+// override the app's environment, select a variant of the app spec or disable the review app.
+type DirectivesConfig struct {
+	// Enabled enables directives.
+	Enabled bool `yaml:"enabled"`
+	// OnEdit is what happens if the directives of a pull request are edited. With "reconcile", the
+	// review app is updated accordingly right away. With "ignore", edits are only picked up when
+	// the review app is created anew, e.g. when the pull request is reopened. Defaults to
+	// "reconcile".
+	OnEdit string `yaml:"on_edit"`
+}
+
+// GetOnEdit returns the configured handling of edits or the default if none is configured.
+func (c DirectivesConfig) GetOnEdit() string {
+	if c.OnEdit == "" {
+		return directivesOnEditReconcile
+	}
+	return c.OnEdit
+}
+
+// SpecConfig configures where the app spec comes from. By default, it's read from
+// ".do/app.yaml" of the pull request's branch. At most one of the sources can be configured.
+type SpecConfig struct {
+	// Command is a command that writes the app spec to stdout. The pull request's details are
+	// passed as REVIEWAPPS_* environment variables.
+	Command []string `yaml:"command"`
+	// Artifact is a GitHub Actions artifact containing the app spec.
+	Artifact ArtifactConfig `yaml:"artifact"`
+	// Submodule is the path of a submodule containing the app spec at ".do/app.yaml".
+	Submodule string `yaml:"submodule"`
+	// Timeout is how long to wait for the app spec to be generated. Defaults to 15 minutes.
+	Timeout time.Duration `yaml:"timeout"`
+	// Locations are the candidate locations of the app spec committed to the repository, which are
+	// tried in order. Defaults to ".do/app.yaml".
+	Locations []string `yaml:"locations"`
+	// Apps is a directory of the repository containing several app specs, like ".do/apps". Each
+	// "*.yaml" file in it is deployed as a review app of its own, named after the file.
+	Apps string `yaml:"apps"`
+}
+
+// GetLocations returns the configured spec locations or ".do/app.yaml" if none are configured.
+func (c SpecConfig) GetLocations() []string {
+	if len(c.Locations) == 0 {
+		return []string{canonicalAppSpecLocation}
+	}
+	return c.Locations
+}
+
+// GetTimeout returns the configured timeout or the default if none is configured.
+func (c SpecConfig) GetTimeout() time.Duration {
+	if c.Timeout == 0 {
+		return 15 * time.Minute
+	}
+	return c.Timeout
+}
+
+// ArtifactConfig configures a GitHub Actions artifact containing the app spec.
+type ArtifactConfig struct {
+	// Workflow is the file name of the workflow producing the artifact, e.g. "spec.yml".
+	Workflow string `yaml:"workflow"`
+	// Name is the name of the artifact.
+	Name string `yaml:"name"`
+	// File is the path of the app spec within the artifact. Defaults to "app.yaml".
+	File string `yaml:"file"`
+}
+
+// GetFile returns the configured file or the default if none is configured.
+func (c ArtifactConfig) GetFile() string {
+	if c.File == "" {
+		return "app.yaml"
+	}
+	return c.File
+}
+
+// TestMergeConfig configures deploying GitHub's test merge commit of a pull request with its base,
+// so previews reflect the result after merging.
+type TestMergeConfig struct {
+	// Enabled enables deploying the test merge commit.
+	Enabled bool `yaml:"enabled"`
+	// Fallback is one of "head" or "skip" and controls what happens if the pull request conflicts
+	// with its base. Defaults to "head".
+	Fallback string `yaml:"fallback"`
+}
+
+// GetFallback returns the configured fallback or the default fallback if none is configured.
+func (c TestMergeConfig) GetFallback() string {
+	if c.Fallback == "" {
+		return testMergeFallbackHead
+	}
+	return c.Fallback
+}
+
+// RefreshConfig configures scheduled redeploys of review apps, to keep long-lived previews from
+// drifting away from the base branch. The schedule itself is configured globally.
+type RefreshConfig struct {
+	// Enabled enables scheduled redeploys.
+	Enabled bool `yaml:"enabled"`
+	// UpdateBranch merges the base branch into the pull request's branch before redeploying, if the
+	// pull request is behind its base branch.
+	UpdateBranch bool `yaml:"update_branch"`
+	// MinAge is the minimum age of the latest deployment of a review app for it to be refreshed.
+	// Defaults to 24 hours.
+	MinAge time.Duration `yaml:"min_age"`
+}
+
+// GetMinAge returns the configured minimum age or the default if none is configured.
+func (c RefreshConfig) GetMinAge() time.Duration {
+	if c.MinAge == 0 {
+		return 24 * time.Hour
+	}
+	return c.MinAge
+}
+
+// SourceConfig rewrites the GitHub source of components of the app spec. Components sourced from
+// the pull request's repository are otherwise pointed to the pull request's branch and all other
+// components are left untouched.
+type SourceConfig struct {
+	// Component is the name of the component to rewrite. If empty, all components sourced from
+	// Repo are rewritten.
+	Component string `yaml:"component"`
+	// Repo is the full name of the repository to source the component from if Component is set
+	// and the repository to match components by otherwise.
+	Repo string `yaml:"repo"`
+	// Branch is the branch the components should be deployed from.
+	Branch string `yaml:"branch"`
+}
+
+// validate validates the configuration.
+func (c ReviewAppConfig) validate() error {
+	if c.Naming.Prefix != "" && !namingPrefixPattern.MatchString(c.Naming.Prefix) {
+		return fmt.Errorf("invalid naming prefix %q: must be at most 12 lowercase letters, digits and dashes, starting with a letter and not ending in a dash", c.Naming.Prefix)
+	}
+	if c.Naming.GetStrategy() == namingSequential && c.Naming.Prefix == "" {
+		return fmt.Errorf("naming strategy %q requires a prefix to be configured", namingSequential)
+	}
+
+	switch c.Databases.GetPolicy() {
+	case databasesKeep, databasesDev, databasesNone:
+	case databasesEphemeral:
+		if c.Databases.Region == "" {
+			return fmt.Errorf("database policy %q requires a region to be configured", databasesEphemeral)
+		}
+	default:
+		return fmt.Errorf("unknown database policy %q", c.Databases.Policy)
+	}
+
+	for _, glob := range append(append([]string{}, c.Paths.Include...), c.Paths.Exclude...) {
+		if _, err := globRegexp(glob, false); err != nil {
+			return fmt.Errorf("invalid path glob %q: %w", glob, err)
+		}
+	}
+
+	if c.Scale.Enabled {
+		if len(c.Scale.InstanceSizes) == 0 {
+			return errors.New("scaling requires instance sizes to be configured")
+		}
+		if c.Scale.MaxInstanceCount < 0 || c.Scale.Duration < 0 || c.Scale.MaxDuration < 0 {
+			return errors.New("scale limits must not be negative")
+		}
+		if c.Scale.GetDuration() > c.Scale.GetMaxDuration() {
+			return fmt.Errorf("scale duration %s exceeds the maximum duration %s", c.Scale.GetDuration(), c.Scale.GetMaxDuration())
+		}
+	}
+
+	switch c.Bots.GetPolicy() {
+	case botPolicyDeploy, botPolicySkip, botPolicySmall:
+	case botPolicyLabel:
+		if c.Bots.Label == "" {
+			return fmt.Errorf("bot policy %q requires a label to be configured", botPolicyLabel)
+		}
+	default:
+		return fmt.Errorf("unknown bot policy %q", c.Bots.Policy)
+	}
+
+	switch c.Stacks.Mode {
+	case "", stackModeTop, stackModeLink:
+	default:
+		return fmt.Errorf("unknown stack mode %q", c.Stacks.Mode)
+	}
+	if c.Expiry.TTL < 0 || c.Expiry.Unvisited < 0 || c.Expiry.Warning < 0 {
+		return errors.New("expiry ttl, unvisited and warning must not be negative")
+	}
+	switch c.Budget.GetPolicy() {
+	case budgetPolicyQueue, budgetPolicyEvict:
+	default:
+		return fmt.Errorf("unknown budget policy %q", c.Budget.Policy)
+	}
+	if c.Budget.MaxApps < 0 {
+		return errors.New("budget max_apps must not be negative")
+	}
+	if v := c.Deployments.GetPayloadVersion(); v < 0 || v > deploymentPayloadVersion {
+		return fmt.Errorf("deployment payload_version must be between 0 and %d", deploymentPayloadVersion)
+	}
+	if c.FailureLogs.Lines < 0 {
+		return errors.New("failure_logs lines must not be negative")
+	}
+	if r := c.Forks.Reminders; r.Interval < 0 || r.EscalateAfter < 0 {
+		return errors.New("reminder interval and escalate_after must not be negative")
+	} else if r.EscalateAfter != 0 && len(r.EscalateTo) == 0 {
+		return errors.New("escalating pull requests waiting for approval requires users or teams to escalate to")
+	}
+	for _, mention := range c.Forks.Reminders.EscalateTo {
+		if mention == "" || strings.ContainsAny(mention, "@ \t\n") || strings.Count(mention, "/") > 1 {
+			return fmt.Errorf("invalid user or team %q to escalate to", mention)
+		}
+	}
+
+	for key, env := range c.Env {
+		if (env.Value == "") == (env.Secret == "") {
+			return fmt.Errorf("environment variable %s needs either a value or a secret", key)
+		}
+		if _, err := parseEnvValue(key, env.Value); err != nil {
+			return err
+		}
+		switch env.GetScope() {
+		case "RUN_TIME", "BUILD_TIME", "RUN_AND_BUILD_TIME":
+		default:
+			return fmt.Errorf("unknown scope %q of environment variable %s", env.Scope, key)
+		}
+	}
+
+	for rule, level := range c.Lint.Rules {
+		if _, ok := lintDefaultLevels[rule]; !ok {
+			return fmt.Errorf("unknown lint rule %q", rule)
+		}
+		switch level {
+		case lintLevelNotice, lintLevelWarning, lintLevelFailure, lintLevelOff:
+		default:
+			return fmt.Errorf("unknown level %q of lint rule %q", level, rule)
+		}
+	}
+
+	for _, src := range c.Sources {
+		if src.Component == "" && src.Repo == "" {
+			return errors.New("sources need either a component or a repo")
+		}
+		if src.Branch == "" {
+			return errors.New("sources need a branch")
+		}
+	}
+
+	sources := 0
+	if len(c.Spec.Command) > 0 {
+		sources++
+	}
+	if c.Spec.Artifact.Workflow != "" {
+		sources++
+		if c.Spec.Artifact.Name == "" {
+			return errors.New("spec artifacts need a name")
+		}
+	}
+	if c.Spec.Submodule != "" {
+		sources++
+	}
+	if c.Spec.Apps != "" {
+		sources++
+		if path.IsAbs(c.Spec.Apps) || strings.HasPrefix(path.Clean(c.Spec.Apps), "..") {
+			return fmt.Errorf("invalid spec apps directory %q", c.Spec.Apps)
+		}
+		if c.Naming.GetStrategy() == namingSequential {
+			// Sequential names only depend on the pull request, so the apps' names would collide.
+			return fmt.Errorf("naming strategy %q can't be used with several app specs", namingSequential)
+		}
+	}
+	if sources > 1 {
+		return errors.New("only one of spec command, artifact, submodule and apps can be configured")
+	}
+	for _, location := range c.Spec.Locations {
+		if location == "" || path.IsAbs(location) || strings.HasPrefix(path.Clean(location), "..") {
+			return fmt.Errorf("invalid spec location %q", location)
+		}
+	}
+	if c.Forks.Enabled && (len(c.Spec.Command) > 0 || c.Spec.Submodule != "") {
+		// The command would run the fork's code and the submodule could point anywhere.
+		return errors.New("forks can't be enabled with a spec command or submodule")
+	}
+
+	switch c.TestMerge.GetFallback() {
+	case testMergeFallbackHead, testMergeFallbackSkip:
+	default:
+		return fmt.Errorf("unknown test merge fallback %q", c.TestMerge.Fallback)
+	}
+
+	for _, b := range c.Branches {
+		if _, err := globRegexp(b, true); err != nil {
+			return fmt.Errorf("invalid branch pattern %q: %w", b, err)
+		}
+	}
+
+	switch c.Directives.GetOnEdit() {
+	case directivesOnEditReconcile, directivesOnEditIgnore:
+	default:
+		return fmt.Errorf("unknown directives edit handling %q", c.Directives.OnEdit)
+	}
+
+	if c.SkipComments != "" {
+		if _, err := zerolog.ParseLevel(c.SkipComments); err != nil {
+			return fmt.Errorf("invalid skip comments level: %w", err)
+		}
+	}
+
+	switch c.GetWait() {
+	case waitBlock, waitDetach:
+	default:
+		return fmt.Errorf("unknown wait strategy %q", c.Wait)
+	}
+	return nil
+}
+
+// mergeReviewAppConfig applies the given overrides to the given base configuration. The base
+// configuration itself is not modified.
+func mergeReviewAppConfig(base ReviewAppConfig, overrides interface{}) (ReviewAppConfig, error) {
+	// Roundtrip through YAML to deep-copy the base configuration before applying the overrides.
+	bytes, err := yaml.Marshal(base)
+	if err != nil {
+		return ReviewAppConfig{}, fmt.Errorf("failed to marshal configuration: %w", err)
+	}
+	var merged ReviewAppConfig
+	if err := yaml.Unmarshal(bytes, &merged); err != nil {
+		return ReviewAppConfig{}, fmt.Errorf("failed to unmarshal configuration: %w", err)
+	}
+
+	if overrides == nil {
+		return merged, nil
+	}
+	bytes, err = yaml.Marshal(overrides)
+	if err != nil {
+		return ReviewAppConfig{}, fmt.Errorf("failed to marshal configuration overrides: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(bytes, &merged); err != nil {
+		return ReviewAppConfig{}, fmt.Errorf("failed to apply configuration overrides: %w", err)
+	}
+	return merged, nil
+}
+
+const (
+	// botPolicyDeploy treats bot-authored pull requests like any other pull request.
+	botPolicyDeploy = "deploy"
+	// botPolicySkip never creates review apps for bot-authored pull requests.
+	botPolicySkip = "skip"
+	// botPolicyLabel only creates review apps for bot-authored pull requests carrying a label.
+	botPolicyLabel = "label"
+	// botPolicySmall creates review apps for bot-authored pull requests with minimal sizing.
+	botPolicySmall = "small"
+)
+
+const (
+	// waitBlock polls deployments until they finished.
+	waitBlock = "block"
+	// waitDetach leaves propagating the status of deployments to the Reconciler.
+	waitDetach = "detach"
+)
+
+// BotConfig configures how pull requests authored by bots like dependabot or renovate are
+// handled. Those are usually numerous and rarely need a review app.
+type BotConfig struct {
+	// Policy is one of "deploy", "skip", "label" or "small". Defaults to "deploy".
+	Policy string `yaml:"policy"`
+	// Label is the label that has to be present on the pull request for the "label" policy.
+	Label string `yaml:"label"`
+	// InstanceSizeSlug is the instance size used for all components for the "small" policy.
+	InstanceSizeSlug string `yaml:"instance_size_slug"`
+	// Logins are the user logins that are considered bots. Defaults to dependabot and renovate.
+	Logins []string `yaml:"logins"`
+}
+
+// IsBot returns whether or not the given login is considered a bot.
+func (c BotConfig) IsBot(login string) bool {
+	logins := c.Logins
+	if len(logins) == 0 {
+		logins = []string{"dependabot[bot]", "renovate[bot]"}
+	}
+	return contains(logins, login)
+}
+
+// GetPolicy returns the configured policy or the default policy if none is configured.
+func (c BotConfig) GetPolicy() string {
+	if c.Policy == "" {
+		return botPolicyDeploy
+	}
+	return c.Policy
+}
+
+// GetInstanceSizeSlug returns the configured instance size or the smallest available size if
+// none is configured.
+func (c BotConfig) GetInstanceSizeSlug() string {
+	if c.InstanceSizeSlug == "" {
+		return "apps-s-1vcpu-0.5gb"
+	}
+	return c.InstanceSizeSlug
+}
+
+// ReadConfig reads the configuration file at the given path, see ParseConfig.
+func ReadConfig(path string) (*Config, error) {
 	bytes, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed reading server config file: %s: %w", path, err)
 	}
+	return ParseConfig(bytes)
+}
 
+// ParseConfig parses and validates the given configuration file. References to environment
+// variables in its values, like "${NAME}", are expanded and the REVIEWAPPS_* environment variables
+// that are set override its values.
+func ParseConfig(bytes []byte) (*Config, error) {
+	bytes, err := expandEnv(bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed expanding configuration file: %w", err)
+	}
+	var c Config
 	if err := yaml.UnmarshalStrict(bytes, &c); err != nil {
 		return nil, fmt.Errorf("failed parsing configuration file: %w", err)
 	}
+	if path := c.DigitalOcean.TokenFile; path != "" {
+		if c.DigitalOcean.Token != "" {
+			return nil, errors.New("invalid DigitalOcean configuration: token and token_file are mutually exclusive")
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DigitalOcean configuration: failed to read token file: %w", err)
+		}
+		c.DigitalOcean.Token = strings.TrimSpace(string(b))
+	}
+	if err := c.setValuesFromEnv(); err != nil {
+		return nil, fmt.Errorf("invalid configuration from environment: %w", err)
+	}
+	if err := setGithubURLs(&c.Github); err != nil {
+		return nil, fmt.Errorf("invalid GitHub configuration: %w", err)
+	}
+
+	if err := c.ReviewApps.validate(); err != nil {
+		return nil, fmt.Errorf("invalid review app configuration: %w", err)
+	}
+	if err := c.checkSecrets(c.ReviewApps); err != nil {
+		return nil, fmt.Errorf("invalid review app configuration: %w", err)
+	}
+	if err := c.checkTier(c.ReviewApps); err != nil {
+		return nil, fmt.Errorf("invalid review app configuration: %w", err)
+	}
+	for name, tier := range c.Tiers {
+		switch tier.Databases {
+		case "", databasesKeep, databasesDev, databasesNone, databasesEphemeral:
+		default:
+			return nil, fmt.Errorf("unknown database policy %q of tier %q", tier.Databases, name)
+		}
+	}
+	for name, value := range c.Secrets {
+		resolved, err := resolveSecret(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve secret %q: %w", name, err)
+		}
+		c.Secrets[name] = resolved
+	}
+	if c.RefreshSchedule != "" {
+		if _, err := parseCron(c.RefreshSchedule); err != nil {
+			return nil, fmt.Errorf("invalid refresh schedule: %w", err)
+		}
+	}
+	if c.RefreshWorkers < 0 {
+		return nil, errors.New("refresh_workers must not be negative")
+	}
+	if c.GCSchedule != "" {
+		if _, err := parseCron(c.GCSchedule); err != nil {
+			return nil, fmt.Errorf("invalid garbage collection schedule: %w", err)
+		}
+	}
+	if c.OrphanSchedule != "" {
+		if _, err := parseCron(c.OrphanSchedule); err != nil {
+			return nil, fmt.Errorf("invalid orphan schedule: %w", err)
+		}
+	}
+	if c.DriftSchedule != "" {
+		if _, err := parseCron(c.DriftSchedule); err != nil {
+			return nil, fmt.Errorf("invalid drift schedule: %w", err)
+		}
+	}
+	if c.ReconcileSchedule != "" {
+		if _, err := parseCron(c.ReconcileSchedule); err != nil {
+			return nil, fmt.Errorf("invalid reconcile schedule: %w", err)
+		}
+	} else {
+		if c.ReviewApps.GetWait() == waitDetach {
+			return nil, errors.New("detaching from deployments requires a reconcile schedule to be configured")
+		}
+		for repo := range c.Repos {
+			if rc, err := c.ForRepo(repo); err == nil && rc.GetWait() == waitDetach {
+				return nil, fmt.Errorf("detaching from deployments for repo %s requires a reconcile schedule to be configured", repo)
+			}
+		}
+		// Expired scale-ups are reverted by the reconciler.
+		if c.ReviewApps.Scale.Enabled {
+			return nil, errors.New("scaling requires a reconcile schedule to be configured")
+		}
+		for repo := range c.Repos {
+			if rc, err := c.ForRepo(repo); err == nil && rc.Scale.Enabled {
+				return nil, fmt.Errorf("scaling for repo %s requires a reconcile schedule to be configured", repo)
+			}
+		}
+	}
+	for _, pattern := range append(append([]string{}, c.Access.Allow...), c.Access.Deny...) {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return nil, fmt.Errorf("invalid access pattern %q", pattern)
+		}
+	}
+	invalidationEndpoints := make(map[string]bool)
+	for _, e := range c.Invalidation.Endpoints {
+		if e.Name == "" || invalidationEndpoints[e.Name] {
+			return nil, fmt.Errorf("invalidation endpoints need a unique name, got %q", e.Name)
+		}
+		invalidationEndpoints[e.Name] = true
+		if u, err := url.Parse(e.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid url %q of invalidation endpoint %q", e.URL, e.Name)
+		}
+		for key, value := range e.Headers {
+			resolved, err := resolveSecret(value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve header %s of invalidation endpoint %q: %w", key, e.Name, err)
+			}
+			e.Headers[key] = resolved
+		}
+	}
+	if err := validateRollouts(c.Rollouts); err != nil {
+		return nil, fmt.Errorf("invalid rollouts: %w", err)
+	}
+	if c.ExpirySchedule != "" {
+		if _, err := parseCron(c.ExpirySchedule); err != nil {
+			return nil, fmt.Errorf("invalid expiry schedule: %w", err)
+		}
+	}
+	if c.ReminderSchedule != "" {
+		if _, err := parseCron(c.ReminderSchedule); err != nil {
+			return nil, fmt.Errorf("invalid reminder schedule: %w", err)
+		}
+	}
+	if c.GithubClient.Timeout < 0 || c.GithubClient.ContentsTimeout < 0 || c.GithubClient.DeploymentsTimeout < 0 {
+		return nil, errors.New("GitHub client timeouts must not be negative")
+	}
+	if c.Retry.MaxAttempts < 0 || c.Retry.InitialBackoff < 0 || c.Retry.MaxBackoff < 0 {
+		return nil, errors.New("retry attempts and backoffs must not be negative")
+	}
+	if j := c.Retry.GetJitter(); j < 0 || j > 1 {
+		return nil, fmt.Errorf("retry jitter must be between 0 and 1, got %v", j)
+	}
+	if c.Canary.Schedule != "" {
+		if _, err := parseCron(c.Canary.Schedule); err != nil {
+			return nil, fmt.Errorf("invalid canary schedule: %w", err)
+		}
+		if owner, name, ok := strings.Cut(c.Canary.Repo, "/"); !ok || owner == "" || name == "" {
+			return nil, errors.New("the canary requires a repository of the form owner/name")
+		}
+	}
+	if c.Encryption.Key != "" {
+		if _, err := newSealer(c.Encryption.Key); err != nil {
+			return nil, fmt.Errorf("invalid encryption key: %w", err)
+		}
+	}
+	for name, d := range c.Notifications.Destinations {
+		set := 0
+		for _, v := range []string{d.SlackWebhook, d.Email, d.Webhook} {
+			if v != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return nil, fmt.Errorf("notification destination %q must have exactly one of slack_webhook, email and webhook", name)
+		}
+	}
+	if c.DOWebhooks.URL != "" && c.DOWebhooks.Secret == "" {
+		return nil, errors.New("DigitalOcean webhooks require a secret to be configured")
+	}
+	if c.Gateway.URL != "" {
+		if u, err := url.Parse(c.Gateway.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid gateway url %q", c.Gateway.URL)
+		}
+	}
+	if c.Telemetry.Enabled && c.Telemetry.Endpoint == "" {
+		return nil, errors.New("telemetry requires an endpoint to be configured")
+	}
+	if c.Polling.MinInterval < 0 || c.Polling.MaxInterval < 0 || c.Polling.TimeoutFactor < 0 || c.Polling.MinTimeout < 0 || c.Polling.DeploymentTimeout < 0 || c.Polling.LiveURLTimeout < 0 || c.Polling.JobsTimeout < 0 {
+		return nil, errors.New("polling settings must not be negative")
+	}
+	if c.Polling.GetMinInterval() > c.Polling.GetMaxInterval() {
+		return nil, errors.New("polling min_interval must not exceed max_interval")
+	}
+	switch c.StateStore.GetType() {
+	case stateStoreMemory:
+	case stateStoreSpaces:
+		if c.StateStore.Spaces.Bucket == "" {
+			return nil, errors.New("spaces state store requires a Spaces bucket to be configured")
+		}
+	default:
+		return nil, fmt.Errorf("unknown state store type %q", c.StateStore.Type)
+	}
+	if c.Deliveries.Persist && c.StateStore.GetType() == stateStoreMemory {
+		return nil, errors.New("persisting webhook deliveries requires a state store other than memory")
+	}
+	if c.MaxApps < 0 {
+		return nil, errors.New("max_apps must not be negative")
+	}
+	if c.Deliveries.MaxAttempts < 0 || c.Deliveries.Backoff < 0 {
+		return nil, errors.New("delivery max_attempts and backoff must not be negative")
+	}
+	if t := c.Throttling; t.ErrorRate < 0 || t.ErrorRate > 1 || t.RecoveryRate < 0 || t.RecoveryRate > t.ErrorRate {
+		return nil, errors.New("throttling error_rate must be between 0 and 1, and recovery_rate between 0 and error_rate")
+	}
+	if c.Throttling.Window < 0 || c.Throttling.MinRequests < 0 {
+		return nil, errors.New("throttling window and min_requests must not be negative")
+	}
+	if c.Backups.Spaces.Bucket == "" {
+		if c.ReviewApps.BackupDatabases {
+			return nil, errors.New("backing up databases requires a Spaces bucket to be configured")
+		}
+		for repo := range c.Repos {
+			if rc, err := c.ForRepo(repo); err == nil && rc.BackupDatabases {
+				return nil, fmt.Errorf("backing up databases for repo %s requires a Spaces bucket to be configured", repo)
+			}
+		}
+	}
+	if c.ReviewApps.Naming.GetStrategy() == namingSequential {
+		// A global prefix would be shared by all repositories.
+		return nil, fmt.Errorf("naming strategy %q can only be configured per repository", namingSequential)
+	}
+	// sequentialPrefixes are the repositories by their prefix of the "sequential" strategy.
+	sequentialPrefixes := make(map[string]string)
+	for repo := range c.Repos {
+		rc, err := c.ForRepo(repo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid review app configuration for repo %s: %w", repo, err)
+		}
+		if err := rc.validate(); err != nil {
+			return nil, fmt.Errorf("invalid review app configuration for repo %s: %w", repo, err)
+		}
+		if err := c.checkSecrets(rc); err != nil {
+			return nil, fmt.Errorf("invalid review app configuration for repo %s: %w", repo, err)
+		}
+		if err := c.checkTier(rc); err != nil {
+			return nil, fmt.Errorf("invalid review app configuration for repo %s: %w", repo, err)
+		}
+		if rc.Naming.GetStrategy() == namingSequential {
+			if other, ok := sequentialPrefixes[rc.Naming.Prefix]; ok {
+				return nil, fmt.Errorf("repos %s and %s share the naming prefix %q", other, repo, rc.Naming.Prefix)
+			}
+			sequentialPrefixes[rc.Naming.Prefix] = repo
+		}
+	}
 
 	return &c, nil
 }
+
+// checkTier checks that the given configuration only selects a configured tier and can provision
+// the databases of all tiers, as any of them can be selected by label.
+func (c *Config) checkTier(rc ReviewAppConfig) error {
+	if _, ok := c.Tiers[rc.Tier]; rc.Tier != "" && !ok {
+		return fmt.Errorf("unknown tier %q", rc.Tier)
+	}
+	for name, tier := range c.Tiers {
+		if tier.Databases == databasesEphemeral && rc.Databases.Region == "" {
+			return fmt.Errorf("database policy %q of tier %q requires a region to be configured", databasesEphemeral, name)
+		}
+	}
+	return nil
+}
+
+// checkSecrets checks that the environment variables of the given configuration only reference
+// configured secrets.
+func (c *Config) checkSecrets(rc ReviewAppConfig) error {
+	for key, env := range rc.Env {
+		if _, ok := c.Secrets[env.Secret]; env.Secret != "" && !ok {
+			return fmt.Errorf("environment variable %s references unknown secret %q", key, env.Secret)
+		}
+	}
+	return nil
+}
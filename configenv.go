@@ -0,0 +1,156 @@
+package reviewapps
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configEnvPrefix prefixes the environment variables overriding the configuration.
+const configEnvPrefix = "REVIEWAPPS_"
+
+// defaultConfig is the configuration used if no configuration file exists, documenting the
+// environment variables overriding it.
+//
+//go:embed config.default.yml
+var defaultConfig []byte
+
+// DefaultConfig returns the default configuration file, for running the service entirely from the
+// environment or as a starting point for a configuration file.
+func DefaultConfig() []byte {
+	return append([]byte(nil), defaultConfig...)
+}
+
+// setValuesFromEnv overrides the configuration with the REVIEWAPPS_* environment variables that
+// are set, so containers can be configured without a configuration file.
+func (c *Config) setValuesFromEnv() error {
+	values := map[string]*string{
+		"SERVER_ADDRESS":   &c.Server.Address,
+		"ADMIN_TOKEN":      &c.Server.AdminToken,
+		"API_TOKEN":        &c.Server.APIToken,
+		"DO_TOKEN":         &c.DigitalOcean.Token,
+		"STATE_STORE_TYPE": &c.StateStore.Type,
+		"ENCRYPTION_KEY":   &c.Encryption.Key,
+	}
+	for key, value := range values {
+		if v, ok := os.LookupEnv(configEnvPrefix + key); ok {
+			*value = v
+		}
+	}
+	if id, ok := os.LookupEnv(configEnvPrefix + "GITHUB_APP_INTEGRATION_ID"); ok {
+		// SetValuesFromEnv silently ignores invalid IDs.
+		if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+			return fmt.Errorf("invalid GitHub App integration ID %q", id)
+		}
+	}
+	c.Github.SetValuesFromEnv(configEnvPrefix)
+	if c.DigitalOcean.Token == "" {
+		// The variable the DigitalOcean tooling and platforms commonly inject the token as.
+		c.DigitalOcean.Token = os.Getenv("DO_TOKEN")
+	}
+
+	port, ok := os.LookupEnv(configEnvPrefix + "SERVER_PORT")
+	if !ok {
+		// App Platform tells services which port to listen on.
+		port, ok = os.LookupEnv("PORT")
+	}
+	if ok {
+		p, err := strconv.Atoi(port)
+		if err != nil || p <= 0 || p > 65535 {
+			return fmt.Errorf("invalid port %q", port)
+		}
+		c.Server.Port = p
+	}
+	return nil
+}
+
+// envReference matches references to environment variables, like "${NAME}", and escaped dollar
+// signs, i.e. "$${", in configuration values.
+var envReference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces the references to environment variables in the values of the given
+// configuration file with their values, so secrets can be injected by the platform rather than
+// checked into the file. Values are expanded after parsing the file, so whatever the variables hold
+// is taken verbatim. Referencing variables that aren't set is an error.
+func expandEnv(content []byte) ([]byte, error) {
+	if !bytes.Contains(content, []byte("${")) {
+		return content, nil
+	}
+	var tree interface{}
+	if err := yaml.Unmarshal(content, &tree); err != nil {
+		// Parsing the configuration reports the error.
+		return content, nil
+	}
+	expanded, err := expandEnvValue(tree)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(expanded)
+}
+
+// expandEnvValue expands the references to environment variables in the strings within the given
+// value of a parsed configuration file.
+func expandEnvValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return expandEnvString(v)
+	case map[interface{}]interface{}:
+		for key, value := range v {
+			expanded, err := expandEnvValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", key, err)
+			}
+			v[key] = expanded
+		}
+	case []interface{}:
+		for i, value := range v {
+			expanded, err := expandEnvValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", i, err)
+			}
+			v[i] = expanded
+		}
+	}
+	return v, nil
+}
+
+// expandEnvString expands the references to environment variables in the given string. Strings
+// consisting of a single reference to a number or boolean, like "${PORT}", become one, so they can
+// configure numeric and boolean fields, too.
+func expandEnvString(s string) (interface{}, error) {
+	var missing string
+	expanded := envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$${" {
+			return "${"
+		}
+		name := ref[2 : len(ref)-1]
+		v, ok := os.LookupEnv(name)
+		if !ok && missing == "" {
+			missing = name
+		}
+		return v
+	})
+	if missing != "" {
+		return nil, fmt.Errorf("environment variable %s is not set, escape the dollar sign as $$ to keep the reference", missing)
+	}
+
+	if m := envReference.FindStringSubmatchIndex(s); m != nil && m[0] == 0 && m[1] == len(s) && m[2] >= 0 {
+		var typed interface{}
+		if err := yaml.Unmarshal([]byte(expanded), &typed); err == nil {
+			switch typed.(type) {
+			case int, float64, bool:
+				// Only taken if it doesn't change the value, e.g. "yes" or "012" stay strings.
+				if b, err := yaml.Marshal(typed); err == nil && strings.TrimSpace(string(b)) == expanded {
+					return typed, nil
+				}
+			}
+		}
+	}
+	return expanded, nil
+}
@@ -0,0 +1,251 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+const (
+	// databasesKeep deploys the databases of the app spec as they are.
+	databasesKeep = "keep"
+	// databasesDev replaces the managed databases of the app spec with dev databases.
+	databasesDev = "dev"
+	// databasesNone deploys no databases at all.
+	databasesNone = "none"
+	// databasesEphemeral replaces the managed databases of the app spec with database clusters
+	// provisioned for the review app, which are deleted on teardown.
+	databasesEphemeral = "ephemeral"
+)
+
+const (
+	// ephemeralDatabaseTag tags all database clusters provisioned for review apps. Clusters are only
+	// ever deleted if they carry it.
+	ephemeralDatabaseTag = "app-platform-review-apps"
+	// ephemeralDatabaseAppTagPrefix prefixes the tag naming the review app a cluster belongs to.
+	ephemeralDatabaseAppTagPrefix = "review-app:"
+	// maxDatabaseNameLength is the maximum length of the names of database clusters.
+	maxDatabaseNameLength = 63
+)
+
+// ephemeralDatabasePollInterval is how often provisioned database clusters are checked for
+// whether they're online.
+var ephemeralDatabasePollInterval = 10 * time.Second
+
+// databaseEngines maps the engines of app spec databases to those of database clusters.
+var databaseEngines = map[godo.AppDatabaseSpecEngine]string{
+	godo.AppDatabaseSpecEngine_PG:      "pg",
+	godo.AppDatabaseSpecEngine_MySQL:   "mysql",
+	godo.AppDatabaseSpecEngine_Redis:   "redis",
+	godo.AppDatabaseSpecEngine_MongoDB: "mongodb",
+}
+
+// isManagedDatabase returns whether or not the given database of an app spec is a managed
+// database cluster, as opposed to a dev database.
+func isManagedDatabase(db *godo.AppDatabaseSpec) bool {
+	return db.Production || db.ClusterName != ""
+}
+
+// databasePolicy returns the database policy of the review app, which is the one of its tier if
+// that has one and the configured one otherwise.
+func (h *PRHandler) databasePolicy(ra *reviewApp) string {
+	if tier := h.tierOf(ra); tier != "" && h.config.Tiers[tier].Databases != "" {
+		return h.config.Tiers[tier].Databases
+	}
+	return ra.cfg.Databases.GetPolicy()
+}
+
+// applyDatabases applies the database policy of the review app to the given spec, so review apps
+// don't silently use the managed databases of production. Forks never keep the managed databases
+// of their app spec; they get dev databases instead, and those that can't be one are dropped. With
+// dryRun, no database clusters are provisioned and managed databases whose clusters don't exist
+// yet are left as they are.
+func (h *PRHandler) applyDatabases(ctx context.Context, spec *godo.AppSpec, ra *reviewApp, dryRun bool) error {
+	policy := h.databasePolicy(ra)
+	if ra.fork && policy == databasesKeep {
+		// Forks could attach any cluster of the account, exposing its credentials to their code.
+		policy = databasesDev
+	}
+	switch policy {
+	case databasesNone:
+		spec.Databases = nil
+	case databasesDev:
+		var dropped []string
+		spec.Databases = slices.DeleteFunc(spec.Databases, func(db *godo.AppDatabaseSpec) bool {
+			if ra.fork && isManagedDatabase(db) && db.Engine != godo.AppDatabaseSpecEngine_PG {
+				dropped = append(dropped, db.Name)
+				return true
+			}
+			return false
+		})
+		if len(dropped) > 0 {
+			ra.logger.Info().Strs("databases", dropped).Msg("dropping managed databases of forked pull request that can't be dev databases")
+		}
+		for _, db := range spec.Databases {
+			if !isManagedDatabase(db) {
+				continue
+			}
+			if db.Engine != godo.AppDatabaseSpecEngine_PG {
+				return errorf(ErrorKindSpecInvalid, "database %q can't be a dev database as its engine is %s", db.Name, db.Engine)
+			}
+			db.Production = false
+			db.ClusterName = ""
+			db.DBName = ""
+			db.DBUser = ""
+		}
+	case databasesEphemeral:
+		for _, db := range spec.Databases {
+			if !isManagedDatabase(db) {
+				continue
+			}
+			var cluster *godo.Database
+			var err error
+			if dryRun {
+				cluster, err = h.existingEphemeralDatabase(ctx, ra, db)
+			} else {
+				cluster, err = h.ephemeralDatabase(ctx, ra, db)
+			}
+			if err != nil {
+				return err
+			}
+			if cluster == nil {
+				continue
+			}
+			db.ClusterName = cluster.Name
+			db.Production = true
+			// The cluster's default database and user are used.
+			db.DBName = ""
+			db.DBUser = ""
+		}
+	default:
+		for _, db := range spec.Databases {
+			if db.ClusterName != "" {
+				ra.logger.Warn().Str("database", db.Name).Str("cluster", db.ClusterName).Msg("review app uses the managed database cluster of the app spec")
+			}
+		}
+	}
+	return nil
+}
+
+// ephemeralDatabaseName returns the name of the database cluster provisioned for the given
+// database of the given app.
+func ephemeralDatabaseName(appName, database string) string {
+	name := appName + "-" + strings.ToLower(database)
+	if len(name) > maxDatabaseNameLength {
+		name = name[:maxDatabaseNameLength]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+// ephemeralDatabase returns the database cluster provisioned for the given database of the review
+// app, provisioning it first if it doesn't exist yet. It waits for the cluster to be online, as
+// apps can't be deployed against clusters that are still being created.
+func (h *PRHandler) ephemeralDatabase(ctx context.Context, ra *reviewApp, db *godo.AppDatabaseSpec) (*godo.Database, error) {
+	name := ephemeralDatabaseName(ra.appName, db.Name)
+	cluster, err := h.existingEphemeralDatabase(ctx, ra, db)
+	if err != nil {
+		return nil, err
+	}
+
+	if cluster == nil {
+		engine := databaseEngines[db.Engine]
+		cfg := ra.cfg.Databases
+		ra.logger.Info().Str("database", db.Name).Str("cluster", name).Msg("provisioning database cluster")
+		cluster, _, err = h.do.Databases.Create(ctx, &godo.DatabaseCreateRequest{
+			Name:       name,
+			EngineSlug: engine,
+			Version:    db.Version,
+			SizeSlug:   cfg.GetSizeSlug(),
+			Region:     cfg.Region,
+			NumNodes:   1,
+			Tags:       []string{ephemeralDatabaseTag, ephemeralDatabaseAppTagPrefix + ra.appName},
+		})
+		if err != nil {
+			return nil, doError(err, fmt.Sprintf("failed to provision database cluster %s", name))
+		}
+	}
+
+	timeout := time.NewTimer(ra.cfg.Databases.GetTimeout())
+	defer timeout.Stop()
+	for cluster.Status != "online" {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timeout.C:
+			return nil, fmt.Errorf("database cluster %s didn't come online within %s", name, ra.cfg.Databases.GetTimeout())
+		case <-time.After(ephemeralDatabasePollInterval):
+		}
+		cluster, _, err = h.do.Databases.Get(ctx, cluster.ID)
+		if err != nil {
+			return nil, doError(err, fmt.Sprintf("failed to get database cluster %s", name))
+		}
+	}
+	return cluster, nil
+}
+
+// existingEphemeralDatabase returns the database cluster provisioned for the given database of the
+// review app, or nil if there is none yet. It fails if the database can't be provisioned at all.
+func (h *PRHandler) existingEphemeralDatabase(ctx context.Context, ra *reviewApp, db *godo.AppDatabaseSpec) (*godo.Database, error) {
+	if _, ok := databaseEngines[db.Engine]; !ok {
+		return nil, errorf(ErrorKindSpecInvalid, "database %q can't be provisioned as its engine is %s", db.Name, db.Engine)
+	}
+	name := ephemeralDatabaseName(ra.appName, db.Name)
+	clusters, err := listDatabases(ctx, h.do)
+	if err != nil {
+		return nil, err
+	}
+	for i := range clusters {
+		if clusters[i].Name == name && slices.Contains(clusters[i].Tags, ephemeralDatabaseTag) {
+			return &clusters[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// cleanupDatabases deletes the database clusters provisioned for the torn down review app.
+// Clusters that are already gone aren't an error.
+func (h *PRHandler) cleanupDatabases(ctx context.Context, ra *reviewApp) error {
+	clusters, err := listDatabases(ctx, h.do)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, cluster := range clusters {
+		if !slices.Contains(cluster.Tags, ephemeralDatabaseTag) || !slices.Contains(cluster.Tags, ephemeralDatabaseAppTagPrefix+ra.appName) {
+			continue
+		}
+		ra.logger.Info().Str("cluster", cluster.Name).Msg("deleting database cluster")
+		if resp, err := h.do.Databases.Delete(ctx, cluster.ID); err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+			errs = append(errs, doError(err, fmt.Sprintf("failed to delete database cluster %s", cluster.Name)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// listDatabases lists all database clusters of the account.
+func listDatabases(ctx context.Context, do *godo.Client) ([]godo.Database, error) {
+	var all []godo.Database
+	opts := &godo.ListOptions{PerPage: 200}
+	for {
+		clusters, resp, err := do.Databases.List(ctx, opts)
+		if err != nil {
+			return nil, doError(err, "failed to list database clusters")
+		}
+		all = append(all, clusters...)
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			return all, nil
+		}
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current page: %w", err)
+		}
+		opts.Page = page + 1
+	}
+}
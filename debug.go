@@ -0,0 +1,242 @@
+package reviewapps
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// debugCaptureSize is the amount of exchanges with the DigitalOcean API kept per pull request.
+	debugCaptureSize = 100
+	// maxDebugCaptureBody is the maximum length of captured request and response bodies.
+	maxDebugCaptureBody = 64 << 10
+	// redacted replaces sensitive values in captured exchanges.
+	redacted = "REDACTED"
+)
+
+// sensitiveKey matches the keys of JSON fields whose values are redacted from captured bodies.
+var sensitiveKey = regexp.MustCompile(`(?i)(token|password|secret|credentials?|private_key|^uri$)`)
+
+// debugExchange is a sanitized request to the DigitalOcean API and its response.
+type debugExchange struct {
+	Time       time.Time       `json:"time"`
+	Method     string          `json:"method"`
+	URL        string          `json:"url"`
+	Request    json.RawMessage `json:"request,omitempty"`
+	StatusCode int             `json:"status_code,omitempty"`
+	Response   json.RawMessage `json:"response,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Duration   string          `json:"duration"`
+}
+
+// debugCaptureResponse is the body of responses downloading the captured exchanges of a pull
+// request.
+type debugCaptureResponse struct {
+	Repo        string `json:"repo"`
+	PullRequest int    `json:"pull_request"`
+	// Since is when capturing was enabled.
+	Since     time.Time       `json:"since"`
+	Exchanges []debugExchange `json:"exchanges"`
+}
+
+// debugCaptures records the exchanges with the DigitalOcean API made on behalf of pull requests
+// capturing is enabled for, each in a ring buffer, so provider-side issues reported by users can
+// be debugged. Capturing is enabled and the exchanges are downloaded via the admin API.
+type debugCaptures struct {
+	adminToken string
+
+	mu    sync.Mutex
+	rings map[turnKey]*debugRing
+}
+
+// debugRing holds the latest exchanges of a pull request.
+type debugRing struct {
+	since     time.Time
+	exchanges []debugExchange
+	// next is the index the next exchange is recorded at once the ring is full.
+	next int
+}
+
+func newDebugCaptures(adminToken string) *debugCaptures {
+	return &debugCaptures{adminToken: adminToken, rings: make(map[turnKey]*debugRing)}
+}
+
+// debugCaptureKey is the context key of the pull request on whose behalf requests are made.
+type debugCaptureKey struct{}
+
+// withDebugCapture returns a context whose requests to the DigitalOcean API are captured if
+// capturing is enabled for the review app's pull request.
+func withDebugCapture(ctx context.Context, ra *reviewApp) context.Context {
+	if ra.number == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, debugCaptureKey{}, turnKey{repo: ra.repo.GetFullName(), number: ra.number})
+}
+
+// record records the given exchange if capturing is enabled for the given pull request.
+func (c *debugCaptures) record(key turnKey, e debugExchange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ring, ok := c.rings[key]
+	if !ok {
+		return
+	}
+	if len(ring.exchanges) < debugCaptureSize {
+		ring.exchanges = append(ring.exchanges, e)
+		return
+	}
+	ring.exchanges[ring.next] = e
+	ring.next = (ring.next + 1) % debugCaptureSize
+}
+
+// capturing returns whether or not capturing is enabled for the given pull request.
+func (c *debugCaptures) capturing(key turnKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.rings[key]
+	return ok
+}
+
+// transport wraps the given transport of the DigitalOcean client to capture its exchanges.
+func (c *debugCaptures) transport(next http.RoundTripper) http.RoundTripper {
+	return &debugTransport{next: next, captures: c}
+}
+
+// debugTransport captures the exchanges of requests made on behalf of pull requests capturing is
+// enabled for.
+type debugTransport struct {
+	next     http.RoundTripper
+	captures *debugCaptures
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, ok := req.Context().Value(debugCaptureKey{}).(turnKey)
+	if !ok || !t.captures.capturing(key) {
+		return t.next.RoundTrip(req)
+	}
+
+	e := debugExchange{Time: time.Now().UTC(), Method: req.Method, URL: req.URL.String()}
+	if req.Body != nil && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			b, _ := io.ReadAll(body)
+			body.Close()
+			e.Request = sanitizeBody(b)
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	e.Duration = time.Since(e.Time).String()
+	if err != nil {
+		e.Error = err.Error()
+		t.captures.record(key, e)
+		return resp, err
+	}
+	e.StatusCode = resp.StatusCode
+	b, readErr := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	// The response is read entirely, so it's handed on from memory.
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	if readErr != nil {
+		e.Error = readErr.Error()
+	}
+	e.Response = sanitizeBody(b)
+	t.captures.record(key, e)
+	return resp, readErr
+}
+
+// sanitizeBody returns the given JSON body with the values of sensitive fields and secret
+// environment variables redacted. Bodies that aren't JSON or too long are replaced by a note, as
+// they can't be sanitized or would bloat the ring buffer.
+func sanitizeBody(b []byte) json.RawMessage {
+	if len(b) == 0 {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return json.RawMessage(strconv.Quote("<" + strconv.Itoa(len(b)) + " bytes of non-JSON content>"))
+	}
+	sanitized, err := json.Marshal(redact(v))
+	if err != nil {
+		return nil
+	}
+	if len(sanitized) > maxDebugCaptureBody {
+		return json.RawMessage(strconv.Quote("<" + strconv.Itoa(len(sanitized)) + " bytes, truncated>"))
+	}
+	return sanitized
+}
+
+// redact redacts the values of sensitive fields and secret environment variables in the given
+// decoded JSON value.
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		secret := v["type"] == "SECRET"
+		for k, field := range v {
+			if _, isString := field.(string); isString && (sensitiveKey.MatchString(k) || (secret && k == "value")) {
+				v[k] = redacted
+				continue
+			}
+			v[k] = redact(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redact(item)
+		}
+	}
+	return v
+}
+
+// ServeHTTP serves the admin API of the captures under "/admin/debug/{owner}/{repo}/{number}".
+// POSTs enable capturing for the pull request, GETs download its captured exchanges as JSON and
+// DELETEs disable capturing and drop them. Requests must be authorized with the admin token as
+// bearer token.
+func (c *debugCaptures) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, c.adminToken) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	number, err := strconv.Atoi(r.PathValue("number"))
+	if err != nil || number <= 0 {
+		http.Error(w, "invalid pull request number", http.StatusBadRequest)
+		return
+	}
+	key := turnKey{repo: r.PathValue("owner") + "/" + r.PathValue("repo"), number: number}
+	logger := zerolog.Ctx(r.Context()).With().Str("component", "debug").Str("repo", key.repo).Int("pull_request", number).Logger()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch r.Method {
+	case http.MethodPost:
+		if _, ok := c.rings[key]; !ok {
+			logger.Info().Msg("capturing DigitalOcean API requests")
+			c.rings[key] = &debugRing{since: time.Now().UTC()}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		ring, ok := c.rings[key]
+		if !ok {
+			http.Error(w, "capturing is not enabled for the pull request", http.StatusNotFound)
+			return
+		}
+		// The oldest exchange is the next to be overwritten.
+		exchanges := append(append([]debugExchange{}, ring.exchanges[ring.next:]...), ring.exchanges[:ring.next]...)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(debugCaptureResponse{Repo: key.repo, PullRequest: key.number, Since: ring.since, Exchanges: exchanges})
+	case http.MethodDelete:
+		logger.Info().Msg("no longer capturing DigitalOcean API requests")
+		delete(c.rings, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
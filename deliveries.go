@@ -0,0 +1,233 @@
+package reviewapps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/rs/zerolog"
+	"github.internal.digitalocean.com/mthoemmes/reviewapps/store"
+)
+
+// deliveriesKeyRepo is the repository of the state store keys persisted deliveries are recorded
+// under, one per delivery. Repository names always contain a slash, so the keys never collide with
+// those of apps.
+const deliveriesKeyRepo = "deliveries"
+
+// persistedDelivery is a webhook delivery persisted until it's handled.
+type persistedDelivery struct {
+	EventType string `json:"event_type"`
+	Payload   []byte `json:"payload"`
+	// Attempts is the amount of attempts of handling the delivery that were started.
+	Attempts   int       `json:"attempts"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// deliveryScheduler is a scheduler handling webhook deliveries asynchronously, which persists them
+// in the state store first and only forgets them once they're handled. Deliveries failing due to the
+// GitHub or DigitalOcean APIs are retried with backoff, and deliveries that weren't handled before
+// the service stopped are replayed once it restarts. Handlers are idempotent, so replaying a
+// delivery that was partially handled is safe.
+type deliveryScheduler struct {
+	store    deliveryStore
+	config   DeliveriesConfig
+	handlers []githubapp.EventHandler
+
+	// mu serializes checking whether deliveries are pending with persisting them.
+	mu sync.Mutex
+}
+
+// deliveryStore is a state store deliveries can be persisted in, as pending deliveries are found by
+// listing their keys.
+type deliveryStore interface {
+	store.Store
+	store.Lister
+}
+
+// newDeliveryScheduler returns a scheduler persisting the deliveries of the given handlers in the
+// given store. Stores that don't survive restarts or can't list their keys are refused.
+func newDeliveryScheduler(st store.Store, config DeliveriesConfig, handlers []githubapp.EventHandler) (*deliveryScheduler, error) {
+	if _, ok := st.(*store.Memory); ok {
+		return nil, errors.New("persisting webhook deliveries requires a state store other than memory")
+	}
+	ds, ok := st.(deliveryStore)
+	if !ok {
+		return nil, errors.New("persisting webhook deliveries requires a state store that can list its keys")
+	}
+	return &deliveryScheduler{store: ds, config: config, handlers: handlers}, nil
+}
+
+// Schedule implements githubapp.Scheduler. Redeliveries of deliveries that are still being handled
+// are ignored. Deliveries that can't be persisted are still handled, just without surviving a
+// restart.
+func (s *deliveryScheduler) Schedule(ctx context.Context, d githubapp.Dispatch) error {
+	delivery := &persistedDelivery{EventType: d.EventType, Payload: d.Payload, ReceivedAt: time.Now().UTC()}
+	if pending, err := s.persist(ctx, d.DeliveryID, delivery); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("delivery_id", d.DeliveryID).Msg("failed to persist webhook delivery")
+	} else if pending {
+		zerolog.Ctx(ctx).Info().Str("delivery_id", d.DeliveryID).Msg("ignoring redelivery of webhook delivery that is still being handled")
+		return nil
+	}
+	go s.handle(githubapp.DefaultContextDeriver(ctx), d.Handler, d.DeliveryID, delivery)
+	return nil
+}
+
+// replay handles all deliveries that were persisted but not handled before the service restarted.
+func (s *deliveryScheduler) replay(ctx context.Context) {
+	logger := zerolog.Ctx(ctx).With().Str("component", "deliveries").Logger()
+
+	ids, err := s.pending(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to look up persisted webhook deliveries")
+		return
+	}
+	for _, id := range ids {
+		value, err := s.store.Get(ctx, deliveryKey(id))
+		if errors.Is(err, store.ErrNotFound) {
+			s.forget(ctx, id)
+			continue
+		} else if err != nil {
+			logger.Error().Err(err).Str("delivery_id", id).Msg("failed to look up persisted webhook delivery")
+			continue
+		}
+		var delivery persistedDelivery
+		if err := json.Unmarshal([]byte(value), &delivery); err != nil {
+			logger.Warn().Err(err).Str("delivery_id", id).Msg("dropping invalid persisted webhook delivery")
+			s.forget(ctx, id)
+			continue
+		}
+		handler := s.handler(delivery.EventType)
+		if handler == nil {
+			logger.Warn().Str("delivery_id", id).Str("github_event_type", delivery.EventType).Msg("dropping persisted webhook delivery without handler")
+			s.forget(ctx, id)
+			continue
+		}
+
+		logger.Info().Str("delivery_id", id).Str("github_event_type", delivery.EventType).Int("attempts", delivery.Attempts).Msg("replaying persisted webhook delivery")
+		deliveriesTotal.Add("replayed", 1)
+		go s.handle(logger.WithContext(context.Background()), handler, id, &delivery)
+	}
+}
+
+// handler returns the handler of the given event type. Like with the dispatcher, earlier handlers
+// take precedence.
+func (s *deliveryScheduler) handler(eventType string) githubapp.EventHandler {
+	for _, h := range s.handlers {
+		if handles(h, eventType) {
+			return h
+		}
+	}
+	return nil
+}
+
+// handle handles the given delivery until it succeeds, fails permanently or runs out of attempts,
+// and forgets it afterwards.
+func (s *deliveryScheduler) handle(ctx context.Context, h githubapp.EventHandler, id string, delivery *persistedDelivery) {
+	logger := zerolog.Ctx(ctx).With().Str("delivery_id", id).Str("github_event_type", delivery.EventType).Logger()
+	defer s.forget(ctx, id)
+
+	backoff := s.config.GetBackoff()
+	for i := 1; i < delivery.Attempts; i++ {
+		backoff *= 2
+	}
+	for {
+		delivery.Attempts++
+		if delivery.Attempts > s.config.GetMaxAttempts() {
+			// The delivery ran out of attempts before the service stopped, likely crashing it.
+			logger.Error().Int("attempts", delivery.Attempts-1).Msg("dropping webhook delivery that ran out of attempts")
+			deliveriesTotal.Add("failed", 1)
+			return
+		}
+		if delivery.Attempts > 1 {
+			// Recording the attempt first makes sure deliveries crashing the service run out of
+			// attempts eventually.
+			if _, err := s.persist(ctx, id, delivery); err != nil {
+				logger.Warn().Err(err).Msg("failed to record attempt of webhook delivery")
+			}
+		}
+
+		err := execute(ctx, h, id, delivery)
+		if err == nil {
+			return
+		}
+		if !retryableDelivery(err) || delivery.Attempts >= s.config.GetMaxAttempts() {
+			logger.Error().Err(err).Int("attempts", delivery.Attempts).Msg("failed to handle webhook delivery")
+			deliveriesTotal.Add("failed", 1)
+			return
+		}
+
+		logger.Warn().Err(err).Int("attempts", delivery.Attempts).Dur("backoff", backoff).Msg("retrying webhook delivery")
+		deliveriesTotal.Add("retried", 1)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// execute calls the given handler with the given delivery, recovering panics.
+func execute(ctx context.Context, h githubapp.EventHandler, id string, delivery *persistedDelivery) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+	return h.Handle(ctx, delivery.EventType, id, delivery.Payload)
+}
+
+// retryableDelivery returns whether or not a delivery failing with the given error is retried, i.e.
+// if it failed due to the GitHub or DigitalOcean APIs. Other failures, like invalid app specs, fail
+// again until the pull request changes.
+func retryableDelivery(err error) bool {
+	return errors.Is(err, ErrGitHubAPI) || errors.Is(err, ErrDOAPI) || errors.Is(err, ErrDeployTimeout)
+}
+
+// deliveryKey returns the key of the given delivery in the state store.
+func deliveryKey(id string) store.Key {
+	return store.Key{Repo: deliveriesKeyRepo, App: id}
+}
+
+// pending returns the IDs of all persisted deliveries.
+func (s *deliveryScheduler) pending(ctx context.Context) ([]string, error) {
+	keys, err := s.store.List(ctx, deliveriesKeyRepo)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, key.App)
+	}
+	return ids, nil
+}
+
+// persist records the given delivery in the state store and returns whether or not it was already
+// pending.
+func (s *deliveryScheduler) persist(ctx context.Context, id string, delivery *persistedDelivery) (bool, error) {
+	b, err := json.Marshal(delivery)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal webhook delivery: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.store.Get(ctx, deliveryKey(id))
+	pending := err == nil
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return false, err
+	}
+	if pending && delivery.Attempts == 0 {
+		// The delivery is being handled already, with its attempts recorded.
+		return true, nil
+	}
+	return pending, s.store.Put(ctx, deliveryKey(id), string(b))
+}
+
+// forget removes the given delivery from the state store once it's handled.
+func (s *deliveryScheduler) forget(ctx context.Context, id string) {
+	// Handlers might have run until the context was done.
+	if err := s.store.Delete(context.WithoutCancel(ctx), deliveryKey(id)); err != nil && !errors.Is(err, store.ErrNotFound) {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("delivery_id", id).Msg("failed to forget webhook delivery")
+	}
+}
@@ -0,0 +1,90 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.internal.digitalocean.com/mthoemmes/reviewapps/store"
+)
+
+// durableStore is a store.Memory the delivery scheduler accepts, as if it survived restarts.
+type durableStore struct {
+	*store.Memory
+}
+
+// countingHandler counts the events it handles and fails with the given error.
+type countingHandler struct {
+	calls int
+	err   error
+}
+
+func (h *countingHandler) Handles() []string { return []string{"pull_request"} }
+
+func (h *countingHandler) Handle(context.Context, string, string, []byte) error {
+	h.calls++
+	return h.err
+}
+
+func testDeliveryScheduler(t *testing.T, h githubapp.EventHandler) *deliveryScheduler {
+	t.Helper()
+	s, err := newDeliveryScheduler(durableStore{store.NewMemory()}, DeliveriesConfig{Persist: true, MaxAttempts: 3, Backoff: time.Millisecond}, []githubapp.EventHandler{h})
+	if err != nil {
+		t.Fatalf("newDeliveryScheduler() = %v", err)
+	}
+	return s
+}
+
+func TestDeliverySchedulerRefusesMemoryStore(t *testing.T) {
+	if _, err := newDeliveryScheduler(store.NewMemory(), DeliveriesConfig{Persist: true}, nil); err == nil {
+		t.Error("newDeliveryScheduler() accepted the memory store")
+	}
+}
+
+func TestDeliverySchedulerRetries(t *testing.T) {
+	h := &countingHandler{err: errorf(ErrorKindDOAPI, "unavailable")}
+	s := testDeliveryScheduler(t, h)
+	ctx := context.Background()
+	delivery := &persistedDelivery{EventType: "pull_request"}
+	if _, err := s.persist(ctx, "1", delivery); err != nil {
+		t.Fatalf("persist() = %v", err)
+	}
+
+	s.handle(ctx, h, "1", delivery)
+	if h.calls != 3 {
+		t.Errorf("handled %d times, want 3", h.calls)
+	}
+	if ids, _ := s.pending(ctx); len(ids) != 0 {
+		t.Errorf("pending deliveries = %v, want none", ids)
+	}
+}
+
+func TestDeliverySchedulerDoesntRetryPermanentFailures(t *testing.T) {
+	h := &countingHandler{err: errors.New("invalid app spec")}
+	s := testDeliveryScheduler(t, h)
+	s.handle(context.Background(), h, "1", &persistedDelivery{EventType: "pull_request"})
+	if h.calls != 1 {
+		t.Errorf("handled %d times, want 1", h.calls)
+	}
+}
+
+func TestDeliverySchedulerDropsReplaysOutOfAttempts(t *testing.T) {
+	h := &countingHandler{}
+	s := testDeliveryScheduler(t, h)
+	ctx := context.Background()
+	// The delivery crashed the service on its last attempt.
+	delivery := &persistedDelivery{EventType: "pull_request", Attempts: 3}
+	if _, err := s.persist(ctx, "1", delivery); err != nil {
+		t.Fatalf("persist() = %v", err)
+	}
+
+	s.handle(ctx, h, "1", delivery)
+	if h.calls != 0 {
+		t.Errorf("handled %d times, want the delivery to be dropped", h.calls)
+	}
+	if ids, _ := s.pending(ctx); len(ids) != 0 {
+		t.Errorf("pending deliveries = %v, want none", ids)
+	}
+}
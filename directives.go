@@ -0,0 +1,191 @@
+package reviewapps
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-github/v60/github"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	directivesOnEditReconcile = "reconcile"
+	directivesOnEditIgnore    = "ignore"
+
+	// noPreviewTitleFlag disables the review app of a pull request if its title contains it.
+	noPreviewTitleFlag = "[no-preview]"
+)
+
+// variantPattern matches valid variant names, which must not escape the ".do" directory.
+var variantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// prDirectives are the directives of a pull request, set in the front matter of its description
+// like:
+//
+//	---
+//	reviewapps:
+//	  preview: false
+//	  variant: staging
+//	  env:
+//	    LOG_LEVEL: debug
+//	---
+type prDirectives struct {
+	// Preview disables the review app if set to false.
+	Preview *bool `yaml:"preview"`
+	// Variant selects the app spec at ".do/app.<variant>.yaml".
+	Variant string `yaml:"variant"`
+	// Env overrides environment variables of the app and all of its components.
+	Env map[string]string `yaml:"env"`
+}
+
+// disabled returns whether or not the directives disable the review app.
+func (d prDirectives) disabled() bool {
+	return d.Preview != nil && !*d.Preview
+}
+
+// equal returns whether or not both directives have the same effect.
+func (d prDirectives) equal(o prDirectives) bool {
+	return d.key() == o.key()
+}
+
+// key returns a canonical representation of the directives.
+func (d prDirectives) key() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%t\n%s\n", d.disabled(), d.Variant)
+	keys := make([]string, 0, len(d.Env))
+	for k := range d.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%s\n", k, d.Env[k])
+	}
+	return b.String()
+}
+
+// attempt returns the attempt to apply the directives with, so retried deliveries of the same edit
+// are idempotent while every distinct set of directives is applied.
+func (d prDirectives) attempt() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(d.key()))
+	// Attempt 0 is used by pushes, so keep clear of it.
+	return int64(h.Sum64()>>1) | 1
+}
+
+// specLocation returns the location of the app spec in the repository.
+func (d prDirectives) specLocation() string {
+	if d.Variant == "" {
+		return canonicalAppSpecLocation
+	}
+	return fmt.Sprintf(".do/app.%s.yaml", d.Variant)
+}
+
+// applyEnv overrides the environment variables of the given app spec. Variables defined by
+// components are overridden in place, all others are set app-wide.
+func (d prDirectives) applyEnv(spec *godo.AppSpec) {
+	if len(d.Env) == 0 {
+		return
+	}
+
+	set := make(map[string]bool, len(d.Env))
+	override := func(envs []*godo.AppVariableDefinition) {
+		for _, env := range envs {
+			if value, ok := d.Env[env.Key]; ok {
+				// Directives are public, so overridden secrets are plain values from now on.
+				env.Value = value
+				env.Type = godo.AppVariableType_General
+				set[env.Key] = true
+			}
+		}
+	}
+	override(spec.Envs)
+	godo.ForEachAppSpecComponent(spec, func(c godo.AppBuildableComponentSpec) error {
+		override(c.GetEnvs())
+		return nil
+	})
+
+	keys := make([]string, 0, len(d.Env))
+	for k := range d.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if set[k] {
+			continue
+		}
+		spec.Envs = append(spec.Envs, &godo.AppVariableDefinition{
+			Key:   k,
+			Value: d.Env[k],
+			Scope: godo.AppVariableScope_RunAndBuildTime,
+			Type:  godo.AppVariableType_General,
+		})
+	}
+}
+
+// parseDirectives parses the directives from the given title and description of a pull request.
+func parseDirectives(title, body string) (prDirectives, error) {
+	var d prDirectives
+	if frontMatter, ok := parseFrontMatter(body); ok {
+		var doc struct {
+			ReviewApps prDirectives `yaml:"reviewapps"`
+		}
+		// Other tools might put their own keys into the front matter.
+		if err := yaml.Unmarshal([]byte(frontMatter), &doc); err != nil {
+			return prDirectives{}, fmt.Errorf("failed to parse front matter: %w", err)
+		}
+		d = doc.ReviewApps
+	}
+	if d.Variant != "" && !variantPattern.MatchString(d.Variant) {
+		return prDirectives{}, fmt.Errorf("invalid variant %q", d.Variant)
+	}
+	if strings.Contains(strings.ToLower(title), noPreviewTitleFlag) {
+		d.Preview = ptr(false)
+	}
+	return d, nil
+}
+
+// parseFrontMatter returns the front matter at the start of the given description, if any.
+func parseFrontMatter(body string) (string, bool) {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	rest, ok := strings.CutPrefix(body, "---\n")
+	if !ok {
+		return "", false
+	}
+	frontMatter, _, ok := strings.Cut(rest, "\n---")
+	if !ok {
+		return "", false
+	}
+	return frontMatter, true
+}
+
+// prDirectivesOf returns the directives of the given pull request, if enabled.
+func prDirectivesOf(cfg ReviewAppConfig, pr *github.PullRequest) (prDirectives, error) {
+	if !cfg.Directives.Enabled {
+		return prDirectives{}, nil
+	}
+	return parseDirectives(pr.GetTitle(), pr.GetBody())
+}
+
+// editedDirectives returns the directives of the given edited pull request before and after the
+// edit. Invalid directives before the edit are treated as no directives at all.
+func editedDirectives(cfg ReviewAppConfig, event *github.PullRequestEvent) (prDirectives, prDirectives, error) {
+	pr := event.GetPullRequest()
+	title, body := pr.GetTitle(), pr.GetBody()
+	if change := event.GetChanges().GetTitle(); change != nil {
+		title = change.GetFrom()
+	}
+	if change := event.GetChanges().GetBody(); change != nil {
+		body = change.GetFrom()
+	}
+	before, _ := parseDirectives(title, body)
+
+	after, err := prDirectivesOf(cfg, pr)
+	if err != nil {
+		return prDirectives{}, prDirectives{}, err
+	}
+	return before, after, nil
+}
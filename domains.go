@@ -0,0 +1,311 @@
+package reviewapps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"github.com/rs/zerolog"
+	"github.internal.digitalocean.com/mthoemmes/reviewapps/store"
+)
+
+// domainKeySuffix suffixes the app names of the state store keys the DNS records and certificates
+// of review apps are tracked under. App names can't contain slashes, so the keys never collide
+// with those of apps.
+const domainKeySuffix = "/domain"
+
+// prRecordName matches the names of DNS records that are review app names of pull requests.
+var prRecordName = regexp.MustCompile(`^[a-z][a-z0-9-]*-[0-9]+$`)
+
+// trackedDomain records the DNS records and certificates created for the domain of a review app,
+// so they're deleted even if App Platform leaves them behind.
+type trackedDomain struct {
+	Zone         string   `json:"zone"`
+	Domain       string   `json:"domain"`
+	Records      []int    `json:"records"`
+	Certificates []string `json:"certificates,omitempty"`
+}
+
+// domain returns the domain of the review app, or an empty string if review apps get none.
+func (ra *reviewApp) domain() string {
+	if ra.cfg.Domain.Zone == "" {
+		return ""
+	}
+	return ra.appName + "." + ra.cfg.Domain.Zone
+}
+
+// domainKey returns the key the DNS records and certificates of the review app are tracked under
+// in the state store.
+func (ra *reviewApp) domainKey() store.Key {
+	return store.Key{Repo: ra.repo.GetFullName(), App: ra.appName + domainKeySuffix}
+}
+
+// applyDomain gives the given spec the review app's domain, if configured. App Platform manages
+// its DNS record in the zone and its certificate.
+func applyDomain(spec *godo.AppSpec, ra *reviewApp) {
+	if domain := ra.domain(); domain != "" {
+		spec.Domains = []*godo.AppDomainSpec{{Domain: domain, Type: godo.AppDomainSpecType_Primary, Zone: ra.cfg.Domain.Zone}}
+	}
+}
+
+// trackDomain records the DNS records and certificates of the review app's domain in the state
+// store once it's live. Cleaning up also finds them by name, so failing to record them is logged
+// instead of failing the deployment.
+func (h *PRHandler) trackDomain(ctx context.Context, ra *reviewApp) {
+	domain := ra.domain()
+	if domain == "" {
+		return
+	}
+	tracked := h.trackedDomain(ctx, ra)
+	records, certs, err := h.domainResources(ctx, ra.cfg.Domain.Zone, domain)
+	if err != nil {
+		ra.logger.Warn().Err(err).Msg("failed to look up DNS records and certificates of domain")
+		return
+	}
+	if tracked == nil || tracked.Zone != ra.cfg.Domain.Zone {
+		tracked = &trackedDomain{Zone: ra.cfg.Domain.Zone, Domain: domain}
+	}
+	for _, id := range records {
+		if !slices.Contains(tracked.Records, id) {
+			tracked.Records = append(tracked.Records, id)
+		}
+	}
+	for _, id := range certs {
+		if !slices.Contains(tracked.Certificates, id) {
+			tracked.Certificates = append(tracked.Certificates, id)
+		}
+	}
+	b, err := json.Marshal(tracked)
+	if err != nil {
+		ra.logger.Warn().Err(err).Msg("failed to encode DNS records and certificates of domain")
+		return
+	}
+	if err := h.store.Put(ctx, ra.domainKey(), string(b)); err != nil {
+		ra.logger.Warn().Err(err).Msg("failed to record DNS records and certificates of domain in state store")
+	}
+}
+
+// trackedDomain returns the DNS records and certificates recorded for the review app's domain, or
+// nil if there are none.
+func (h *PRHandler) trackedDomain(ctx context.Context, ra *reviewApp) *trackedDomain {
+	value, err := h.store.Get(ctx, ra.domainKey())
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			ra.logger.Warn().Err(err).Msg("failed to look up domain in state store")
+		}
+		return nil
+	}
+	var tracked trackedDomain
+	if err := json.Unmarshal([]byte(value), &tracked); err != nil {
+		ra.logger.Warn().Err(err).Msg("ignoring invalid domain recorded in state store")
+		return nil
+	}
+	return &tracked
+}
+
+// cleanupDomain deletes the DNS records and certificates of the torn down review app's domain,
+// both the recorded ones and those found by name, and forgets them once they're gone.
+func (h *PRHandler) cleanupDomain(ctx context.Context, ra *reviewApp) error {
+	tracked := h.trackedDomain(ctx, ra)
+	if tracked == nil && ra.domain() == "" {
+		return nil
+	}
+	if tracked == nil {
+		tracked = &trackedDomain{Zone: ra.cfg.Domain.Zone, Domain: ra.domain()}
+	}
+	records, certs, err := h.domainResources(ctx, tracked.Zone, tracked.Domain)
+	if err != nil {
+		return err
+	}
+	for _, id := range records {
+		if !slices.Contains(tracked.Records, id) {
+			tracked.Records = append(tracked.Records, id)
+		}
+	}
+	for _, id := range certs {
+		if !slices.Contains(tracked.Certificates, id) {
+			tracked.Certificates = append(tracked.Certificates, id)
+		}
+	}
+
+	if err := h.deleteDomainResources(ctx, tracked); err != nil {
+		return err
+	}
+	if len(tracked.Records)+len(tracked.Certificates) > 0 {
+		ra.logger.Info().Str("domain", tracked.Domain).Ints("records", tracked.Records).Strs("certificates", tracked.Certificates).Msg("deleted DNS records and certificates of domain")
+	}
+	if err := h.store.Delete(ctx, ra.domainKey()); err != nil {
+		ra.logger.Warn().Err(err).Msg("failed to remove domain from state store")
+	}
+	return nil
+}
+
+// deleteDomainResources deletes the given DNS records and certificates. Ones that are already gone
+// aren't an error.
+func (h *PRHandler) deleteDomainResources(ctx context.Context, tracked *trackedDomain) error {
+	var errs []error
+	for _, id := range tracked.Records {
+		if resp, err := h.do.Domains.DeleteRecord(ctx, tracked.Zone, id); err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+			errs = append(errs, doError(err, fmt.Sprintf("failed to delete DNS record %d of %s", id, tracked.Domain)))
+			continue
+		}
+		domainRecordsDeletedTotal.Add(tracked.Zone, 1)
+	}
+	for _, id := range tracked.Certificates {
+		if resp, err := h.do.Certificates.Delete(ctx, id); err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+			errs = append(errs, doError(err, fmt.Sprintf("failed to delete certificate %s of %s", id, tracked.Domain)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// domainResources returns the IDs of the DNS records of the given domain in the given zone and of
+// the certificates covering nothing but the domain.
+func (h *PRHandler) domainResources(ctx context.Context, zone, domain string) ([]int, []string, error) {
+	records, _, err := h.do.Domains.RecordsByName(ctx, zone, domain, &godo.ListOptions{PerPage: 200})
+	if err != nil {
+		return nil, nil, doError(err, fmt.Sprintf("failed to list DNS records of %s", domain))
+	}
+	var recordIDs []int
+	for _, r := range records {
+		recordIDs = append(recordIDs, r.ID)
+	}
+
+	certs, err := listCertificates(ctx, h.do)
+	if err != nil {
+		return nil, nil, err
+	}
+	var certIDs []string
+	for _, c := range certs {
+		if len(c.DNSNames) == 1 && c.DNSNames[0] == domain {
+			certIDs = append(certIDs, c.ID)
+		}
+	}
+	return recordIDs, certIDs, nil
+}
+
+// listCertificates lists all certificates of the account.
+func listCertificates(ctx context.Context, do *godo.Client) ([]godo.Certificate, error) {
+	var all []godo.Certificate
+	opts := &godo.ListOptions{PerPage: 200}
+	for {
+		certs, resp, err := do.Certificates.List(ctx, opts)
+		if err != nil {
+			return nil, doError(err, "failed to list certificates")
+		}
+		all = append(all, certs...)
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			return all, nil
+		}
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current page: %w", err)
+		}
+		opts.Page = page + 1
+	}
+}
+
+// listRecords lists all DNS records of the given zone.
+func listRecords(ctx context.Context, do *godo.Client, zone string) ([]godo.DomainRecord, error) {
+	var all []godo.DomainRecord
+	opts := &godo.ListOptions{PerPage: 200}
+	for {
+		records, resp, err := do.Domains.Records(ctx, zone, opts)
+		if err != nil {
+			return nil, doError(err, fmt.Sprintf("failed to list DNS records of %s", zone))
+		}
+		all = append(all, records...)
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			return all, nil
+		}
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current page: %w", err)
+		}
+		opts.Page = page + 1
+	}
+}
+
+// sweepDomains deletes the DNS records and certificates in the zones of review apps' domains that
+// are named like review apps of pull requests but belong to none of the apps managed by review
+// apps, for example as their app was deleted without being torn down by the service.
+func (rc *Reconciler) sweepDomains(ctx context.Context) error {
+	zones := rc.prs.config.domainZones()
+	if len(zones) == 0 {
+		return nil
+	}
+	logger := zerolog.Ctx(ctx)
+
+	apps, err := listApps(ctx, rc.prs.do)
+	if err != nil {
+		return err
+	}
+	live := make(map[string]bool)
+	for _, app := range apps {
+		if !isOwned(app.GetSpec()) {
+			continue
+		}
+		for _, d := range app.GetSpec().GetDomains() {
+			live[d.Domain] = true
+		}
+	}
+	dead := func(domain, zone string) bool {
+		name, ok := strings.CutSuffix(domain, "."+zone)
+		return ok && prRecordName.MatchString(name) && !live[domain]
+	}
+
+	certs, err := listCertificates(ctx, rc.prs.do)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, zone := range zones {
+		records, err := listRecords(ctx, rc.prs.do, zone)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		byDomain := make(map[string]*trackedDomain)
+		for _, r := range records {
+			domain := r.Name + "." + zone
+			switch r.Type {
+			case "A", "AAAA", "CNAME":
+			default:
+				continue
+			}
+			if !dead(domain, zone) {
+				continue
+			}
+			if byDomain[domain] == nil {
+				byDomain[domain] = &trackedDomain{Zone: zone, Domain: domain}
+			}
+			byDomain[domain].Records = append(byDomain[domain].Records, r.ID)
+		}
+		for _, c := range certs {
+			if len(c.DNSNames) != 1 || !dead(c.DNSNames[0], zone) {
+				continue
+			}
+			domain := c.DNSNames[0]
+			if byDomain[domain] == nil {
+				byDomain[domain] = &trackedDomain{Zone: zone, Domain: domain}
+			}
+			byDomain[domain].Certificates = append(byDomain[domain].Certificates, c.ID)
+		}
+
+		for _, tracked := range byDomain {
+			logger.Info().Str("domain", tracked.Domain).Ints("records", tracked.Records).Strs("certificates", tracked.Certificates).Msg("deleting DNS records and certificates of domain without review app")
+			if err := rc.prs.deleteDomainResources(ctx, tracked); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,157 @@
+package reviewapps
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+
+	"github.com/digitalocean/godo"
+	"github.com/rs/zerolog"
+)
+
+// doWebhookChannel is the channel of the Slack webhooks App Platform alerts are sent to. App
+// Platform requires one, but the bot ignores it.
+const doWebhookChannel = "reviewapps"
+
+// doWebhookAlertRules are the alert rules notifying the bot about finished deployments.
+var doWebhookAlertRules = []godo.AppAlertSpecRule{
+	godo.AppAlertSpecRule_DeploymentLive,
+	godo.AppAlertSpecRule_DeploymentFailed,
+	godo.AppAlertSpecRule_DeploymentCanceled,
+}
+
+// doWebhooks receives the alerts of review apps about finished deployments, so waiting for
+// deployments doesn't rely on frequent polling alone. App Platform only sends alerts to emails and
+// Slack webhooks, so the bot poses as a Slack webhook whose URL identifies the app.
+type doWebhooks struct {
+	config DOWebhooksConfig
+
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+func newDOWebhooks(config DOWebhooksConfig) *doWebhooks {
+	return &doWebhooks{config: config, waiters: make(map[string]map[chan struct{}]struct{})}
+}
+
+// url returns the webhook URL alerts of the given app are sent to. It's signed with the secret, so
+// only App Platform can notify about the app.
+func (wh *doWebhooks) url(appID string) string {
+	q := url.Values{"app": {appID}, "sig": {wh.sign(appID)}}
+	return wh.config.URL + "?" + q.Encode()
+}
+
+// sign returns the signature of the given app ID.
+func (wh *doWebhooks) sign(appID string) string {
+	mac := hmac.New(sha256.New, []byte(wh.config.Secret))
+	mac.Write([]byte(appID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// subscribe returns a channel that receives a value whenever an alert of the given app arrives,
+// and a function to unsubscribe again.
+func (wh *doWebhooks) subscribe(appID string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	if wh.waiters[appID] == nil {
+		wh.waiters[appID] = make(map[chan struct{}]struct{})
+	}
+	wh.waiters[appID][ch] = struct{}{}
+
+	return ch, func() {
+		wh.mu.Lock()
+		defer wh.mu.Unlock()
+		delete(wh.waiters[appID], ch)
+		if len(wh.waiters[appID]) == 0 {
+			delete(wh.waiters, appID)
+		}
+	}
+}
+
+// notify wakes up everyone waiting for a deployment of the given app.
+func (wh *doWebhooks) notify(appID string) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	for ch := range wh.waiters[appID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// A wakeup is already pending.
+		}
+	}
+}
+
+// ServeHTTP receives an alert of the app given as the "app" query parameter. The alert itself is
+// a Slack message, so it's not parsed: waiters fetch the state of the deployment themselves.
+func (wh *doWebhooks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	appID := r.URL.Query().Get("app")
+	if appID == "" || !hmac.Equal([]byte(r.URL.Query().Get("sig")), []byte(wh.sign(appID))) {
+		doWebhooksTotal.Add("rejected", 1)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	doWebhooksTotal.Add("accepted", 1)
+	zerolog.Ctx(r.Context()).Debug().Str("app_id", appID).Msg("received App Platform alert")
+
+	wh.notify(appID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addAlerts adds the alerts notifying the bot about finished deployments to the given spec,
+// unless it already has them.
+func addAlerts(spec *godo.AppSpec) {
+	for _, rule := range doWebhookAlertRules {
+		if !slices.ContainsFunc(spec.Alerts, func(a *godo.AppAlertSpec) bool { return a.Rule == rule }) {
+			spec.Alerts = append(spec.Alerts, &godo.AppAlertSpec{Rule: rule})
+		}
+	}
+}
+
+// subscribeAlerts points the alerts of the given app about finished deployments at the bot, in
+// addition to their existing destinations.
+func (h *PRHandler) subscribeAlerts(ctx context.Context, appID string) error {
+	return h.addAlertDestinations(ctx, appID, nil, []*godo.AppAlertSlackWebhook{{URL: h.doWebhooks.url(appID), Channel: doWebhookChannel}})
+}
+
+// addAlertDestinations adds the given emails and Slack webhooks to the alerts of the given app
+// about finished deployments, unless they already have them.
+func (h *PRHandler) addAlertDestinations(ctx context.Context, appID string, emails []string, slack []*godo.AppAlertSlackWebhook) error {
+	alerts, _, err := h.do.Apps.ListAlerts(ctx, appID)
+	if err != nil {
+		return doError(err, "failed to list alerts")
+	}
+
+	for _, alert := range alerts {
+		if !slices.Contains(doWebhookAlertRules, alert.GetSpec().GetRule()) {
+			continue
+		}
+		req := &godo.AlertDestinationUpdateRequest{Emails: alert.Emails, SlackWebhooks: alert.SlackWebhooks}
+		for _, email := range emails {
+			if !slices.Contains(req.Emails, email) {
+				req.Emails = append(req.Emails, email)
+			}
+		}
+		for _, wh := range slack {
+			if !slices.ContainsFunc(req.SlackWebhooks, func(existing *godo.AppAlertSlackWebhook) bool { return existing.URL == wh.URL }) {
+				req.SlackWebhooks = append(req.SlackWebhooks, wh)
+			}
+		}
+		if len(req.Emails) == len(alert.Emails) && len(req.SlackWebhooks) == len(alert.SlackWebhooks) {
+			continue
+		}
+		if _, _, err := h.do.Apps.UpdateAlertDestinations(ctx, appID, alert.GetID(), req); err != nil {
+			return doError(err, "failed to update alert destinations")
+		}
+	}
+	return nil
+}
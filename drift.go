@@ -0,0 +1,163 @@
+package reviewapps
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/rs/zerolog"
+)
+
+// DriftDetector periodically compares the live spec of review apps with the spec they were last
+// deployed with and flags differences, which are usually manual edits in the control panel.
+type DriftDetector struct {
+	prs      *PRHandler
+	schedule *cronSchedule
+
+	mu sync.Mutex
+	// flagged holds the hash of the drifted spec per app ID that has already been flagged, so
+	// every drift is only reported once.
+	flagged map[string]string
+}
+
+// NewDriftDetector returns a new DriftDetector for the given schedule.
+func NewDriftDetector(prs *PRHandler, schedule string) (*DriftDetector, error) {
+	s, err := parseCron(schedule)
+	if err != nil {
+		return nil, err
+	}
+	return &DriftDetector{prs: prs, schedule: s, flagged: make(map[string]string)}, nil
+}
+
+// Run detects drift of all review apps on the schedule until the context is done.
+func (dd *DriftDetector) Run(ctx context.Context) {
+	logger := zerolog.Ctx(ctx).With().Str("component", "drift").Logger()
+	ctx = logger.WithContext(ctx)
+
+	for {
+		next := dd.schedule.Next(time.Now().UTC())
+		if next.IsZero() {
+			logger.Error().Msg("drift schedule never matches")
+			return
+		}
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		if !dd.prs.throttle.wait(ctx, "drift") {
+			return
+		}
+		if err := dd.detect(ctx); err != nil {
+			logger.Error().Err(err).Msg("failed to detect drift of review apps")
+		}
+	}
+}
+
+// detect detects drift of all review apps whose repository has drift detection enabled.
+func (dd *DriftDetector) detect(ctx context.Context) error {
+	ras, err := dd.prs.openReviewApps(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, ra := range ras {
+		if !ra.cfg.Drift.Enabled || ra.cfg.Task {
+			continue
+		}
+		if err := dd.detectOne(ctx, ra); err != nil {
+			ra.logger.Error().Err(err).Msg("failed to detect drift of review app")
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// detectOne flags the given review app on its pull request if its spec drifted since it was
+// last deployed.
+func (dd *DriftDetector) detectOne(ctx context.Context, ra *reviewApp) error {
+	deployment, payload, err := dd.prs.latestDeployment(ctx, ra)
+	if err != nil || deployment == nil {
+		return err
+	}
+	app, drifted, err := dd.prs.drift(ctx, payload)
+	if err != nil || app == nil {
+		return err
+	}
+
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	if !drifted {
+		delete(dd.flagged, app.GetID())
+		return nil
+	}
+	hash := specHash(app.GetSpec())
+	if dd.flagged[app.GetID()] == hash {
+		return nil
+	}
+
+	ra.logger.Warn().Str("app_id", app.GetID()).Msg("review app drifted from its last deployed spec")
+	driftDetectedTotal.Add(ra.repo.GetFullName(), 1)
+	dd.prs.notify(ctx, ra, ra.lifecycleEvent(LifecycleAppDrifted, app.GetID(), "", app.GetLiveURL()))
+
+	msg := "The review app was changed outside of review apps, e.g. in the control panel. Redeploys keep these changes."
+	if ra.cfg.Drift.Revert {
+		msg = "The review app was changed outside of review apps, e.g. in the control panel. These changes are reverted on the next deploy."
+	}
+	if err := dd.prs.comment(ctx, ra, commentKindDrift, msg); err != nil {
+		return err
+	}
+	dd.flagged[app.GetID()] = hash
+	return nil
+}
+
+// drift returns the app of the given deployment payload and whether its spec differs from the one
+// it was deployed with. It returns a nil app if the app doesn't exist anymore. Apps deployed
+// before their spec was recorded never drift.
+func (h *PRHandler) drift(ctx context.Context, payload *deploymentPayload) (*godo.App, bool, error) {
+	app, resp, err := h.do.Apps.Get(ctx, payload.AppID)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, false, nil
+		}
+		return nil, false, doError(err, "failed to get app")
+	}
+	return app, payload.SpecHash != "" && specHash(app.GetSpec()) != payload.SpecHash, nil
+}
+
+// revertDrift reverts changes made to the spec of the given app outside of review apps by updating
+// it with the spec of the review app, which also deploys it.
+func (h *PRHandler) revertDrift(ctx context.Context, ra *reviewApp, app *godo.App, key string) error {
+	ra.logger.Info().Str("app_id", app.GetID()).Msg("reverting drift of app")
+	updated, err := h.updateSpec(ctx, ra, app)
+	if err != nil {
+		return err
+	}
+	driftRevertedTotal.Add(ra.repo.GetFullName(), 1)
+	return h.deployUpdated(ctx, ra, updated, key)
+}
+
+// specHash returns a hash of the given app spec. It's computed from the spec as returned by App
+// Platform, which fills in defaults, so it's only comparable to hashes of returned specs.
+func specHash(spec *godo.AppSpec) string {
+	if spec == nil {
+		return ""
+	}
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
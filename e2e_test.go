@@ -0,0 +1,305 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-github/v60/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.internal.digitalocean.com/mthoemmes/reviewapps/dofake"
+	"github.internal.digitalocean.com/mthoemmes/reviewapps/githubfake"
+)
+
+// testSpec is the app spec the pull requests of the tests deploy.
+const testSpec = `name: web
+services:
+- name: web
+  github:
+    repo: acme/web
+    branch: main
+`
+
+// testEnv drives the handlers end-to-end against fakes of GitHub and App Platform.
+type testEnv struct {
+	t        *testing.T
+	gh       *githubfake.Server
+	do       *dofake.Server
+	prs      *PRHandler
+	handlers []githubapp.EventHandler
+	repo     *github.Repository
+	pr       *github.PullRequest
+}
+
+// newTestEnv returns an environment with the repository "acme/web" and its open pull request #1
+// with the given configuration, or the default one if it's nil.
+func newTestEnv(t *testing.T, config *Config) *testEnv {
+	t.Helper()
+	if config == nil {
+		var err error
+		if config, err = ParseConfig(DefaultConfig()); err != nil {
+			t.Fatalf("ParseConfig() = %v", err)
+		}
+	}
+
+	gh := githubfake.New()
+	t.Cleanup(gh.Close)
+	fake := dofake.New()
+	t.Cleanup(fake.Close)
+	// Don't wait needlessly long for the polling of the deployment to finish.
+	fake.SetPhases(godo.DeploymentPhase_Deploying, godo.DeploymentPhase_Active)
+
+	cc, err := gh.ClientCreator()
+	if err != nil {
+		t.Fatalf("ClientCreator() = %v", err)
+	}
+	b := NewBuilder(config)
+	ext, err := b.startPlugins()
+	if err != nil {
+		t.Fatalf("startPlugins() = %v", err)
+	}
+	t.Cleanup(ext.close)
+
+	prs := b.newPRHandler(cc, fake.Client(), ext)
+	env := &testEnv{
+		t:        t,
+		gh:       gh,
+		do:       fake,
+		prs:      prs,
+		handlers: b.eventHandlers(prs),
+		repo:     gh.AddRepo("acme", "web"),
+		pr: &github.PullRequest{
+			Number: ptr(1),
+			State:  ptr("open"),
+			User:   &github.User{Login: ptr("alice")},
+			Head:   &github.PullRequestBranch{Ref: ptr("feature"), SHA: ptr("0000000000000000000000000000000000000001")},
+		},
+	}
+	gh.SetFile("acme/web", "feature", canonicalAppSpecLocation, testSpec)
+	gh.SetPullRequest("acme/web", env.pr)
+	return env
+}
+
+// send dispatches a pull request event of the given action.
+func (e *testEnv) send(action string) error {
+	e.t.Helper()
+	e.gh.SetPullRequest("acme/web", e.pr)
+	return dispatch(context.Background(), e.handlers, "pull_request", prEvent(action, e.repo, e.pr, nil))
+}
+
+// apps returns the apps of the account by name.
+func (e *testEnv) apps() map[string]*godo.App {
+	apps := make(map[string]*godo.App)
+	for _, app := range e.do.Apps() {
+		apps[app.GetSpec().GetName()] = app
+	}
+	return apps
+}
+
+// latestState returns the state of the latest status of the latest GitHub deployment, or an
+// empty string if there is none.
+func (e *testEnv) latestState() string {
+	deployments := e.gh.Deployments("acme/web")
+	if len(deployments) == 0 {
+		return ""
+	}
+	statuses := e.gh.DeploymentStatuses("acme/web", deployments[0].GetID())
+	if len(statuses) == 0 {
+		return ""
+	}
+	return statuses[0].GetState()
+}
+
+func TestPullRequestLifecycle(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	if err := env.send(actionOpened); err != nil {
+		t.Fatalf("opening pull request = %v", err)
+	}
+	app, ok := env.apps()["acme-web-1"]
+	if !ok || len(env.apps()) != 1 {
+		t.Fatalf("apps = %v, want acme-web-1", env.apps())
+	}
+	if !isOwned(app.GetSpec()) {
+		t.Error("app doesn't carry the ownership marker")
+	}
+	if repo, number, ok := pullRequestOf(app.GetSpec()); !ok || repo != "acme/web" || number != 1 {
+		t.Errorf("pullRequestOf() = %s, %d, %t, want acme/web, 1, true", repo, number, ok)
+	}
+	if branch := app.GetSpec().GetServices()[0].GetGitHub().GetBranch(); branch != "feature" {
+		t.Errorf("service is deployed from %q, want the pull request's branch", branch)
+	}
+	if got := env.latestState(); got != deploymentStateSuccess {
+		t.Errorf("deployment state = %q, want %q", got, deploymentStateSuccess)
+	}
+	if got := env.gh.Deployments("acme/web")[0].GetEnvironment(); got != "acme-web-1" {
+		t.Errorf("deployment environment = %q, want acme-web-1", got)
+	}
+
+	env.pr.Head.SHA = ptr("0000000000000000000000000000000000000002")
+	if err := env.send(actionSynchronize); err != nil {
+		t.Fatalf("pushing to pull request = %v", err)
+	}
+	if got := env.apps()["acme-web-1"]; len(env.apps()) != 1 || got.GetID() != app.GetID() {
+		t.Fatalf("apps = %v, want app %s to be reused", env.apps(), app.GetID())
+	}
+	if got := len(env.do.Deployments(app.GetID())); got != 2 {
+		t.Errorf("app has %d deployments, want 2", got)
+	}
+	if got := env.gh.Deployments("acme/web")[0].GetSHA(); got != env.pr.Head.GetSHA() {
+		t.Errorf("latest deployment is of %s, want %s", got, env.pr.Head.GetSHA())
+	}
+	if got := env.latestState(); got != deploymentStateSuccess {
+		t.Errorf("deployment state = %q, want %q", got, deploymentStateSuccess)
+	}
+
+	env.pr.State = ptr("closed")
+	if err := env.send(actionClosed); err != nil {
+		t.Fatalf("closing pull request = %v", err)
+	}
+	if len(env.apps()) != 0 {
+		t.Errorf("apps = %v, want none", env.apps())
+	}
+	if got := env.latestState(); got != deploymentStateInactive {
+		t.Errorf("deployment state = %q, want %q", got, deploymentStateInactive)
+	}
+
+	env.pr.State = ptr("open")
+	if err := env.send(actionReopened); err != nil {
+		t.Fatalf("reopening pull request = %v", err)
+	}
+	if _, ok := env.apps()["acme-web-1"]; !ok || len(env.apps()) != 1 {
+		t.Errorf("apps = %v, want acme-web-1 to be recreated", env.apps())
+	}
+}
+
+func TestFailedDeployment(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.do.SetPhases(godo.DeploymentPhase_Building, godo.DeploymentPhase_Error)
+
+	// Failed deployments are reported on the pull request rather than failing the event.
+	_ = env.send(actionOpened)
+	if got := env.latestState(); got != deploymentStateFailure && got != deploymentStateError {
+		t.Errorf("deployment state = %q, want %q or %q", got, deploymentStateFailure, deploymentStateError)
+	}
+	if _, ok := env.apps()["acme-web-1"]; !ok {
+		t.Errorf("apps = %v, want the failed app to be kept for debugging", env.apps())
+	}
+
+	env.pr.State = ptr("closed")
+	if err := env.send(actionClosed); err != nil {
+		t.Fatalf("closing pull request = %v", err)
+	}
+	if len(env.apps()) != 0 {
+		t.Errorf("apps = %v, want none", env.apps())
+	}
+}
+
+func TestForeignAppIsntOverwritten(t *testing.T) {
+	env := newTestEnv(t, nil)
+	// An app that isn't a review app, but happens to be named like one.
+	foreign, _, err := env.do.Client().Apps.Create(context.Background(), &godo.AppCreateRequest{Spec: &godo.AppSpec{
+		Name:     "acme-web-1",
+		Services: []*godo.AppServiceSpec{{Name: "api", Image: &godo.ImageSourceSpec{RegistryType: godo.ImageSourceSpecRegistryType_DOCR, Repository: "api"}}},
+	}})
+	if err != nil {
+		t.Fatalf("creating app = %v", err)
+	}
+
+	if err := env.send(actionOpened); !errors.Is(err, ErrNotOwned) {
+		t.Errorf("opening pull request = %v, want %v", err, ErrNotOwned)
+	}
+	app := env.apps()["acme-web-1"]
+	if app.GetID() != foreign.GetID() || isOwned(app.GetSpec()) || app.GetSpec().GetServices()[0].GetName() != "api" {
+		t.Errorf("app %s was changed to %+v", foreign.GetID(), app.GetSpec())
+	}
+}
+
+func TestDeletedForkIsTornDown(t *testing.T) {
+	env := newTestEnv(t, nil)
+	if err := env.send(actionOpened); err != nil {
+		t.Fatalf("opening pull request = %v", err)
+	}
+	if len(env.apps()) != 1 {
+		t.Fatalf("apps = %v, want one", env.apps())
+	}
+
+	// Pull requests of forks that were deleted lose their head repository.
+	env.pr.State = ptr("closed")
+	env.pr.Head.Repo = nil
+	env.pr.Head.Ref = nil
+	if err := env.send(actionClosed); err != nil {
+		t.Fatalf("closing pull request = %v", err)
+	}
+	if len(env.apps()) != 0 {
+		t.Errorf("apps = %v, want none", env.apps())
+	}
+}
+
+// adopt creates an app that isn't a review app, named like the preview of pull request #1, and
+// adopts it.
+func (e *testEnv) adopt() *godo.App {
+	e.t.Helper()
+	app, _, err := e.do.Client().Apps.Create(context.Background(), &godo.AppCreateRequest{Spec: &godo.AppSpec{
+		Name:     "preview-1",
+		Services: []*godo.AppServiceSpec{{Name: "web", GitHub: &godo.GitHubSourceSpec{Repo: "acme/web", Branch: "feature"}}},
+	}})
+	if err != nil {
+		e.t.Fatalf("creating app = %v", err)
+	}
+	a := &adopter{prs: e.prs}
+	result, err := a.adopt(context.Background(), "acme", "web", regexp.MustCompile(`^preview-(\d+)$`), false)
+	if err != nil {
+		e.t.Fatalf("adopt() = %v", err)
+	}
+	if len(result.Adopted) != 1 {
+		e.t.Fatalf("adopted %+v, skipped %+v, want the app to be adopted", result.Adopted, result.Skipped)
+	}
+	adopted, ok := e.apps()["acme-web-1"]
+	if !ok || adopted.GetID() != app.GetID() {
+		e.t.Fatalf("apps = %v, want %s to be renamed to acme-web-1", e.apps(), app.GetID())
+	}
+	if !isReviewAppOf(adopted.GetSpec(), &reviewApp{repo: e.repo, number: 1}) {
+		e.t.Errorf("adopted app isn't marked as the review app of acme/web#1")
+	}
+	return adopted
+}
+
+func TestAdoptedAppIsTornDown(t *testing.T) {
+	env := newTestEnv(t, nil)
+	adopted := env.adopt()
+
+	// Deploying updates the adopted app rather than refusing to.
+	if err := env.send(actionSynchronize); err != nil {
+		t.Fatalf("pushing to pull request = %v", err)
+	}
+	if got := env.apps()["acme-web-1"]; len(env.apps()) != 1 || got.GetID() != adopted.GetID() {
+		t.Fatalf("apps = %v, want app %s to be reused", env.apps(), adopted.GetID())
+	}
+
+	env.pr.State = ptr("closed")
+	if err := env.send(actionClosed); err != nil {
+		t.Fatalf("closing pull request = %v", err)
+	}
+	if len(env.apps()) != 0 {
+		t.Errorf("apps = %v, want none", env.apps())
+	}
+}
+
+func TestAdoptedAppIsCollectedAsOrphan(t *testing.T) {
+	env := newTestEnv(t, nil)
+	env.adopt()
+
+	// The pull request is closed without the event being delivered.
+	env.pr.State = ptr("closed")
+	env.gh.SetPullRequest("acme/web", env.pr)
+	oc := &OrphanCollector{prs: env.prs}
+	if err := oc.collect(context.Background()); err != nil {
+		t.Fatalf("collect() = %v", err)
+	}
+	if len(env.apps()) != 0 {
+		t.Errorf("apps = %v, want the orphaned app to be deleted", env.apps())
+	}
+}
@@ -0,0 +1,130 @@
+package reviewapps
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.internal.digitalocean.com/mthoemmes/reviewapps/store"
+)
+
+// sealer encrypts and decrypts data stored at rest with AES-256-GCM. Sealed data is the random
+// nonce followed by the ciphertext.
+type sealer struct {
+	aead cipher.AEAD
+}
+
+// newSealer returns a new sealer for the given base64 encoded 32 byte key.
+func newSealer(key string) (*sealer, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode encryption key: %w", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return &sealer{aead: aead}, nil
+}
+
+// seal encrypts the given data.
+func (s *sealer) seal(data []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(data)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return s.aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// open decrypts the given sealed data.
+func (s *sealer) open(sealed []byte) ([]byte, error) {
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, errors.New("failed to decrypt: data is too short")
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	data, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return data, nil
+}
+
+// Decrypt decrypts data encrypted at rest with the given key, like database backups.
+func Decrypt(key string, sealed []byte) ([]byte, error) {
+	s, err := newSealer(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(sealed)
+}
+
+// sealedValuePrefix prefixes the values of sealed stores that are encrypted. Values without it were
+// recorded before encryption was configured and are read as they are.
+const sealedValuePrefix = "sealed:"
+
+// sealedStore is a state store encrypting the values of the store it wraps, as they include the
+// payloads of webhook deliveries.
+type sealedStore struct {
+	store.Store
+	sealer *sealer
+}
+
+// sealedListingStore is a sealedStore wrapping a store that can list its keys. Keys aren't
+// encrypted, so they're listed as they are.
+type sealedListingStore struct {
+	*sealedStore
+	lister store.Lister
+}
+
+// sealStore returns a store encrypting the values of the given one with the given sealer.
+func sealStore(st store.Store, s *sealer) store.Store {
+	sealed := &sealedStore{Store: st, sealer: s}
+	if lister, ok := st.(store.Lister); ok {
+		return &sealedListingStore{sealedStore: sealed, lister: lister}
+	}
+	return sealed
+}
+
+func (s *sealedStore) Get(ctx context.Context, key store.Key) (string, error) {
+	value, err := s.Store.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	encoded, ok := strings.CutPrefix(value, sealedValuePrefix)
+	if !ok {
+		return value, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode sealed value: %w", err)
+	}
+	data, err := s.sealer.open(sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (s *sealedStore) Put(ctx context.Context, key store.Key, value string) error {
+	sealed, err := s.sealer.seal([]byte(value))
+	if err != nil {
+		return err
+	}
+	return s.Store.Put(ctx, key, sealedValuePrefix+base64.StdEncoding.EncodeToString(sealed))
+}
+
+func (s *sealedListingStore) List(ctx context.Context, repo string) ([]store.Key, error) {
+	return s.lister.List(ctx, repo)
+}
@@ -0,0 +1,74 @@
+package reviewapps
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.internal.digitalocean.com/mthoemmes/reviewapps/store"
+)
+
+func testSealer(t *testing.T) *sealer {
+	t.Helper()
+	s, err := newSealer(base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")))
+	if err != nil {
+		t.Fatalf("newSealer() = %v", err)
+	}
+	return s
+}
+
+func TestSealedStore(t *testing.T) {
+	ctx := context.Background()
+	inner := store.NewMemory()
+	st := sealStore(inner, testSealer(t))
+	key := store.Key{Repo: "acme/web", App: "acme-web-1"}
+
+	if err := st.Put(ctx, key, "secret"); err != nil {
+		t.Fatalf("Put() = %v", err)
+	}
+	raw, _ := inner.Get(ctx, key)
+	if !strings.HasPrefix(raw, sealedValuePrefix) || strings.Contains(raw, "secret") {
+		t.Errorf("stored value = %q, want it to be sealed", raw)
+	}
+	if got, err := st.Get(ctx, key); err != nil || got != "secret" {
+		t.Errorf("Get() = %q, %v, want secret", got, err)
+	}
+
+	// Values recorded before encryption was configured are still read.
+	legacy := store.Key{Repo: "acme/web", App: "acme-web-2"}
+	_ = inner.Put(ctx, legacy, "app-id")
+	if got, err := st.Get(ctx, legacy); err != nil || got != "app-id" {
+		t.Errorf("Get() = %q, %v, want app-id", got, err)
+	}
+
+	lister, ok := st.(store.Lister)
+	if !ok {
+		t.Fatal("sealed store of a listing store doesn't list")
+	}
+	if keys, err := lister.List(ctx, "acme/web"); err != nil || len(keys) != 2 {
+		t.Errorf("List() = %v, %v, want 2 keys", keys, err)
+	}
+
+	if err := st.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	if _, err := st.Get(ctx, key); err != store.ErrNotFound {
+		t.Errorf("Get() = %v, want %v", err, store.ErrNotFound)
+	}
+}
+
+func TestSealedStoreWrongKey(t *testing.T) {
+	ctx := context.Background()
+	inner := store.NewMemory()
+	key := store.Key{Repo: "acme/web", App: "acme-web-1"}
+	_ = sealStore(inner, testSealer(t)).Put(ctx, key, "secret")
+
+	other, err := newSealer(base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210")))
+	if err != nil {
+		t.Fatalf("newSealer() = %v", err)
+	}
+	if _, err := sealStore(inner, other).Get(ctx, key); err == nil {
+		t.Error("Get() with the wrong key succeeded")
+	}
+}
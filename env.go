@@ -0,0 +1,143 @@
+package reviewapps
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/digitalocean/godo"
+)
+
+// envValueData is the data the values of configured environment variables are templated with.
+type envValueData struct {
+	Repo    string
+	Owner   string
+	Name    string
+	Number  int
+	Branch  string
+	SHA     string
+	AppName string
+}
+
+// parseEnvValue parses the given value of a configured environment variable as a template.
+func parseEnvValue(key, value string) (*template.Template, error) {
+	tmpl, err := template.New(key).Option("missingkey=error").Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value of environment variable %s: %w", key, err)
+	}
+	return tmpl, nil
+}
+
+// resolveSecret returns the value of the given configured secret. Values of the form "env:NAME"
+// are read from the service's environment and values of the form "file:PATH" from a file, like a
+// mounted Kubernetes secret.
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	case strings.HasPrefix(value, "file:"):
+		b, err := os.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret: %w", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	return value, nil
+}
+
+// applyConfiguredEnv sets the configured environment variables on every component of the given
+// spec, replacing variables of the same key defined by the app spec. Secrets aren't set for
+// forked pull requests, whose code is untrusted. It returns the keys of the skipped secrets.
+func applyConfiguredEnv(spec *godo.AppSpec, ra *reviewApp, secrets map[string]string) ([]string, error) {
+	if len(ra.cfg.Env) == 0 {
+		return nil, nil
+	}
+	data := envValueData{
+		Repo:    ra.repo.GetFullName(),
+		Owner:   ra.owner,
+		Name:    ra.name,
+		Number:  ra.number,
+		Branch:  ra.branch,
+		SHA:     ra.pr.GetHead().GetSHA(),
+		AppName: ra.appName,
+	}
+
+	keys := make([]string, 0, len(ra.cfg.Env))
+	for k := range ra.cfg.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var (
+		envs    []*godo.AppVariableDefinition
+		skipped []string
+	)
+	for _, k := range keys {
+		e := ra.cfg.Env[k]
+		env := &godo.AppVariableDefinition{Key: k, Scope: godo.AppVariableScope(e.GetScope()), Type: godo.AppVariableType_General}
+		if e.Secret != "" {
+			if ra.fork {
+				skipped = append(skipped, k)
+				continue
+			}
+			value, ok := secrets[e.Secret]
+			if !ok {
+				return nil, fmt.Errorf("environment variable %s references unknown secret %q", k, e.Secret)
+			}
+			env.Value, env.Type = value, godo.AppVariableType_Secret
+		} else {
+			tmpl, err := parseEnvValue(k, e.Value)
+			if err != nil {
+				return nil, err
+			}
+			var value strings.Builder
+			if err := tmpl.Execute(&value, data); err != nil {
+				return nil, fmt.Errorf("failed to template value of environment variable %s: %w", k, err)
+			}
+			env.Value = value.String()
+		}
+		envs = append(envs, env)
+	}
+
+	godo.ForEachAppSpecComponent(spec, func(c godo.AppBuildableComponentSpec) error {
+		for _, env := range envs {
+			setComponentEnv(c, env)
+		}
+		return nil
+	})
+	return skipped, nil
+}
+
+// setComponentEnv sets a copy of the given environment variable on the given component, replacing
+// any existing variable of the same key.
+func setComponentEnv(c godo.AppBuildableComponentSpec, env *godo.AppVariableDefinition) {
+	cp := *env
+	var envs *[]*godo.AppVariableDefinition
+	switch c := c.(type) {
+	case *godo.AppServiceSpec:
+		envs = &c.Envs
+	case *godo.AppWorkerSpec:
+		envs = &c.Envs
+	case *godo.AppJobSpec:
+		envs = &c.Envs
+	case *godo.AppStaticSiteSpec:
+		envs = &c.Envs
+	case *godo.AppFunctionsSpec:
+		envs = &c.Envs
+	default:
+		return
+	}
+	for i, existing := range *envs {
+		if existing.Key == cp.Key {
+			(*envs)[i] = &cp
+			return
+		}
+	}
+	*envs = append(*envs, &cp)
+}
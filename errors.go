@@ -0,0 +1,138 @@
+package reviewapps
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-github/v60/github"
+)
+
+// ErrorKind classifies errors returned by the service, so callers can branch on failure kinds.
+type ErrorKind string
+
+const (
+	// ErrorKindSpecNotFound means that the repository doesn't contain an app spec.
+	ErrorKindSpecNotFound ErrorKind = "spec_not_found"
+	// ErrorKindSpecInvalid means that the app spec couldn't be parsed or was rejected.
+	ErrorKindSpecInvalid ErrorKind = "spec_invalid"
+	// ErrorKindDOQuotaExceeded means that an account limit on DigitalOcean has been reached.
+	ErrorKindDOQuotaExceeded ErrorKind = "do_quota_exceeded"
+	// ErrorKindDORegionUnavailable means that a region on DigitalOcean lacks the capacity to create
+	// an app.
+	ErrorKindDORegionUnavailable ErrorKind = "do_region_unavailable"
+	// ErrorKindDOAPI means that a call to the DigitalOcean API failed otherwise.
+	ErrorKindDOAPI ErrorKind = "do_api"
+	// ErrorKindGitHubAPI means that a call to the GitHub API failed.
+	ErrorKindGitHubAPI ErrorKind = "github_api"
+	// ErrorKindDeployTimeout means that a deployment didn't finish in time.
+	ErrorKindDeployTimeout ErrorKind = "deploy_timeout"
+	// ErrorKindInvalidEvent means that a webhook event couldn't be parsed.
+	ErrorKindInvalidEvent ErrorKind = "invalid_event"
+	// ErrorKindPreflightFailed means that the pre-flight checks before creating an app failed.
+	ErrorKindPreflightFailed ErrorKind = "preflight_failed"
+	// ErrorKindNotOwned means that an app was about to be deleted or updated that isn't the review
+	// app it was expected to be.
+	ErrorKindNotOwned ErrorKind = "not_owned"
+	// ErrorKindBudgetExceeded means that a review app wasn't created as the cap on concurrently
+	// running review apps was hit.
+	ErrorKindBudgetExceeded ErrorKind = "budget_exceeded"
+)
+
+// Sentinel errors to compare errors against by kind via errors.Is.
+var (
+	ErrSpecNotFound        = &Error{Kind: ErrorKindSpecNotFound}
+	ErrSpecInvalid         = &Error{Kind: ErrorKindSpecInvalid}
+	ErrDOQuotaExceeded     = &Error{Kind: ErrorKindDOQuotaExceeded}
+	ErrDORegionUnavailable = &Error{Kind: ErrorKindDORegionUnavailable}
+	ErrDOAPI               = &Error{Kind: ErrorKindDOAPI}
+	ErrGitHubAPI           = &Error{Kind: ErrorKindGitHubAPI}
+	ErrDeployTimeout       = &Error{Kind: ErrorKindDeployTimeout}
+	ErrInvalidEvent        = &Error{Kind: ErrorKindInvalidEvent}
+	ErrPreflightFailed     = &Error{Kind: ErrorKindPreflightFailed}
+	ErrNotOwned            = &Error{Kind: ErrorKindNotOwned}
+	ErrBudgetExceeded      = &Error{Kind: ErrorKindBudgetExceeded}
+)
+
+// Error is an error of a specific kind.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return string(e.Kind)
+	}
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the target is an error of the same kind.
+func (e *Error) Is(target error) bool {
+	var t *Error
+	return errors.As(target, &t) && t.Kind == e.Kind
+}
+
+// KindOf returns the kind of the given error and whether or not it has a kind at all.
+func KindOf(err error) (ErrorKind, bool) {
+	var e *Error
+	if !errors.As(err, &e) {
+		return "", false
+	}
+	return e.Kind, true
+}
+
+// errorf returns an error of the given kind formatted like fmt.Errorf.
+func errorf(kind ErrorKind, format string, args ...any) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+// doError classifies an error returned by the DigitalOcean API.
+func doError(err error, msg string) error {
+	kind := ErrorKindDOAPI
+	var errResp *godo.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil {
+		switch errResp.Response.StatusCode {
+		case http.StatusForbidden, http.StatusUnprocessableEntity, http.StatusServiceUnavailable:
+			msg := strings.ToLower(errResp.Message)
+			switch {
+			case strings.Contains(msg, "capacity"):
+				kind = ErrorKindDORegionUnavailable
+			case strings.Contains(msg, "limit"):
+				kind = ErrorKindDOQuotaExceeded
+			}
+		}
+	}
+	return errorf(kind, "%s: %w", msg, err)
+}
+
+// doSpecError classifies an error returned by the DigitalOcean API when submitting an app spec.
+// Client errors that aren't caused by account limits are caused by the spec being rejected.
+func doSpecError(err error, msg string) error {
+	err = doError(err, msg)
+	var errResp *godo.ErrorResponse
+	if kind, _ := KindOf(err); kind == ErrorKindDOAPI && errors.As(err, &errResp) && errResp.Response != nil {
+		switch errResp.Response.StatusCode {
+		case http.StatusBadRequest, http.StatusUnprocessableEntity:
+			return errorf(ErrorKindSpecInvalid, "%s: %w", msg, errors.Unwrap(err))
+		}
+	}
+	return err
+}
+
+// githubError classifies an error returned by the GitHub API.
+func githubError(err error, msg string) error {
+	return errorf(ErrorKindGitHubAPI, "%s: %w", msg, err)
+}
+
+// isGitHubNotFound returns whether or not the given error is a 404 returned by the GitHub API.
+func isGitHubNotFound(err error) bool {
+	var errResp *github.ErrorResponse
+	return errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,141 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v60/github"
+	"github.com/rs/zerolog"
+)
+
+// notActionableError is returned by the validation of incoming events if they lack fields
+// required to act upon them.
+type notActionableError struct {
+	reasons []string
+}
+
+func (e *notActionableError) Error() string {
+	return "event not actionable: " + strings.Join(e.reasons, ", ")
+}
+
+// fieldCheck is a single check of a required field of an event.
+type fieldCheck struct {
+	ok     bool
+	reason string
+}
+
+// checkFields returns a notActionableError with the reasons of all failed checks, if any.
+func checkFields(checks ...fieldCheck) error {
+	var reasons []string
+	for _, c := range checks {
+		if !c.ok {
+			reasons = append(reasons, c.reason)
+		}
+	}
+	if len(reasons) > 0 {
+		return &notActionableError{reasons: reasons}
+	}
+	return nil
+}
+
+// repoChecks checks the fields of a repository used to manage review apps.
+func repoChecks(repo *github.Repository) []fieldCheck {
+	return []fieldCheck{
+		{repo != nil, "repository is missing"},
+		{repo.GetID() != 0, "repository ID is missing"},
+		{repo.GetOwner().GetLogin() != "", "repository owner is missing"},
+		{repo.GetName() != "", "repository name is missing"},
+		{repo.GetFullName() != "", "repository full name is missing"},
+	}
+}
+
+const (
+	headRefMissing  = "head ref is missing"
+	headRepoMissing = "head repository is missing"
+)
+
+// pullRequestChecks checks the fields of a pull request used to manage review apps.
+func pullRequestChecks(pr *github.PullRequest) []fieldCheck {
+	return []fieldCheck{
+		{pr != nil, "pull request is missing"},
+		{pr.GetNumber() != 0, "pull request number is missing"},
+		{pr.GetHead().GetRef() != "", headRefMissing},
+		{pr.GetHead().GetSHA() != "", "head SHA is missing"},
+		// The head repository is missing if it has been deleted.
+		{pr.GetHead().GetRepo().GetID() != 0, headRepoMissing},
+		{pr.GetBase().GetRef() != "", "base ref is missing"},
+	}
+}
+
+// validatePullRequestEvent validates that the given event has all fields required to manage its
+// review app.
+func validatePullRequestEvent(event *github.PullRequestEvent) error {
+	checks := []fieldCheck{
+		{event.GetInstallation().GetID() != 0, "installation is missing"},
+		{event.GetNumber() == event.GetPullRequest().GetNumber(), "pull request number is inconsistent"},
+		{event.GetAction() != actionLabeled || event.GetLabel().GetName() != "", "label is missing"},
+	}
+	checks = append(checks, repoChecks(event.GetRepo())...)
+	prChecks := pullRequestChecks(event.GetPullRequest())
+	if event.GetAction() == actionClosed {
+		// Pull requests of forks that were deleted are closed without their head, but their review
+		// apps have to be torn down still.
+		prChecks = slices.DeleteFunc(prChecks, func(c fieldCheck) bool {
+			return c.reason == headRepoMissing || c.reason == headRefMissing
+		})
+	}
+	checks = append(checks, prChecks...)
+	return checkFields(checks...)
+}
+
+// validateIssueCommentEvent validates that the given event has all fields required to execute a
+// command.
+func validateIssueCommentEvent(event *github.IssueCommentEvent) error {
+	checks := []fieldCheck{
+		{event.GetInstallation().GetID() != 0, "installation is missing"},
+		{event.GetIssue().GetNumber() != 0, "issue number is missing"},
+		{event.GetComment().GetID() != 0, "comment ID is missing"},
+		{event.GetComment().GetUser().GetLogin() != "", "commenter is missing"},
+	}
+	checks = append(checks, repoChecks(event.GetRepo())...)
+	return checkFields(checks...)
+}
+
+// validatePushEvent validates that the given event has all fields required to manage the app of
+// the pushed branch.
+func validatePushEvent(event *github.PushEvent) error {
+	checks := []fieldCheck{
+		{event.GetInstallation().GetID() != 0, "installation is missing"},
+		{event.GetRef() != "", "ref is missing"},
+		{event.GetDeleted() || event.GetAfter() != "", "pushed SHA is missing"},
+	}
+	checks = append(checks, repoChecks(pushRepository(event.GetRepo()))...)
+	return checkFields(checks...)
+}
+
+// validateCheckSuiteEvent validates that the given event has all fields required to redeploy the
+// review apps of the check suite's pull requests.
+func validateCheckSuiteEvent(event *github.CheckSuiteEvent) error {
+	checks := []fieldCheck{
+		{event.GetInstallation().GetID() != 0, "installation is missing"},
+		{event.GetCheckSuite().GetHeadSHA() != "", "head SHA is missing"},
+	}
+	checks = append(checks, repoChecks(event.GetRepo())...)
+	return checkFields(checks...)
+}
+
+// skipNotActionable logs and counts the given error if it's a notActionableError and swallows it,
+// as redelivering the event won't help. All other errors are returned as is.
+func skipNotActionable(ctx context.Context, eventType string, err error) error {
+	var notActionable *notActionableError
+	if !errors.As(err, &notActionable) {
+		return err
+	}
+	for _, reason := range notActionable.reasons {
+		eventsNotActionableTotal.Add(eventType+": "+reason, 1)
+	}
+	zerolog.Ctx(ctx).Warn().Str("github_event_type", eventType).Strs("reasons", notActionable.reasons).Msg("skipping event that's not actionable")
+	return nil
+}
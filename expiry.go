@@ -0,0 +1,213 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/go-github/v60/github"
+	"github.com/rs/zerolog"
+	"github.internal.digitalocean.com/mthoemmes/reviewapps/store"
+)
+
+// Expirer tears down review apps on a schedule once they haven't been deployed, kept or visited for
+// longer than their TTL, so forgotten pull requests don't keep their apps around. Review apps are only torn
+// down after a warning on their pull request, which "/keep" answers to keep them around.
+type Expirer struct {
+	prs      *PRHandler
+	schedule *cronSchedule
+
+	// warned are the times the review apps were warned about their teardown. Warnings are only
+	// tracked in memory, so restarts delay teardowns instead of tearing down without warning.
+	warned map[store.Key]time.Time
+}
+
+// NewExpirer returns a new Expirer for the given schedule.
+func NewExpirer(prs *PRHandler, schedule string) (*Expirer, error) {
+	s, err := parseCron(schedule)
+	if err != nil {
+		return nil, err
+	}
+	return &Expirer{prs: prs, schedule: s, warned: make(map[store.Key]time.Time)}, nil
+}
+
+// Run expires review apps on the schedule until the context is done.
+func (e *Expirer) Run(ctx context.Context) {
+	logger := zerolog.Ctx(ctx).With().Str("component", "expirer").Logger()
+	ctx = logger.WithContext(ctx)
+
+	for {
+		next := e.schedule.Next(time.Now().UTC())
+		if next.IsZero() {
+			logger.Error().Msg("expiry schedule never matches")
+			return
+		}
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		if !e.prs.throttle.wait(ctx, "expiry") {
+			return
+		}
+		if err := e.expire(ctx, time.Now()); err != nil {
+			logger.Error().Err(err).Msg("failed to expire review apps")
+		}
+	}
+}
+
+// expire tears down all review apps that weren't deployed or visited for longer than their TTL at
+// the given time.
+func (e *Expirer) expire(ctx context.Context, now time.Time) error {
+	ras, err := e.prs.openReviewApps(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, ra := range ras {
+		if err := e.expireOne(ctx, ra, now); err != nil {
+			ra.logger.Error().Err(err).Msg("failed to expire review app")
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// expireOne warns about or tears down the given review app if it expires at the given time.
+func (e *Expirer) expireOne(ctx context.Context, ra *reviewApp, now time.Time) error {
+	if !ra.cfg.rolledOut(featureExpiry) {
+		return nil
+	}
+	key := ra.storeKey()
+	if p := e.prs.protectionOf(ra); p != nil {
+		delete(e.warned, key)
+		return nil
+	}
+	repoCfg, err := e.prs.repoConfig(ctx, ra)
+	if err != nil {
+		return err
+	}
+	ttl := ra.cfg.Expiry.TTL
+	if repoCfg.TTL != 0 {
+		ttl = repoCfg.TTL
+	}
+	var unvisited time.Duration
+	if e.prs.gateway != nil {
+		unvisited = ra.cfg.Expiry.Unvisited
+	}
+	if ttl == 0 && unvisited == 0 {
+		delete(e.warned, key)
+		return nil
+	}
+	idleSince, ok, err := e.prs.idleSince(ctx, ra)
+	if err != nil || !ok {
+		return err
+	}
+
+	// The review app expires by whichever comes first, not being deployed or not being visited.
+	var since, expiry time.Time
+	var period time.Duration
+	var reason string
+	if ttl != 0 {
+		since, period, expiry = idleSince, ttl, idleSince.Add(ttl)
+		reason = fmt.Sprintf("it wasn't deployed or kept for %s", ttl)
+	}
+	if unvisited != 0 {
+		visited := e.prs.gateway.lastVisited(key, idleSince)
+		if expiry.IsZero() || visited.Add(unvisited).Before(expiry) {
+			since, period, expiry = visited, unvisited, visited.Add(unvisited)
+			reason = fmt.Sprintf("its preview wasn't visited for %s", unvisited)
+		}
+	}
+
+	warning := ra.cfg.Expiry.GetWarning()
+	if now.Before(expiry.Add(-warning)) {
+		delete(e.warned, key)
+		return nil
+	}
+	warnedAt, warned := e.warned[key]
+	if !warned || warnedAt.Before(since) {
+		// Review apps expiring without warning, e.g. as their TTL was lowered, get the full
+		// warning period.
+		if expiry.Before(now.Add(warning)) {
+			expiry = now.Add(warning)
+		}
+		ra.logger.Info().Time("expiry", expiry).Msg("warning of teardown of idle review app")
+		body := fmt.Sprintf("The review app will be torn down after %s as %s. Comment `%s` to keep it for another %s.", expiry.UTC().Format(time.RFC1123), reason, commandKeep, period)
+		if err := e.prs.comment(ctx, ra, commentKindExpiry, body); err != nil {
+			return err
+		}
+		e.warned[key] = now
+		return nil
+	}
+	if now.Before(expiry) || now.Before(warnedAt.Add(warning)) {
+		return nil
+	}
+
+	// Expiring supersedes the deployment in flight like other teardowns do.
+	ctx, done, _, err := e.prs.turns.take(ctx, ra.repo.GetFullName(), ra.number, turnSuperseding)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := e.prs.teardown(ctx, ra, reason); err != nil {
+		return err
+	}
+	delete(e.warned, key)
+	body := fmt.Sprintf("The review app was torn down as %s. Comment `%s` to recreate it.", reason, commandDeploy)
+	return e.prs.comment(ctx, ra, commentKindExpiry, body)
+}
+
+// idleSince returns when the review app was last deployed or kept, if it's live.
+func (h *PRHandler) idleSince(ctx context.Context, ra *reviewApp) (time.Time, bool, error) {
+	deployment, _, err := h.liveDeployment(ctx, ra)
+	if err != nil || deployment == nil {
+		return time.Time{}, false, err
+	}
+	status, err := latestDeploymentStatus(ctx, ra.client, ra.owner, ra.name, deployment.GetID())
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	// "/keep" records a new status of the live deployment.
+	idleSince := deployment.GetCreatedAt().Time
+	if since := status.GetCreatedAt().Time; since.After(idleSince) {
+		idleSince = since
+	}
+	return idleSince, true, nil
+}
+
+// keep keeps the review app from expiring for another TTL on behalf of the given user, by
+// recording a new status of its live deployment.
+func (h *PRHandler) keep(ctx context.Context, ra *reviewApp, user string) error {
+	deployment, _, err := h.liveDeployment(ctx, ra)
+	if err != nil || deployment == nil {
+		return err
+	}
+	status, err := latestDeploymentStatus(ctx, ra.client, ra.owner, ra.name, deployment.GetID())
+	if err != nil {
+		return err
+	}
+	if status.GetState() != deploymentStateSuccess {
+		// Deployments in progress reset the TTL anyway and failed ones are fixed rather than kept.
+		ra.logger.Info().Str("state", status.GetState()).Msg("not keeping review app whose deployment didn't succeed")
+		return nil
+	}
+
+	ra.logger.Info().Str("user", user).Msg("keeping review app")
+	_, _, err = ra.client.Repositories.CreateDeploymentStatus(ctx, ra.owner, ra.name, deployment.GetID(), &github.DeploymentStatusRequest{
+		State:          ptr(deploymentStateSuccess),
+		EnvironmentURL: ptr(status.GetEnvironmentURL()),
+		Description:    ptr(fmt.Sprintf("Kept by %s", user)),
+		AutoInactive:   ptr(true),
+	})
+	if err != nil {
+		return githubError(err, "failed to keep review app")
+	}
+	return h.comment(ctx, ra, commentKindExpiry, fmt.Sprintf("The review app was kept by @%s.", user))
+}
@@ -0,0 +1,109 @@
+package reviewapps
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Faults are failures injected into simulations, to validate how retries, timeouts and the
+// reconciliation of review apps cope with an unreliable App Platform and GitHub.
+type Faults struct {
+	// DOErrorPercentage is the percentage of requests to App Platform that fail with a 503.
+	DOErrorPercentage int
+	// DeploymentDelay keeps deployments in their first phase for this long.
+	DeploymentDelay time.Duration
+	// StatusDropPercentage is the percentage of GitHub deployment statuses that are acknowledged,
+	// but never recorded.
+	StatusDropPercentage int
+	// Seed seeds the choice of the requests that fail, so simulations are reproducible.
+	Seed int64
+}
+
+// validate returns an error if the faults are invalid.
+func (f Faults) validate() error {
+	if f.DOErrorPercentage < 0 || f.DOErrorPercentage > 100 {
+		return fmt.Errorf("DO error percentage %d must be between 0 and 100", f.DOErrorPercentage)
+	}
+	if f.StatusDropPercentage < 0 || f.StatusDropPercentage > 100 {
+		return fmt.Errorf("status drop percentage %d must be between 0 and 100", f.StatusDropPercentage)
+	}
+	if f.DeploymentDelay < 0 {
+		return fmt.Errorf("deployment delay %s must not be negative", f.DeploymentDelay)
+	}
+	return nil
+}
+
+// deploymentStatusesPath matches the paths of requests creating GitHub deployment statuses.
+var deploymentStatusesPath = regexp.MustCompile(`^/repos/[^/]+/[^/]+/deployments/\d+/statuses$`)
+
+// faultInjector injects the configured faults into requests and records them as actions instead
+// of the requests. Like
+// actions, faults are only injected once the recorder is enabled, so the simulated event is hit by
+// them rather than the setup preceding it.
+type faultInjector struct {
+	faults Faults
+	rec    *actionRecorder
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newFaultInjector(faults Faults, rec *actionRecorder) *faultInjector {
+	return &faultInjector{faults: faults, rec: rec, rand: rand.New(rand.NewSource(faults.Seed))}
+}
+
+// hit returns whether or not the next request is hit by a fault of the given percentage.
+func (f *faultInjector) hit(percentage int) bool {
+	if percentage == 0 || !f.rec.isEnabled() {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rand.Intn(100) < percentage
+}
+
+// do returns a middleware failing requests to App Platform.
+func (f *faultInjector) do(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if !f.hit(f.faults.DOErrorPercentage) {
+			return next.RoundTrip(req)
+		}
+		f.rec.record(fmt.Sprintf("fault  %-6s %s -> 503 (injected)", req.Method, req.URL.Path))
+		return injectedResponse(req, http.StatusServiceUnavailable, `{"id":"service_unavailable","message":"injected fault"}`), nil
+	})
+}
+
+// github returns a middleware dropping GitHub deployment statuses.
+func (f *faultInjector) github(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method != http.MethodPost || !deploymentStatusesPath.MatchString(req.URL.Path) || !f.hit(f.faults.StatusDropPercentage) {
+			return next.RoundTrip(req)
+		}
+		f.rec.record(fmt.Sprintf("fault  %-6s %s -> dropped (injected)", req.Method, req.URL.Path))
+		return injectedResponse(req, http.StatusCreated, `{}`), nil
+	})
+}
+
+// injectedResponse returns a JSON response with the given status and body to the given request,
+// which is never sent.
+func injectedResponse(req *http.Request, status int, body string) *http.Response {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+}
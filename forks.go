@@ -0,0 +1,149 @@
+package reviewapps
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-github/v60/github"
+)
+
+// forkBranch returns the branch the approved commit of a pull request from a forked repository is
+// deployed from. The fork's branch doesn't exist in the pull request's repository and keeps moving
+// without approval, so the approved commit is mirrored to a branch.
+func forkBranch(number int) string {
+	return fmt.Sprintf("reviewapps/fork/%d", number)
+}
+
+// isFork returns whether or not the given pull request is from a fork of the given repository.
+func isFork(repo *github.Repository, pr *github.PullRequest) bool {
+	return repo.GetID() != pr.GetHead().GetRepo().GetID()
+}
+
+// forkApprovalSkip returns why pull requests from forked repositories aren't deployed until their
+// head is approved.
+func forkApprovalSkip(cfg ReviewAppConfig) string {
+	return fmt.Sprintf("the head of pull requests of forked repositories has to be approved with label %q or %s by a user with write access", cfg.Forks.GetApprovalLabel(), commandDeploy)
+}
+
+// triageFork triages the given event of a pull request from a forked repository. Only approvals
+// create or update its review app, no matter the rest of the configuration.
+func triageFork(cfg ReviewAppConfig, event *github.PullRequestEvent) *triageResult {
+	if !cfg.Forks.Enabled {
+		return &triageResult{cfg: cfg, skip: "pull requests of forked repositories are not allowed"}
+	}
+
+	pr := event.GetPullRequest()
+	approval := cfg.Forks.GetApprovalLabel()
+	switch event.GetAction() {
+	case actionClosed:
+		return &triageResult{cfg: cfg, teardown: true}
+	case actionLabeled:
+		label := event.GetLabel().GetName()
+		if contains(cfg.TeardownLabels, label) {
+			return &triageResult{cfg: cfg, teardown: true}
+		}
+		if label != approval {
+			return &triageResult{skip: fmt.Sprintf("label %q neither approves nor tears down review apps", label), unhandled: true}
+		}
+		if hasAnyLabel(pr, cfg.TeardownLabels) {
+			return &triageResult{cfg: cfg, skip: "the pull request carries a teardown label"}
+		}
+		return &triageResult{cfg: cfg}
+	case actionUnlabeled, actionEdited:
+		// The approved commit stays deployed and the fork's directives are ignored anyway.
+		return &triageResult{skip: fmt.Sprintf("action %q is not handled for pull requests of forked repositories", event.GetAction()), unhandled: true}
+	}
+
+	// The approval label approves the head it was added at. A new or reopened pull request might
+	// carry it from elsewhere, and new commits aren't approved by it.
+	return &triageResult{cfg: cfg, skip: forkApprovalSkip(cfg), revokeApproval: hasLabel(pr, approval), approval: true}
+}
+
+// revokeApproval removes the approval label from the given pull request of a forked repository.
+func (h *PRHandler) revokeApproval(ctx context.Context, installationID int64, repo *github.Repository, number int, cfg ReviewAppConfig) error {
+	client, err := h.cc.NewInstallationClient(installationID)
+	if err != nil {
+		return githubError(err, "failed to create installation client")
+	}
+	resp, err := client.Issues.RemoveLabelForIssue(ctx, repo.GetOwner().GetLogin(), repo.GetName(), number, cfg.Forks.GetApprovalLabel())
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return githubError(err, "failed to remove approval label")
+	}
+	return nil
+}
+
+// hasWriteAccess returns whether or not the given user has write access to the review app's
+// repository.
+func hasWriteAccess(ctx context.Context, ra *reviewApp, login string) (bool, error) {
+	permission, _, err := ra.client.Repositories.GetPermissionLevel(ctx, ra.owner, ra.name, login)
+	if err != nil {
+		return false, githubError(err, "failed to get permission level")
+	}
+	switch permission.GetPermission() {
+	case "admin", "maintain", "write":
+		return true, nil
+	}
+	return false, nil
+}
+
+// sanitizeForkSpec makes the given spec of a pull request from a forked repository safe to
+// deploy. All secrets and registry credentials are stripped and all components are downsized to
+// the given instance size. Components may only be sourced from the given repository or one of the
+// given sources, so the fork can't deploy other private repositories. It returns the keys of the
+// stripped secrets.
+func sanitizeForkSpec(spec *godo.AppSpec, repo string, sources []SourceConfig, instanceSizeSlug string) ([]string, error) {
+	err := godo.ForEachAppSpecComponent(spec, func(c godo.AppBuildableComponentSpec) error {
+		if ref := c.GetGitHub(); ref != nil && ref.Repo != repo {
+			if _, ok := findSource(sources, c.GetName(), ref.Repo); !ok {
+				return errorf(ErrorKindSpecInvalid, "component %q of a forked pull request can't be sourced from %s", c.GetName(), ref.Repo)
+			}
+		}
+		if ref := c.GetGitLab(); ref != nil {
+			return errorf(ErrorKindSpecInvalid, "component %q of a forked pull request can't be sourced from GitLab", c.GetName())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var stripped []string
+	strip := func(envs []*godo.AppVariableDefinition) []*godo.AppVariableDefinition {
+		kept := envs[:0]
+		for _, env := range envs {
+			if env.Type == godo.AppVariableType_Secret {
+				stripped = append(stripped, env.Key)
+				continue
+			}
+			kept = append(kept, env)
+		}
+		return kept
+	}
+	spec.Envs = strip(spec.Envs)
+	for _, svc := range spec.Services {
+		svc.Envs = strip(svc.Envs)
+	}
+	for _, worker := range spec.Workers {
+		worker.Envs = strip(worker.Envs)
+	}
+	for _, job := range spec.Jobs {
+		job.Envs = strip(job.Envs)
+	}
+	for _, site := range spec.StaticSites {
+		site.Envs = strip(site.Envs)
+	}
+	for _, fn := range spec.Functions {
+		fn.Envs = strip(fn.Envs)
+	}
+
+	godo.ForEachAppSpecComponent(spec, func(c godo.AppContainerComponentSpec) error {
+		if image := c.GetImage(); image != nil {
+			image.RegistryCredentials = ""
+		}
+		return nil
+	})
+	downsizeSpec(spec, instanceSizeSlug)
+	return stripped, nil
+}
@@ -0,0 +1,243 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/rs/zerolog"
+	"github.internal.digitalocean.com/mthoemmes/reviewapps/store"
+)
+
+// gatewayPath is the path the gateway serves previews under.
+const gatewayPath = "/preview/"
+
+const (
+	// gatewayMissTTL is how long previews without a review app are answered without looking them up
+	// again.
+	gatewayMissTTL = time.Minute
+	// gatewayMaxMisses bounds the previews without a review app that are remembered.
+	gatewayMaxMisses = 10000
+)
+
+// previewVisits are the visits of a review app through the gateway.
+type previewVisits struct {
+	Count       int
+	LastVisited time.Time
+}
+
+// gateway fronts the live URLs of review apps, so their visits can be counted. Previews are linked
+// as "<url>/preview/<owner>/<repo>/<app name>/", which redirects to the app's live URL after
+// recording the visit. Visits are only tracked in memory.
+type gateway struct {
+	prs *PRHandler
+	url string
+	// started is when the gateway started tracking visits. Review apps count as visited then, so
+	// restarts don't make all of them look unvisited.
+	started time.Time
+
+	mu      sync.Mutex
+	targets map[store.Key]string
+	visits  map[store.Key]previewVisits
+	// misses are when previews were last found to have no review app.
+	misses map[store.Key]time.Time
+}
+
+func newGateway(prs *PRHandler, config GatewayConfig) *gateway {
+	return &gateway{
+		prs:     prs,
+		url:     strings.TrimSuffix(config.URL, "/"),
+		started: time.Now(),
+		targets: make(map[store.Key]string),
+		visits:  make(map[store.Key]previewVisits),
+		misses:  make(map[store.Key]time.Time),
+	}
+}
+
+// front records the given live URL of the given review app and returns the URL fronting it.
+func (g *gateway) front(key store.Key, liveURL string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.targets[key] = liveURL
+	delete(g.misses, key)
+	return g.url + gatewayPath + key.Repo + "/" + url.PathEscape(key.App) + "/"
+}
+
+// visitsOf returns the visits of the given review app.
+func (g *gateway) visitsOf(key store.Key) previewVisits {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.visits[key]
+}
+
+// lastVisited returns when the given review app was last visited, or the given time if it's later.
+func (g *gateway) lastVisited(key store.Key, since time.Time) time.Time {
+	if g.started.After(since) {
+		since = g.started
+	}
+	if v := g.visitsOf(key); v.LastVisited.After(since) {
+		return v.LastVisited
+	}
+	return since
+}
+
+// forget stops fronting the given review app once it's torn down.
+func (g *gateway) forget(key store.Key) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.targets, key)
+	delete(g.visits, key)
+}
+
+// ServeHTTP records the visit of the review app of the requested preview and redirects to the same
+// path of its live URL.
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, gatewayPath), "/", 4)
+	if len(parts) < 3 || parts[0] == "" || parts[1] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	key := store.Key{Repo: parts[0] + "/" + parts[1], App: parts[2]}
+
+	ctx := r.Context()
+	logger := zerolog.Ctx(ctx).With().Str("component", "gateway").Str("repo", key.Repo).Str("app_name", key.App).Logger()
+	ctx = logger.WithContext(ctx)
+
+	target, err := g.target(ctx, key)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to look up live URL of review app")
+		http.Error(w, "failed to look up review app", http.StatusBadGateway)
+		return
+	}
+	if target == "" {
+		http.Error(w, "review app not found", http.StatusNotFound)
+		return
+	}
+
+	g.mu.Lock()
+	v := g.visits[key]
+	v.Count++
+	v.LastVisited = time.Now()
+	g.visits[key] = v
+	g.mu.Unlock()
+	previewVisitsTotal.Add(key.Repo, 1)
+
+	dest := strings.TrimSuffix(target, "/") + "/"
+	if len(parts) == 4 {
+		dest += parts[3]
+	}
+	if r.URL.RawQuery != "" {
+		dest += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, dest, http.StatusFound)
+}
+
+// target returns the live URL of the given review app, or an empty string if it has none. Review
+// apps not fronted since the last restart are looked up by their app recorded in the state store
+// only, as the gateway is public and must not make anyone list all apps of the account.
+func (g *gateway) target(ctx context.Context, key store.Key) (string, error) {
+	g.mu.Lock()
+	target, ok := g.targets[key]
+	missed := time.Since(g.misses[key]) < gatewayMissTTL
+	g.mu.Unlock()
+	if ok {
+		return target, nil
+	}
+	if missed {
+		return "", nil
+	}
+
+	app, err := g.lookup(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if app == nil || !isOwned(app.GetSpec()) || app.GetSpec().GetName() != key.App || app.GetLiveURL() == "" {
+		g.miss(key)
+		return "", nil
+	}
+	g.targets[key] = app.GetLiveURL()
+	return app.GetLiveURL(), nil
+}
+
+// lookup returns the app recorded in the state store for the given review app, or nil if there's
+// none.
+func (g *gateway) lookup(ctx context.Context, key store.Key) (*godo.App, error) {
+	owner, name, _ := strings.Cut(key.Repo, "/")
+	if _, ok := parsePRAppName(g.prs.repoNamer(key.Repo), owner, name, key.App); !ok {
+		return nil, nil
+	}
+	appID, err := g.prs.store.Get(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up app in state store: %w", err)
+	}
+	app, resp, err := g.prs.do.Apps.Get(ctx, appID)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, doError(err, "failed to get app")
+	}
+	return app, nil
+}
+
+// miss records that the given preview has no review app. Expired misses are dropped once too many
+// are remembered. It must be called with mu held.
+func (g *gateway) miss(key store.Key) {
+	if len(g.misses) >= gatewayMaxMisses {
+		for k, at := range g.misses {
+			if time.Since(at) >= gatewayMissTTL {
+				delete(g.misses, k)
+			}
+		}
+		if len(g.misses) >= gatewayMaxMisses {
+			return
+		}
+	}
+	g.misses[key] = time.Now()
+}
+
+// frontedURL returns the URL the given live URL of the review app is linked as, which is the
+// gateway's if it's configured.
+func (h *PRHandler) frontedURL(ra *reviewApp, liveURL string) string {
+	if h.gateway == nil || liveURL == "" {
+		return liveURL
+	}
+	return h.gateway.front(ra.storeKey(), liveURL)
+}
+
+// visitSummary returns a summary of the visits of the torn down review app of a closed pull
+// request, or an empty string if it wasn't fronted by the gateway.
+func (h *PRHandler) visitSummary(ra *reviewApp) string {
+	if h.gateway == nil {
+		return ""
+	}
+	key := ra.storeKey()
+	h.gateway.mu.Lock()
+	_, fronted := h.gateway.targets[key]
+	h.gateway.mu.Unlock()
+	v := h.gateway.visitsOf(key)
+	switch {
+	case v.Count > 0:
+		times := "times"
+		if v.Count == 1 {
+			times = "time"
+		}
+		return fmt.Sprintf("The review app was torn down. Its preview was visited %d %s, last at %s.", v.Count, times, v.LastVisited.UTC().Format(time.RFC1123))
+	case fronted:
+		return "The review app was torn down. Its preview was never visited."
+	}
+	return ""
+}
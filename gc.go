@@ -0,0 +1,295 @@
+package reviewapps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v60/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/rs/zerolog"
+)
+
+// GarbageCollector removes GitHub deployments and environments of review apps of closed pull
+// requests, which piled up before teardowns cleaned up after themselves. It runs on a schedule
+// and on demand via the admin endpoint.
+type GarbageCollector struct {
+	prs        *PRHandler
+	schedule   *cronSchedule
+	adminToken string
+}
+
+// NewGarbageCollector returns a new GarbageCollector for the given schedule, which may be empty to
+// only collect on demand. Requests to collect on demand must present the given admin token.
+func NewGarbageCollector(prs *PRHandler, schedule, adminToken string) (*GarbageCollector, error) {
+	gc := &GarbageCollector{prs: prs, adminToken: adminToken}
+	if schedule != "" {
+		s, err := parseCron(schedule)
+		if err != nil {
+			return nil, err
+		}
+		gc.schedule = s
+	}
+	return gc, nil
+}
+
+// gcResult is the result of collecting the garbage of a single repository.
+type gcResult struct {
+	Repo string `json:"repo"`
+	// Environments are the environments whose deployments were deleted.
+	Environments []string `json:"environments"`
+	Deployments  int      `json:"deployments"`
+	// Kept are the environments of closed pull requests that were kept, with the reason why.
+	Kept map[string]string `json:"kept,omitempty"`
+}
+
+// Run collects the garbage of all repositories on the schedule until the context is done.
+func (gc *GarbageCollector) Run(ctx context.Context) {
+	if gc.schedule == nil {
+		return
+	}
+
+	logger := zerolog.Ctx(ctx).With().Str("component", "gc").Logger()
+	ctx = logger.WithContext(ctx)
+
+	for {
+		next := gc.schedule.Next(time.Now().UTC())
+		if next.IsZero() {
+			logger.Error().Msg("garbage collection schedule never matches")
+			return
+		}
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		if !gc.prs.throttle.wait(ctx, "gc") {
+			return
+		}
+		if err := gc.collectAll(ctx); err != nil {
+			logger.Error().Err(err).Msg("failed to collect garbage")
+		}
+	}
+}
+
+// collectAll collects the garbage of all repositories of all installations. Failures of single
+// repositories don't stop the others from being collected.
+func (gc *GarbageCollector) collectAll(ctx context.Context) error {
+	installationIDs, err := gc.prs.installationIDs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, installationID := range installationIDs {
+		client, err := gc.prs.cc.NewInstallationClient(installationID)
+		if err != nil {
+			return githubError(err, "failed to create installation client")
+		}
+		repos, err := installationRepos(ctx, client)
+		if err != nil {
+			return err
+		}
+		for _, repo := range repos {
+			repoCtx, logger := githubapp.PrepareRepoContext(ctx, installationID, repo)
+			result, err := gc.collect(repoCtx, client, repo)
+			if err != nil {
+				logger.Error().Err(err).Msg("failed to collect garbage of repository")
+				continue
+			}
+			if len(result.Environments) > 0 {
+				logger.Info().Strs("environments", result.Environments).Int("deployments", result.Deployments).Msg("collected garbage")
+			}
+		}
+	}
+	return nil
+}
+
+// collect deletes all deployments and environments of review apps of closed pull requests of the
+// given repository. Environments whose app still exists are kept, so the app isn't orphaned.
+func (gc *GarbageCollector) collect(ctx context.Context, client *github.Client, repo *github.Repository) (*gcResult, error) {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	result := &gcResult{Repo: repo.GetFullName(), Environments: []string{}, Kept: map[string]string{}}
+
+	environments, err := reviewAppEnvironments(ctx, client, gc.prs.repoNamer(repo.GetFullName()), owner, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, env := range environments {
+		pr, _, err := client.PullRequests.Get(ctx, owner, name, env.number)
+		if err != nil && !isGitHubNotFound(err) {
+			return nil, githubError(err, fmt.Sprintf("failed to get pull request #%d", env.number))
+		}
+		if err == nil && pr.GetState() != "closed" {
+			continue
+		}
+
+		deployments, err := environmentDeployments(ctx, client, owner, name, env.name)
+		if err != nil {
+			return nil, err
+		}
+		if len(deployments) > 0 {
+			if payload, err := parseDeploymentPayload(deployments[0].Payload); err == nil && payload.AppID != "" {
+				exists, err := appExists(ctx, gc.prs.do, payload.AppID)
+				if err != nil {
+					return nil, err
+				}
+				if exists {
+					result.Kept[env.name] = fmt.Sprintf("app %s still exists", payload.AppID)
+					continue
+				}
+			}
+		}
+
+		for _, d := range deployments {
+			// Only inactive deployments can be deleted.
+			_, _, err := client.Repositories.CreateDeploymentStatus(ctx, owner, name, d.GetID(), &github.DeploymentStatusRequest{
+				State: ptr(deploymentStateInactive),
+			})
+			if err != nil {
+				return nil, githubError(err, fmt.Sprintf("failed to deactivate deployment %d", d.GetID()))
+			}
+			if _, err := client.Repositories.DeleteDeployment(ctx, owner, name, d.GetID()); err != nil && !isGitHubNotFound(err) {
+				return nil, githubError(err, fmt.Sprintf("failed to delete deployment %d", d.GetID()))
+			}
+			result.Deployments++
+		}
+		// Deployments created before environments existed have no environment to delete.
+		if _, err := client.Repositories.DeleteEnvironment(ctx, owner, name, env.name); err != nil && !isGitHubNotFound(err) {
+			return nil, githubError(err, fmt.Sprintf("failed to delete environment %s", env.name))
+		}
+		result.Environments = append(result.Environments, env.name)
+	}
+	return result, nil
+}
+
+// reviewAppEnvironment is an environment named like the review app of a pull request.
+type reviewAppEnvironment struct {
+	name   string
+	number int
+}
+
+// reviewAppEnvironments returns all environments of the given repository that are named like
+// review apps by the given namer, including the ones only known from deployments.
+func reviewAppEnvironments(ctx context.Context, client *github.Client, namer Namer, owner, repo string) ([]reviewAppEnvironment, error) {
+	numbers := make(map[string]int)
+	add := func(name string) {
+		if number, ok := parsePRAppName(namer, owner, repo, name); ok {
+			numbers[name] = number
+		}
+	}
+
+	envOpts := &github.EnvironmentListOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		envs, resp, err := client.Repositories.ListEnvironments(ctx, owner, repo, envOpts)
+		if isGitHubNotFound(err) {
+			// A 404 means there are no environments to list.
+			break
+		} else if err != nil {
+			return nil, githubError(err, "failed to list environments")
+		}
+		for _, env := range envs.Environments {
+			add(env.GetName())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		envOpts.Page = resp.NextPage
+	}
+
+	deploymentOpts := &github.DeploymentsListOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		deployments, resp, err := client.Repositories.ListDeployments(ctx, owner, repo, deploymentOpts)
+		if err != nil {
+			return nil, githubError(err, "failed to list deployments")
+		}
+		for _, d := range deployments {
+			add(d.GetEnvironment())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		deploymentOpts.Page = resp.NextPage
+	}
+
+	environments := make([]reviewAppEnvironment, 0, len(numbers))
+	for name, number := range numbers {
+		environments = append(environments, reviewAppEnvironment{name: name, number: number})
+	}
+	sort.Slice(environments, func(i, j int) bool { return environments[i].number < environments[j].number })
+	return environments, nil
+}
+
+// environmentDeployments returns all deployments of the given environment, newest first.
+func environmentDeployments(ctx context.Context, client *github.Client, owner, repo, environment string) ([]*github.Deployment, error) {
+	var all []*github.Deployment
+	opts := &github.DeploymentsListOptions{Environment: environment, ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		deployments, resp, err := client.Repositories.ListDeployments(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, githubError(err, "failed to list deployments")
+		}
+		all = append(all, deployments...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// ServeHTTP collects the garbage of the repository given as the "repo" query parameter, i.e.
+// "owner/name", and responds with the result as JSON. Requests must be POSTs authorized with the
+// admin token as bearer token.
+func (gc *GarbageCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, gc.adminToken) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	owner, name, ok := strings.Cut(r.URL.Query().Get("repo"), "/")
+	if !ok {
+		http.Error(w, "the repo query parameter must be of the form owner/name", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	logger := zerolog.Ctx(ctx).With().Str("component", "gc").Str(githubapp.LogKeyRepositoryOwner, owner).Str(githubapp.LogKeyRepositoryName, name).Logger()
+	ctx = logger.WithContext(ctx)
+
+	result, err := gc.collectRepo(ctx, owner, name)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to collect garbage of repository")
+		status := http.StatusInternalServerError
+		if isGitHubNotFound(err) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	logger.Info().Strs("environments", result.Environments).Int("deployments", result.Deployments).Msg("collected garbage")
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// collectRepo collects the garbage of the given repository.
+func (gc *GarbageCollector) collectRepo(ctx context.Context, owner, name string) (*gcResult, error) {
+	_, client, err := gc.prs.repoInstallation(ctx, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	repo, _, err := client.Repositories.Get(ctx, owner, name)
+	if err != nil {
+		return nil, githubError(err, "failed to get repository")
+	}
+	return gc.collect(ctx, client, repo)
+}
@@ -0,0 +1,112 @@
+package reviewapps
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/palantir/go-githubapp/githubapp"
+)
+
+const (
+	// githubAPIURL is the URL of the REST API of github.com.
+	githubAPIURL = "https://api.github.com/"
+	// githubGraphQLURL is the URL of the GraphQL API of github.com.
+	githubGraphQLURL = "https://api.github.com/graphql"
+	// githubWebURL is the URL of github.com itself.
+	githubWebURL = "https://github.com"
+)
+
+// setGithubURLs defaults the URLs of the GitHub APIs to the ones of github.com and completes the
+// URLs of GitHub Enterprise Server instances, so only their REST API URL needs to be configured.
+// Like go-github's enterprise URLs, REST API URLs of instances that aren't served from an "api."
+// host are served under "/api/v3/", and their GraphQL API under "/api/graphql".
+func setGithubURLs(c *githubapp.Config) error {
+	if c.V3APIURL == "" {
+		c.V3APIURL = githubAPIURL
+	}
+	u, err := url.Parse(c.V3APIURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid v3_api_url %q: must be an absolute http or https URL", c.V3APIURL)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	apiHost := strings.HasPrefix(u.Host, "api.") || strings.Contains(u.Host, ".api.")
+	if !apiHost && !strings.HasSuffix(u.Path, "/api/v3/") {
+		u.Path += "api/v3/"
+	}
+	c.V3APIURL = u.String()
+
+	if c.V4APIURL == "" {
+		graphql := *u
+		if apiHost {
+			graphql.Path = "/graphql"
+		} else {
+			graphql.Path = strings.TrimSuffix(u.Path, "/v3/") + "/graphql"
+		}
+		c.V4APIURL = graphql.String()
+	}
+	if c.WebURL == "" {
+		web := url.URL{Scheme: u.Scheme, Host: u.Host}
+		if apiHost {
+			web.Host = strings.TrimPrefix(strings.Replace(u.Host, ".api.", ".", 1), "api.")
+		}
+		c.WebURL = web.String()
+	}
+	for name, value := range map[string]string{"v4_api_url": c.V4APIURL, "web_url": c.WebURL} {
+		if u, err := url.Parse(value); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("invalid %s %q: must be an absolute http or https URL", name, value)
+		}
+	}
+	return nil
+}
+
+// timeout returns the timeout of the given request to the GitHub API.
+func (c GithubClientConfig) timeout(req *http.Request) time.Duration {
+	switch {
+	case req.Method == http.MethodGet && (strings.Contains(req.URL.Path, "/contents/") || strings.Contains(req.URL.Path, "/actions/artifacts/")):
+		return c.GetContentsTimeout()
+	case req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/deployments"):
+		return c.GetDeploymentsTimeout()
+	}
+	return c.GetTimeout()
+}
+
+// maxTimeout returns the longest timeout of any request to the GitHub API.
+func (c GithubClientConfig) maxTimeout() time.Duration {
+	return max(c.GetTimeout(), c.GetContentsTimeout(), c.GetDeploymentsTimeout())
+}
+
+// githubDeadlines returns a middleware applying the configured timeout of each request to the
+// GitHub API as its deadline. The deadline covers reading the response's body, too.
+func githubDeadlines(c GithubClientConfig) githubapp.ClientMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			ctx, cancel := context.WithTimeout(req.Context(), c.timeout(req))
+			resp, err := next.RoundTrip(req.WithContext(ctx))
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			resp.Body = &cancelingBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		})
+	}
+}
+
+// cancelingBody cancels the context of its request once it's closed.
+type cancelingBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelingBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
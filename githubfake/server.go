@@ -0,0 +1,846 @@
+// Package githubfake implements an in-memory fake of the subset of the GitHub API used by the
+// review apps service, for a single installation of the GitHub App. Together with the dofake
+// package, it allows driving the handlers end-to-end without any external dependencies.
+package githubfake
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v60/github"
+	"github.com/palantir/go-githubapp/githubapp"
+)
+
+const (
+	// InstallationID is the ID of the only installation of the fake.
+	InstallationID int64 = 1
+	// AppID is the ID of the GitHub App of the fake.
+	AppID int64 = 1
+)
+
+// Server is a fake GitHub API server.
+type Server struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	nextID int64
+	repos  map[string]*repo
+}
+
+// repo is the state of a single repository.
+type repo struct {
+	*github.Repository
+	// files are the files per ref and path.
+	files       map[string]map[string]string
+	pulls       map[int]*github.PullRequest
+	pullFiles   map[int][]string
+	permissions map[string]string
+	deployments []*github.Deployment
+	// environments are created implicitly by their first deployment, like on GitHub.
+	environments map[string]bool
+	statuses     map[int64][]*github.DeploymentStatus
+	comments     map[int][]*github.IssueComment
+	refs         map[string]string
+	checkRuns    []*github.CheckRun
+}
+
+// New starts a new fake server. It must be closed by the caller.
+func New() *Server {
+	s := &Server{repos: make(map[string]*repo)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /app", s.getApp)
+	mux.HandleFunc("POST /app/installations/{installation}/access_tokens", s.createToken)
+	mux.HandleFunc("GET /app/installations", s.listInstallations)
+	mux.HandleFunc("GET /installation/repositories", s.listInstallationRepos)
+	mux.HandleFunc("GET /repos/{owner}/{repo}", s.getRepo)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/installation", s.getRepoInstallation)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/contents/{path...}", s.getContents)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/pulls", s.listPulls)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/pulls/{number}", s.getPull)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/pulls/{number}/files", s.listPullFiles)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/compare/{basehead}", s.compare)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/collaborators/{user}/permission", s.getPermission)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/deployments", s.listDeployments)
+	mux.HandleFunc("POST /repos/{owner}/{repo}/deployments", s.createDeployment)
+	mux.HandleFunc("DELETE /repos/{owner}/{repo}/deployments/{deployment}", s.deleteDeployment)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/environments", s.listEnvironments)
+	mux.HandleFunc("DELETE /repos/{owner}/{repo}/environments/{environment}", s.deleteEnvironment)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/deployments/{deployment}/statuses", s.listDeploymentStatuses)
+	mux.HandleFunc("POST /repos/{owner}/{repo}/deployments/{deployment}/statuses", s.createDeploymentStatus)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/issues/{number}/comments", s.listComments)
+	mux.HandleFunc("POST /repos/{owner}/{repo}/issues/{number}/comments", s.createComment)
+	mux.HandleFunc("PATCH /repos/{owner}/{repo}/issues/comments/{comment}", s.editComment)
+	mux.HandleFunc("POST /repos/{owner}/{repo}/issues/comments/{comment}/reactions", s.createReaction)
+	mux.HandleFunc("POST /repos/{owner}/{repo}/issues/{number}/labels", s.addLabels)
+	mux.HandleFunc("DELETE /repos/{owner}/{repo}/issues/{number}/labels/{label}", s.removeLabel)
+	mux.HandleFunc("POST /repos/{owner}/{repo}/check-runs", s.createCheckRun)
+	mux.HandleFunc("PATCH /repos/{owner}/{repo}/check-runs/{run}", s.updateCheckRun)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/commits/{ref}/check-runs", s.listCheckRuns)
+	mux.HandleFunc("PATCH /repos/{owner}/{repo}/git/refs/{ref...}", s.updateRef)
+	mux.HandleFunc("POST /repos/{owner}/{repo}/git/refs", s.createRef)
+	mux.HandleFunc("DELETE /repos/{owner}/{repo}/git/refs/{ref...}", s.deleteRef)
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// ClientCreator returns a client creator for clients talking to the fake server.
+func (s *Server) ClientCreator(opts ...githubapp.ClientOption) (githubapp.ClientCreator, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return githubapp.NewClientCreator(s.URL+"/", s.URL+"/graphql", AppID, pemKey, opts...), nil
+}
+
+// AddRepo adds a repository to the installation.
+func (s *Server) AddRepo(owner, name string) *github.Repository {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &github.Repository{
+		ID:            ptr(s.id()),
+		Name:          ptr(name),
+		FullName:      ptr(owner + "/" + name),
+		Owner:         &github.User{Login: ptr(owner)},
+		DefaultBranch: ptr("main"),
+	}
+	s.repos[r.GetFullName()] = &repo{
+		Repository:   r,
+		files:        make(map[string]map[string]string),
+		pulls:        make(map[int]*github.PullRequest),
+		pullFiles:    make(map[int][]string),
+		permissions:  make(map[string]string),
+		statuses:     make(map[int64][]*github.DeploymentStatus),
+		comments:     make(map[int][]*github.IssueComment),
+		refs:         make(map[string]string),
+		environments: make(map[string]bool),
+	}
+	return r
+}
+
+// SetFile sets the content of the file at the given path of the given ref of the repository.
+func (s *Server) SetFile(fullName, ref, path, content string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.repos[fullName]
+	if r.files[ref] == nil {
+		r.files[ref] = make(map[string]string)
+	}
+	r.files[ref][path] = content
+}
+
+// SetPullRequest adds or replaces the given pull request of the repository. Its base and, unless
+// set, head repository are set to the repository.
+func (s *Server) SetPullRequest(fullName string, pr *github.PullRequest, files ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.repos[fullName]
+	if pr.Base == nil {
+		pr.Base = &github.PullRequestBranch{Ref: r.DefaultBranch}
+	}
+	pr.Base.Repo = r.Repository
+	if pr.Head == nil {
+		pr.Head = &github.PullRequestBranch{}
+	}
+	if pr.Head.Repo == nil {
+		pr.Head.Repo = r.Repository
+	}
+	r.pulls[pr.GetNumber()] = pr
+	r.pullFiles[pr.GetNumber()] = files
+}
+
+// SetPermission sets the permission of the given user on the repository.
+func (s *Server) SetPermission(fullName, user, permission string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.repos[fullName].permissions[user] = permission
+}
+
+// Deployments returns all deployments of the repository, latest first.
+func (s *Server) Deployments(fullName string) []*github.Deployment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*github.Deployment(nil), s.repos[fullName].deployments...)
+}
+
+// DeploymentStatuses returns all statuses of the given deployment of the repository, latest first.
+func (s *Server) DeploymentStatuses(fullName string, deploymentID int64) []*github.DeploymentStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*github.DeploymentStatus(nil), s.repos[fullName].statuses[deploymentID]...)
+}
+
+// Environments returns the names of all environments of the repository, sorted.
+func (s *Server) Environments(fullName string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.repos[fullName].environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Comments returns all comments on the given pull request of the repository.
+func (s *Server) Comments(fullName string, number int) []*github.IssueComment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*github.IssueComment(nil), s.repos[fullName].comments[number]...)
+}
+
+// CheckRuns returns all check runs of the repository, in the order they were created.
+func (s *Server) CheckRuns(fullName string) []*github.CheckRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*github.CheckRun(nil), s.repos[fullName].checkRuns...)
+}
+
+func (s *Server) getApp(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, &github.App{ID: ptr(AppID), Slug: ptr("reviewapps")})
+}
+
+func (s *Server) createToken(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusCreated, &github.InstallationToken{
+		Token:     ptr("fake-token"),
+		ExpiresAt: &github.Timestamp{Time: time.Now().Add(time.Hour)},
+	})
+}
+
+func (s *Server) listInstallations(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, []*github.Installation{{ID: ptr(InstallationID), AppID: ptr(AppID)}})
+}
+
+func (s *Server) listInstallationRepos(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var repos []*github.Repository
+	for _, repo := range s.repos {
+		repos = append(repos, repo.Repository)
+	}
+	writeJSON(w, http.StatusOK, &github.ListRepositories{TotalCount: ptr(len(repos)), Repositories: repos})
+}
+
+func (s *Server) getRepo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, repo.Repository)
+}
+
+func (s *Server) getRepoInstallation(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.repo(w, r); !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, &github.Installation{ID: ptr(InstallationID), AppID: ptr(AppID)})
+}
+
+func (s *Server) getContents(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	ref := r.URL.Query().Get("ref")
+	if ref == "" {
+		ref = repo.GetDefaultBranch()
+	}
+	path := r.PathValue("path")
+	content, ok := repo.files[ref][path]
+	if !ok {
+		// Paths that aren't files are listed as directories of the files directly below them.
+		var dir []*github.RepositoryContent
+		for p := range repo.files[ref] {
+			if name, ok := strings.CutPrefix(p, path+"/"); ok && !strings.Contains(name, "/") {
+				dir = append(dir, &github.RepositoryContent{Type: ptr("file"), Name: ptr(name), Path: ptr(p)})
+			}
+		}
+		if len(dir) == 0 {
+			writeError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		sort.Slice(dir, func(i, j int) bool { return dir[i].GetName() < dir[j].GetName() })
+		writeJSON(w, http.StatusOK, dir)
+		return
+	}
+	writeJSON(w, http.StatusOK, &github.RepositoryContent{
+		Type:     ptr("file"),
+		Path:     ptr(path),
+		Encoding: ptr("base64"),
+		Content:  ptr(base64.StdEncoding.EncodeToString([]byte(content))),
+	})
+}
+
+func (s *Server) listPulls(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	state, base := query.Get("state"), query.Get("base")
+	// Heads are of the form "owner:branch" and never match pull requests from forks.
+	_, head, _ := strings.Cut(query.Get("head"), ":")
+	var prs []*github.PullRequest
+	for _, pr := range repo.pulls {
+		if (state == "" || state == "all" || pr.GetState() == state) &&
+			(base == "" || pr.GetBase().GetRef() == base) &&
+			(head == "" || pr.GetHead().GetRef() == head) {
+			prs = append(prs, pr)
+		}
+	}
+	writeJSON(w, http.StatusOK, prs)
+}
+
+func (s *Server) getPull(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pr, ok := s.pull(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, pr)
+}
+
+func (s *Server) listPullFiles(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pr, ok := s.pull(w, r)
+	if !ok {
+		return
+	}
+	var files []*github.CommitFile
+	for _, f := range s.repos[pr.GetBase().GetRepo().GetFullName()].pullFiles[pr.GetNumber()] {
+		files = append(files, &github.CommitFile{Filename: ptr(f), Status: ptr("modified")})
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+// compare compares with the head of a pull request, whose files are the files changed since any
+// base.
+func (s *Server) compare(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	_, head, _ := strings.Cut(r.PathValue("basehead"), "...")
+	for number, pr := range repo.pulls {
+		if pr.GetHead().GetSHA() != head {
+			continue
+		}
+		files := []*github.CommitFile{}
+		for _, f := range repo.pullFiles[number] {
+			files = append(files, &github.CommitFile{Filename: ptr(f), Status: ptr("modified")})
+		}
+		writeJSON(w, http.StatusOK, &github.CommitsComparison{Files: files})
+		return
+	}
+	writeError(w, http.StatusNotFound, "Not Found")
+}
+
+func (s *Server) createCheckRun(w http.ResponseWriter, r *http.Request) {
+	var req github.CreateCheckRunOptions
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	run := &github.CheckRun{
+		ID:         ptr(s.id()),
+		Name:       ptr(req.Name),
+		HeadSHA:    ptr(req.HeadSHA),
+		ExternalID: req.ExternalID,
+		DetailsURL: req.DetailsURL,
+		Status:     req.Status,
+		Conclusion: req.Conclusion,
+		Output:     req.Output,
+	}
+	repo.checkRuns = append(repo.checkRuns, run)
+	writeJSON(w, http.StatusCreated, run)
+}
+
+func (s *Server) updateCheckRun(w http.ResponseWriter, r *http.Request) {
+	var req github.UpdateCheckRunOptions
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	for _, run := range repo.checkRuns {
+		if fmt.Sprint(run.GetID()) != r.PathValue("run") {
+			continue
+		}
+		if req.Status != nil {
+			run.Status = req.Status
+		}
+		if req.Conclusion != nil {
+			run.Conclusion = req.Conclusion
+		}
+		if req.Output != nil {
+			run.Output = req.Output
+		}
+		writeJSON(w, http.StatusOK, run)
+		return
+	}
+	writeError(w, http.StatusNotFound, "Not Found")
+}
+
+func (s *Server) listCheckRuns(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	name := r.URL.Query().Get("check_name")
+	runs := []*github.CheckRun{}
+	for i := len(repo.checkRuns) - 1; i >= 0; i-- {
+		run := repo.checkRuns[i]
+		if run.GetHeadSHA() == r.PathValue("ref") && (name == "" || run.GetName() == name) {
+			runs = append(runs, run)
+		}
+	}
+	writeJSON(w, http.StatusOK, &github.ListCheckRunsResults{Total: ptr(len(runs)), CheckRuns: runs})
+}
+
+func (s *Server) getPermission(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	permission, ok := repo.permissions[r.PathValue("user")]
+	if !ok {
+		permission = "none"
+	}
+	writeJSON(w, http.StatusOK, &github.RepositoryPermissionLevel{
+		Permission: ptr(permission),
+		User:       &github.User{Login: ptr(r.PathValue("user"))},
+	})
+}
+
+func (s *Server) listDeployments(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	deployments := []*github.Deployment{}
+	for _, d := range repo.deployments {
+		if (q.Get("environment") == "" || q.Get("environment") == d.GetEnvironment()) &&
+			(q.Get("sha") == "" || q.Get("sha") == d.GetSHA()) &&
+			(q.Get("ref") == "" || q.Get("ref") == d.GetRef()) {
+			deployments = append(deployments, d)
+		}
+	}
+	writeJSON(w, http.StatusOK, deployments)
+}
+
+func (s *Server) createDeployment(w http.ResponseWriter, r *http.Request) {
+	var req github.DeploymentRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	sha := req.GetRef()
+	for _, pr := range repo.pulls {
+		if pr.GetHead().GetRef() == req.GetRef() {
+			sha = pr.GetHead().GetSHA()
+		}
+	}
+	if refSHA, ok := repo.refs[req.GetRef()]; ok {
+		sha = refSHA
+	}
+	payload, err := json.Marshal(req.Payload)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "invalid payload")
+		return
+	}
+	d := &github.Deployment{
+		ID:          ptr(s.id()),
+		Ref:         req.Ref,
+		SHA:         ptr(sha),
+		Environment: req.Environment,
+		Description: req.Description,
+		Payload:     payload,
+		CreatedAt:   &github.Timestamp{Time: time.Now()},
+	}
+	repo.deployments = append([]*github.Deployment{d}, repo.deployments...)
+	repo.environments[d.GetEnvironment()] = true
+	writeJSON(w, http.StatusCreated, d)
+}
+
+func (s *Server) deleteDeployment(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	id, _ := strconv.ParseInt(r.PathValue("deployment"), 10, 64)
+	for i, d := range repo.deployments {
+		if d.GetID() != id {
+			continue
+		}
+		if statuses := repo.statuses[id]; len(statuses) > 0 && statuses[0].GetState() != "inactive" {
+			writeError(w, http.StatusUnprocessableEntity, "We cannot delete an active deployment unless it is the only deployment in a given environment.")
+			return
+		}
+		repo.deployments = append(repo.deployments[:i], repo.deployments[i+1:]...)
+		delete(repo.statuses, id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeError(w, http.StatusNotFound, "Not Found")
+}
+
+func (s *Server) listEnvironments(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	var names []string
+	for name := range repo.environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	envs := []*github.Environment{}
+	for _, name := range names {
+		envs = append(envs, &github.Environment{Name: ptr(name)})
+	}
+	writeJSON(w, http.StatusOK, &github.EnvResponse{TotalCount: ptr(len(envs)), Environments: envs})
+}
+
+func (s *Server) deleteEnvironment(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	name := r.PathValue("environment")
+	if !repo.environments[name] {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	delete(repo.environments, name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listDeploymentStatuses(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	id, _ := strconv.ParseInt(r.PathValue("deployment"), 10, 64)
+	writeJSON(w, http.StatusOK, append([]*github.DeploymentStatus{}, repo.statuses[id]...))
+}
+
+func (s *Server) createDeploymentStatus(w http.ResponseWriter, r *http.Request) {
+	var req github.DeploymentStatusRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	id, _ := strconv.ParseInt(r.PathValue("deployment"), 10, 64)
+	status := &github.DeploymentStatus{
+		ID:             ptr(s.id()),
+		State:          req.State,
+		Description:    req.Description,
+		EnvironmentURL: req.EnvironmentURL,
+		LogURL:         req.LogURL,
+		CreatedAt:      &github.Timestamp{Time: time.Now()},
+	}
+	repo.statuses[id] = append([]*github.DeploymentStatus{status}, repo.statuses[id]...)
+	writeJSON(w, http.StatusCreated, status)
+}
+
+func (s *Server) listComments(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	number, _ := strconv.Atoi(r.PathValue("number"))
+	writeJSON(w, http.StatusOK, append([]*github.IssueComment{}, repo.comments[number]...))
+}
+
+func (s *Server) createComment(w http.ResponseWriter, r *http.Request) {
+	var req github.IssueComment
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	number, _ := strconv.Atoi(r.PathValue("number"))
+	comment := &github.IssueComment{
+		ID:        ptr(s.id()),
+		Body:      req.Body,
+		User:      &github.User{Login: ptr("reviewapps[bot]"), Type: ptr("Bot")},
+		CreatedAt: &github.Timestamp{Time: time.Now()},
+	}
+	repo.comments[number] = append(repo.comments[number], comment)
+	writeJSON(w, http.StatusCreated, comment)
+}
+
+func (s *Server) editComment(w http.ResponseWriter, r *http.Request) {
+	var req github.IssueComment
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	id, _ := strconv.ParseInt(r.PathValue("comment"), 10, 64)
+	for _, comments := range repo.comments {
+		for _, comment := range comments {
+			if comment.GetID() == id {
+				comment.Body = req.Body
+				comment.UpdatedAt = &github.Timestamp{Time: time.Now()}
+				writeJSON(w, http.StatusOK, comment)
+				return
+			}
+		}
+	}
+	writeError(w, http.StatusNotFound, "comment not found")
+}
+
+func (s *Server) createReaction(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Content string `json:"content"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusCreated, &github.Reaction{ID: ptr(s.id()), Content: ptr(req.Content)})
+}
+
+func (s *Server) updateRef(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SHA string `json:"sha"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	ref := strings.TrimPrefix(r.PathValue("ref"), "heads/")
+	if _, ok := repo.refs[ref]; !ok {
+		writeError(w, http.StatusUnprocessableEntity, "Reference does not exist")
+		return
+	}
+	repo.refs[ref] = req.SHA
+	writeJSON(w, http.StatusOK, reference(ref, req.SHA))
+}
+
+func (s *Server) createRef(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Ref string `json:"ref"`
+		SHA string `json:"sha"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	ref := strings.TrimPrefix(req.Ref, "refs/heads/")
+	if _, ok := repo.refs[ref]; ok {
+		writeError(w, http.StatusUnprocessableEntity, "Reference already exists")
+		return
+	}
+	repo.refs[ref] = req.SHA
+	writeJSON(w, http.StatusCreated, reference(ref, req.SHA))
+}
+
+func (s *Server) deleteRef(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return
+	}
+	ref := strings.TrimPrefix(r.PathValue("ref"), "heads/")
+	if _, ok := repo.refs[ref]; !ok {
+		writeError(w, http.StatusUnprocessableEntity, "Reference does not exist")
+		return
+	}
+	delete(repo.refs, ref)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) addLabels(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	if err := json.NewDecoder(r.Body).Decode(&names); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pr, ok := s.pull(w, r)
+	if !ok {
+		return
+	}
+	for _, name := range names {
+		if !slices.ContainsFunc(pr.Labels, func(l *github.Label) bool { return l.GetName() == name }) {
+			pr.Labels = append(pr.Labels, &github.Label{Name: ptr(name)})
+		}
+	}
+	writeJSON(w, http.StatusOK, pr.Labels)
+}
+
+func (s *Server) removeLabel(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pr, ok := s.pull(w, r)
+	if !ok {
+		return
+	}
+	for i, l := range pr.Labels {
+		if l.GetName() == r.PathValue("label") {
+			pr.Labels = append(pr.Labels[:i], pr.Labels[i+1:]...)
+			writeJSON(w, http.StatusOK, pr.Labels)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "Label does not exist")
+}
+
+// repo returns the repository of the request or writes a 404 if it doesn't exist. The lock must
+// be held.
+func (s *Server) repo(w http.ResponseWriter, r *http.Request) (*repo, bool) {
+	repo, ok := s.repos[r.PathValue("owner")+"/"+r.PathValue("repo")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+	return repo, ok
+}
+
+// pull returns the pull request of the request or writes a 404 if it doesn't exist. The lock must
+// be held.
+func (s *Server) pull(w http.ResponseWriter, r *http.Request) (*github.PullRequest, bool) {
+	repo, ok := s.repo(w, r)
+	if !ok {
+		return nil, false
+	}
+	number, _ := strconv.Atoi(r.PathValue("number"))
+	pr, ok := repo.pulls[number]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+	return pr, ok
+}
+
+// id returns a new ID. The lock must be held.
+func (s *Server) id() int64 {
+	s.nextID++
+	return s.nextID
+}
+
+// reference returns the reference of the given branch.
+func reference(branch, sha string) *github.Reference {
+	return &github.Reference{
+		Ref:    ptr("refs/heads/" + branch),
+		Object: &github.GitObject{Type: ptr("commit"), SHA: ptr(sha)},
+	}
+}
+
+// readJSON decodes the request's body into the given value or writes a 400 if that fails.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Problems parsing JSON: %v", err))
+		return false
+	}
+	return true
+}
+
+// writeJSON writes the given value as JSON with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in the format of the GitHub API.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
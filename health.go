@@ -0,0 +1,116 @@
+package reviewapps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// readinessTimeout is the timeout of each of the readiness checks.
+	readinessTimeout = 5 * time.Second
+	// readinessCacheTTL is how long the outcome of the readiness checks is reused, so frequent
+	// probes don't use up rate limits.
+	readinessCacheTTL = 10 * time.Second
+)
+
+// readinessResponse is the body of responses of the "/readyz" endpoint.
+type readinessResponse struct {
+	Ready bool `json:"ready"`
+	// Checks are the outcomes of the checks by name, i.e. "ok" or why the check failed.
+	Checks    map[string]string `json:"checks"`
+	CheckedAt time.Time         `json:"checked_at"`
+}
+
+// readiness serves whether or not the service can reach GitHub with valid app credentials and
+// DigitalOcean with a valid token, for readiness probes.
+type readiness struct {
+	prs *PRHandler
+
+	mu   sync.Mutex
+	last *readinessResponse
+}
+
+// ServeHTTP responds with the outcome of the readiness checks as JSON, with a 503 if any of them
+// failed.
+func (rd *readiness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := rd.check(r.Context(), time.Now().UTC())
+	status := http.StatusOK
+	if !resp.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// check runs the readiness checks, unless their last outcome is recent enough to be reused.
+func (rd *readiness) check(ctx context.Context, now time.Time) *readinessResponse {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if rd.last != nil && now.Sub(rd.last.CheckedAt) < readinessCacheTTL {
+		return rd.last
+	}
+
+	logger := zerolog.Ctx(ctx).With().Str("component", "readiness").Logger()
+	resp := &readinessResponse{Ready: true, Checks: make(map[string]string), CheckedAt: now}
+	for name, check := range map[string]func(context.Context) error{
+		"github":       rd.checkGitHub,
+		"digitalocean": rd.checkDigitalOcean,
+	} {
+		checkCtx, cancel := context.WithTimeout(ctx, readinessTimeout)
+		err := check(checkCtx)
+		cancel()
+		if err != nil {
+			logger.Warn().Err(err).Str("check", name).Msg("readiness check failed")
+			resp.Ready = false
+			resp.Checks[name] = err.Error()
+			continue
+		}
+		resp.Checks[name] = "ok"
+	}
+	rd.last = resp
+	return resp
+}
+
+// checkGitHub verifies the app's credentials by getting the app itself, and that the clients talk
+// to the configured GitHub instance.
+func (rd *readiness) checkGitHub(ctx context.Context) error {
+	client, err := rd.prs.cc.NewAppClient()
+	if err != nil {
+		return githubError(err, "failed to create app client")
+	}
+	if configured := rd.prs.config.Github.V3APIURL; client.BaseURL.String() != configured {
+		return fmt.Errorf("app client talks to %s instead of the configured %s", client.BaseURL, configured)
+	}
+	if _, _, err := client.Apps.Get(ctx, ""); err != nil {
+		return githubError(err, "failed to get app")
+	}
+	return nil
+}
+
+// checkDigitalOcean verifies the token by getting the account it belongs to.
+func (rd *readiness) checkDigitalOcean(ctx context.Context) error {
+	if _, _, err := rd.prs.do.Account.Get(ctx); err != nil {
+		return doError(err, "failed to get account")
+	}
+	return nil
+}
+
+// healthz responds with a 200 as long as the service serves requests, for liveness probes. It
+// deliberately doesn't check GitHub or DigitalOcean, so their outages don't get the service
+// restarted.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,90 @@
+package reviewapps
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-github/v60/github"
+)
+
+// idempotencyKey derives a key identifying a deployment of the given commit of the given pull
+// request. The attempt allows deliberately deploying the same commit multiple times.
+func idempotencyKey(repo string, pr int, sha string, attempt int64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s#%d@%s/%d", repo, pr, sha, attempt)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// findDeploymentByKey finds the GitHub deployment of the given environment and commit that was
+// created with the given idempotency key. It returns nil if there is none.
+func findDeploymentByKey(ctx context.Context, client *github.Client, owner, repo, environment, sha, key string) (*github.Deployment, *deploymentPayload, error) {
+	deployments, _, err := client.Repositories.ListDeployments(ctx, owner, repo, &github.DeploymentsListOptions{
+		Environment: environment,
+		SHA:         sha,
+	})
+	if err != nil {
+		return nil, nil, githubError(err, "failed to list deployments")
+	}
+	for _, d := range deployments {
+		payload, err := parseDeploymentPayload(d.Payload)
+		if err != nil {
+			// Deployments created by other tools might not have a compatible payload.
+			continue
+		}
+		if payload.IdempotencyKey == key {
+			return d, payload, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// appExists returns whether or not the app with the given ID still exists.
+func appExists(ctx context.Context, do *godo.Client, appID string) (bool, error) {
+	_, resp, err := do.Apps.Get(ctx, appID)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, doError(err, "failed to get app")
+	}
+	return true, nil
+}
+
+// findAppByName finds the app with the given name. It returns nil if there is none.
+func findAppByName(ctx context.Context, do *godo.Client, name string) (*godo.App, error) {
+	apps, err := listApps(ctx, do)
+	if err != nil {
+		return nil, err
+	}
+	for _, app := range apps {
+		if app.GetSpec().GetName() == name {
+			return app, nil
+		}
+	}
+	return nil, nil
+}
+
+// listApps lists all apps of the account.
+func listApps(ctx context.Context, do *godo.Client) ([]*godo.App, error) {
+	var all []*godo.App
+	opts := &godo.ListOptions{PerPage: 200}
+	for {
+		apps, resp, err := do.Apps.List(ctx, opts)
+		if err != nil {
+			return nil, doError(err, "failed to list apps")
+		}
+		all = append(all, apps...)
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			return all, nil
+		}
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current page: %w", err)
+		}
+		opts.Page = page + 1
+	}
+}
@@ -0,0 +1,170 @@
+package reviewapps
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v60/github"
+)
+
+const (
+	// ignoreFileLocation is the location of the file listing changes that never trigger review
+	// app deployments.
+	ignoreFileLocation = ".do/reviewapps-ignore"
+	// ignoreBranchPrefix marks branch patterns in the ignore file.
+	ignoreBranchPrefix = "branch:"
+)
+
+// ignoreRules are the parsed rules of an ignore file. Each line of the file is either a path glob
+// or a branch pattern prefixed with "branch:". Empty lines and lines starting with "#" are
+// skipped.
+type ignoreRules struct {
+	paths    []*regexp.Regexp
+	branches []*regexp.Regexp
+}
+
+// parseIgnoreRules parses the given ignore file.
+func parseIgnoreRules(content string) (*ignoreRules, error) {
+	var rules ignoreRules
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if branch, ok := strings.CutPrefix(line, ignoreBranchPrefix); ok {
+			re, err := globRegexp(strings.TrimSpace(branch), true)
+			if err != nil {
+				return nil, fmt.Errorf("invalid branch pattern %q: %w", branch, err)
+			}
+			rules.branches = append(rules.branches, re)
+			continue
+		}
+		re, err := globRegexp(line, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path glob %q: %w", line, err)
+		}
+		rules.paths = append(rules.paths, re)
+	}
+	return &rules, scanner.Err()
+}
+
+// globRegexp compiles the given glob into a regular expression. "*" matches anything but "/",
+// "**" matches anything and "?" matches a single character but "/". Path globs without a "/"
+// match the file name in any directory and path globs ending with "/" match everything in that
+// directory.
+func globRegexp(glob string, anchored bool) (*regexp.Regexp, error) {
+	if glob == "" {
+		return nil, errors.New("empty pattern")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("^")
+	if !anchored {
+		if !strings.Contains(strings.TrimSuffix(glob, "/"), "/") {
+			sb.WriteString("(?:.*/)?")
+		}
+		glob = strings.TrimPrefix(glob, "/")
+		if strings.HasSuffix(glob, "/") {
+			glob += "**"
+		}
+	}
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				sb.WriteString(".*")
+				i++
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+// ignoresBranch returns whether or not the given branch is ignored.
+func (r *ignoreRules) ignoresBranch(branch string) bool {
+	for _, re := range r.branches {
+		if re.MatchString(branch) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoresFiles returns whether or not all of the given files are ignored.
+func (r *ignoreRules) ignoresFiles(files []string) bool {
+	if len(r.paths) == 0 || len(files) == 0 {
+		return false
+	}
+	for _, f := range files {
+		ignored := false
+		for _, re := range r.paths {
+			if re.MatchString(f) {
+				ignored = true
+				break
+			}
+		}
+		if !ignored {
+			return false
+		}
+	}
+	return true
+}
+
+// ignored returns whether or not the pull request is ignored by the ignore file of its branch and
+// why.
+func (h *PRHandler) ignored(ctx context.Context, ra *reviewApp) (bool, string, error) {
+	content, err := fileContent(ctx, ra.client, ra.owner, ra.name, ignoreFileLocation, ra.ref)
+	if errors.Is(err, ErrSpecNotFound) {
+		// No ignore file, nothing is ignored.
+		return false, "", nil
+	} else if err != nil {
+		return false, "", err
+	}
+	rules, err := parseIgnoreRules(string(content))
+	if err != nil {
+		return false, "", errorf(ErrorKindSpecInvalid, "failed to parse %s: %w", ignoreFileLocation, err)
+	}
+
+	if rules.ignoresBranch(ra.branch) {
+		return true, fmt.Sprintf("branch %q is ignored", ra.branch), nil
+	}
+	if len(rules.paths) == 0 {
+		return false, "", nil
+	}
+
+	var files []string
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		list, resp, err := ra.client.PullRequests.ListFiles(ctx, ra.owner, ra.name, ra.number, opts)
+		if err != nil {
+			return false, "", githubError(err, "failed to list changed files")
+		}
+		for _, f := range list {
+			files = append(files, f.GetFilename())
+			if f.GetPreviousFilename() != "" {
+				// Renames change both locations.
+				files = append(files, f.GetPreviousFilename())
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	if rules.ignoresFiles(files) {
+		return true, "all changed files are ignored", nil
+	}
+	return false, "", nil
+}
@@ -0,0 +1,99 @@
+package reviewapps
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/rs/zerolog"
+)
+
+// invalidation is the body of requests to invalidation endpoints.
+type invalidation struct {
+	Repo        string `json:"repo"`
+	PullRequest int    `json:"pull_request,omitempty"`
+	AppName     string `json:"app_name"`
+	AppID       string `json:"app_id"`
+	// URLs are the preview URLs of the torn down review app.
+	URLs []string  `json:"urls"`
+	Time time.Time `json:"time"`
+}
+
+// previewURLs returns all URLs the previews of the given app were served under, i.e. its default
+// URL and the URLs of its domains.
+func previewURLs(app *godo.App) []string {
+	var urls []string
+	if app.GetLiveURL() != "" {
+		urls = append(urls, app.GetLiveURL())
+	}
+	for _, d := range app.GetSpec().GetDomains() {
+		if u := "https://" + d.Domain; d.Domain != "" && !contains(urls, u) {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// invalidate calls all invalidation endpoints with the preview URLs of the given deleted app of the
+// given pull request. Endpoints are called in the background, so failing ones never keep the
+// teardown from finishing. Failures are logged and counted.
+func (h *PRHandler) invalidate(ctx context.Context, logger zerolog.Logger, repo string, number int, app *godo.App) {
+	endpoints := h.config.Invalidation.Endpoints
+	if len(endpoints) == 0 {
+		return
+	}
+	urls := previewURLs(app)
+	if len(urls) == 0 {
+		return
+	}
+	body, err := json.Marshal(invalidation{
+		Repo:        repo,
+		PullRequest: number,
+		AppName:     app.GetSpec().GetName(),
+		AppID:       app.GetID(),
+		URLs:        urls,
+		Time:        time.Now(),
+	})
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to encode invalidation")
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	for _, e := range endpoints {
+		go func() {
+			if err := postInvalidation(ctx, e, body); err != nil {
+				invalidationsTotal.Add(e.Name+": failed", 1)
+				logger.Warn().Err(err).Str("endpoint", e.Name).Strs("urls", urls).Msg("failed to invalidate preview URLs")
+				return
+			}
+			invalidationsTotal.Add(e.Name+": succeeded", 1)
+			logger.Info().Str("endpoint", e.Name).Strs("urls", urls).Msg("invalidated preview URLs")
+		}()
+	}
+}
+
+// postInvalidation POSTs the given encoded invalidation to the given endpoint.
+func postInvalidation(ctx context.Context, e InvalidationEndpoint, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := notificationClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call invalidation endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("failed to call invalidation endpoint: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
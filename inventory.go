@@ -0,0 +1,108 @@
+package reviewapps
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/rs/zerolog"
+)
+
+// inventoryApp is an active review app as exported by the inventory.
+type inventoryApp struct {
+	Repo        string        `json:"repo"`
+	PullRequest int           `json:"pull_request"`
+	Branch      string        `json:"branch"`
+	AppName     string        `json:"app_name"`
+	AppID       string        `json:"app_id"`
+	LiveURL     string        `json:"live_url,omitempty"`
+	SHA         string        `json:"sha"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+	Spec        *godo.AppSpec `json:"spec"`
+	// Protection is why the review app is protected from being torn down when idle, if it is.
+	Protection *protection `json:"protection,omitempty"`
+}
+
+// inventoryResponse is the body of responses of the "/admin/inventory" endpoint.
+type inventoryResponse struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Apps        []inventoryApp `json:"apps"`
+}
+
+// inventory exports all active review apps, including their app IDs and live specs, so the apps
+// managed by review apps can be reconciled with other audit tooling.
+type inventory struct {
+	prs        *PRHandler
+	adminToken string
+}
+
+// ServeHTTP responds with the inventory as JSON. Requests must be GETs authorized with the admin
+// token as bearer token.
+func (inv *inventory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, inv.adminToken) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	logger := zerolog.Ctx(ctx).With().Str("component", "inventory").Logger()
+	ctx = logger.WithContext(ctx)
+
+	apps, err := inv.list(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to list inventory of review apps")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(inventoryResponse{GeneratedAt: time.Now().UTC(), Apps: apps})
+}
+
+// list returns all review apps of open pull requests that have an app.
+func (inv *inventory) list(ctx context.Context) ([]inventoryApp, error) {
+	ras, err := inv.prs.openReviewApps(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	apps := []inventoryApp{}
+	for _, ra := range ras {
+		deployment, payload, err := inv.prs.latestDeployment(ctx, ra)
+		if err != nil {
+			return nil, err
+		}
+		if deployment == nil || payload.AppID == "" {
+			continue
+		}
+		app, resp, err := inv.prs.do.Apps.Get(ctx, payload.AppID)
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				// Torn down, or never created successfully.
+				continue
+			}
+			return nil, doError(err, "failed to get app")
+		}
+		apps = append(apps, inventoryApp{
+			Repo:        ra.repo.GetFullName(),
+			PullRequest: ra.number,
+			Branch:      ra.branch,
+			AppName:     ra.appName,
+			AppID:       app.GetID(),
+			LiveURL:     app.GetLiveURL(),
+			SHA:         deployment.GetSHA(),
+			CreatedAt:   app.GetCreatedAt(),
+			UpdatedAt:   app.GetUpdatedAt(),
+			Spec:        app.GetSpec(),
+			Protection:  inv.prs.protectionOf(ra),
+		})
+	}
+	return apps, nil
+}
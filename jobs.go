@@ -0,0 +1,126 @@
+package reviewapps
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// postDeployJobOutcomes returns the status of every POST_DEPLOY job of the given deployment that
+// its progress reports on, by job name. Jobs with several steps take the status of the step that
+// is least done, or of the failed one.
+func postDeployJobOutcomes(d *godo.Deployment) map[string]godo.DeploymentProgressStepStatus {
+	jobs := make(map[string]bool)
+	for _, job := range d.GetSpec().GetJobs() {
+		if job.Kind == godo.AppJobSpecKind_PostDeploy {
+			jobs[job.Name] = true
+		}
+	}
+	if len(jobs) == 0 || d.GetProgress() == nil {
+		return nil
+	}
+
+	outcomes := make(map[string]godo.DeploymentProgressStepStatus)
+	var walk func(steps []*godo.DeploymentProgressStep)
+	walk = func(steps []*godo.DeploymentProgressStep) {
+		for _, step := range steps {
+			if jobs[step.ComponentName] {
+				if current, ok := outcomes[step.ComponentName]; !ok || jobStatusRank(step.Status) > jobStatusRank(current) {
+					outcomes[step.ComponentName] = step.Status
+				}
+			}
+			walk(step.Steps)
+		}
+	}
+	walk(d.GetProgress().Steps)
+	return outcomes
+}
+
+// jobStatusRank orders the statuses of job steps by how much they determine the job's outcome.
+func jobStatusRank(status godo.DeploymentProgressStepStatus) int {
+	switch status {
+	case godo.DeploymentProgressStepStatus_Error:
+		return 3
+	case godo.DeploymentProgressStepStatus_Running, godo.DeploymentProgressStepStatus_Pending, godo.DeploymentProgressStepStatus_Unknown:
+		return 2
+	case godo.DeploymentProgressStepStatus_Success:
+		return 1
+	}
+	return 0
+}
+
+// pendingPostDeployJobs returns whether or not POST_DEPLOY jobs of the given deployment are still
+// running.
+func pendingPostDeployJobs(d *godo.Deployment) bool {
+	for _, status := range postDeployJobOutcomes(d) {
+		if jobStatusRank(status) == 2 {
+			return true
+		}
+	}
+	return false
+}
+
+// failedPostDeployJobs returns the names of the failed POST_DEPLOY jobs of the given deployment,
+// sorted.
+func failedPostDeployJobs(d *godo.Deployment) []string {
+	var failed []string
+	for name, status := range postDeployJobOutcomes(d) {
+		if status == godo.DeploymentProgressStepStatus_Error {
+			failed = append(failed, name)
+		}
+	}
+	sort.Strings(failed)
+	return failed
+}
+
+// postDeployJobsDescription describes the outcome of the given failed POST_DEPLOY jobs of the
+// given deployment, or returns an empty string if there are no POST_DEPLOY jobs.
+func postDeployJobsDescription(d *godo.Deployment, failed []string) string {
+	outcomes := postDeployJobOutcomes(d)
+	switch {
+	case len(outcomes) == 0:
+		return ""
+	case len(failed) == 1:
+		return fmt.Sprintf("Deployed, but post-deploy job %s failed", failed[0])
+	case len(failed) > 1:
+		return fmt.Sprintf("Deployed, but post-deploy jobs %s failed", strings.Join(failed, ", "))
+	case pendingPostDeployJobs(d):
+		return "Deployed, but post-deploy jobs didn't finish in time"
+	}
+	return "Deployed and ran all post-deploy jobs"
+}
+
+// waitForPostDeployJobs waits for the POST_DEPLOY jobs of the given active deployment to finish
+// and returns the deployment as of then. Jobs that don't finish in time are reported as such
+// rather than failing the wait, as the app itself is live already.
+func (h *PRHandler) waitForPostDeployJobs(ctx context.Context, ra *reviewApp, appID string, d *godo.Deployment) (*godo.Deployment, error) {
+	if !pendingPostDeployJobs(d) {
+		return d, nil
+	}
+	ra.logger.Info().Str("deployment_id", d.GetID()).Msg("waiting for post-deploy jobs to finish")
+	timeout := time.NewTimer(h.config.Polling.GetJobsTimeout())
+	defer timeout.Stop()
+	for pendingPostDeployJobs(d) {
+		t := time.NewTimer(h.config.Polling.GetMinInterval())
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-timeout.C:
+			t.Stop()
+			ra.logger.Warn().Str("deployment_id", d.GetID()).Msg("post-deploy jobs didn't finish in time")
+			return d, nil
+		case <-t.C:
+		}
+		latest, _, err := h.do.Apps.GetDeployment(ctx, appID, d.GetID())
+		if err != nil {
+			return nil, doError(err, "failed to get deployment")
+		}
+		d = latest
+	}
+	return d, nil
+}
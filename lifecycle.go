@@ -0,0 +1,65 @@
+package reviewapps
+
+import "context"
+
+// LifecycleEventType is the type of a lifecycle event of a review app.
+type LifecycleEventType string
+
+const (
+	// LifecycleAppCreated is emitted when a new review app was created.
+	LifecycleAppCreated LifecycleEventType = "app_created"
+	// LifecycleDeploymentStarted is emitted when a new deployment of a review app was started.
+	LifecycleDeploymentStarted LifecycleEventType = "deployment_started"
+	// LifecycleDeploymentSucceeded is emitted when a deployment of a review app became active and
+	// the app is reachable under its live URL.
+	LifecycleDeploymentSucceeded LifecycleEventType = "deployment_succeeded"
+	// LifecycleDeploymentFailed is emitted when a deployment of a review app failed.
+	LifecycleDeploymentFailed LifecycleEventType = "deployment_failed"
+	// LifecycleAppDeleted is emitted when a review app was deleted.
+	LifecycleAppDeleted LifecycleEventType = "app_deleted"
+	// LifecycleAppPromoted is emitted when the spec of a review app was applied to the app
+	// configured for promotions. The event refers to that app and its new deployment.
+	LifecycleAppPromoted LifecycleEventType = "app_promoted"
+	// LifecycleAppDrifted is emitted when the spec of a review app was found to be changed outside
+	// of review apps, e.g. in the control panel.
+	LifecycleAppDrifted LifecycleEventType = "app_drifted"
+)
+
+// LifecycleEvent describes a change in the lifecycle of a review app.
+type LifecycleEvent struct {
+	Type LifecycleEventType
+	// Repo is the full name of the repository, i.e. "owner/name".
+	Repo string
+	// PullRequest is the number of the pull request, or 0 for apps of branches.
+	PullRequest int
+	AppName     string
+	AppID       string
+	// DeploymentID is the ID of the App Platform deployment, if any.
+	DeploymentID string
+	// LiveURL is the URL the review app is reachable under, if any.
+	LiveURL string
+}
+
+// LifecycleListener is notified about lifecycle events of review apps. Implementations are called
+// synchronously and should therefore return quickly.
+type LifecycleListener interface {
+	OnLifecycleEvent(ctx context.Context, event LifecycleEvent)
+}
+
+// LifecycleListenerFunc is an adapter to allow the use of ordinary functions as lifecycle
+// listeners.
+type LifecycleListenerFunc func(ctx context.Context, event LifecycleEvent)
+
+// OnLifecycleEvent calls f(ctx, event).
+func (f LifecycleListenerFunc) OnLifecycleEvent(ctx context.Context, event LifecycleEvent) {
+	f(ctx, event)
+}
+
+// lifecycleListeners fans out lifecycle events to multiple listeners.
+type lifecycleListeners []LifecycleListener
+
+func (ls lifecycleListeners) OnLifecycleEvent(ctx context.Context, event LifecycleEvent) {
+	for _, l := range ls {
+		l.OnLifecycleEvent(ctx, event)
+	}
+}
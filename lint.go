@@ -0,0 +1,185 @@
+package reviewapps
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-github/v60/github"
+)
+
+// The lint rules and their annotation levels.
+const (
+	lintRuleUnpinnedImage      = "unpinned-image"
+	lintRuleMissingHealthCheck = "missing-health-check"
+	lintRulePlaintextSecret    = "plaintext-secret"
+	lintRuleDeprecatedField    = "deprecated-field"
+
+	lintLevelNotice  = "notice"
+	lintLevelWarning = "warning"
+	lintLevelFailure = "failure"
+	lintLevelOff     = "off"
+)
+
+// lintDefaultLevels are the annotation levels of the lint rules unless configured otherwise.
+var lintDefaultLevels = map[string]string{
+	lintRuleUnpinnedImage:      lintLevelWarning,
+	lintRuleMissingHealthCheck: lintLevelWarning,
+	lintRulePlaintextSecret:    lintLevelFailure,
+	lintRuleDeprecatedField:    lintLevelNotice,
+}
+
+// maxCheckRunAnnotations is the maximum amount of annotations GitHub accepts per check run update.
+const maxCheckRunAnnotations = 50
+
+// secretKeyPattern matches keys of environment variables that usually hold secrets.
+var secretKeyPattern = regexp.MustCompile(`(?i)(secret|passw(or)?d|token|api_?key|private_?key|credential)`)
+
+// lintFinding is a violation of a lint rule by the app spec.
+type lintFinding struct {
+	rule    string
+	message string
+	// needle is text on the line of the spec the finding is annotated on.
+	needle string
+}
+
+// lintSpec checks the given fetched app spec against the lint rules.
+func lintSpec(spec *godo.AppSpec) []lintFinding {
+	var findings []lintFinding
+	find := func(rule, needle, format string, args ...interface{}) {
+		findings = append(findings, lintFinding{rule: rule, message: fmt.Sprintf(format, args...), needle: needle})
+	}
+	lintEnvs := func(where string, envs []*godo.AppVariableDefinition) {
+		for _, env := range envs {
+			if env.Type == godo.AppVariableType_Secret || env.Value == "" || strings.Contains(env.Value, "${") {
+				continue
+			}
+			if secretKeyPattern.MatchString(env.Key) {
+				find(lintRulePlaintextSecret, env.Key, "Environment variable `%s` of %s looks like a secret but is stored in plain text. Set its `type` to `SECRET`.", env.Key, where)
+			}
+		}
+	}
+
+	lintEnvs("the app", spec.GetEnvs())
+	godo.ForEachAppSpecComponent(spec, func(c godo.AppBuildableComponentSpec) error {
+		lintEnvs(fmt.Sprintf("component `%s`", c.GetName()), c.GetEnvs())
+		return nil
+	})
+	godo.ForEachAppSpecComponent(spec, func(c godo.AppContainerComponentSpec) error {
+		image := c.GetImage()
+		if image == nil || image.Digest != "" {
+			return nil
+		}
+		if image.Tag == "" || image.Tag == "latest" {
+			find(lintRuleUnpinnedImage, c.GetName(), "Component `%s` deploys image `%s` without pinning it to a tag or digest, so redeploys may pick up a different image.", c.GetName(), image.Repository)
+		}
+		return nil
+	})
+	godo.ForEachAppSpecComponent(spec, func(c godo.AppRoutableComponentSpec) error {
+		if len(c.GetRoutes()) > 0 {
+			find(lintRuleDeprecatedField, "routes:", "Component `%s` uses the deprecated `routes`. Use the app's `ingress` instead.", c.GetName())
+		}
+		return nil
+	})
+	for _, svc := range spec.GetServices() {
+		switch hc := svc.GetHealthCheck(); {
+		case hc == nil:
+			find(lintRuleMissingHealthCheck, svc.GetName(), "Service `%s` has no health check, so it's considered healthy as soon as it starts.", svc.GetName())
+		case hc.Path != "":
+			find(lintRuleDeprecatedField, "path:", "The health check of service `%s` uses the deprecated `path`. Use `http_path` instead.", svc.GetName())
+		}
+	}
+	for _, db := range spec.GetDatabases() {
+		if db.Size != "" || db.NumNodes != 0 {
+			find(lintRuleDeprecatedField, db.GetName(), "Database `%s` uses the deprecated `size` or `num_nodes`, which are ignored.", db.GetName())
+		}
+	}
+	return findings
+}
+
+// lintLevel returns the configured annotation level of the given rule.
+func (c LintConfig) lintLevel(rule string) string {
+	if level, ok := c.Rules[rule]; ok {
+		return level
+	}
+	return lintDefaultLevels[rule]
+}
+
+// lint reports the findings of the lint rules on the given fetched app spec as annotations of a
+// check run on the pull request's head, if configured. Linting doesn't gate deployments, so
+// failing to report its findings is logged instead of failing the deployment.
+func (h *PRHandler) lint(ctx context.Context, ra *reviewApp, content []byte, spec *godo.AppSpec) {
+	if !ra.cfg.Lint.Enabled || ra.number == 0 {
+		return
+	}
+
+	var (
+		annotations []*github.CheckRunAnnotation
+		text        strings.Builder
+		conclusion  = "success"
+	)
+	for _, f := range lintSpec(spec) {
+		level := ra.cfg.Lint.lintLevel(f.rule)
+		if level == lintLevelOff {
+			continue
+		}
+		if level == lintLevelFailure {
+			conclusion = "failure"
+		} else if conclusion == "success" {
+			conclusion = "neutral"
+		}
+		fmt.Fprintf(&text, "- **%s** (`%s`): %s\n", level, f.rule, f.message)
+		if ra.specPath == "" || len(annotations) == maxCheckRunAnnotations {
+			// Only committed app specs can be annotated.
+			continue
+		}
+		line := specLine(content, f.needle)
+		annotations = append(annotations, &github.CheckRunAnnotation{
+			Path:            ptr(ra.specPath),
+			StartLine:       ptr(line),
+			EndLine:         ptr(line),
+			AnnotationLevel: ptr(level),
+			Title:           ptr(f.rule),
+			Message:         ptr(f.message),
+		})
+	}
+
+	output := &github.CheckRunOutput{
+		Title:       ptr("No findings"),
+		Summary:     ptr(fmt.Sprintf("The app spec of review app `%s` passes all lint rules.", ra.appName)),
+		Annotations: annotations,
+	}
+	if text.Len() > 0 {
+		output.Title = ptr("Findings")
+		output.Summary = ptr(fmt.Sprintf("The app spec of review app `%s` violates lint rules.", ra.appName))
+		s := text.String()
+		if len(s) > maxCheckRunText {
+			s = s[:maxCheckRunText]
+		}
+		output.Text = ptr(s)
+	}
+	_, _, err := ra.client.Checks.CreateCheckRun(ctx, ra.owner, ra.name, github.CreateCheckRunOptions{
+		Name:       ra.cfg.Lint.GetName(),
+		HeadSHA:    ra.pr.GetHead().GetSHA(),
+		Status:     ptr("completed"),
+		Conclusion: ptr(conclusion),
+		Output:     output,
+	})
+	if err != nil {
+		ra.logger.Warn().Err(githubError(err, "failed to create check run")).Msg("failed to report lint findings as check run")
+	}
+}
+
+// specLine returns the first line of the given app spec containing the given text, or the first
+// line if none does.
+func specLine(content []byte, needle string) int {
+	for i, line := range bytes.Split(content, []byte("\n")) {
+		if bytes.Contains(line, []byte(needle)) {
+			return i + 1
+		}
+	}
+	return 1
+}
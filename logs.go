@@ -0,0 +1,157 @@
+package reviewapps
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/digitalocean/godo"
+)
+
+// maxLogBytes caps how much of a log is downloaded.
+const maxLogBytes = 1 << 20
+
+// fetchLogTail fetches the last lines of the given type of logs of the given component of the given
+// deployment. It returns an empty string if there are no logs.
+func fetchLogTail(ctx context.Context, do *godo.Client, appID, deploymentID, component string, logType godo.AppLogType, lines int) (string, error) {
+	logs, _, err := do.Apps.GetLogs(ctx, appID, deploymentID, component, logType, false, lines)
+	if err != nil {
+		return "", doError(err, "failed to get logs")
+	}
+
+	var sb strings.Builder
+	for _, url := range logs.HistoricURLs {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to create logs request: %w", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("failed to download logs: %w", err)
+		}
+		_, err = io.Copy(&sb, io.LimitReader(resp.Body, maxLogBytes))
+		resp.Body.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to download logs: %w", err)
+		}
+	}
+	return tail(sb.String(), lines), nil
+}
+
+// tail returns the last n lines of the given string.
+func tail(s string, n int) string {
+	all := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(all) > n {
+		all = all[len(all)-n:]
+	}
+	return strings.Join(all, "\n")
+}
+
+// failedComponent is a component that failed a deployment.
+type failedComponent struct {
+	name string
+	// logType is the type of the logs telling why, i.e. the build logs if the component failed to
+	// build and the deploy logs otherwise.
+	logType godo.AppLogType
+	// reason is what the deployment's progress says went wrong, if anything.
+	reason string
+}
+
+// failedComponents returns the components whose steps failed the given deployment. If its progress
+// doesn't name any, all components are returned with their build logs, as most deployments fail
+// building.
+func failedComponents(d *godo.Deployment) []failedComponent {
+	var failed []failedComponent
+	seen := make(map[string]bool)
+	var walk func(steps []*godo.DeploymentProgressStep, logType godo.AppLogType)
+	walk = func(steps []*godo.DeploymentProgressStep, logType godo.AppLogType) {
+		for _, step := range steps {
+			typ := logType
+			switch step.Name {
+			case "build":
+				typ = godo.AppLogTypeBuild
+			case "deploy":
+				typ = godo.AppLogTypeDeploy
+			}
+			if step.Status == godo.DeploymentProgressStepStatus_Error && step.ComponentName != "" && !seen[step.ComponentName] {
+				seen[step.ComponentName] = true
+				c := failedComponent{name: step.ComponentName, logType: typ}
+				if step.Reason != nil {
+					c.reason = step.Reason.Message
+				}
+				failed = append(failed, c)
+			}
+			walk(step.Steps, typ)
+		}
+	}
+	walk(d.GetProgress().GetSteps(), godo.AppLogTypeDeploy)
+	if len(failed) > 0 {
+		return failed
+	}
+
+	godo.ForEachAppSpecComponent(d.GetSpec(), func(c godo.AppBuildableComponentSpec) error {
+		failed = append(failed, failedComponent{name: c.GetName(), logType: godo.AppLogTypeBuild})
+		return nil
+	})
+	return failed
+}
+
+// failureLogs returns the tails of the logs of the components that failed the given deployment as
+// markdown, or an empty string if there are none. Logs that can't be fetched are left out.
+func failureLogs(ctx context.Context, do *godo.Client, appID string, d *godo.Deployment, lines int) string {
+	var text strings.Builder
+	for _, c := range failedComponents(d) {
+		logs, err := fetchLogTail(ctx, do, appID, d.GetID(), c.name, c.logType, lines)
+		if err != nil {
+			// The reason is worth reporting without the logs.
+			logs = ""
+		}
+		if logs == "" && c.reason == "" {
+			continue
+		}
+		fmt.Fprintf(&text, "### %s logs of `%s`\n\n", logTypeTitle(c.logType), c.name)
+		if c.reason != "" {
+			fmt.Fprintf(&text, "%s\n\n", c.reason)
+		}
+		if logs != "" {
+			fmt.Fprintf(&text, "```\n%s\n```\n\n", logs)
+		}
+	}
+	return text.String()
+}
+
+// commentFailureLogs comments on the pull request with the tails of the logs of the components that
+// failed the given deployment, if configured and it ended in an error. Task previews report their logs anyway and apps of
+// branches have no pull request to comment on.
+func (h *PRHandler) commentFailureLogs(ctx context.Context, ra *reviewApp, appID string, d *godo.Deployment) {
+	if !ra.cfg.FailureLogs.Comment || ra.cfg.Task || ra.number == 0 || d.GetPhase() != godo.DeploymentPhase_Error {
+		return
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "### Deployment of review app `%s` failed\n\n", ra.appName)
+	fmt.Fprintf(&body, "Deployment [`%s`](%s) of %s finished in phase `%s`.\n\n", d.GetID(), deploymentDashboardURL(appID, d.GetID()), ra.pr.GetHead().GetSHA(), d.GetPhase())
+	if logs := failureLogs(ctx, h.do, appID, d, ra.cfg.FailureLogs.GetLines()); logs != "" {
+		body.WriteString(logs)
+	} else {
+		body.WriteString("No logs of the failed components are available.\n")
+	}
+	text := body.String()
+	if len(text) > maxCheckRunText {
+		// Comments are limited like the text of check runs.
+		text = text[:maxCheckRunText]
+	}
+	if err := h.comment(ctx, ra, commentKindFailure, text); err != nil {
+		ra.logger.Warn().Err(err).Msg("failed to comment with logs of failed deployment")
+	}
+}
+
+// logTypeTitle returns the title of the given type of logs.
+func logTypeTitle(logType godo.AppLogType) string {
+	if logType == godo.AppLogTypeBuild {
+		return "Build"
+	}
+	return "Deploy"
+}
@@ -0,0 +1,112 @@
+package reviewapps
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/v60/github"
+)
+
+const (
+	// testMergeFallbackHead deploys the pull request's head if it conflicts with its base.
+	testMergeFallbackHead = "head"
+	// testMergeFallbackSkip doesn't deploy pull requests that conflict with their base.
+	testMergeFallbackSkip = "skip"
+)
+
+// testMergeBranch returns the branch the test merge commit of the review app is deployed from.
+// App Platform can only deploy branches, so GitHub's merge ref is mirrored to a branch.
+func testMergeBranch(ra *reviewApp) string {
+	return fmt.Sprintf("reviewapps/merge/%d", ra.number)
+}
+
+// updateTestMerge points the test merge branch of the review app to GitHub's test merge commit of
+// the pull request and deploys the review app from that branch. If the pull request conflicts with
+// its base, the configured fallback applies. It returns false if the review app must not be
+// deployed.
+func (h *PRHandler) updateTestMerge(ctx context.Context, ra *reviewApp) (bool, error) {
+	pr, err := h.waitForMergeable(ctx, ra)
+	if err != nil {
+		return false, err
+	}
+
+	sha := pr.GetMergeCommitSHA()
+	if !pr.GetMergeable() {
+		if ra.cfg.TestMerge.GetFallback() == testMergeFallbackSkip {
+			ra.logger.Info().Msg("skipping pull request conflicting with its base")
+			body := fmt.Sprintf("The review app isn't updated to %s as it conflicts with `%s`. Resolve the conflicts to update it.", ra.pr.GetHead().GetSHA(), ra.pr.GetBase().GetRef())
+			if err := h.comment(ctx, ra, commentKindTestMerge, body); err != nil {
+				return false, err
+			}
+			return false, nil
+		}
+		ra.logger.Info().Msg("deploying head of pull request conflicting with its base")
+		sha = ra.pr.GetHead().GetSHA()
+	}
+
+	branch := testMergeBranch(ra)
+	if err := updateBranch(ctx, ra, branch, sha); err != nil {
+		return false, err
+	}
+	ra.sourceBranch = branch
+	return true, nil
+}
+
+// updateBranch points the given branch of the pull request's repository to the given commit,
+// creating it if necessary.
+func updateBranch(ctx context.Context, ra *reviewApp, branch, sha string) error {
+	ref := &github.Reference{
+		Ref:    ptr("refs/heads/" + branch),
+		Object: &github.GitObject{SHA: ptr(sha)},
+	}
+	_, resp, err := ra.client.Git.UpdateRef(ctx, ra.owner, ra.name, ref, true)
+	if err != nil && resp != nil && resp.StatusCode == http.StatusUnprocessableEntity {
+		// The branch doesn't exist yet.
+		_, _, err = ra.client.Git.CreateRef(ctx, ra.owner, ra.name, ref)
+	}
+	if err != nil {
+		return githubError(err, fmt.Sprintf("failed to update branch %s", branch))
+	}
+	return nil
+}
+
+// waitForMergeable waits for GitHub to compute the mergeability of the pull request, which happens
+// asynchronously after every push.
+func (h *PRHandler) waitForMergeable(ctx context.Context, ra *reviewApp) (*github.PullRequest, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	t := time.NewTicker(2 * time.Second)
+	defer t.Stop()
+	for {
+		pr, _, err := ra.client.PullRequests.Get(ctx, ra.owner, ra.name, ra.number)
+		if err != nil {
+			return nil, githubError(err, "failed to get pull request")
+		}
+		if pr.Mergeable != nil {
+			return pr, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errorf(ErrorKindGitHubAPI, "failed to wait for mergeability of pull request: %w", ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+// deleteTestMerge deletes the test merge branch of the review app, if any.
+func (h *PRHandler) deleteTestMerge(ctx context.Context, ra *reviewApp) error {
+	return deleteBranch(ctx, ra, testMergeBranch(ra))
+}
+
+// deleteBranch deletes the given branch of the pull request's repository, if it exists.
+func deleteBranch(ctx context.Context, ra *reviewApp, branch string) error {
+	resp, err := ra.client.Git.DeleteRef(ctx, ra.owner, ra.name, "heads/"+branch)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusUnprocessableEntity) {
+		return githubError(err, fmt.Sprintf("failed to delete branch %s", branch))
+	}
+	return nil
+}
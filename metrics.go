@@ -0,0 +1,111 @@
+package reviewapps
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// Metrics are exposed through expvar at /debug/vars.
+var (
+	// buildDurationSeconds is the duration of the last build per repository.
+	buildDurationSeconds = expvar.NewMap("build_duration_seconds")
+	// buildDurationDeltaSeconds is the difference between the duration of the last and the previous
+	// build per repository. Negative values mean that builds got faster, for example due to build
+	// caches being reused.
+	buildDurationDeltaSeconds = expvar.NewMap("build_duration_delta_seconds")
+	// deploymentsTotal is the amount of finished deployments per terminal phase.
+	deploymentsTotal = expvar.NewMap("deployments_total")
+	// eventsTotal is the amount of received webhook events per event type.
+	eventsTotal = expvar.NewMap("events_total")
+	// eventsNotActionableTotal is the amount of events that lacked required fields per event type
+	// and reason.
+	eventsNotActionableTotal = expvar.NewMap("events_not_actionable_total")
+	// driftDetectedTotal is the amount of review apps found to be changed outside of review apps per
+	// repository.
+	driftDetectedTotal = expvar.NewMap("drift_detected_total")
+	// driftRevertedTotal is the amount of reverted changes made outside of review apps per
+	// repository.
+	driftRevertedTotal = expvar.NewMap("drift_reverted_total")
+	// deletionsRefusedTotal is the amount of refused deletions of apps that aren't the expected
+	// review app per repository.
+	deletionsRefusedTotal = expvar.NewMap("deletions_refused_total")
+	// orphansDeletedTotal is the amount of deleted apps of closed pull requests or uninstalled
+	// repositories per repository.
+	orphansDeletedTotal = expvar.NewMap("orphans_deleted_total")
+	// doWebhooksTotal is the amount of received App Platform alerts per result, i.e. "accepted" or
+	// "rejected".
+	doWebhooksTotal = expvar.NewMap("do_webhooks_total")
+	// regionFallbacksTotal is the amount of review apps created in a fallback region per region.
+	regionFallbacksTotal = expvar.NewMap("region_fallbacks_total")
+	// domainRecordsDeletedTotal is the amount of deleted DNS records of review apps' domains per
+	// zone.
+	domainRecordsDeletedTotal = expvar.NewMap("domain_records_deleted_total")
+	// apiRetriesTotal is the amount of retried requests to the GitHub and DigitalOcean APIs per API
+	// and reason.
+	apiRetriesTotal = expvar.NewMap("api_retries_total")
+	// previewVisitsTotal is the amount of visits of previews through the gateway per repository.
+	previewVisitsTotal = expvar.NewMap("preview_visits_total")
+	// pollIntervalSeconds is the initial poll interval of the last deployment waited for per
+	// repository with adaptive polling.
+	pollIntervalSeconds = expvar.NewMap("poll_interval_seconds")
+	// invalidationsTotal is the amount of calls of invalidation endpoints with the preview URLs of
+	// torn down review apps per endpoint and result, e.g. "cdn: succeeded" or "cdn: failed".
+	invalidationsTotal = expvar.NewMap("invalidations_total")
+	// deliveriesTotal is the amount of persisted webhook deliveries per outcome, i.e. "replayed",
+	// "retried" or "failed".
+	deliveriesTotal = expvar.NewMap("deliveries_total")
+	// throttledRunsTotal is the amount of runs of background jobs that were deferred while the
+	// DigitalOcean API error rate was high per job, e.g. "reconcile".
+	throttledRunsTotal = expvar.NewMap("throttled_runs_total")
+	// canaryRunsTotal is the amount of canary runs per result, i.e. "succeeded" or "failed".
+	canaryRunsTotal = expvar.NewMap("canary_runs_total")
+	// canaryDurationSeconds is the duration of the last canary run, from reading its spec until
+	// its app was live.
+	canaryDurationSeconds = expvar.NewFloat("canary_duration_seconds")
+	// canaryLastSuccessTimestamp is the Unix time of the last successful canary run.
+	canaryLastSuccessTimestamp = expvar.NewInt("canary_last_success_timestamp")
+)
+
+// recordDeployment records the metrics of the given finished deployment of the given repository.
+// It returns the duration of the deployment's build and its difference to the previous build of
+// the same repository, if known.
+func recordDeployment(repo string, d *godo.Deployment) (build time.Duration, delta time.Duration, ok bool) {
+	deploymentsTotal.Add(string(d.GetPhase()), 1)
+
+	build, ok = buildDuration(d)
+	if !ok {
+		return 0, 0, false
+	}
+
+	previous, hasPrevious := buildDurationSeconds.Get(repo).(*expvar.Float)
+	if hasPrevious {
+		delta = build - time.Duration(previous.Value()*float64(time.Second))
+	}
+	buildDurationSeconds.Set(repo, float(build.Seconds()))
+	if !hasPrevious {
+		return build, 0, false
+	}
+	buildDurationDeltaSeconds.Set(repo, float(delta.Seconds()))
+	return build, delta, true
+}
+
+// float returns a new expvar.Float with the given value.
+func float(v float64) *expvar.Float {
+	f := new(expvar.Float)
+	f.Set(v)
+	return f
+}
+
+// buildDuration returns the duration of the build step of the given deployment, if it has
+// finished.
+func buildDuration(d *godo.Deployment) (time.Duration, bool) {
+	for _, step := range d.GetProgress().GetSteps() {
+		if step.Name != "build" || step.StartedAt.IsZero() || step.EndedAt.IsZero() {
+			continue
+		}
+		return step.EndedAt.Sub(step.StartedAt), true
+	}
+	return 0, false
+}
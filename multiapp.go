@@ -0,0 +1,168 @@
+package reviewapps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-github/v60/github"
+	"github.internal.digitalocean.com/mthoemmes/reviewapps/store"
+)
+
+const (
+	// appsKeySuffix suffixes the app names of the state store keys the app specs of pull requests
+	// with several review apps are recorded under. App names can't contain slashes, so the keys never
+	// collide with those of apps.
+	appsKeySuffix = "/apps"
+
+	// appSpecMarkerKey is the app-wide environment variable recording the app spec of review apps of
+	// repositories with several app specs, as their names can't be mapped back to it.
+	appSpecMarkerKey = "REVIEW_APP_SPEC"
+)
+
+// specNamer names the apps of one of several app specs of a repository by naming them like the
+// apps of a repository named "<repo>-<spec>", so names of different specs don't collide. Names end
+// in the pull request's number still.
+type specNamer struct {
+	Namer
+	spec string
+}
+
+func (n specNamer) PullRequestAppName(owner, repo string, number int) string {
+	return n.Namer.PullRequestAppName(owner, repo+"-"+n.spec, number)
+}
+
+func (n specNamer) legacyPullRequestAppName(owner, repo string, number int) string {
+	if l, ok := n.Namer.(legacyNamer); ok {
+		return l.legacyPullRequestAppName(owner, repo+"-"+n.spec, number)
+	}
+	return n.PullRequestAppName(owner, repo, number)
+}
+
+func (n specNamer) BranchAppName(owner, repo, branch string) string {
+	return n.Namer.BranchAppName(owner, repo+"-"+n.spec, branch)
+}
+
+// appsKey returns the key the app specs of the pull request are recorded under in the state store.
+func (ra *reviewApp) appsKey() store.Key {
+	return store.Key{Repo: ra.repo.GetFullName(), App: ra.appName + appsKeySuffix}
+}
+
+// forSpec returns the review app of the given one of the repository's app specs.
+func (ra *reviewApp) forSpec(spec string) *reviewApp {
+	app := *ra
+	app.spec = spec
+	app.namer = specNamer{Namer: ra.namer, spec: spec}
+	app.appName = app.namer.PullRequestAppName(ra.owner, ra.name, ra.number)
+	app.logger = ra.logger.With().Str("app_name", app.appName).Str("app_spec", spec).Logger()
+	return &app
+}
+
+// specLocation returns the location of the review app's app spec within the repository's app specs.
+func (ra *reviewApp) specLocation() string {
+	return path.Join(ra.cfg.Spec.Apps, ra.spec+".yaml")
+}
+
+// appSpecs lists the names of the app specs in the configured directory on the pull request's
+// branch, i.e. the names of its "*.yaml" files without the extension, sorted. A missing directory
+// has no app specs.
+func appSpecs(ctx context.Context, ra *reviewApp) ([]string, error) {
+	_, files, _, err := ra.client.Repositories.GetContents(ctx, ra.owner, ra.name, ra.cfg.Spec.Apps, &github.RepositoryContentGetOptions{
+		Ref: ra.ref,
+	})
+	if isGitHubNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, githubError(err, "failed to list app specs")
+	}
+	var specs []string
+	for _, f := range files {
+		if name, ok := strings.CutSuffix(f.GetName(), ".yaml"); ok && f.GetType() == "file" && name != "" {
+			specs = append(specs, name)
+		}
+	}
+	sort.Strings(specs)
+	return specs, nil
+}
+
+// recordedAppSpecs returns the app specs of the pull request's review apps as recorded in the state
+// store. Failing to look them up is logged, as the app specs on the branch are used as well.
+func (h *PRHandler) recordedAppSpecs(ctx context.Context, ra *reviewApp) []string {
+	value, err := h.store.Get(ctx, ra.appsKey())
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			ra.logger.Warn().Err(err).Msg("failed to look up app specs in state store")
+		}
+		return nil
+	}
+	var specs []string
+	if err := json.Unmarshal([]byte(value), &specs); err != nil {
+		ra.logger.Warn().Err(err).Msg("ignoring invalid app specs recorded in state store")
+		return nil
+	}
+	return specs
+}
+
+// recordAppSpecs records the app specs of the pull request's review apps in the state store, so
+// they can be torn down once they're gone from the branch.
+func (h *PRHandler) recordAppSpecs(ctx context.Context, ra *reviewApp, specs []string) {
+	var err error
+	if len(specs) == 0 {
+		err = h.store.Delete(ctx, ra.appsKey())
+	} else {
+		b, _ := json.Marshal(specs)
+		err = h.store.Put(ctx, ra.appsKey(), string(b))
+	}
+	if err != nil {
+		ra.logger.Warn().Err(err).Msg("failed to record app specs in state store")
+	}
+}
+
+// reviewApps returns the review apps of the pull request of the given review app, one per app spec
+// if the repository has several, or just the given one otherwise. It also returns the review apps
+// of app specs that were removed from the branch since they were deployed, which are to be torn
+// down.
+func (h *PRHandler) reviewApps(ctx context.Context, ra *reviewApp) ([]*reviewApp, []*reviewApp, error) {
+	if ra.cfg.Spec.Apps == "" || ra.inlineSpec != nil {
+		return []*reviewApp{ra}, nil, nil
+	}
+	specs, err := appSpecs(ctx, ra)
+	if err != nil {
+		return nil, nil, err
+	}
+	var apps, removed []*reviewApp
+	for _, spec := range specs {
+		apps = append(apps, ra.forSpec(spec))
+	}
+	for _, spec := range h.recordedAppSpecs(ctx, ra) {
+		if !slices.Contains(specs, spec) {
+			removed = append(removed, ra.forSpec(spec))
+		}
+	}
+	return apps, removed, nil
+}
+
+// markAppSpec records the given app spec of the repository's app specs in the given spec.
+func markAppSpec(spec *godo.AppSpec, name string) {
+	setAppEnv(spec, &godo.AppVariableDefinition{
+		Key:   appSpecMarkerKey,
+		Value: name,
+		Scope: godo.AppVariableScope_RunTime,
+		Type:  godo.AppVariableType_General,
+	})
+}
+
+// appSpecOf returns the app spec of the repository's app specs recorded in the given spec, if any.
+func appSpecOf(spec *godo.AppSpec) string {
+	for _, env := range spec.GetEnvs() {
+		if env.Key == appSpecMarkerKey {
+			return env.Value
+		}
+	}
+	return ""
+}
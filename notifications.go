@@ -0,0 +1,124 @@
+package reviewapps
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// notificationClient sends the lifecycle events of review apps to webhook destinations.
+var notificationClient = &http.Client{Timeout: 10 * time.Second}
+
+// notificationDestinations returns the destinations the repository's configuration routes the
+// notifications about the review app to.
+func (h *PRHandler) notificationDestinations(ctx context.Context, ra *reviewApp) ([]NotificationDestination, error) {
+	repoCfg, err := h.repoConfig(ctx, ra)
+	if err != nil {
+		return nil, err
+	}
+	destinations := make([]NotificationDestination, 0, len(repoCfg.Notifications))
+	for _, name := range repoCfg.Notifications {
+		d, ok := h.config.Notifications.Destinations[name]
+		if !ok {
+			return nil, errorf(ErrorKindSpecInvalid, "notification destination %q in %s is not allowed", name, repoConfigLocation)
+		}
+		destinations = append(destinations, d)
+	}
+	return destinations, nil
+}
+
+// hasAlertDestinations returns whether or not any of the given destinations is notified via App
+// Platform alerts.
+func hasAlertDestinations(destinations []NotificationDestination) bool {
+	return slices.ContainsFunc(destinations, func(d NotificationDestination) bool { return d.Webhook == "" })
+}
+
+// routeAlerts adds the given destinations to the alerts of the given app about finished
+// deployments. Destinations the repository stopped routing to are kept, as they can't be told
+// apart from destinations added outside of review apps.
+func (h *PRHandler) routeAlerts(ctx context.Context, appID string, destinations []NotificationDestination) error {
+	var (
+		emails []string
+		slack  []*godo.AppAlertSlackWebhook
+	)
+	for _, d := range destinations {
+		switch {
+		case d.Email != "":
+			emails = append(emails, d.Email)
+		case d.SlackWebhook != "":
+			slack = append(slack, &godo.AppAlertSlackWebhook{URL: d.SlackWebhook, Channel: d.SlackChannel})
+		}
+	}
+	if len(emails) == 0 && len(slack) == 0 {
+		return nil
+	}
+	return h.addAlertDestinations(ctx, appID, emails, slack)
+}
+
+// notify notifies all lifecycle listeners, the status comment and the webhook destinations of the
+// review app's repository about the given event. Webhooks are called in the background and only if
+// the repository's configuration was already read, so notifying never blocks or fails on them.
+func (h *PRHandler) notify(ctx context.Context, ra *reviewApp, event LifecycleEvent) {
+	h.listeners.OnLifecycleEvent(ctx, event)
+	h.updateStatusComment(ctx, ra, event)
+	if ra.repoCfg == nil || len(ra.repoCfg.Notifications) == 0 {
+		return
+	}
+
+	var urls []string
+	for _, name := range ra.repoCfg.Notifications {
+		if d := h.config.Notifications.Destinations[name]; d.Webhook != "" {
+			urls = append(urls, d.Webhook)
+		}
+	}
+	if len(urls) == 0 {
+		return
+	}
+	body, err := json.Marshal(streamedEvent{
+		Type:         event.Type,
+		Repo:         event.Repo,
+		PullRequest:  event.PullRequest,
+		AppName:      event.AppName,
+		AppID:        event.AppID,
+		DeploymentID: event.DeploymentID,
+		LiveURL:      event.LiveURL,
+		Time:         time.Now(),
+	})
+	if err != nil {
+		ra.logger.Error().Err(err).Msg("failed to encode lifecycle event")
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	for _, url := range urls {
+		go func() {
+			if err := postNotification(ctx, url, body); err != nil {
+				ra.logger.Warn().Err(err).Str("event", string(event.Type)).Msg("failed to notify webhook")
+			}
+		}()
+	}
+}
+
+// postNotification POSTs the given encoded lifecycle event to the given URL.
+func postNotification(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := notificationClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("failed to send notification: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package reviewapps
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec is the OpenAPI definition of the status, admin and preview APIs. The client package
+// implements it, so both have to be kept in sync with the handlers.
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// serveOpenAPISpec serves the OpenAPI definition of the APIs, so tools can integrate with them.
+func serveOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	_, _ = w.Write(openAPISpec)
+}
@@ -0,0 +1,169 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-github/v60/github"
+	"github.com/rs/zerolog"
+)
+
+// OrphanCollector deletes the apps of review apps whose pull request was closed or whose repository
+// no longer has the GitHub App installed on a schedule, so crashes and missed webhooks don't leak
+// apps forever.
+type OrphanCollector struct {
+	prs      *PRHandler
+	schedule *cronSchedule
+}
+
+// NewOrphanCollector returns a new OrphanCollector for the given schedule.
+func NewOrphanCollector(prs *PRHandler, schedule string) (*OrphanCollector, error) {
+	s, err := parseCron(schedule)
+	if err != nil {
+		return nil, err
+	}
+	return &OrphanCollector{prs: prs, schedule: s}, nil
+}
+
+// Run deletes orphaned apps on the schedule until the context is done.
+func (oc *OrphanCollector) Run(ctx context.Context) {
+	logger := zerolog.Ctx(ctx).With().Str("component", "orphans").Logger()
+	ctx = logger.WithContext(ctx)
+
+	for {
+		next := oc.schedule.Next(time.Now().UTC())
+		if next.IsZero() {
+			logger.Error().Msg("orphan schedule never matches")
+			return
+		}
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		if !oc.prs.throttle.wait(ctx, "orphans") {
+			return
+		}
+		if err := oc.collect(ctx); err != nil {
+			logger.Error().Err(err).Msg("failed to delete orphaned apps")
+		}
+	}
+}
+
+// collect deletes all apps of the account that carry the ownership marker and the pull request
+// marker and are named like the review app of that pull request, if the pull request is orphaned.
+// Apps without the markers, like the warm pool's, are never considered.
+func (oc *OrphanCollector) collect(ctx context.Context) error {
+	logger := zerolog.Ctx(ctx)
+	apps, err := listApps(ctx, oc.prs.do)
+	if err != nil {
+		return err
+	}
+
+	// The clients of the repositories, or nil if the GitHub App isn't installed on them.
+	clients := make(map[string]*github.Client)
+	var errs []error
+	for _, app := range apps {
+		spec := app.GetSpec()
+		if !isOwned(spec) {
+			continue
+		}
+		repo, number, ok := pullRequestOf(spec)
+		if !ok {
+			continue
+		}
+		owner, name, ok := strings.Cut(repo, "/")
+		namer := oc.prs.repoNamer(repo)
+		if s := appSpecOf(spec); s != "" {
+			namer = specNamer{Namer: namer, spec: s}
+		}
+		if !ok || !isPRAppName(namer, owner, name, number, spec.GetName()) {
+			logger.Warn().Str("app_id", app.GetID()).Str("app_name", spec.GetName()).Str("pull_request", fmt.Sprintf("%s#%d", repo, number)).Msg("ignoring app that isn't named like the review app of its pull request")
+			continue
+		}
+
+		reason, err := oc.orphaned(ctx, clients, owner, name, number)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to check app %s: %w", spec.GetName(), err))
+			continue
+		}
+		if reason == "" {
+			continue
+		}
+
+		logger.Info().Str("app_id", app.GetID()).Str("app_name", spec.GetName()).Msgf("deleting orphaned app as %s", reason)
+		ra, err := oc.orphanedReviewApp(ctx, owner, name, number, spec)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete app %s: %w", spec.GetName(), err))
+			continue
+		}
+		// Its domain and database clusters are deleted, too, as nothing else would delete them.
+		deleted, err := oc.prs.deleteReviewApp(ctx, ra, app.GetID())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete app %s: %w", spec.GetName(), err))
+		}
+		if deleted {
+			orphansDeletedTotal.Add(repo, 1)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// orphanedReviewApp returns the review app of the given pull request owning the app of the given
+// spec. The pull request or even the GitHub App's installation might be gone, so it only knows what
+// deleting the app and everything provisioned for it needs.
+func (oc *OrphanCollector) orphanedReviewApp(ctx context.Context, owner, name string, number int, spec *godo.AppSpec) (*reviewApp, error) {
+	repo := owner + "/" + name
+	cfg, err := oc.prs.config.ForRepo(repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get review app configuration: %w", err)
+	}
+	return &reviewApp{
+		cfg:     cfg,
+		logger:  zerolog.Ctx(ctx).With().Str("app_name", spec.GetName()).Logger(),
+		repo:    &github.Repository{Name: &name, FullName: &repo, Owner: &github.User{Login: &owner}},
+		owner:   owner,
+		name:    name,
+		number:  number,
+		appName: spec.GetName(),
+		spec:    appSpecOf(spec),
+	}, nil
+}
+
+// orphaned returns why the app of the given pull request is orphaned, or an empty string if it
+// isn't. Only pull requests that are known to be closed or gone are orphaned, so failing requests
+// never delete an app.
+func (oc *OrphanCollector) orphaned(ctx context.Context, clients map[string]*github.Client, owner, name string, number int) (string, error) {
+	repo := owner + "/" + name
+	client, ok := clients[repo]
+	if !ok {
+		_, c, err := oc.prs.repoInstallation(ctx, owner, name)
+		if err != nil && !isGitHubNotFound(err) {
+			return "", err
+		}
+		client = c
+		clients[repo] = client
+	}
+	if client == nil {
+		return fmt.Sprintf("the GitHub App is no longer installed on %s", repo), nil
+	}
+
+	pr, _, err := client.PullRequests.Get(ctx, owner, name, number)
+	if isGitHubNotFound(err) {
+		return fmt.Sprintf("pull request #%d doesn't exist", number), nil
+	} else if err != nil {
+		return "", githubError(err, fmt.Sprintf("failed to get pull request #%d", number))
+	}
+	if pr.GetState() == "closed" {
+		return fmt.Sprintf("pull request #%d is closed", number), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,24 @@
+package reviewapps
+
+import (
+	"errors"
+	"sync"
+)
+
+// parallel runs the given functions concurrently and waits for all of them to return. It returns
+// the errors of all failed functions joined together.
+func parallel(fns ...func() error) error {
+	errs := make([]error, len(fns))
+
+	var wg sync.WaitGroup
+	for i, fn := range fns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn()
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,150 @@
+package reviewapps
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-github/v60/github"
+)
+
+// maxComparedFiles is the maximum amount of files GitHub returns when comparing commits.
+const maxComparedFiles = 300
+
+// affectedComponents returns the components of the review app's app spec whose sources are
+// affected by the changes between the latest deployment and the pull request's head, as filtered
+// by the configured paths. It returns false if that can't be determined, in which case all
+// components must be considered affected.
+func (h *PRHandler) affectedComponents(ctx context.Context, ra *reviewApp) ([]string, bool, error) {
+	deployment, payload, err := h.latestDeployment(ctx, ra)
+	if err != nil || deployment == nil {
+		return nil, false, err
+	}
+
+	files, ok := changedFiles(ctx, ra, deployment.GetSHA())
+	if !ok {
+		return nil, false, nil
+	}
+	if changed, err := h.specFilesChanged(ctx, ra, files); err != nil || changed {
+		// Changes to the app spec or its configuration can affect every component.
+		return nil, false, err
+	}
+	include, exclude := compileGlobs(ra.cfg.Paths.Include), compileGlobs(ra.cfg.Paths.Exclude)
+	files = slices.DeleteFunc(files, func(f string) bool { return matchesAny(exclude, f) })
+
+	app, _, err := h.do.Apps.Get(ctx, payload.AppID)
+	if err != nil {
+		return nil, false, doError(err, "failed to get app")
+	}
+
+	var affected []string
+	godo.ForEachAppSpecComponent(app.GetSpec(), func(c godo.AppBuildableComponentSpec) error {
+		ref := c.GetGitHub()
+		if ref == nil || ref.Repo != ra.repo.GetFullName() {
+			// Only components sourced from the pull request's repository are affected.
+			return nil
+		}
+		dockerfile := ""
+		if dc, ok := c.(godo.AppDockerBuildableComponentSpec); ok {
+			// Dockerfiles are relative to the repository's root.
+			dockerfile = strings.TrimPrefix(dc.GetDockerfilePath(), "/")
+		}
+		for _, f := range files {
+			if len(include) > 0 {
+				// Included paths replace the source directories of all components.
+				if matchesAny(include, f) {
+					affected = append(affected, c.GetName())
+					return nil
+				}
+				continue
+			}
+			if inSourceDir(c.GetSourceDir(), f) || (dockerfile != "" && f == dockerfile) {
+				affected = append(affected, c.GetName())
+				return nil
+			}
+		}
+		return nil
+	})
+	return affected, true, nil
+}
+
+// specChanged returns whether or not the review app's committed spec or the repository's
+// configuration changed since the given deployment. Generated specs, and changes that can't be
+// determined, are never considered changed, so the app is redeployed with its existing spec.
+func (h *PRHandler) specChanged(ctx context.Context, ra *reviewApp, deployment *github.Deployment) (bool, error) {
+	if len(ra.cfg.Spec.Command) > 0 || ra.cfg.Spec.Artifact.Workflow != "" || ra.cfg.Spec.Submodule != "" {
+		return false, nil
+	}
+	files, ok := changedFiles(ctx, ra, deployment.GetSHA())
+	if !ok {
+		return false, nil
+	}
+	return h.specFilesChanged(ctx, ra, files)
+}
+
+// changedFiles returns the files changed between the given commit and the pull request's head,
+// including the previous names of renamed files. It returns false if they can't be determined.
+func changedFiles(ctx context.Context, ra *reviewApp, base string) ([]string, bool) {
+	comparison, _, err := ra.client.Repositories.CompareCommits(ctx, ra.owner, ra.name, base, ra.pr.GetHead().GetSHA(), nil)
+	if err != nil {
+		// Force-pushes might have removed the deployed commit.
+		ra.logger.Warn().Err(err).Msg("failed to compare pull request with its latest deployment")
+		return nil, false
+	}
+	if len(comparison.Files) >= maxComparedFiles {
+		// The list of files is truncated.
+		return nil, false
+	}
+	var files []string
+	for _, f := range comparison.Files {
+		files = append(files, f.GetFilename())
+		if f.GetPreviousFilename() != "" {
+			files = append(files, f.GetPreviousFilename())
+		}
+	}
+	return files, true
+}
+
+// specFilesChanged returns whether or not the given changed files include the review app's spec or
+// the repository's configuration.
+func (h *PRHandler) specFilesChanged(ctx context.Context, ra *reviewApp, files []string) (bool, error) {
+	repoCfg, err := h.repoConfig(ctx, ra)
+	if err != nil {
+		return false, err
+	}
+	for _, location := range append(specLocations(ra.directives, repoCfg, ra.cfg.Spec), repoConfigLocation) {
+		if contains(files, location) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// compileGlobs compiles the given path globs, which are validated with the configuration.
+func compileGlobs(globs []string) []*regexp.Regexp {
+	var res []*regexp.Regexp
+	for _, glob := range globs {
+		if re, err := globRegexp(glob, false); err == nil {
+			res = append(res, re)
+		}
+	}
+	return res
+}
+
+// matchesAny returns whether or not the given file matches any of the given compiled globs.
+func matchesAny(res []*regexp.Regexp, file string) bool {
+	for _, re := range res {
+		if re.MatchString(file) {
+			return true
+		}
+	}
+	return false
+}
+
+// inSourceDir returns whether or not the given file is within the given source directory.
+func inSourceDir(dir, file string) bool {
+	dir = strings.Trim(dir, "/")
+	return dir == "" || dir == "." || file == dir || strings.HasPrefix(file, dir+"/")
+}
@@ -0,0 +1,54 @@
+package reviewapps
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// deploymentPayloadVersion is the latest version of the schema of deployment payloads. Version 1
+// added the repository, pull request and commit.
+const deploymentPayloadVersion = 1
+
+// parseDeploymentPayload parses the given payload of a GitHub deployment of a review app, of any
+// version. Versions only ever add fields and never change the meaning of existing ones, so payloads
+// of older versions miss fields, and payloads of newer versions, written by newer bots while they're
+// rolled out or rolled back, are parsed for the fields this version knows. GitHub returns payloads
+// created as JSON strings as strings, which are parsed, too. Empty payloads have no fields.
+func parseDeploymentPayload(raw json.RawMessage) (*deploymentPayload, error) {
+	var payload deploymentPayload
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return &payload, nil
+	}
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, fmt.Errorf("failed to parse deployment payload: %w", err)
+		}
+		if s == "" {
+			return &payload, nil
+		}
+		raw = json.RawMessage(s)
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse deployment payload: %w", err)
+	}
+	if payload.Version < 0 {
+		return nil, fmt.Errorf("invalid deployment payload version %d", payload.Version)
+	}
+	return &payload, nil
+}
+
+// versioned returns the payload of the given review app in the given version of the schema, with
+// the fields that version doesn't have left out.
+func (p deploymentPayload) versioned(ra *reviewApp, version int) deploymentPayload {
+	p.Version, p.Repo, p.PullRequest, p.SHA = 0, "", 0, ""
+	if version >= 1 {
+		p.Version = version
+		p.Repo = ra.repo.GetFullName()
+		p.PullRequest = ra.number
+		p.SHA = ra.pr.GetHead().GetSHA()
+	}
+	return p
+}
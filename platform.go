@@ -0,0 +1,69 @@
+package reviewapps
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/digitalocean/godo"
+)
+
+// buildpackStackFeature is the app-level feature selecting the stack buildpacks run on, as in
+// "buildpack-stack=ubuntu-22".
+const buildpackStackFeature = "buildpack-stack"
+
+// pinPlatform pins the platform settings of the given spec of the given review app as configured.
+// Pins replace whatever the app spec selects, so they're applied after its features were
+// restricted.
+func (h *PRHandler) pinPlatform(ctx context.Context, spec *godo.AppSpec, ra *reviewApp) error {
+	cfg := ra.cfg.Platform
+	stack := cfg.BuildpackStack
+	if cfg.MatchApp != "" {
+		apps, err := listApps(ctx, h.do)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(apps, func(app *godo.App) bool { return app.GetSpec().GetName() == cfg.MatchApp })
+		if i < 0 {
+			return fmt.Errorf("app %s to match the platform of doesn't exist", cfg.MatchApp)
+		}
+		stack = buildpackStackOf(apps[i].GetSpec())
+		if stack == "" {
+			// The matched app is built on the default stack, so review apps must be, too.
+			setBuildpackStack(spec, "")
+			return nil
+		}
+	}
+	if stack == "" {
+		return nil
+	}
+	if current := buildpackStackOf(spec); current != stack {
+		ra.logger.Info().Str("stack", stack).Str("spec_stack", current).Msg("pinning buildpack stack")
+	}
+	setBuildpackStack(spec, stack)
+	return nil
+}
+
+// buildpackStackOf returns the stack the given spec selects for buildpacks, or an empty string if
+// it uses the default one.
+func buildpackStackOf(spec *godo.AppSpec) string {
+	for _, f := range spec.GetFeatures() {
+		if key, value, ok := strings.Cut(f, "="); ok && key == buildpackStackFeature {
+			return value
+		}
+	}
+	return ""
+}
+
+// setBuildpackStack makes the given spec select the given stack for buildpacks, or the default one
+// if it's empty.
+func setBuildpackStack(spec *godo.AppSpec, stack string) {
+	spec.Features = slices.DeleteFunc(spec.Features, func(f string) bool {
+		key, _, _ := strings.Cut(f, "=")
+		return key == buildpackStackFeature
+	})
+	if stack != "" {
+		spec.Features = append(spec.Features, buildpackStackFeature+"="+stack)
+	}
+}
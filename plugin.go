@@ -0,0 +1,316 @@
+package reviewapps
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/rs/zerolog"
+)
+
+// SpecMutator mutates the app spec of a review app before it's created.
+type SpecMutator interface {
+	MutateSpec(ctx context.Context, req SpecMutationRequest) (*godo.AppSpec, error)
+}
+
+// SpecMutationRequest is the input of a SpecMutator.
+type SpecMutationRequest struct {
+	// Repo is the full name of the repository, i.e. "owner/name".
+	Repo string `json:"repo"`
+	// PullRequest is the number of the pull request, or 0 for apps of branches.
+	PullRequest int           `json:"pull_request"`
+	Spec        *godo.AppSpec `json:"spec"`
+}
+
+// PolicyDecider decides whether or not a pull request should get a review app.
+type PolicyDecider interface {
+	Decide(ctx context.Context, req PolicyRequest) (PolicyDecision, error)
+}
+
+// PolicyRequest is the input of a PolicyDecider.
+type PolicyRequest struct {
+	// Action is the action of the pull request event, the command like "/deploy", "push" for
+	// pushes to branches with an app or "rerequested" for re-run checks.
+	Action string `json:"action"`
+	// Repo is the full name of the repository, i.e. "owner/name".
+	Repo string `json:"repo"`
+	// PullRequest is the number of the pull request, or 0 for apps of branches.
+	PullRequest int      `json:"pull_request"`
+	Author      string   `json:"author"`
+	Branch      string   `json:"branch"`
+	Labels      []string `json:"labels"`
+}
+
+// PolicyDecision is the result of a PolicyDecider.
+type PolicyDecision struct {
+	Allow bool `json:"allow"`
+	// Reason explains the decision. It's logged if the pull request is denied.
+	Reason string `json:"reason"`
+}
+
+// Notifier is notified about lifecycle events of review apps.
+type Notifier interface {
+	Notify(ctx context.Context, event LifecycleEvent) error
+}
+
+const (
+	pluginCapabilitySpecMutator   = "spec_mutator"
+	pluginCapabilityPolicyDecider = "policy_decider"
+	pluginCapabilityNotifier      = "notifier"
+)
+
+const (
+	// pluginProtocolVersion is the version of the protocol spoken with plugins. Plugins are
+	// refused if they don't speak it.
+	pluginProtocolVersion = 1
+	// pluginProtocol is the protocol plugins speak after the handshake.
+	pluginProtocol = "jsonrpc"
+	// pluginMagicCookieKey and pluginMagicCookie are set in the environment of plugins, so they
+	// can tell that they're started by the service rather than run directly.
+	pluginMagicCookieKey = "REVIEWAPPS_PLUGIN_MAGIC_COOKIE"
+	pluginMagicCookie    = "2b6c0e3f9d8a4b7e8f1a5c3d7e9b0a4f"
+	// pluginProtocolVersionKey passes the protocol version the service speaks to plugins.
+	pluginProtocolVersionKey = "REVIEWAPPS_PLUGIN_PROTOCOL_VERSION"
+)
+
+// pluginStopTimeout is how long plugins get to exit once their stdin is closed, before they're
+// killed.
+var pluginStopTimeout = 5 * time.Second
+
+// pluginRestartInterval is how long failed plugins are left alone after being restarted. Calls in
+// between fail right away.
+var pluginRestartInterval = 10 * time.Second
+
+// pluginStartTimeout is how long plugins get to complete their handshake and report their
+// capabilities.
+const pluginStartTimeout = 10 * time.Second
+
+// Plugin is an out-of-process extension. The plugin's command is started as a subprocess with
+// REVIEWAPPS_PLUGIN_MAGIC_COOKIE and REVIEWAPPS_PLUGIN_PROTOCOL_VERSION set in its environment. Like
+// with hashicorp/go-plugin, it must first write a handshake line of the protocol version it speaks
+// and its protocol to its stdout, i.e. "1|jsonrpc". Afterwards, it speaks JSON-RPC 1.0 over its
+// stdin and stdout, so plugins can be written in any language. A plugin must implement
+// "Plugin.Capabilities", returning a list of the capabilities it implements ("spec_mutator",
+// "policy_decider" and "notifier"), and the respective "Plugin.MutateSpec", "Plugin.Decide" and
+// "Plugin.Notify" methods. Calls that are given up on are announced with a "Plugin.Cancel"
+// notification carrying their ID, which plugins are free to ignore. Its stderr is forwarded.
+// Plugins that exit or answer with malformed responses are restarted with the next call.
+type Plugin struct {
+	name         string
+	command      []string
+	capabilities []string
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	client *pluginClient
+	// restarted is when the plugin was last restarted.
+	restarted time.Time
+	closed    bool
+}
+
+// StartPlugin starts the plugin with the given name and command, waits for its handshake and
+// queries its capabilities.
+func StartPlugin(ctx context.Context, name string, command []string) (*Plugin, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("plugin %q has no command", name)
+	}
+	p := &Plugin{name: name, command: command}
+	if err := p.start(ctx); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %q: %w", name, err)
+	}
+	if err := p.client.call(ctx, "Plugin.Capabilities", struct{}{}, &p.capabilities); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("failed to query capabilities of plugin %q: %w", name, err)
+	}
+	return p, nil
+}
+
+// start starts the plugin's command and waits for its handshake. It must be called with mu held,
+// unless the plugin isn't shared yet.
+func (p *Plugin) start(ctx context.Context) error {
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Env = append(os.Environ(),
+		pluginMagicCookieKey+"="+pluginMagicCookie,
+		pluginProtocolVersionKey+"="+strconv.Itoa(pluginProtocolVersion),
+	)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdout: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(stdout)
+	if err := pluginHandshake(ctx, r); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	p.cmd = cmd
+	p.client = newPluginClient(r, stdin)
+	return nil
+}
+
+// pluginHandshake reads the handshake line of a plugin from the given reader and checks that the
+// plugin speaks the service's protocol, giving up once the context is done.
+func pluginHandshake(ctx context.Context, r *bufio.Reader) error {
+	type result struct {
+		line string
+		err  error
+	}
+	read := make(chan result, 1)
+	go func() {
+		line, err := r.ReadString('\n')
+		read <- result{line, err}
+	}()
+
+	var line string
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("no handshake: %w", ctx.Err())
+	case res := <-read:
+		if res.err != nil {
+			return fmt.Errorf("no handshake: %w", res.err)
+		}
+		line = strings.TrimSpace(res.line)
+	}
+	version, protocol, ok := strings.Cut(line, "|")
+	if !ok {
+		return fmt.Errorf("invalid handshake %q, must be like \"%d|%s\"", line, pluginProtocolVersion, pluginProtocol)
+	}
+	if version != strconv.Itoa(pluginProtocolVersion) {
+		return fmt.Errorf("unsupported protocol version %s, must be %d", version, pluginProtocolVersion)
+	}
+	if protocol != pluginProtocol {
+		return fmt.Errorf("unsupported protocol %q, must be %q", protocol, pluginProtocol)
+	}
+	return nil
+}
+
+// Name returns the name of the plugin.
+func (p *Plugin) Name() string {
+	return p.name
+}
+
+// Has returns whether or not the plugin implements the given capability.
+func (p *Plugin) Has(capability string) bool {
+	return contains(p.capabilities, capability)
+}
+
+// MutateSpec implements SpecMutator.
+func (p *Plugin) MutateSpec(ctx context.Context, req SpecMutationRequest) (*godo.AppSpec, error) {
+	var spec godo.AppSpec
+	if err := p.call(ctx, "Plugin.MutateSpec", req, &spec); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// Decide implements PolicyDecider.
+func (p *Plugin) Decide(ctx context.Context, req PolicyRequest) (PolicyDecision, error) {
+	var decision PolicyDecision
+	err := p.call(ctx, "Plugin.Decide", req, &decision)
+	return decision, err
+}
+
+// Notify implements Notifier.
+func (p *Plugin) Notify(ctx context.Context, event LifecycleEvent) error {
+	return p.call(ctx, "Plugin.Notify", event, &struct{}{})
+}
+
+// Close stops the plugin by closing its stdin. Plugins that don't exit within pluginStopTimeout
+// are killed.
+func (p *Plugin) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	if p.cmd == nil {
+		// The plugin failed and couldn't be restarted.
+		return nil
+	}
+	p.client.close()
+	done := make(chan error, 1)
+	go func() { done <- p.cmd.Wait() }()
+
+	timeout := time.NewTimer(pluginStopTimeout)
+	defer timeout.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timeout.C:
+		p.cmd.Process.Kill()
+		<-done
+		return fmt.Errorf("plugin %q didn't stop within %s and was killed", p.name, pluginStopTimeout)
+	}
+}
+
+// call calls the given method of the plugin, giving up once the context is done.
+func (p *Plugin) call(ctx context.Context, method string, args, reply any) error {
+	client, err := p.healthyClient(ctx)
+	if err != nil {
+		return err
+	}
+	return client.call(ctx, method, args, reply)
+}
+
+// healthyClient returns the client of the plugin, restarting the plugin first if its client failed,
+// at most once per pluginRestartInterval.
+func (p *Plugin) healthyClient(ctx context.Context) (*pluginClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, errPluginClosed
+	}
+	err := p.client.failed()
+	if err == nil {
+		return p.client, nil
+	}
+	if time.Since(p.restarted) < pluginRestartInterval {
+		return nil, fmt.Errorf("plugin %q is unhealthy: %w", p.name, err)
+	}
+
+	p.restarted = time.Now()
+	zerolog.Ctx(ctx).Warn().Err(err).Str("plugin", p.name).Msg("restarting failed plugin")
+	if p.cmd != nil {
+		// A failed plugin isn't listened to anymore, so it doesn't get to stop gracefully.
+		p.client.close()
+		p.cmd.Process.Kill()
+		p.cmd.Wait()
+		p.cmd = nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, pluginStartTimeout)
+	defer cancel()
+	if err := p.start(ctx); err != nil {
+		return nil, fmt.Errorf("failed to restart plugin %q: %w", p.name, err)
+	}
+	// The capabilities the plugin was registered with can't change anymore.
+	var capabilities []string
+	if err := p.client.call(ctx, "Plugin.Capabilities", struct{}{}, &capabilities); err != nil {
+		return nil, fmt.Errorf("failed to query capabilities of restarted plugin %q: %w", p.name, err)
+	}
+	return p.client, nil
+}
+
+// notifierListener adapts a Notifier to a LifecycleListener, logging failed notifications.
+type notifierListener struct {
+	notifier Notifier
+}
+
+func (l notifierListener) OnLifecycleEvent(ctx context.Context, event LifecycleEvent) {
+	if err := l.notifier.Notify(ctx, event); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to notify plugin about lifecycle event")
+	}
+}
@@ -0,0 +1,191 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPluginModeKey selects the behavior of the test binary acting as plugin.
+const testPluginModeKey = "REVIEWAPPS_TEST_PLUGIN"
+
+// testPlugin implements the methods of a plugin deciding policies.
+type testPlugin struct{}
+
+func (testPlugin) Capabilities(_ struct{}, reply *[]string) error {
+	*reply = []string{pluginCapabilityPolicyDecider}
+	return nil
+}
+
+func (testPlugin) Decide(req PolicyRequest, reply *PolicyDecision) error {
+	switch req.Author {
+	case "slow":
+		time.Sleep(time.Minute)
+	case "failing":
+		return errors.New("boom")
+	case "malformed":
+		fmt.Println("not a response")
+	}
+	*reply = PolicyDecision{Allow: req.Author != "mallory", Reason: "decided for " + req.Author}
+	return nil
+}
+
+func (testPlugin) Cancel(_ pluginCancellation, _ *struct{}) error {
+	return nil
+}
+
+// TestPluginProcess isn't a test, but the plugin started by the other tests.
+func TestPluginProcess(t *testing.T) {
+	mode := os.Getenv(testPluginModeKey)
+	if mode == "" {
+		t.Skip("only run as plugin")
+	}
+	if os.Getenv(pluginMagicCookieKey) != pluginMagicCookie {
+		fmt.Fprintln(os.Stderr, "not started by the service")
+		os.Exit(1)
+	}
+	switch mode {
+	case "bad-version":
+		fmt.Println("0|jsonrpc")
+	case "no-handshake":
+		time.Sleep(time.Minute)
+	default:
+		fmt.Printf("%s|%s\n", os.Getenv(pluginProtocolVersionKey), pluginProtocol)
+	}
+
+	server := rpc.NewServer()
+	_ = server.RegisterName("Plugin", testPlugin{})
+	server.ServeCodec(jsonrpc.NewServerCodec(struct {
+		io.Reader
+		io.WriteCloser
+	}{os.Stdin, os.Stdout}))
+	if mode == "hang" {
+		// Ignores that its stdin is closed.
+		time.Sleep(time.Minute)
+	}
+	os.Exit(0)
+}
+
+// startTestPlugin starts the test binary as a plugin in the given mode.
+func startTestPlugin(t *testing.T, ctx context.Context, mode string) (*Plugin, error) {
+	t.Helper()
+	t.Setenv(testPluginModeKey, mode)
+	return StartPlugin(ctx, "test", []string{os.Args[0], "-test.run=^TestPluginProcess$"})
+}
+
+func TestPlugin(t *testing.T) {
+	p, err := startTestPlugin(t, context.Background(), "ok")
+	if err != nil {
+		t.Fatalf("StartPlugin() = %v", err)
+	}
+	defer p.Close()
+
+	if !p.Has(pluginCapabilityPolicyDecider) || p.Has(pluginCapabilitySpecMutator) {
+		t.Errorf("capabilities = %v, want only %s", p.capabilities, pluginCapabilityPolicyDecider)
+	}
+	decision, err := p.Decide(context.Background(), PolicyRequest{Author: "mallory"})
+	if err != nil || decision.Allow || decision.Reason != "decided for mallory" {
+		t.Errorf("Decide() = %+v, %v, want a denial", decision, err)
+	}
+	if _, err := p.Decide(context.Background(), PolicyRequest{Author: "failing"}); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Decide() = %v, want the plugin's error", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+	if _, err := p.Decide(context.Background(), PolicyRequest{Author: "alice"}); !errors.Is(err, errPluginClosed) {
+		t.Errorf("Decide() after Close() = %v, want %v", err, errPluginClosed)
+	}
+}
+
+func TestPluginCanceledCall(t *testing.T) {
+	// The plugin only exits once the canceled call returned.
+	defer func(timeout time.Duration) { pluginStopTimeout = timeout }(pluginStopTimeout)
+	pluginStopTimeout = 100 * time.Millisecond
+
+	p, err := startTestPlugin(t, context.Background(), "ok")
+	if err != nil {
+		t.Fatalf("StartPlugin() = %v", err)
+	}
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.Decide(ctx, PolicyRequest{Author: "slow"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Decide() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	p.client.mu.Lock()
+	pending := len(p.client.pending)
+	p.client.mu.Unlock()
+	if pending != 0 {
+		t.Errorf("%d calls are still pending, want none", pending)
+	}
+	// The plugin is still usable.
+	if decision, err := p.Decide(context.Background(), PolicyRequest{Author: "alice"}); err != nil || !decision.Allow {
+		t.Errorf("Decide() = %+v, %v, want an approval", decision, err)
+	}
+}
+
+func TestPluginRestartsAfterMalformedResponse(t *testing.T) {
+	defer func(interval time.Duration) { pluginRestartInterval = interval }(pluginRestartInterval)
+	pluginRestartInterval = time.Hour
+
+	p, err := startTestPlugin(t, context.Background(), "ok")
+	if err != nil {
+		t.Fatalf("StartPlugin() = %v", err)
+	}
+	defer p.Close()
+
+	if _, err := p.Decide(context.Background(), PolicyRequest{Author: "malformed"}); err == nil {
+		t.Error("Decide() = nil, want an error")
+	}
+	// Restarts are delayed, so broken plugins aren't restarted with every call.
+	p.restarted = time.Now()
+	if _, err := p.Decide(context.Background(), PolicyRequest{Author: "alice"}); err == nil || !strings.Contains(err.Error(), "unhealthy") {
+		t.Errorf("Decide() = %v, want the plugin to be unhealthy", err)
+	}
+	p.restarted = time.Time{}
+	if decision, err := p.Decide(context.Background(), PolicyRequest{Author: "alice"}); err != nil || !decision.Allow {
+		t.Errorf("Decide() after restart = %+v, %v, want an approval", decision, err)
+	}
+}
+
+func TestPluginHandshake(t *testing.T) {
+	for mode, want := range map[string]string{
+		"bad-version":  "unsupported protocol version 0",
+		"no-handshake": "no handshake",
+	} {
+		t.Run(mode, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_, err := startTestPlugin(t, ctx, mode)
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("StartPlugin() = %v, want %q", err, want)
+			}
+		})
+	}
+}
+
+func TestPluginCloseKillsHangingPlugin(t *testing.T) {
+	defer func(timeout time.Duration) { pluginStopTimeout = timeout }(pluginStopTimeout)
+	pluginStopTimeout = 100 * time.Millisecond
+
+	p, err := startTestPlugin(t, context.Background(), "hang")
+	if err != nil {
+		t.Fatalf("StartPlugin() = %v", err)
+	}
+	start := time.Now()
+	if err := p.Close(); err == nil || !strings.Contains(err.Error(), "killed") {
+		t.Errorf("Close() = %v, want the plugin to be killed", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Close() returned after %s", elapsed)
+	}
+}
@@ -0,0 +1,187 @@
+package reviewapps
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// errPluginClosed is returned by calls of plugins that were stopped or whose stdout was closed.
+var errPluginClosed = errors.New("plugin is closed")
+
+// pluginRequest is a JSON-RPC 1.0 request. Notifications have no ID.
+type pluginRequest struct {
+	Method string  `json:"method"`
+	Params [1]any  `json:"params"`
+	ID     *uint64 `json:"id"`
+}
+
+// pluginResponse is a JSON-RPC 1.0 response.
+type pluginResponse struct {
+	ID     *uint64         `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  any             `json:"error"`
+}
+
+// pluginCancellation is the parameter of the "Plugin.Cancel" notification.
+type pluginCancellation struct {
+	ID uint64 `json:"id"`
+}
+
+// pluginReply is what a pending call of a plugin is answered with.
+type pluginReply struct {
+	result json.RawMessage
+	err    error
+}
+
+// pluginClient is a JSON-RPC 1.0 client of a plugin. Unlike net/rpc, it forgets calls that are
+// given up on, so their replies are dropped once they arrive.
+type pluginClient struct {
+	w io.WriteCloser
+	// writing serializes the requests written to the plugin.
+	writing sync.Mutex
+
+	mu      sync.Mutex
+	seq     uint64
+	pending map[uint64]chan pluginReply
+	// err is set once the client can't be used anymore.
+	err error
+}
+
+// newPluginClient returns a client reading responses from the given reader and writing requests
+// to the given writer.
+func newPluginClient(r *bufio.Reader, w io.WriteCloser) *pluginClient {
+	c := &pluginClient{w: w, pending: make(map[uint64]chan pluginReply)}
+	go c.read(r)
+	return c
+}
+
+// call calls the given method of the plugin and decodes its result into the given reply. Once the
+// context is done, the call is given up on and the plugin is notified to cancel it.
+func (c *pluginClient) call(ctx context.Context, method string, args, reply any) error {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.seq++
+	id := c.seq
+	replies := make(chan pluginReply, 1)
+	c.pending[id] = replies
+	c.mu.Unlock()
+
+	if err := c.write(pluginRequest{Method: method, Params: [1]any{args}, ID: &id}); err != nil {
+		c.forget(id)
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		if c.forget(id) {
+			// Plugins only learn about given up calls if they implement cancellation.
+			_ = c.write(pluginRequest{Method: "Plugin.Cancel", Params: [1]any{pluginCancellation{ID: id}}})
+		}
+		return ctx.Err()
+	case r := <-replies:
+		if r.err != nil {
+			return r.err
+		}
+		if err := json.Unmarshal(r.result, reply); err != nil {
+			return fmt.Errorf("failed to decode result of %s: %w", method, err)
+		}
+		return nil
+	}
+}
+
+// forget forgets the given pending call and returns whether or not it was still pending.
+func (c *pluginClient) forget(id uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.pending[id]
+	delete(c.pending, id)
+	return ok
+}
+
+// write writes the given request to the plugin. The plugin's stdin only fails to be written to
+// once the plugin exited, which fails the client.
+func (c *pluginClient) write(req pluginRequest) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+	c.writing.Lock()
+	defer c.writing.Unlock()
+	if _, err := c.w.Write(append(b, '\n')); err != nil {
+		err = fmt.Errorf("failed to write request: %w", err)
+		c.fail(err)
+		return err
+	}
+	return nil
+}
+
+// read answers the pending calls with the responses read from the given reader until it fails,
+// which fails all calls that are still pending.
+func (c *pluginClient) read(r *bufio.Reader) {
+	dec := json.NewDecoder(r)
+	for {
+		var resp pluginResponse
+		if err := dec.Decode(&resp); err != nil {
+			if !errors.Is(err, io.EOF) {
+				err = fmt.Errorf("failed to decode response: %w", err)
+			} else {
+				err = errPluginClosed
+			}
+			c.fail(err)
+			return
+		}
+		if resp.ID == nil {
+			continue
+		}
+
+		c.mu.Lock()
+		replies, ok := c.pending[*resp.ID]
+		delete(c.pending, *resp.ID)
+		c.mu.Unlock()
+		if !ok {
+			// The call was given up on.
+			continue
+		}
+		var reply pluginReply
+		if resp.Error != nil {
+			reply.err = fmt.Errorf("%v", resp.Error)
+		} else {
+			reply.result = resp.Result
+		}
+		replies <- reply
+	}
+}
+
+// fail fails all pending and future calls with the given error.
+func (c *pluginClient) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	for id, replies := range c.pending {
+		replies <- pluginReply{err: c.err}
+		delete(c.pending, id)
+	}
+}
+
+// failed returns why the client can't be used anymore, or nil if it can.
+func (c *pluginClient) failed() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// close closes the plugin's stdin, which asks it to exit, and fails all pending calls.
+func (c *pluginClient) close() {
+	c.fail(errPluginClosed)
+	c.w.Close()
+}
@@ -0,0 +1,92 @@
+package reviewapps
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// pollHistorySize is the amount of recent deployments per repository polling is tuned by.
+	pollHistorySize = 10
+	// pollsPerRemaining is how many times a deployment is polled in the time it's expected to
+	// still take, within the configured bounds.
+	pollsPerRemaining = 10
+)
+
+// deploymentDurations tracks the durations of the recent successful deployments per repository.
+type deploymentDurations struct {
+	mu        sync.Mutex
+	durations map[string][]time.Duration
+}
+
+func newDeploymentDurations() *deploymentDurations {
+	return &deploymentDurations{durations: make(map[string][]time.Duration)}
+}
+
+// record records the given duration of a successful deployment of the given repository.
+func (d *deploymentDurations) record(repo string, duration time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	durations := append(d.durations[repo], duration)
+	if len(durations) > pollHistorySize {
+		durations = durations[len(durations)-pollHistorySize:]
+	}
+	d.durations[repo] = durations
+}
+
+// stats returns the median and the longest of the recent durations of the given repository's
+// deployments, if there are any.
+func (d *deploymentDurations) stats(repo string) (median, longest time.Duration, ok bool) {
+	d.mu.Lock()
+	sorted := append([]time.Duration(nil), d.durations[repo]...)
+	d.mu.Unlock()
+	if len(sorted) == 0 {
+		return 0, 0, false
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2], sorted[len(sorted)-1], true
+}
+
+// pollSchedule decides how often a deployment is polled and how long it's waited for.
+type pollSchedule struct {
+	minInterval, maxInterval time.Duration
+	// expected is how long the deployment is expected to take, or 0 if unknown.
+	expected time.Duration
+	// timeout is how long the deployment is waited for, or 0 if indefinitely.
+	timeout time.Duration
+}
+
+// fixedPollSchedule polls at the given interval and waits indefinitely.
+func fixedPollSchedule(interval time.Duration) pollSchedule {
+	return pollSchedule{minInterval: interval, maxInterval: interval}
+}
+
+// interval returns how long to wait for the next poll of a deployment that has been running for
+// the given time. Deployments are polled rarely while they're expected to take long and at the
+// minimum interval once they're expected to finish, so fast repositories stay snappy.
+func (s pollSchedule) interval(running time.Duration) time.Duration {
+	if s.expected == 0 || running >= s.expected {
+		return s.minInterval
+	}
+	return min(max((s.expected-running)/pollsPerRemaining, s.minInterval), s.maxInterval)
+}
+
+// pollSchedule returns the schedule deployments of the given repository are polled on. Without
+// adaptive polling or recent deployments of the repository, they're polled at the minimum
+// interval and waited for indefinitely.
+func (h *PRHandler) pollSchedule(repo string) pollSchedule {
+	c := h.config.Polling
+	s := pollSchedule{minInterval: c.GetMinInterval(), maxInterval: c.GetMaxInterval()}
+	if !c.Adaptive || repo == "" {
+		return s
+	}
+	median, longest, ok := h.durations.stats(repo)
+	if !ok {
+		return s
+	}
+	s.expected = median
+	s.timeout = max(time.Duration(c.GetTimeoutFactor())*longest, c.GetMinTimeout())
+	pollIntervalSeconds.Set(repo, float(s.interval(0).Seconds()))
+	return s
+}
@@ -1,14 +1,18 @@
-package main
+package reviewapps
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/digitalocean/godo"
 	"github.com/google/go-github/v60/github"
 	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/rs/zerolog"
+	"github.internal.digitalocean.com/mthoemmes/reviewapps/store"
 	"sigs.k8s.io/yaml"
 )
 
@@ -19,257 +23,1570 @@ const (
 	actionReopened    = "reopened"
 	actionClosed      = "closed"
 	actionSynchronize = "synchronize"
+	actionLabeled     = "labeled"
+	actionUnlabeled   = "unlabeled"
+	actionEdited      = "edited"
 
-	deploymentStateInactive = "inactive"
-	deploymentStateSuccess  = "success"
-	deploymentStateError    = "error"
+	deploymentStateInactive   = "inactive"
+	deploymentStateInProgress = "in_progress"
+	deploymentStateSuccess    = "success"
+	deploymentStateError      = "error"
+	deploymentStateFailure    = "failure"
 )
 
+// deploymentPayload is the payload of the GitHub deployments of review apps, which is all the state
+// some of them have. See parseDeploymentPayload for how its versions stay compatible.
 type deploymentPayload struct {
+	// Version is the version of the payload's schema. Payloads of bots that didn't version them
+	// have none, i.e. version 0.
+	Version int `json:"version,omitempty"`
+	// Repo, PullRequest and SHA are the repository, pull request and commit the deployment was
+	// created for, since version 1. Apps of branches have no pull request.
+	Repo        string `json:"repo,omitempty"`
+	PullRequest int    `json:"pr,omitempty"`
+	SHA         string `json:"sha,omitempty"`
+
 	AppID string `json:"app_id"`
+	// IdempotencyKey identifies the operation that created the deployment, to avoid repeating it
+	// when it's retried after an ambiguous failure.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// SpecHash is the hash of the app's spec as of the deployment, to detect changes made outside
+	// of review apps.
+	SpecHash string `json:"spec_hash,omitempty"`
 }
 
+// PRHandler manages review apps in response to pull request events.
 type PRHandler struct {
-	cc githubapp.ClientCreator
-	do *godo.Client
+	cc        githubapp.ClientCreator
+	do        *godo.Client
+	config    *Config
+	listeners lifecycleListeners
+	mutators  []SpecMutator
+	deciders  []PolicyDecider
+	// namers are the naming strategies registered with the Builder.
+	namers   map[string]Namer
+	pool     *WarmPool
+	backups  *DatabaseBackups
+	skips    *skipStore
+	comments *commenter
+	// doWebhooks receives alerts about finished deployments, if configured.
+	doWebhooks *doWebhooks
+	// queue tracks the events being handled and the deployments being waited for.
+	queue *queue
+	// store records the apps of review apps.
+	store store.Store
+	// durations are the durations of recent deployments polling is tuned by.
+	durations *deploymentDurations
+	// turns serializes acting upon pull requests.
+	turns *turns
+	// captures records the DigitalOcean API requests of pull requests being debugged.
+	captures *debugCaptures
+	// gateway fronts the previews of review apps, if configured.
+	gateway *gateway
+	// protections records who protected review apps and why.
+	protections *protections
+	// budget tracks the review apps created and queued under the caps on running review apps.
+	budget *budget
+	// throttle holds back background work while the DigitalOcean API fails a lot, or is nil.
+	throttle *throttle
+}
+
+// NewPRHandler returns a new PRHandler.
+func NewPRHandler(cc githubapp.ClientCreator, do *godo.Client, config *Config) *PRHandler {
+	h := &PRHandler{cc: cc, do: do, config: config, skips: newSkipStore(), comments: newCommenter(config.Comments), queue: newQueue(), store: store.NewMemory(), durations: newDeploymentDurations(), turns: newTurns(), protections: newProtections(), budget: newBudget()}
+	h.queue.adminToken = config.Server.AdminToken
+	h.skips.adminToken = config.Server.AdminToken
+	h.captures = newDebugCaptures(config.Server.AdminToken)
+	if config.DOWebhooks.URL != "" {
+		h.doWebhooks = newDOWebhooks(config.DOWebhooks)
+	}
+	if config.Gateway.URL != "" {
+		h.gateway = newGateway(h, config.Gateway)
+	}
+	if config.Throttling.ErrorRate > 0 {
+		h.throttle = newThrottle(config.Throttling)
+	}
+	return h
 }
 
 func (h *PRHandler) Handles() []string {
 	return []string{"pull_request"}
 }
 
-func (h *PRHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+// onDemandSkip is why pull requests of repositories with on-demand review apps get none.
+var onDemandSkip = fmt.Sprintf("review apps are only created on demand with %s", commandDeploy)
+
+// triageResult is the result of triaging a pull request event.
+type triageResult struct {
+	cfg ReviewAppConfig
+	// teardown is whether or not the event causes the review app to be deleted.
+	teardown bool
+	// skip is why the event is skipped, if it is.
+	skip string
+	// unhandled is whether or not the event is skipped as its action is never handled.
+	unhandled bool
+	// revokeApproval is whether or not the approval label is removed from the pull request of a
+	// forked repository, as it doesn't approve its current head.
+	revokeApproval bool
+	// approval is whether or not the event is skipped as the pull request's head isn't approved.
+	approval bool
+}
+
+// triageEvent decides whether or not the given event is acted upon without calling any APIs, so
+// it's cheap enough to be done before the event is processed asynchronously.
+func (h *PRHandler) triageEvent(event *github.PullRequestEvent) (*triageResult, error) {
+	switch event.GetAction() {
+	case actionOpened, actionReopened, actionClosed, actionSynchronize, actionLabeled, actionUnlabeled, actionEdited:
+	default:
+		return &triageResult{skip: fmt.Sprintf("action %q is not handled", event.GetAction()), unhandled: true}, nil
+	}
+	if err := validatePullRequestEvent(event); err != nil {
+		return nil, err
+	}
+
+	repo := event.GetRepo()
+	pr := event.GetPullRequest()
+	cfg, err := h.config.ForRepo(repo.GetFullName())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get review app configuration: %w", err)
+	}
+
+	if event.GetAction() == actionEdited && (!cfg.Directives.Enabled || cfg.Directives.GetOnEdit() != directivesOnEditReconcile) {
+		return &triageResult{skip: "edits are not reconciled", unhandled: true}, nil
+	}
+	if isFork(repo, pr) {
+		return triageFork(cfg, event), nil
+	}
+
+	directives, err := prDirectivesOf(cfg, pr)
+	if err != nil {
+		return &triageResult{cfg: cfg, skip: fmt.Sprintf("the directives in the pull request's description are invalid: %v", err)}, nil
+	}
+	if event.GetAction() == actionEdited {
+		before, _, _ := editedDirectives(cfg, event)
+		switch {
+		case before.equal(directives):
+			return &triageResult{skip: "the pull request's directives are unchanged", unhandled: true}, nil
+		case directives.disabled() && !before.disabled():
+			return &triageResult{cfg: cfg, teardown: true}, nil
+		}
+	}
+	if directives.disabled() {
+		return &triageResult{cfg: cfg, skip: "the pull request's directives disable its review app"}, nil
+	}
+
+	isBot := cfg.Bots.IsBot(pr.GetUser().GetLogin())
+	isBotDeployLabel := isBot && cfg.Bots.GetPolicy() == botPolicyLabel && event.GetLabel().GetName() == cfg.Bots.Label
+	isTeardownLabel := contains(cfg.TeardownLabels, event.GetLabel().GetName())
+	isDeployLabel := cfg.DeployOnLabel != "" && event.GetLabel().GetName() == cfg.DeployOnLabel
+	isTierLabel := h.isTierLabel(event.GetLabel().GetName())
+	if event.GetAction() == actionLabeled && !isBotDeployLabel && !isTeardownLabel && !isDeployLabel && !isTierLabel {
+		// Labels only matter if they cause a review app to be created, torn down or changed.
+		return &triageResult{skip: fmt.Sprintf("label %q neither creates nor tears down review apps", event.GetLabel().GetName()), unhandled: true}, nil
+	}
+	if event.GetAction() == actionUnlabeled && !isDeployLabel && !isTierLabel {
+		return &triageResult{skip: fmt.Sprintf("removing label %q doesn't tear down review apps", event.GetLabel().GetName()), unhandled: true}, nil
+	}
+
+	t := &triageResult{
+		cfg:      cfg,
+		teardown: event.GetAction() == actionClosed || (event.GetAction() == actionUnlabeled && isDeployLabel) || (event.GetAction() == actionLabeled && isTeardownLabel),
+	}
+	if t.teardown {
+		return t, nil
+	}
+
+	if hasAnyLabel(pr, cfg.TeardownLabels) {
+		t.skip = "the pull request carries a teardown label"
+		return t, nil
+	}
+	if isBot {
+		switch cfg.Bots.GetPolicy() {
+		case botPolicySkip:
+			t.skip = "the pull request is authored by a bot"
+		case botPolicyLabel:
+			if !hasLabel(pr, cfg.Bots.Label) {
+				t.skip = fmt.Sprintf("the pull request is authored by a bot and lacks label %q", cfg.Bots.Label)
+			}
+		}
+	}
+	if t.skip == "" && cfg.DeployOnLabel != "" && !hasLabel(pr, cfg.DeployOnLabel) {
+		t.skip = fmt.Sprintf("the pull request lacks label %q", cfg.DeployOnLabel)
+	}
+	if t.skip == "" && cfg.OnDemand && !isTierLabel {
+		switch event.GetAction() {
+		case actionOpened, actionReopened, actionLabeled:
+			t.skip = onDemandSkip
+		}
+	}
+	return t, nil
+}
+
+// triage implements triager.
+func (h *PRHandler) triage(eventType string, payload []byte) (string, error) {
 	var event github.PullRequestEvent
 	if err := json.Unmarshal(payload, &event); err != nil {
-		return fmt.Errorf("failed to parse pull request event: %w", err)
+		return "", errorf(ErrorKindInvalidEvent, "failed to parse pull request event: %w", err)
 	}
+	t, err := h.triageEvent(&event)
+	if err != nil {
+		return "", err
+	}
+	return t.skip, nil
+}
 
-	switch event.GetAction() {
-	case actionOpened, actionReopened, actionClosed, actionSynchronize:
-	default:
-		// Short-circuit for all the actions we don't want to deal with.
-		return nil
+func (h *PRHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	var event github.PullRequestEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return errorf(ErrorKindInvalidEvent, "failed to parse pull request event: %w", err)
 	}
 
 	repo := event.GetRepo()
-	prNum := event.GetNumber()
+	pr := event.GetPullRequest()
 	installationID := githubapp.GetInstallationIDFromEvent(&event)
-	ctx, logger := githubapp.PreparePRContext(ctx, installationID, repo, prNum)
-	logger = logger.With().Str("github_event_action", event.GetAction()).Logger()
+	ctx, logger := githubapp.PreparePRContext(ctx, installationID, repo, event.GetNumber())
+	logger = logger.With().Str("github_event_action", event.GetAction()).Str("tracking_id", deliveryID).Logger()
 
-	if repo.GetID() != event.GetPullRequest().GetHead().GetRepo().GetID() {
-		logger.Warn().Msg("pull requests of forked repositories are not allowed")
+	t, err := h.triageEvent(&event)
+	if err != nil {
+		return skipNotActionable(ctx, eventType, err)
+	}
+	if t.unhandled {
+		// Unhandled events don't say anything about why there's no review app, so they're not
+		// recorded.
+		logger.Debug().Str("reason", t.skip).Msg("skipping pull request event")
 		return nil
 	}
+	cfg, teardown := t.cfg, t.teardown
+	skipPR := func(level zerolog.Level, msg, reason string, approval bool) error {
+		return h.skip(ctx, installationID, cfg, skippedPR{
+			Repo:           repo.GetFullName(),
+			PullRequest:    event.GetNumber(),
+			Action:         event.GetAction(),
+			Reason:         reason,
+			TrackingID:     deliveryID,
+			installationID: installationID,
+			approval:       approval,
+		}, level, msg)
+	}
+	skip := func(level zerolog.Level, msg, reason string) error {
+		return skipPR(level, msg, reason, false)
+	}
+	if t.revokeApproval {
+		if err := h.revokeApproval(ctx, installationID, repo, event.GetNumber(), cfg); err != nil {
+			return err
+		}
+	}
+	if t.skip != "" {
+		return skipPR(zerolog.InfoLevel, "skipping pull request event", t.skip, t.approval)
+	}
 
-	repoOwner := repo.GetOwner().GetLogin()
-	repoName := repo.GetName()
-	prBranch := event.GetPullRequest().GetHead().GetRef()
+	kind := turnQueued
+	switch {
+	case teardown:
+		kind = turnSuperseding
+	case event.GetAction() == actionSynchronize:
+		kind = turnPush
+	}
+	ctx, done, ok, err := h.turns.take(ctx, repo.GetFullName(), event.GetNumber(), kind)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		logger.Info().Msg("skipping push superseded by a newer push")
+		return nil
+	}
+	defer done()
+
+	if !teardown {
+		decision, err := h.decide(ctx, event.GetAction(), repo, pr)
+		if err != nil {
+			return err
+		}
+		if !decision.Allow {
+			return skip(zerolog.InfoLevel, "skipping pull request denied by policy", fmt.Sprintf("denied by policy: %s", decision.Reason))
+		}
+	}
 
-	// TODO: The 32 char limit pretty narrow here. Maybe we should compute a hash?
-	appName := fmt.Sprintf("%s-%s-%d", repoOwner, repoName, prNum)
+	ra, err := h.newReviewApp(ctx, installationID, repo, pr, cfg)
+	if err != nil {
+		return err
+	}
+	ra.logger = logger.With().Str("app_name", ra.appName).Logger()
+	ctx = withDebugCapture(ra.logger.WithContext(ctx), ra)
 
-	client, err := h.cc.NewInstallationClient(installationID)
+	if ra.fork && !teardown {
+		// Anyone with triage access can label pull requests, but only write access approves them.
+		approver := event.GetSender().GetLogin()
+		ok, err := hasWriteAccess(ctx, ra, approver)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return skipPR(zerolog.WarnLevel, "skipping approval of user without write access", fmt.Sprintf("%s approved the pull request without write access", approver), true)
+		}
+	}
+
+	apps, removed, err := h.reviewApps(ctx, ra)
+	if err != nil {
+		return err
+	}
+	// teardownAll tears down all review apps of the pull request, including the ones of app specs
+	// removed from its branch.
+	teardownAll := func(reason string) error {
+		var errs []error
+		for _, app := range append(apps, removed...) {
+			errs = append(errs, h.teardown(ctx, app, reason))
+		}
+		if err := errors.Join(errs...); err != nil {
+			return err
+		}
+		if cfg.Spec.Apps != "" {
+			h.recordAppSpecs(ctx, ra, nil)
+		}
+		return nil
+	}
+
+	if teardown {
+		h.skips.clear(repo.GetFullName(), event.GetNumber())
+		reason := "the PR was closed"
+		switch event.GetAction() {
+		case actionLabeled:
+			reason = fmt.Sprintf("the PR was labeled %q", event.GetLabel().GetName())
+		case actionUnlabeled:
+			reason = fmt.Sprintf("the PR's label %q was removed", event.GetLabel().GetName())
+		case actionEdited:
+			reason = "the PR's directives disable its review app"
+		}
+		// Visits are forgotten once the review app is torn down.
+		var summary string
+		if event.GetAction() == actionClosed {
+			summary = h.visitSummary(ra)
+		}
+		if err := teardownAll(reason); err != nil {
+			return err
+		}
+		if cfg.Stacks.Mode == stackModeLink {
+			h.relinkStack(ctx, ra)
+		}
+		if summary != "" {
+			return h.comment(ctx, ra, commentKindVisits, summary)
+		}
+		return nil
+	}
+
+	repoCfg, err := h.repoConfig(ctx, ra)
 	if err != nil {
-		return fmt.Errorf("failed to create installation client: %w", err)
+		return err
+	}
+	if repoCfg.disabled() {
+		// Disabling review apps in the repository also removes the ones that exist.
+		if err := teardownAll(repoConfigDisabledReason); err != nil {
+			return err
+		}
+		return skip(zerolog.InfoLevel, "skipping pull request of repository disabling review apps", repoConfigDisabledReason)
 	}
 
-	logger = logger.With().
-		Str("github_event_action", event.GetAction()).
-		Str("app_name", appName).
-		Logger()
+	ignored, reason, err := h.ignored(ctx, ra)
+	if err != nil {
+		return err
+	}
+	if ignored {
+		return skip(zerolog.InfoLevel, fmt.Sprintf("skipping pull request ignored by %s", ignoreFileLocation), reason)
+	}
 
-	waitAndPropagate := func(appID, deploymentID string, ghDeploymentID int64) error {
-		d, err := h.waitForDeploymentTerminal(ctx, appID, deploymentID)
+	if cfg.Stacks.Mode == stackModeTop {
+		reason, err := h.deployTopOfStack(ctx, ra)
 		if err != nil {
-			return fmt.Errorf("failed to wait deployment to finish: %w", err)
+			return err
 		}
+		if reason != "" {
+			return skip(zerolog.InfoLevel, "skipping pull request below the top of its stack", reason)
+		}
+	}
 
-		if d.Phase != godo.DeploymentPhase_Active {
-			_, _, err = client.Repositories.CreateDeploymentStatus(ctx, repoOwner, repoName, ghDeploymentID, &github.DeploymentStatusRequest{
-				State:        ptr(deploymentStateError),
-				AutoInactive: ptr(true),
+	// deployed records the outcome of acting upon the event. Pre-flight failures are already
+	// commented on the pull request.
+	deployed := func(err error) error {
+		switch {
+		case err == nil:
+			h.skips.clear(repo.GetFullName(), event.GetNumber())
+			if cfg.Stacks.Mode == stackModeLink {
+				h.relinkStack(ctx, ra)
+			}
+		case errors.Is(err, ErrPreflightFailed):
+			h.skips.record(skippedPR{
+				Repo:        repo.GetFullName(),
+				PullRequest: event.GetNumber(),
+				Action:      event.GetAction(),
+				Reason:      err.Error(),
+				TrackingID:  deliveryID,
+				Time:        time.Now(),
 			})
+		case errors.Is(err, ErrDOQuotaExceeded):
+			if err := skip(zerolog.WarnLevel, "skipping pull request as a quota is exceeded", "a DigitalOcean quota is exceeded"); err != nil {
+				ra.logger.Err(err).Msg("failed to record skip")
+			}
+		case errors.Is(err, ErrBudgetExceeded):
+			// Queued review apps are created once others are torn down.
+			return skip(zerolog.InfoLevel, "queueing pull request as the budget is exhausted", err.Error())
+		}
+		return err
+	}
+
+	if cfg.Spec.Apps != "" {
+		for _, app := range removed {
+			if err := h.teardown(ctx, app, "its app spec was removed"); err != nil {
+				return err
+			}
+		}
+		if len(apps) == 0 {
+			h.recordAppSpecs(ctx, ra, nil)
+			return skip(zerolog.InfoLevel, "skipping pull request without app specs", fmt.Sprintf("no app spec found in %s", cfg.Spec.Apps))
+		}
+		specs := make([]string, 0, len(apps))
+		for _, app := range apps {
+			specs = append(specs, app.spec)
+		}
+		// The app specs are recorded before deploying them, so failed deployments are torn down, too.
+		h.recordAppSpecs(ctx, ra, specs)
+	}
+	var errs []error
+	for _, app := range apps {
+		errs = append(errs, h.act(ctx, &event, app, skip, deployed))
+	}
+	return errors.Join(errs...)
+}
+
+// act acts upon the given pull request event for the given one of the pull request's review apps,
+// once it's clear that it's meant to be deployed.
+func (h *PRHandler) act(ctx context.Context, event *github.PullRequestEvent, ra *reviewApp, skip func(zerolog.Level, string, string) error, deployed func(error) error) error {
+	cfg := ra.cfg
+	if event.GetAction() == actionSynchronize && !cfg.Task {
+		if (cfg.PartialRedeploys || cfg.Paths.configured()) && !cfg.TestMerge.Enabled {
+			affected, ok, err := h.affectedComponents(ctx, ra)
 			if err != nil {
-				return fmt.Errorf("failed to update deployment with failure: %w", err)
+				return err
+			}
+			if ok && len(affected) == 0 {
+				return skip(zerolog.InfoLevel, "skipping redeploy", "no component is affected by the changes")
+			}
+			if ok {
+				ra.logger.Info().Strs("components", affected).Msg("changes affect only some components")
+			}
+		}
+		return deployed(h.redeploy(ctx, ra, 0, "the PR was changed"))
+	}
+
+	if event.GetAction() == actionEdited {
+		if cfg.OnDemand {
+			deployment, _, err := h.liveDeployment(ctx, ra)
+			if err != nil {
+				return err
+			}
+			if deployment == nil {
+				return skip(zerolog.InfoLevel, "skipping pull request without review app", onDemandSkip)
 			}
+		}
+		// Updating the app applies the new directives. A new attempt per set of directives makes
+		// sure it isn't mistaken for the creation of the current commit's app.
+		ra.logger.Info().Msg("reconciling app with edited directives")
+		return deployed(h.create(ctx, ra, ra.directives.attempt()))
+	}
+
+	if h.isTierLabel(event.GetLabel().GetName()) {
+		deployment, _, err := h.liveDeployment(ctx, ra)
+		if err != nil {
+			return err
+		}
+		if deployment == nil && cfg.OnDemand {
+			return skip(zerolog.InfoLevel, "skipping pull request without review app", onDemandSkip)
+		}
+		// Updating the app applies the new tier. A new attempt per tier makes sure it isn't
+		// mistaken for the creation of the current commit's app.
+		tier := h.tierOf(ra)
+		ra.logger.Info().Str("tier", tier).Msg("reconciling app with changed tier")
+		return deployed(h.create(ctx, ra, tierAttempt(tier)))
+	}
+
+	if event.GetAction() == actionLabeled && !ra.fork {
+		ghDeployment, _, err := h.liveDeployment(ctx, ra)
+		if err != nil {
+			return err
+		}
+		if ghDeployment != nil {
+			// The app already exists, for example because the label was present when the PR was opened.
 			return nil
 		}
+	}
+
+	return deployed(h.create(ctx, ra, 0))
+}
+
+// reviewApp bundles everything needed to manage the review app of a single pull request.
+type reviewApp struct {
+	client *github.Client
+	cfg    ReviewAppConfig
+	logger zerolog.Logger
+
+	repo    *github.Repository
+	pr      *github.PullRequest
+	owner   string
+	name    string
+	number  int
+	branch  string
+	appName string
+	// namer names the apps of the repository's review apps.
+	namer Namer
+	// inlineSpec is the app spec supplied via the "/deploy" command, if any. It takes precedence
+	// over all other spec sources.
+	inlineSpec []byte
+	// forceBuild rebuilds all components when the review app is redeployed, instead of reusing
+	// their last build.
+	forceBuild bool
+	// ref is the ref the files of the pull request's repository are read at and GitHub
+	// deployments are created for. It is the pull request's branch unless the pull request is from
+	// a forked repository.
+	ref string
+	// sourceBranch is the branch the components of the pull request's repository are deployed
+	// from. It is the pull request's branch unless the test merge commit is deployed or the pull
+	// request is from a forked repository.
+	sourceBranch string
+	// fork is whether or not the pull request is from a forked repository, whose code is untrusted.
+	// Only its approved commit is deployed, from the fork's mirror branch.
+	fork bool
+	// directives are the directives in the pull request's description.
+	directives prDirectives
+	// repoCfg is the repository's configuration file, once read.
+	repoCfg *repoConfig
+	// specPath is the path of the committed app spec, once fetched.
+	specPath string
+	// spec is the name of the review app's app spec if the repository has several, see
+	// SpecConfig.Apps.
+	spec string
+}
 
-		app, err := h.waitForAppLiveURL(ctx, appID)
+// decide consults all PolicyDeciders about the given action on the given pull request. All of them
+// must allow it for it to be allowed.
+func (h *PRHandler) decide(ctx context.Context, action string, repo *github.Repository, pr *github.PullRequest) (PolicyDecision, error) {
+	policyReq := PolicyRequest{
+		Action:      action,
+		Repo:        repo.GetFullName(),
+		PullRequest: pr.GetNumber(),
+		Author:      pr.GetUser().GetLogin(),
+		Branch:      pr.GetHead().GetRef(),
+	}
+	for _, l := range pr.Labels {
+		policyReq.Labels = append(policyReq.Labels, l.GetName())
+	}
+	for _, d := range h.deciders {
+		decision, err := d.Decide(ctx, policyReq)
 		if err != nil {
-			return fmt.Errorf("failed to wait for app to have a live URL: %w", err)
+			return PolicyDecision{}, fmt.Errorf("failed to decide policy: %w", err)
+		}
+		if !decision.Allow {
+			return decision, nil
 		}
+	}
+	return PolicyDecision{Allow: true}, nil
+}
 
-		_, _, err = client.Repositories.CreateDeploymentStatus(ctx, repoOwner, repoName, ghDeploymentID, &github.DeploymentStatusRequest{
-			State:          ptr(deploymentStateSuccess),
-			EnvironmentURL: ptr(app.LiveURL),
-			AutoInactive:   ptr(true),
-		})
+// newReviewApp returns the reviewApp of the given pull request.
+func (h *PRHandler) newReviewApp(ctx context.Context, installationID int64, repo *github.Repository, pr *github.PullRequest, cfg ReviewAppConfig) (*reviewApp, error) {
+	client, err := h.cc.NewInstallationClient(installationID)
+	if err != nil {
+		return nil, githubError(err, "failed to create installation client")
+	}
+
+	fork := isFork(repo, pr)
+	ref := pr.GetHead().GetRef()
+	var directives prDirectives
+	if fork {
+		// The directives of forks are as untrusted as their code.
+		ref = forkBranch(pr.GetNumber())
+	} else {
+		directives, err = prDirectivesOf(cfg, pr)
 		if err != nil {
-			return fmt.Errorf("failed to update deployment: %w", err)
+			// Events are triaged with the same directives, so this only affects commands and refreshes.
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("ignoring invalid directives")
+		}
+	}
+
+	repoOwner := repo.GetOwner().GetLogin()
+	repoName := repo.GetName()
+	namer := h.namer(cfg)
+	return &reviewApp{
+		client: client,
+		cfg:    cfg,
+		logger: *zerolog.Ctx(ctx),
+
+		repo:         repo,
+		pr:           pr,
+		owner:        repoOwner,
+		name:         repoName,
+		number:       pr.GetNumber(),
+		branch:       pr.GetHead().GetRef(),
+		ref:          ref,
+		sourceBranch: ref,
+		fork:         fork,
+		appName:      namer.PullRequestAppName(repoOwner, repoName, pr.GetNumber()),
+		namer:        namer,
+		directives:   directives,
+	}, nil
+}
+
+// lifecycleEvent returns a LifecycleEvent of the given type for the review app.
+func (ra *reviewApp) lifecycleEvent(typ LifecycleEventType, appID, deploymentID, liveURL string) LifecycleEvent {
+	return LifecycleEvent{
+		Type:         typ,
+		Repo:         ra.repo.GetFullName(),
+		PullRequest:  ra.number,
+		AppName:      ra.appName,
+		AppID:        appID,
+		DeploymentID: deploymentID,
+		LiveURL:      liveURL,
+	}
+}
+
+// latestDeployment returns the latest GitHub deployment of the review app and its parsed payload.
+// It returns nil if there is none. The app recorded in the state store takes precedence over the
+// one in the payload, which might be missing or stale.
+func (h *PRHandler) latestDeployment(ctx context.Context, ra *reviewApp) (*github.Deployment, *deploymentPayload, error) {
+	deployments, _, err := ra.client.Repositories.ListDeployments(ctx, ra.owner, ra.name, &github.DeploymentsListOptions{
+		Environment: ra.appName,
+	})
+	if err != nil {
+		return nil, nil, githubError(err, "failed to list deployments")
+	}
+	if len(deployments) == 0 {
+		return nil, nil, nil
+	}
+	deployment := deployments[0]
+
+	appID := h.recordedApp(ctx, ra)
+	payload, err := parseDeploymentPayload(deployment.Payload)
+	if err != nil {
+		if appID == "" {
+			return nil, nil, errorf(ErrorKindGitHubAPI, "%w", err)
 		}
+		// The recorded app is all that's needed, so incompatible payloads don't keep review apps
+		// from being torn down.
+		ra.logger.Warn().Err(err).Int64("github_deployment_id", deployment.GetID()).Msg("ignoring unparseable deployment payload")
+		payload = &deploymentPayload{}
+	}
+	if appID != "" {
+		payload.AppID = appID
+	}
+	return deployment, payload, nil
+}
+
+// liveDeployment returns the latest GitHub deployment of the review app and its payload, unless
+// there is none or the review app has been torn down since.
+func (h *PRHandler) liveDeployment(ctx context.Context, ra *reviewApp) (*github.Deployment, *deploymentPayload, error) {
+	deployment, payload, err := h.latestDeployment(ctx, ra)
+	if err != nil || deployment == nil {
+		return nil, nil, err
+	}
+	status, err := latestDeploymentStatus(ctx, ra.client, ra.owner, ra.name, deployment.GetID())
+	if err != nil {
+		return nil, nil, err
+	}
+	if status.GetState() == deploymentStateInactive {
+		return nil, nil, nil
+	}
+	return deployment, payload, nil
+}
+
+// latestDeploymentStatus returns the latest status of the given GitHub deployment, or nil if it has
+// none yet.
+func latestDeploymentStatus(ctx context.Context, client *github.Client, owner, repo string, deploymentID int64) (*github.DeploymentStatus, error) {
+	statuses, _, err := client.Repositories.ListDeploymentStatuses(ctx, owner, repo, deploymentID, &github.ListOptions{PerPage: 1})
+	if err != nil {
+		return nil, githubError(err, "failed to list deployment statuses")
+	}
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+	return statuses[0], nil
+}
+
+// teardown deletes the review app for the given reason. Apps recorded in the state store are
+// deleted even if their GitHub deployments are gone.
+func (h *PRHandler) teardown(ctx context.Context, ra *reviewApp, reason string) error {
+	if legacy := ra.legacy(); legacy != nil {
+		// The review app might still run under the name of earlier versions.
+		if err := h.teardown(ctx, legacy, reason); err != nil {
+			return err
+		}
+	}
+	h.budget.unqueue(ra)
+	deployment, payload, err := h.latestDeployment(ctx, ra)
+	if err != nil {
+		return err
+	}
+	if deployment == nil {
+		payload = &deploymentPayload{AppID: h.recordedApp(ctx, ra)}
+	}
+	if payload.AppID == "" {
+		// No existing app, but its domain and databases might have been left behind.
+		h.forgetScaleUp(ctx, ra)
+		return errors.Join(h.cleanupDomain(ctx, ra), h.cleanupDatabases(ctx, ra))
+	}
+
+	ra.logger.Info().Msgf("deleting app as %s", reason)
+	deleted, dbErr := h.deleteReviewApp(ctx, ra, payload.AppID)
+	if !deleted {
+		return dbErr
+	}
+	h.notify(ctx, ra, ra.lifecycleEvent(LifecycleAppDeleted, payload.AppID, "", ""))
+
+	if ra.fork {
+		if err := deleteBranch(ctx, ra, ra.sourceBranch); err != nil {
+			return errors.Join(dbErr, err)
+		}
+	} else if ra.cfg.TestMerge.Enabled {
+		if err := h.deleteTestMerge(ctx, ra); err != nil {
+			return errors.Join(dbErr, err)
+		}
+	}
+
+	if deployment == nil {
+		return dbErr
+	}
+	_, _, err = ra.client.Repositories.CreateDeploymentStatus(ctx, ra.owner, ra.name, deployment.GetID(), &github.DeploymentStatusRequest{
+		State:        ptr(deploymentStateInactive),
+		AutoInactive: ptr(true),
+	})
+	if err != nil {
+		return errors.Join(dbErr, githubError(err, "failed to update deployment"))
+	}
+	return dbErr
+}
+
+// deleteReviewApp deletes the given app of the review app along with everything provisioned for
+// it, i.e. its domain, its ephemeral database clusters and its state, backing up its databases
+// first if configured. It returns whether or not the app was deleted, and an error if it wasn't or
+// its database clusters couldn't be deleted.
+func (h *PRHandler) deleteReviewApp(ctx context.Context, ra *reviewApp, appID string) (bool, error) {
+	if ra.cfg.BackupDatabases && h.backups != nil {
+		// Failing backups must not keep the app around forever.
+		if err := h.backups.Backup(ctx, appID, ra.appName); err != nil {
+			ra.logger.Error().Err(err).Msg("failed to back up databases before deleting app")
+		}
+	}
+
+	if err := h.deleteApp(ctx, ra, appID); err != nil {
+		return false, err
+	}
+	h.forgetApp(ctx, ra)
+	h.forgetScaleUp(ctx, ra)
+	if err := h.cleanupDomain(ctx, ra); err != nil {
+		// The reconciler sweeps left behind records, so they don't keep the teardown from finishing.
+		ra.logger.Error().Err(err).Msg("failed to delete DNS records and certificates of domain")
+	}
+	// Left behind database clusters keep costing money, so failing to delete them fails the
+	// teardown once it's otherwise done. Retries find no app and only clean up.
+	dbErr := h.cleanupDatabases(ctx, ra)
+	if h.gateway != nil {
+		h.gateway.forget(ra.storeKey())
+	}
+	return true, dbErr
+}
+
+// deleteApp deletes the given app of the review app and invalidates its preview URLs. The app's ID
+// comes from the review app's own deployment payload or state, but apps that aren't named like the
+// review app are refused, so a corrupted payload can't delete an unrelated app. Apps that lack the
+// ownership marker are only deleted if they predate it, i.e. if they carry no markers at all.
+func (h *PRHandler) deleteApp(ctx context.Context, ra *reviewApp, appID string) error {
+	app, resp, err := h.do.Apps.Get(ctx, appID)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			// A missing app has already been torn down, for example by a teardown label.
+			return nil
+		}
+		return doError(err, "failed to get app")
+	}
+
+	if !isOwned(app.GetSpec()) && app.GetSpec().GetName() == ra.appName && !hasMarkers(app.GetSpec()) {
+		ra.logger.Warn().Str("app_id", appID).Msg("deleting app of review app that predates the ownership marker")
+	} else if !isOwned(app.GetSpec()) || app.GetSpec().GetName() != ra.appName {
+		ra.logger.Error().Str("app_id", appID).Str("found_app_name", app.GetSpec().GetName()).Msg("refusing to delete app that isn't the review app")
+		deletionsRefusedTotal.Add(ra.repo.GetFullName(), 1)
+		return errorf(ErrorKindNotOwned, "refusing to delete app %s (%s): it isn't the review app %s", appID, app.GetSpec().GetName(), ra.appName)
+	}
+
+	resp, err = h.do.Apps.Delete(ctx, appID)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return doError(err, "failed to delete app")
+	}
+	h.invalidate(ctx, ra.logger, ra.repo.GetFullName(), ra.number, app)
+	h.budgetFreed()
+	return nil
+}
+
+// redeploy creates a new deployment of the existing review app for the given reason. The attempt
+// allows deliberately redeploying the same commit multiple times.
+func (h *PRHandler) redeploy(ctx context.Context, ra *reviewApp, attempt int64, reason string) error {
+	deployment, payload, err := h.liveDeployment(ctx, ra)
+	if err != nil {
+		return err
+	}
+	if deployment == nil {
+		// No existing review app, or it was torn down, e.g. by "/teardown". Nothing to do.
 		return nil
 	}
 
-	if event.GetAction() == actionClosed || event.GetAction() == actionSynchronize {
-		deployments, _, err := client.Repositories.ListDeployments(ctx, repoOwner, repoName, &github.DeploymentsListOptions{
-			Environment: appName,
-		})
+	key := idempotencyKey(ra.repo.GetFullName(), ra.number, ra.pr.GetHead().GetSHA(), attempt)
+	existing, _, err := findDeploymentByKey(ctx, ra.client, ra.owner, ra.name, ra.appName, ra.pr.GetHead().GetSHA(), key)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		// The app is reused for all pushes, so it must still exist if the deployment does.
+		ra.logger.Info().Msgf("resuming redeploy of app as %s", reason)
+		return h.resume(ctx, ra, payload.AppID, existing.GetID())
+	}
+
+	// Forks are redeployed at their approved commit, so they're never deployed with a new test
+	// merge commit.
+	if ra.cfg.TestMerge.Enabled && !ra.fork {
+		if ok, err := h.updateTestMerge(ctx, ra); err != nil || !ok {
+			return err
+		}
+	}
+
+	if ra.cfg.Drift.Revert {
+		app, drifted, err := h.drift(ctx, payload)
 		if err != nil {
-			return fmt.Errorf("failed to list deployments: %w", err)
+			return err
 		}
-		if len(deployments) == 0 {
-			// No existing deployments. Nothing to do.
-			return nil
+		if drifted {
+			return h.revertDrift(ctx, ra, app, key)
 		}
-		deployment := deployments[0]
+	}
 
-		var payload deploymentPayload
-		if err := json.Unmarshal(deployment.Payload, &payload); err != nil {
-			return fmt.Errorf("failed to parse deployment payload: %w", err)
+	changed, err := h.specChanged(ctx, ra, deployment)
+	if err != nil {
+		return err
+	}
+	if changed {
+		app, _, err := h.do.Apps.Get(ctx, payload.AppID)
+		if err != nil {
+			return doError(err, "failed to get app")
 		}
+		updated, err := h.updateSpec(ctx, ra, app)
+		if err != nil {
+			return err
+		}
+		// Updates that don't change the spec don't deploy the app.
+		if specHash(updated.GetSpec()) != specHash(app.GetSpec()) {
+			ra.logger.Info().Msgf("updating app as %s and changed its spec", reason)
+			return h.deployUpdated(ctx, ra, updated, key)
+		}
+	}
 
-		if event.GetAction() == actionClosed {
-			logger.Info().Msg("deleting app as the PR was closed")
-			_, err = h.do.Apps.Delete(ctx, payload.AppID)
-			if err != nil {
-				return fmt.Errorf("failed to delete app: %w", err)
-			}
+	ra.logger.Info().Msgf("redeploying app as %s", reason)
+	return h.deployAgain(ctx, ra, payload, key)
+}
 
-			_, _, err = client.Repositories.CreateDeploymentStatus(ctx, repoOwner, repoName, deployments[0].GetID(), &github.DeploymentStatusRequest{
-				State:        ptr(deploymentStateInactive),
-				AutoInactive: ptr(true),
-			})
-			if err != nil {
-				return fmt.Errorf("failed to update deployment: %w", err)
-			}
-		} else if event.GetAction() == actionSynchronize {
-			logger.Info().Msg("redeploying app after change")
-			// TODO: Should we figure out if the AppSpec changed and update? Should we just
-			// always use "UpdateApp"?
-			d, _, err := h.do.Apps.CreateDeployment(ctx, payload.AppID)
-			if err != nil {
-				return fmt.Errorf("failed to create deployment: %w", err)
-			}
+// resetDB creates a new deployment of the existing review app's recorded app for the given reason,
+// without rebuilding it or looking at its spec, which reruns all pre- and post-deploy jobs like
+// migrations and seeds. The attempt allows resetting the same commit multiple times.
+func (h *PRHandler) resetDB(ctx context.Context, ra *reviewApp, attempt int64, reason string) error {
+	deployment, payload, err := h.liveDeployment(ctx, ra)
+	if err != nil {
+		return err
+	}
+	if deployment == nil {
+		return nil
+	}
 
-			ghDeployment, _, err := client.Repositories.CreateDeployment(ctx, repoOwner, repoName, &github.DeploymentRequest{
-				Ref:              &prBranch,
-				AutoMerge:        ptr(false),
-				Environment:      ptr(appName),
-				RequiredContexts: ptr([]string{}),
-				Payload:          deploymentPayload{AppID: payload.AppID},
-			})
-			if err != nil {
-				return fmt.Errorf("failed to create deployment: %w", err)
-			}
+	key := idempotencyKey(ra.repo.GetFullName(), ra.number, ra.pr.GetHead().GetSHA(), attempt)
+	existing, _, err := findDeploymentByKey(ctx, ra.client, ra.owner, ra.name, ra.appName, ra.pr.GetHead().GetSHA(), key)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		ra.logger.Info().Msgf("resuming database reset of app as %s", reason)
+		return h.resume(ctx, ra, payload.AppID, existing.GetID())
+	}
 
-			if err := waitAndPropagate(payload.AppID, d.GetID(), ghDeployment.GetID()); err != nil {
-				return fmt.Errorf("failed to propagate deployment status: %w", err)
-			}
+	ra.logger.Info().Msgf("resetting database of app as %s", reason)
+	return h.deployAgain(ctx, ra, payload, key)
+}
+
+// deployAgain creates a new deployment of the recorded app of the review app, with its unchanged
+// spec, and waits for it.
+func (h *PRHandler) deployAgain(ctx context.Context, ra *reviewApp, payload *deploymentPayload, key string) error {
+	var (
+		d            *godo.Deployment
+		ghDeployment *github.Deployment
+	)
+	err := parallel(func() error {
+		var err error
+		d, _, err = h.do.Apps.CreateDeployment(ctx, payload.AppID, &godo.DeploymentCreateRequest{ForceBuild: ra.forceBuild})
+		if err != nil {
+			return doError(err, "failed to create deployment")
 		}
 		return nil
+	}, func() error {
+		var err error
+		// The spec is unchanged, so drift carries over to the new deployment.
+		ghDeployment, err = h.createGitHubDeployment(ctx, ra, deploymentPayload{AppID: payload.AppID, IdempotencyKey: key, SpecHash: payload.SpecHash})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := h.waitAndPropagate(ctx, ra, payload.AppID, d.GetID(), ghDeployment.GetID()); err != nil {
+		return fmt.Errorf("failed to propagate deployment status: %w", err)
+	}
+	return nil
+}
+
+// updateSpec updates the given app of the review app with its freshly fetched and prepared spec.
+func (h *PRHandler) updateSpec(ctx context.Context, ra *reviewApp, app *godo.App) (*godo.App, error) {
+	spec, err := h.fetchSpec(ctx, ra)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.prepareSpec(ctx, ra, spec); err != nil {
+		return nil, err
+	}
+	keepFallbackRegion(spec, app.GetSpec(), ra.cfg.FallbackRegions)
+
+	updated, _, err := h.do.Apps.Update(ctx, app.GetID(), &godo.AppUpdateRequest{Spec: spec})
+	if err != nil {
+		return nil, doSpecError(err, "failed to update app")
 	}
+	return updated, nil
+}
 
-	// Fetch the app spec from the respective branch.
-	appSpecFile, _, _, err := client.Repositories.GetContents(ctx, repoOwner, repoName, canonicalAppSpecLocation, &github.RepositoryContentGetOptions{
-		Ref: prBranch,
+// deployUpdated records the deployment an update of the given app started as the review app's
+// deployment and waits for it.
+func (h *PRHandler) deployUpdated(ctx context.Context, ra *reviewApp, app *godo.App, key string) error {
+	var (
+		ghDeployment *github.Deployment
+		ds           []*godo.Deployment
+	)
+	err := parallel(func() error {
+		var err error
+		ghDeployment, err = h.createGitHubDeployment(ctx, ra, deploymentPayload{AppID: app.GetID(), IdempotencyKey: key, SpecHash: specHash(app.GetSpec())})
+		return err
+	}, func() error {
+		var err error
+		ds, _, err = h.do.Apps.ListDeployments(ctx, app.GetID(), &godo.ListOptions{})
+		if err != nil {
+			return doError(err, "failed to list deployments")
+		}
+		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("failed to fetch app spec: %w", err)
+		return err
+	}
+	if len(ds) == 0 {
+		return errorf(ErrorKindDOAPI, "app %s has no deployments", app.GetID())
 	}
-	appSpec, err := appSpecFile.GetContent()
+
+	if err := h.waitAndPropagate(ctx, ra, app.GetID(), ds[0].GetID(), ghDeployment.GetID()); err != nil {
+		return fmt.Errorf("failed to propagate deployment status: %w", err)
+	}
+	return nil
+}
+
+// create creates the review app. The attempt allows deliberately creating the review app for the
+// same commit multiple times.
+func (h *PRHandler) create(ctx context.Context, ra *reviewApp, attempt int64) error {
+	key := idempotencyKey(ra.repo.GetFullName(), ra.number, ra.pr.GetHead().GetSHA(), attempt)
+	existing, existingPayload, err := findDeploymentByKey(ctx, ra.client, ra.owner, ra.name, ra.appName, ra.pr.GetHead().GetSHA(), key)
 	if err != nil {
-		return fmt.Errorf("failed to get app spec content: %w", err)
+		return err
+	}
+	if existing != nil {
+		// The app of a previous attempt might have been deleted since, for example if the PR was
+		// closed and reopened.
+		exists, err := appExists(ctx, h.do, existingPayload.AppID)
+		if err != nil {
+			return err
+		}
+		if exists && !ra.cfg.Task {
+			ra.logger.Info().Msg("resuming creation of app")
+			return h.resume(ctx, ra, existingPayload.AppID, existing.GetID())
+		}
+	}
+
+	if ra.fork {
+		// Creating the review app of a fork is its approval, so the head is what's approved.
+		if err := updateBranch(ctx, ra, ra.sourceBranch, ra.pr.GetHead().GetSHA()); err != nil {
+			return err
+		}
+	} else if ra.cfg.TestMerge.Enabled {
+		if ok, err := h.updateTestMerge(ctx, ra); err != nil || !ok {
+			return err
+		}
+	}
+
+	spec, err := h.fetchSpec(ctx, ra)
+	if err != nil {
+		return err
+	}
+	if err := h.prepareSpec(ctx, ra, spec); err != nil {
+		return err
+	}
+
+	// A previous attempt might have created the app but failed afterwards.
+	app, err := findAppByName(ctx, h.do, ra.appName)
+	if err != nil {
+		return err
+	}
+	if app != nil && !isReviewAppOf(app.GetSpec(), ra) {
+		ra.logger.Error().Str("app_id", app.GetID()).Msg("refusing to update app that isn't the review app")
+		return errorf(ErrorKindNotOwned, "refusing to update app %s (%s): it isn't the review app of %s#%d", app.GetID(), ra.appName, ra.repo.GetFullName(), ra.number)
+	}
+	if err := h.preflight(ctx, ra, spec, app); err != nil {
+		return err
 	}
+	if app == nil {
+		release, err := h.reserveBudget(ctx, ra)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	if app != nil {
+		keepFallbackRegion(spec, app.GetSpec(), ra.cfg.FallbackRegions)
+		ra.logger.Info().Str("app_id", app.GetID()).Msg("updating app created by a previous attempt")
+		app, _, err = h.do.Apps.Update(ctx, app.GetID(), &godo.AppUpdateRequest{
+			Spec: spec,
+		})
+		if err != nil {
+			return doSpecError(err, "failed to update app")
+		}
+	} else if poolAppID, ok := h.pool.Take(spec); ok {
+		ra.logger.Info().Str("app_id", poolAppID).Msg("creating new app from warm pool")
+		app, _, err = h.do.Apps.Update(ctx, poolAppID, &godo.AppUpdateRequest{
+			Spec: spec,
+		})
+		if err != nil {
+			return doSpecError(err, "failed to update pool app")
+		}
+	} else {
+		ra.logger.Info().Msg("creating new app")
+		app, err = h.createApp(ctx, ra, spec)
+		if err != nil {
+			return err
+		}
+	}
+	h.notify(ctx, ra, ra.lifecycleEvent(LifecycleAppCreated, app.GetID(), "", ""))
+
+	// Creating the GitHub deployment and fetching the app's initial deployment are independent.
+	var (
+		ghDeployment *github.Deployment
+		ds           []*godo.Deployment
+	)
+	err = parallel(func() error {
+		var err error
+		ghDeployment, err = h.createGitHubDeployment(ctx, ra, deploymentPayload{AppID: app.GetID(), IdempotencyKey: key, SpecHash: specHash(app.GetSpec())})
+		return err
+	}, func() error {
+		var err error
+		ds, _, err = h.do.Apps.ListDeployments(ctx, app.GetID(), &godo.ListOptions{})
+		if err != nil {
+			return doError(err, "failed to list deployments")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(ds) == 0 {
+		return errorf(ErrorKindDOAPI, "app %s has no deployments", app.GetID())
+	}
+
+	if ra.cfg.Task {
+		return h.runTask(ctx, ra, app.GetID(), ds[0].GetID(), ghDeployment.GetID())
+	}
+	if err := h.waitAndPropagate(ctx, ra, app.GetID(), ds[0].GetID(), ghDeployment.GetID()); err != nil {
+		return fmt.Errorf("failed to propagate deployment status: %w", err)
+	}
+	return nil
+}
+
+// fetchSpec fetches the app spec from the pull request's branch, or from the configured spec
+// source if the spec isn't committed to the repository.
+func (h *PRHandler) fetchSpec(ctx context.Context, ra *reviewApp) (*godo.AppSpec, error) {
+	var (
+		appSpec []byte
+		err     error
+	)
+	switch {
+	case ra.inlineSpec != nil:
+		appSpec = ra.inlineSpec
+	case len(ra.cfg.Spec.Command) > 0:
+		appSpec, err = generateSpec(ctx, ra)
+	case ra.cfg.Spec.Artifact.Workflow != "":
+		appSpec, err = artifactSpec(ctx, ra)
+	case ra.cfg.Spec.Submodule != "":
+		appSpec, err = submoduleSpec(ctx, ra)
+	default:
+		appSpec, err = h.committedSpec(ctx, ra)
+	}
+	if err != nil {
+		return nil, err
+	}
+
 	var spec godo.AppSpec
-	if err := yaml.Unmarshal([]byte(appSpec), &spec); err != nil {
-		return fmt.Errorf("failed to parse app spec: %w", err)
+	if err := yaml.Unmarshal(appSpec, &spec); err != nil {
+		return nil, errorf(ErrorKindSpecInvalid, "failed to parse app spec: %w", err)
 	}
+	addSpecSecrets(&spec)
+	h.lint(ctx, ra, appSpec, &spec)
+	return &spec, nil
+}
 
+// fileContent fetches the content of the file at the given path of the given repository. Missing
+// files are reported as ErrorKindSpecNotFound, as the file is usually the app spec.
+func fileContent(ctx context.Context, client *github.Client, owner, repo, path, ref string) ([]byte, error) {
+	file, _, _, err := client.Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{
+		Ref: ref,
+	})
+	if isGitHubNotFound(err) {
+		return nil, errorf(ErrorKindSpecNotFound, "no file found at %s: %w", path, err)
+	} else if err != nil {
+		return nil, githubError(err, fmt.Sprintf("failed to fetch %s", path))
+	}
+	if file == nil {
+		return nil, errorf(ErrorKindSpecNotFound, "no file found at %s: it's a directory", path)
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, errorf(ErrorKindSpecInvalid, "failed to get content of %s: %w", path, err)
+	}
+	return []byte(content), nil
+}
+
+// prepareSpec transforms the given app spec into the spec of the review app.
+func (h *PRHandler) prepareSpec(ctx context.Context, ra *reviewApp, spec *godo.AppSpec) error {
+	return h.transformSpec(ctx, ra, spec, false)
+}
+
+// transformSpec transforms the given app spec into the spec of the review app. With dryRun,
+// nothing is provisioned for the review app, so the spec can be validated before it's deployed.
+func (h *PRHandler) transformSpec(ctx context.Context, ra *reviewApp, spec *godo.AppSpec, dryRun bool) error {
 	// Override app name to something that identifies this PR.
-	spec.Name = appName
+	spec.Name = ra.appName
 
 	// Unset any domains as those might collide with production apps.
 	spec.Domains = nil
+	applyDomain(spec, ra)
 
 	// Unset any alerts as those will be delivered wrongly anyway.
 	spec.Alerts = nil
 
+	if ra.cfg.Task {
+		if err := pruneToTask(spec); err != nil {
+			return err
+		}
+	}
+
+	downscaleSpec(spec, ra.cfg.Downscale)
+	h.applyTier(spec, ra)
+	if err := h.applyDatabases(ctx, spec, ra, dryRun); err != nil {
+		return err
+	}
+
+	if ra.cfg.Bots.IsBot(ra.pr.GetUser().GetLogin()) && ra.cfg.Bots.GetPolicy() == botPolicySmall {
+		// Dependency updates rarely need more than the bare minimum.
+		downsizeSpec(spec, ra.cfg.Bots.GetInstanceSizeSlug())
+	}
+	// Scale-ups are requested explicitly, so they take precedence over all downsizing.
+	h.applyScaleUp(ctx, spec, ra)
+
+	sources := ra.cfg.Sources
+	if ra.fork {
+		stripped, err := sanitizeForkSpec(spec, ra.repo.GetFullName(), sources, ra.cfg.Forks.GetInstanceSizeSlug())
+		if err != nil {
+			return err
+		}
+		if len(stripped) > 0 {
+			ra.logger.Info().Strs("envs", stripped).Msg("stripping secrets from app spec of forked pull request")
+		}
+	} else if ra.cfg.Companions {
+		companions, err := companionSources(ctx, ra.client, ra.pr.GetBody())
+		if err != nil {
+			return fmt.Errorf("failed to resolve companion pull requests: %w", err)
+		}
+		// Companion pull requests take precedence over the configured sources.
+		sources = append(companions, sources...)
+	}
+
 	// Override the reference of all relevant components to point to the PRs ref.
-	var githubRefs []*godo.GitHubSourceSpec
-	for _, svc := range spec.GetServices() {
-		if svc.GetGitHub() != nil {
-			githubRefs = append(githubRefs, svc.GetGitHub())
+	rewriteGitHubSources(spec, ra.repo.GetFullName(), ra.sourceBranch, sources, ra.logger)
+
+	repoCfg, err := h.repoConfig(ctx, ra)
+	if err != nil {
+		return err
+	}
+	skipped, err := applyConfiguredEnv(spec, ra, h.config.Secrets)
+	if err != nil {
+		return err
+	}
+	if len(skipped) > 0 {
+		ra.logger.Info().Strs("envs", skipped).Msg("not setting secrets of forked pull request")
+	}
+	// Directives are more specific than the repository's configuration, so they're applied last.
+	prDirectives{Env: repoCfg.Env}.applyEnv(spec)
+	ra.directives.applyEnv(spec)
+	if ra.cfg.Annotate {
+		annotateSpec(spec, ra)
+	}
+	if stripped := applyFeatures(spec, ra.cfg.Features); len(stripped) > 0 {
+		ra.logger.Info().Strs("features", stripped).Msg("stripping features that aren't allowed")
+	}
+	if err := h.pinPlatform(ctx, spec, ra); err != nil {
+		return err
+	}
+	destinations, err := h.notificationDestinations(ctx, ra)
+	if err != nil {
+		return err
+	}
+	if h.doWebhooks != nil || hasAlertDestinations(destinations) {
+		addAlerts(spec)
+	}
+	if err := h.capInstances(ctx, spec, repoCfg); err != nil {
+		return err
+	}
+
+	for _, m := range h.mutators {
+		mutated, err := m.MutateSpec(ctx, SpecMutationRequest{Repo: ra.repo.GetFullName(), PullRequest: ra.number, Spec: spec})
+		if err != nil {
+			return fmt.Errorf("failed to mutate app spec: %w", err)
 		}
+		*spec = *mutated
+	}
+
+	// Mark the app as ours last, so mutators can't accidentally drop the markers.
+	markOwned(spec)
+	if ra.number != 0 {
+		markPullRequest(spec, ra.repo.GetFullName(), ra.number)
+	}
+	if ra.spec != "" {
+		markAppSpec(spec, ra.spec)
+	}
+	addSpecSecrets(spec)
+	return nil
+}
+
+// createGitHubDeployment creates a GitHub deployment of the pull request's branch with the given
+// payload, in the configured version of its schema.
+func (h *PRHandler) createGitHubDeployment(ctx context.Context, ra *reviewApp, payload deploymentPayload) (*github.Deployment, error) {
+	payload = payload.versioned(ra, ra.cfg.Deployments.GetPayloadVersion())
+	req, err := deploymentRequest(ra.cfg.Deployments, ra.ref, ra.appName, payload)
+	if err != nil {
+		return nil, err
+	}
+	ghDeployment, _, err := ra.client.Repositories.CreateDeployment(ctx, ra.owner, ra.name, req)
+	if err != nil {
+		return nil, githubError(err, "failed to create deployment")
+	}
+	if payload.AppID != "" {
+		h.recordApp(ctx, ra, payload.AppID)
+	}
+	return ghDeployment, nil
+}
+
+// deploymentRequest returns the request creating a GitHub deployment of the given ref in the given
+// environment, with the given payload extended by the configured fields.
+func deploymentRequest(cfg DeploymentsConfig, ref, environment string, payload any) (*github.DeploymentRequest, error) {
+	req := &github.DeploymentRequest{
+		Ref:         ptr(ref),
+		AutoMerge:   ptr(cfg.AutoMerge),
+		Environment: ptr(environment),
+		// An empty list skips all status checks, which usually haven't finished when review apps
+		// are deployed.
+		RequiredContexts: ptr(append([]string{}, cfg.RequiredContexts...)),
+		Payload:          payload,
+	}
+	if len(cfg.Payload) == 0 {
+		return req, nil
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode deployment payload: %w", err)
+	}
+	fields := make(map[string]any, len(cfg.Payload))
+	for k, v := range cfg.Payload {
+		fields[k] = v
 	}
-	for _, worker := range spec.GetWorkers() {
-		if worker.GetGitHub() != nil {
-			githubRefs = append(githubRefs, worker.GetGitHub())
+	// The fields of review apps take precedence, as they find their deployments by them.
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, fmt.Errorf("failed to decode deployment payload: %w", err)
+	}
+	req.Payload = fields
+	return req, nil
+}
+
+// resume waits for the latest deployment of the given app and propagates its status to the given
+// GitHub deployment, for operations that have already been done by a previous attempt.
+func (h *PRHandler) resume(ctx context.Context, ra *reviewApp, appID string, ghDeploymentID int64) error {
+	ds, _, err := h.do.Apps.ListDeployments(ctx, appID, &godo.ListOptions{})
+	if err != nil {
+		return doError(err, "failed to list deployments")
+	}
+	if len(ds) == 0 {
+		return errorf(ErrorKindDOAPI, "app %s has no deployments", appID)
+	}
+	if err := h.waitAndPropagate(ctx, ra, appID, ds[0].GetID(), ghDeploymentID); err != nil {
+		return fmt.Errorf("failed to propagate deployment status: %w", err)
+	}
+	return nil
+}
+
+// waitAndPropagate waits for the given deployment to finish and propagates its status to the
+// given GitHub deployment. Detached deployments are only marked as in progress; the Reconciler
+// propagates their status once they finished.
+func (h *PRHandler) waitAndPropagate(ctx context.Context, ra *reviewApp, appID, deploymentID string, ghDeploymentID int64) error {
+	h.notify(ctx, ra, ra.lifecycleEvent(LifecycleDeploymentStarted, appID, deploymentID, ""))
+
+	// Invalid destinations already failed preparing the spec.
+	if destinations, err := h.notificationDestinations(ctx, ra); err == nil {
+		if err := h.routeAlerts(ctx, appID, destinations); err != nil {
+			ra.logger.Warn().Err(err).Msg("failed to route alerts of app")
 		}
 	}
-	for _, job := range spec.GetJobs() {
-		if job.GetGitHub() != nil {
-			githubRefs = append(githubRefs, job.GetGitHub())
+
+	checkRunID := h.startCheckRun(ctx, ra, appID, deploymentID)
+
+	if ra.cfg.GetWait() == waitDetach {
+		ra.logger.Info().Str("deployment_id", deploymentID).Msg("detaching from deployment")
+		_, _, err := ra.client.Repositories.CreateDeploymentStatus(ctx, ra.owner, ra.name, ghDeploymentID, &github.DeploymentStatusRequest{
+			State:       ptr(deploymentStateInProgress),
+			LogURL:      ptr(deploymentDashboardURL(appID, deploymentID)),
+			Description: ptr("Deploying to App Platform"),
+		})
+		if err != nil {
+			return githubError(err, "failed to mark deployment as in progress")
 		}
+		return nil
 	}
-	for _, ref := range githubRefs {
-		if ref.Repo != fmt.Sprintf("%s/%s", repoOwner, repoName) {
-			// Skip Github refs pointing to other repos.
-			continue
+
+	if h.doWebhooks != nil {
+		// Polling still picks up the deployment's state if the alerts can't be subscribed to.
+		if err := h.subscribeAlerts(ctx, appID); err != nil {
+			ra.logger.Warn().Err(err).Msg("failed to subscribe to alerts of app")
 		}
-		// We manually kick new deployments so we can watch their status better.
-		ref.DeployOnPush = false
-		ref.Branch = prBranch
 	}
 
-	logger.Info().Msg("creating new app")
-	app, _, err := h.do.Apps.Create(ctx, &godo.AppCreateRequest{
-		Spec: &spec,
+	done := h.queue.startDeployment(queuedDeployment{
+		Repo:         ra.repo.GetFullName(),
+		PullRequest:  ra.number,
+		AppName:      ra.appName,
+		AppID:        appID,
+		DeploymentID: deploymentID,
+		Since:        time.Now().UTC(),
+	})
+	defer done()
+
+	waitCtx, cancel := supersedable(ctx)
+	defer cancel()
+	start := time.Now()
+	var observed godo.DeploymentPhase
+	d, err := h.waitForDeployment(waitCtx, ra.repo.GetFullName(), appID, deploymentID, func(phase godo.DeploymentPhase) {
+		if phase != observed {
+			observed = phase
+			h.progressCheckRun(ctx, ra, checkRunID, phase)
+		}
 	})
+	if errors.Is(context.Cause(waitCtx), errSuperseded) {
+		h.superseded(ctx, ra, checkRunID)
+		return nil
+	}
+	if errors.Is(err, ErrDeployTimeout) {
+		return h.timedOut(ctx, ra, appID, deploymentID, ghDeploymentID, time.Since(start), err)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create app: %w", err)
+		return fmt.Errorf("failed to wait deployment to finish: %w", err)
 	}
+	var app *godo.App
+	if d.Phase == godo.DeploymentPhase_Active {
+		app, err = h.waitForAppLiveURL(waitCtx, appID)
+		if errors.Is(context.Cause(waitCtx), errSuperseded) {
+			h.superseded(ctx, ra, checkRunID)
+			return nil
+		}
+		if errors.Is(err, ErrDeployTimeout) {
+			return h.failDeployment(ctx, ra, appID, d, ghDeploymentID, fmt.Sprintf("App got no live URL within %s", h.config.Polling.GetLiveURLTimeout()), err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to wait for app to have a live URL: %w", err)
+		}
+		d, err = h.waitForPostDeployJobs(waitCtx, ra, appID, d)
+		if errors.Is(context.Cause(waitCtx), errSuperseded) {
+			h.superseded(ctx, ra, checkRunID)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to wait for post-deploy jobs to finish: %w", err)
+		}
+	}
+	return h.propagate(ctx, ra, appID, d, app, ghDeploymentID)
+}
 
-	ghDeployment, _, err := client.Repositories.CreateDeployment(ctx, repoOwner, repoName, &github.DeploymentRequest{
-		Ref:              &prBranch,
-		AutoMerge:        ptr(false),
-		Environment:      ptr(appName),
-		RequiredContexts: ptr([]string{}),
-		Payload:          deploymentPayload{AppID: app.ID},
-	})
+// propagate propagates the status of the given finished deployment to the given GitHub deployment.
+// The app must have a live URL if the deployment succeeded.
+func (h *PRHandler) propagate(ctx context.Context, ra *reviewApp, appID string, d *godo.Deployment, app *godo.App, ghDeploymentID int64) error {
+	if build, delta, ok := recordDeployment(ra.repo.GetFullName(), d); ok {
+		ra.logger.Info().Dur("build_duration", build).Dur("build_duration_delta", delta).Msg("deployment finished")
+	} else {
+		ra.logger.Info().Dur("build_duration", build).Msg("deployment finished")
+	}
+
+	h.completeCheckRun(ctx, ra, appID, d, app)
+	if d.Phase == godo.DeploymentPhase_Active {
+		h.durations.record(ra.repo.GetFullName(), d.GetUpdatedAt().Sub(d.GetCreatedAt()))
+	}
+	if d.Phase != godo.DeploymentPhase_Active {
+		h.notify(ctx, ra, ra.lifecycleEvent(LifecycleDeploymentFailed, appID, d.GetID(), ""))
+		h.commentFailureLogs(ctx, ra, appID, d)
+
+		_, _, err := ra.client.Repositories.CreateDeploymentStatus(ctx, ra.owner, ra.name, ghDeploymentID, &github.DeploymentStatusRequest{
+			State:        ptr(deploymentStateError),
+			AutoInactive: ptr(true),
+		})
+		if err != nil {
+			return githubError(err, "failed to update deployment with failure")
+		}
+		return nil
+	}
+
+	// A live app whose migrations failed isn't a successful deployment.
+	state, typ := deploymentStateSuccess, LifecycleDeploymentSucceeded
+	failedJobs := failedPostDeployJobs(d)
+	if len(failedJobs) > 0 {
+		ra.logger.Warn().Strs("jobs", failedJobs).Msg("post-deploy jobs failed")
+		state, typ = deploymentStateFailure, LifecycleDeploymentFailed
+	}
+	status := &github.DeploymentStatusRequest{
+		State:          ptr(state),
+		EnvironmentURL: ptr(h.frontedURL(ra, app.LiveURL)),
+		AutoInactive:   ptr(true),
+	}
+	if description := postDeployJobsDescription(d, failedJobs); description != "" {
+		status.Description = ptr(description)
+		status.LogURL = ptr(deploymentDashboardURL(appID, d.GetID()))
+	}
+	_, _, err := ra.client.Repositories.CreateDeploymentStatus(ctx, ra.owner, ra.name, ghDeploymentID, status)
 	if err != nil {
-		return fmt.Errorf("failed to create deployment: %w", err)
+		return githubError(err, "failed to update deployment")
 	}
+	h.trackDomain(ctx, ra)
+	h.notify(ctx, ra, ra.lifecycleEvent(typ, appID, d.GetID(), app.LiveURL))
+	return nil
+}
 
-	ds, _, err := h.do.Apps.ListDeployments(ctx, app.GetID(), &godo.ListOptions{})
+// timedOut cancels the given deployment that didn't finish within the given duration and fails its
+// GitHub deployment, explaining why. It returns the given error of the timeout.
+func (h *PRHandler) timedOut(ctx context.Context, ra *reviewApp, appID, deploymentID string, ghDeploymentID int64, elapsed time.Duration, timeoutErr error) error {
+	ra.logger.Warn().Str("deployment_id", deploymentID).Dur("elapsed", elapsed).Msg("canceling deployment that didn't finish in time")
+	d, err := cancelDeployment(ctx, h.do, appID, deploymentID)
+	description := fmt.Sprintf("Deployment didn't finish within %s and was canceled", elapsed.Round(time.Second))
 	if err != nil {
-		return fmt.Errorf("failed to list deployments: %w", err)
+		ra.logger.Error().Err(err).Msg("failed to cancel deployment")
+		d = &godo.Deployment{ID: deploymentID, Phase: godo.DeploymentPhase_Unknown}
+		description = fmt.Sprintf("Deployment didn't finish within %s and failed to be canceled", elapsed.Round(time.Second))
 	}
+	return h.failDeployment(ctx, ra, appID, d, ghDeploymentID, description, timeoutErr)
+}
 
-	if err := waitAndPropagate(app.GetID(), ds[0].GetID(), ghDeployment.GetID()); err != nil {
-		return fmt.Errorf("failed to propagate deployment status: %w", err)
+// failDeployment fails the given GitHub deployment of the given deployment that didn't succeed in
+// time with the given description. It returns the given error of the failure.
+func (h *PRHandler) failDeployment(ctx context.Context, ra *reviewApp, appID string, d *godo.Deployment, ghDeploymentID int64, description string, failure error) error {
+	h.completeCheckRun(ctx, ra, appID, d, nil)
+	h.notify(ctx, ra, ra.lifecycleEvent(LifecycleDeploymentFailed, appID, d.GetID(), ""))
+	_, _, err := ra.client.Repositories.CreateDeploymentStatus(ctx, ra.owner, ra.name, ghDeploymentID, &github.DeploymentStatusRequest{
+		State:        ptr(deploymentStateError),
+		LogURL:       ptr(deploymentDashboardURL(appID, d.GetID())),
+		Description:  ptr(description),
+		AutoInactive: ptr(true),
+	})
+	if err != nil {
+		return errors.Join(failure, githubError(err, "failed to update deployment with failure"))
 	}
+	return failure
+}
 
-	return nil
+// cancelDeployment cancels the given deployment. godo doesn't wrap the endpoint yet.
+func cancelDeployment(ctx context.Context, do *godo.Client, appID, deploymentID string) (*godo.Deployment, error) {
+	req, err := do.NewRequest(ctx, http.MethodPost, fmt.Sprintf("/v2/apps/%s/deployments/%s/cancel", appID, deploymentID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	var root struct {
+		Deployment *godo.Deployment `json:"deployment"`
+	}
+	if _, err := do.Do(ctx, req, &root); err != nil {
+		return nil, doError(err, "failed to cancel deployment")
+	}
+	return root.Deployment, nil
+}
+
+// deploymentDashboardURL returns the URL of the given deployment in the control panel.
+func deploymentDashboardURL(appID, deploymentID string) string {
+	return fmt.Sprintf("https://cloud.digitalocean.com/apps/%s/deployments/%s", appID, deploymentID)
 }
 
 // waitForDeploymentTerminal waits for the given deployment to be in a terminal state.
 func (h *PRHandler) waitForDeploymentTerminal(ctx context.Context, appID, deploymentID string) (*godo.Deployment, error) {
-	t := time.NewTicker(2 * time.Second)
+	return h.waitForDeployment(ctx, "", appID, deploymentID, nil)
+}
+
+// waitForDeployment waits for the given deployment to reach a terminal phase like
+// waitForDeploymentTerminal, and calls the given function, if any, with every phase it observes.
+// Deployments of the given repository, if any, are polled on its schedule.
+func (h *PRHandler) waitForDeployment(ctx context.Context, repo, appID, deploymentID string, observe func(godo.DeploymentPhase)) (*godo.Deployment, error) {
+	schedule := h.pollSchedule(repo)
+	// Receiving never proceeds if there are no alerts.
+	var alerted <-chan struct{}
+	if h.doWebhooks != nil {
+		schedule = fixedPollSchedule(h.config.DOWebhooks.GetPollInterval())
+		ch, unsubscribe := h.doWebhooks.subscribe(appID)
+		defer unsubscribe()
+		alerted = ch
+	}
+	timeout := h.config.Polling.GetDeploymentTimeout()
+	if schedule.timeout > 0 {
+		timeout = schedule.timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
 	var d *godo.Deployment
 	for !isInTerminalPhase(d) {
 		var err error
 		d, _, err = h.do.Apps.GetDeployment(ctx, appID, deploymentID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get deployment: %w", err)
+			if ctx.Err() != nil {
+				return nil, waitError(ctx)
+			}
+			return nil, doError(err, "failed to get deployment")
+		}
+		if observe != nil {
+			observe(d.GetPhase())
+		}
+		if isInTerminalPhase(d) {
+			break
 		}
 
+		t := time.NewTimer(schedule.interval(time.Since(d.GetCreatedAt())))
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			t.Stop()
+			return nil, waitError(ctx)
 		case <-t.C:
+		case <-alerted:
+			t.Stop()
 		}
 	}
 	return d, nil
@@ -277,25 +1594,43 @@ func (h *PRHandler) waitForDeploymentTerminal(ctx context.Context, appID, deploy
 
 // waitForAppLiveURL waits for the given app to have a non-empty live URL.
 func (h *PRHandler) waitForAppLiveURL(ctx context.Context, appID string) (*godo.App, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.config.Polling.GetLiveURLTimeout())
+	defer cancel()
 	t := time.NewTicker(2 * time.Second)
+	defer t.Stop()
 
 	var a *godo.App
 	for a.GetLiveURL() == "" {
 		var err error
 		a, _, err = h.do.Apps.Get(ctx, appID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get deployment: %w", err)
+			if ctx.Err() != nil {
+				return nil, waitError(ctx)
+			}
+			return nil, doError(err, "failed to get app")
+		}
+		if a.GetLiveURL() != "" {
+			break
 		}
 
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return nil, waitError(ctx)
 		case <-t.C:
 		}
 	}
 	return a, nil
 }
 
+// waitError returns the error of the given done context, classifying exceeded deadlines as
+// deployment timeouts.
+func waitError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errorf(ErrorKindDeployTimeout, "timed out waiting for deployment: %w", ctx.Err())
+	}
+	return ctx.Err()
+}
+
 // isInTerminalPhase returns whether or not the given deployment is in a terminal phase.
 func isInTerminalPhase(d *godo.Deployment) bool {
 	switch d.GetPhase() {
@@ -305,6 +1640,37 @@ func isInTerminalPhase(d *godo.Deployment) bool {
 	return false
 }
 
+// hasAnyLabel returns whether or not the given pull request carries any of the labels with the
+// given names.
+func hasAnyLabel(pr *github.PullRequest, names []string) bool {
+	for _, name := range names {
+		if hasLabel(pr, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasLabel returns whether or not the given pull request carries a label with the given name.
+func hasLabel(pr *github.PullRequest, name string) bool {
+	for _, l := range pr.Labels {
+		if l.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns whether or not the given slice contains the given value.
+func contains[T comparable](s []T, v T) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
 func ptr[T any](v T) *T {
 	return &v
 }
@@ -0,0 +1,85 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/digitalocean/godo"
+)
+
+// appNamePattern matches valid App Platform app names.
+var appNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]{0,30}[a-z0-9]$`)
+
+// preflight checks the given prepared spec before the review app is created or updated to it. The
+// given app is the existing app of the review app, if any. All failed checks are reported as a
+// single comment on the pull request.
+func (h *PRHandler) preflight(ctx context.Context, ra *reviewApp, spec *godo.AppSpec, app *godo.App) error {
+	var failures []string
+	fail := func(format string, args ...interface{}) {
+		failures = append(failures, fmt.Sprintf(format, args...))
+	}
+
+	if len(spec.GetServices())+len(spec.GetStaticSites())+len(spec.GetWorkers())+len(spec.GetJobs())+len(spec.GetFunctions()) == 0 {
+		fail("The app spec has no deployable components.")
+	}
+
+	godo.ForEachAppSpecComponent(spec, func(c godo.AppBuildableComponentSpec) error {
+		if ref := c.GetGitHub(); ref != nil {
+			_, hasSource := findSource(ra.cfg.Sources, c.GetName(), ref.Repo)
+			if ref.Repo == ra.repo.GetFullName() && !hasSource && ref.Branch != ra.sourceBranch {
+				fail("Component `%s` is deployed from `%s` instead of the pull request's branch.", c.GetName(), ref.Branch)
+			}
+		}
+		if git := c.GetGit(); git != nil {
+			if m := githubRepoURL.FindStringSubmatch(git.RepoCloneURL); m != nil && strings.EqualFold(m[1]+"/"+m[2], ra.repo.GetFullName()) {
+				fail("Component `%s` uses a `git` source, which isn't deployed from the pull request's branch. Use a `github` source instead.", c.GetName())
+			}
+		}
+		return nil
+	})
+
+	if !appNamePattern.MatchString(spec.Name) {
+		fail("The app name `%s` is invalid. App names must be 2 to 32 lowercase alphanumeric characters or dashes, starting with a letter.", spec.Name)
+	}
+
+	proposeReq := &godo.AppProposeRequest{Spec: spec}
+	if app != nil {
+		proposeReq.AppID = app.GetID()
+	}
+	proposal, _, err := h.do.Apps.Propose(ctx, proposeReq)
+	if err != nil {
+		err = doSpecError(err, "invalid app spec")
+		if !errors.Is(err, ErrSpecInvalid) {
+			return err
+		}
+		fail("The app spec is invalid: %v", errors.Unwrap(err))
+	} else if app == nil && !proposal.AppNameAvailable {
+		fail("The app name `%s` is already taken.", spec.Name)
+	}
+
+	if limit := h.config.DigitalOcean.AppLimit; limit > 0 && app == nil {
+		apps, err := listApps(ctx, h.do)
+		if err != nil {
+			return err
+		}
+		if len(apps) >= limit {
+			fail("The account's limit of %d apps is reached.", limit)
+		}
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	// Apps of branches have no pull request to comment on.
+	if ra.number != 0 {
+		body := "### Review app pre-flight checks failed\n\n- " + strings.Join(failures, "\n- ")
+		if err := h.comment(ctx, ra, commentKindPreflight, body); err != nil {
+			return err
+		}
+	}
+	return errorf(ErrorKindPreflightFailed, "pre-flight checks failed: %s", strings.Join(failures, " "))
+}
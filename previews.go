@@ -0,0 +1,113 @@
+package reviewapps
+
+import (
+	"context"
+
+	"github.com/google/go-github/v60/github"
+	"github.com/palantir/go-githubapp/githubapp"
+)
+
+// openReviewApps returns the reviewApps of all open pull requests of all repositories the GitHub
+// App is installed on. Pull requests of forked repositories are skipped unless forks are enabled.
+func (h *PRHandler) openReviewApps(ctx context.Context) ([]*reviewApp, error) {
+	installationIDs, err := h.installationIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ras []*reviewApp
+	for _, installationID := range installationIDs {
+		installationRAs, err := h.installationReviewApps(ctx, installationID)
+		if err != nil {
+			return nil, err
+		}
+		ras = append(ras, installationRAs...)
+	}
+	return ras, nil
+}
+
+// installationIDs returns the IDs of all installations of the GitHub App.
+func (h *PRHandler) installationIDs(ctx context.Context) ([]int64, error) {
+	appClient, err := h.cc.NewAppClient()
+	if err != nil {
+		return nil, githubError(err, "failed to create app client")
+	}
+
+	var ids []int64
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		installations, resp, err := appClient.Apps.ListInstallations(ctx, opts)
+		if err != nil {
+			return nil, githubError(err, "failed to list installations")
+		}
+		for _, installation := range installations {
+			ids = append(ids, installation.GetID())
+		}
+		if resp.NextPage == 0 {
+			return ids, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// installationRepos returns all repositories of the installation of the given client.
+func installationRepos(ctx context.Context, client *github.Client) ([]*github.Repository, error) {
+	var repos []*github.Repository
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		list, resp, err := client.Apps.ListRepos(ctx, opts)
+		if err != nil {
+			return nil, githubError(err, "failed to list installation repositories")
+		}
+		repos = append(repos, list.Repositories...)
+		if resp.NextPage == 0 {
+			return repos, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// installationReviewApps returns the reviewApps of all open pull requests of all repositories of
+// the given installation.
+func (h *PRHandler) installationReviewApps(ctx context.Context, installationID int64) ([]*reviewApp, error) {
+	client, err := h.cc.NewInstallationClient(installationID)
+	if err != nil {
+		return nil, githubError(err, "failed to create installation client")
+	}
+	repos, err := installationRepos(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	var ras []*reviewApp
+	for _, repo := range repos {
+		cfg, err := h.config.ForRepo(repo.GetFullName())
+		if err != nil {
+			return nil, err
+		}
+
+		prOpts := &github.PullRequestListOptions{State: "open", ListOptions: github.ListOptions{PerPage: 100}}
+		for {
+			prs, resp, err := client.PullRequests.List(ctx, repo.GetOwner().GetLogin(), repo.GetName(), prOpts)
+			if err != nil {
+				return nil, githubError(err, "failed to list pull requests")
+			}
+			for _, pr := range prs {
+				if isFork(repo, pr) && !cfg.Forks.Enabled {
+					continue
+				}
+				prCtx, _ := githubapp.PreparePRContext(ctx, installationID, repo, pr.GetNumber())
+				ra, err := h.newReviewApp(prCtx, installationID, repo, pr, cfg)
+				if err != nil {
+					return nil, err
+				}
+				ras = append(ras, ra)
+			}
+			if resp.NextPage == 0 {
+				break
+			}
+			prOpts.Page = resp.NextPage
+		}
+	}
+	return ras, nil
+}
@@ -0,0 +1,187 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-github/v60/github"
+)
+
+// promotionPayload is the payload of the GitHub deployments recording promotions. It's compatible
+// with deploymentPayload, so retried promotions are found by their idempotency key.
+type promotionPayload struct {
+	AppID          string `json:"app_id"`
+	IdempotencyKey string `json:"idempotency_key"`
+	// SourceAppID and SourceDeploymentID identify the review app deployment whose spec was
+	// promoted.
+	SourceAppID        string `json:"source_app_id"`
+	SourceDeploymentID string `json:"source_deployment_id"`
+	PromotedBy         string `json:"promoted_by"`
+}
+
+// promotion is a validated "/promote" command.
+type promotion struct {
+	// sha is the commit the review app was deployed from.
+	sha    string
+	source *godo.App
+	target *godo.App
+	spec   *godo.AppSpec
+}
+
+// preparePromotion validates and policy-checks a "/promote" command. It returns nil and reports why
+// on the pull request if the review app must not be promoted.
+func (h *CommandHandler) preparePromotion(ctx context.Context, client *github.Client, event *github.IssueCommentEvent, ra *reviewApp) (*promotion, error) {
+	deny := func(msg string) (*promotion, error) {
+		ra.logger.Info().Msg(msg)
+		if err := h.comment(ctx, client, event, commandPromote, fmt.Sprintf("%s: %s.", commandPromote, msg)); err != nil {
+			return nil, err
+		}
+		return nil, h.react(ctx, client, event, reactionDenied)
+	}
+
+	if ra.cfg.Promotion.AppID == "" {
+		return deny("promotions are not configured for this repository")
+	}
+
+	decision, err := h.prs.decide(ctx, commandPromote, ra.repo, ra.pr)
+	if err != nil {
+		return nil, err
+	}
+	if !decision.Allow {
+		return deny(fmt.Sprintf("denied by policy: %s", decision.Reason))
+	}
+
+	ghDeployment, payload, err := h.prs.latestDeployment(ctx, ra)
+	if err != nil {
+		return nil, err
+	}
+	if ghDeployment == nil {
+		return deny("there is no review app to promote")
+	}
+	source, resp, err := h.prs.do.Apps.Get(ctx, payload.AppID)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return deny("there is no review app to promote")
+		}
+		return nil, doError(err, "failed to get review app")
+	}
+	// The active deployment is the latest successful one, so its spec has been proven to work.
+	if source.GetActiveDeployment().GetSpec() == nil {
+		return deny("the review app has no successful deployment")
+	}
+
+	target, _, err := h.prs.do.Apps.Get(ctx, ra.cfg.Promotion.AppID)
+	if err != nil {
+		return nil, doError(err, "failed to get app to promote to")
+	}
+
+	return &promotion{
+		sha:    ghDeployment.GetSHA(),
+		source: source,
+		target: target,
+		spec:   promotedSpec(source.GetActiveDeployment().GetSpec(), target.GetSpec()),
+	}, nil
+}
+
+// promotedSpec returns the given spec of a review app as it's applied to the given app. The parts
+// stripped from review apps are kept from the target's current spec.
+func promotedSpec(spec, target *godo.AppSpec) *godo.AppSpec {
+	promoted := *spec
+	promoted.Name = target.GetName()
+	promoted.Domains = target.Domains
+	promoted.Alerts = target.Alerts
+	// The target isn't managed by review apps and must not be mistaken for a review app.
+	unmarkOwned(&promoted)
+	return &promoted
+}
+
+// promote applies the spec of the review app to the configured app and records the promotion as a
+// GitHub deployment of the configured environment.
+func (h *CommandHandler) promote(ctx context.Context, ra *reviewApp, p *promotion, commenter string, attempt int64) error {
+	environment := ra.cfg.Promotion.GetEnvironment()
+	key := idempotencyKey(ra.repo.GetFullName(), ra.number, p.sha, attempt)
+	existing, _, err := findDeploymentByKey(ctx, ra.client, ra.owner, ra.name, environment, p.sha, key)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		// Promoting again would redeploy the target app for nothing.
+		ra.logger.Info().Msg("skipping promotion that has already been done")
+		return nil
+	}
+
+	req, err := deploymentRequest(ra.cfg.Deployments, p.sha, environment, promotionPayload{
+		AppID:              p.target.GetID(),
+		IdempotencyKey:     key,
+		SourceAppID:        p.source.GetID(),
+		SourceDeploymentID: p.source.GetActiveDeployment().GetID(),
+		PromotedBy:         commenter,
+	})
+	if err != nil {
+		return err
+	}
+	req.Description = ptr(fmt.Sprintf("Promoted from #%d by @%s", ra.number, commenter))
+	ghDeployment, _, err := ra.client.Repositories.CreateDeployment(ctx, ra.owner, ra.name, req)
+	if err != nil {
+		return githubError(err, "failed to create promotion deployment")
+	}
+
+	logger := ra.logger.With().
+		Str("target_app_id", p.target.GetID()).
+		Str("source_deployment_id", p.source.GetActiveDeployment().GetID()).
+		Str("promoted_by", commenter).
+		Logger()
+	logger.Info().Msgf("promoting review app to %s", environment)
+
+	failed := func(err error) error {
+		_, _, statusErr := ra.client.Repositories.CreateDeploymentStatus(ctx, ra.owner, ra.name, ghDeployment.GetID(), &github.DeploymentStatusRequest{
+			State: ptr(deploymentStateError),
+		})
+		if statusErr != nil {
+			return errors.Join(err, githubError(statusErr, "failed to update promotion deployment with failure"))
+		}
+		return err
+	}
+
+	if _, _, err := h.prs.do.Apps.Update(ctx, p.target.GetID(), &godo.AppUpdateRequest{Spec: p.spec}); err != nil {
+		return failed(doSpecError(err, "failed to update app to promote to"))
+	}
+	ds, _, err := h.prs.do.Apps.ListDeployments(ctx, p.target.GetID(), &godo.ListOptions{})
+	if err != nil {
+		return failed(doError(err, "failed to list deployments"))
+	}
+	if len(ds) == 0 {
+		return failed(errorf(ErrorKindDOAPI, "app %s has no deployments", p.target.GetID()))
+	}
+	h.prs.listeners.OnLifecycleEvent(ctx, LifecycleEvent{
+		Type:         LifecycleAppPromoted,
+		Repo:         ra.repo.GetFullName(),
+		PullRequest:  ra.number,
+		AppName:      p.target.GetSpec().GetName(),
+		AppID:        p.target.GetID(),
+		DeploymentID: ds[0].GetID(),
+	})
+
+	d, err := h.prs.waitForDeploymentTerminal(ctx, p.target.GetID(), ds[0].GetID())
+	if err != nil {
+		return failed(fmt.Errorf("failed to wait for promotion to finish: %w", err))
+	}
+	state := deploymentStateSuccess
+	if d.Phase != godo.DeploymentPhase_Active {
+		state = deploymentStateError
+	}
+	logger.Info().Str("phase", string(d.Phase)).Msg("promotion finished")
+
+	_, _, err = ra.client.Repositories.CreateDeploymentStatus(ctx, ra.owner, ra.name, ghDeployment.GetID(), &github.DeploymentStatusRequest{
+		State:          ptr(state),
+		EnvironmentURL: ptr(p.target.GetLiveURL()),
+		AutoInactive:   ptr(true),
+	})
+	if err != nil {
+		return githubError(err, "failed to update promotion deployment")
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package reviewapps
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.internal.digitalocean.com/mthoemmes/reviewapps/store"
+)
+
+// protection is why a review app is protected from being torn down when idle.
+type protection struct {
+	// By is the user who protected the review app, if known.
+	By     string     `json:"by,omitempty"`
+	Reason string     `json:"reason"`
+	Since  *time.Time `json:"since,omitempty"`
+}
+
+// protections records who protected review apps and why. The protection label is what protects a
+// review app, so restarts only lose the details of protections.
+type protections struct {
+	mu    sync.Mutex
+	byKey map[store.Key]protection
+}
+
+func newProtections() *protections {
+	return &protections{byKey: make(map[store.Key]protection)}
+}
+
+// protectionOf returns the protection of the review app, or nil if it isn't protected.
+func (h *PRHandler) protectionOf(ra *reviewApp) *protection {
+	label := ra.cfg.Protection.GetLabel()
+	h.protections.mu.Lock()
+	defer h.protections.mu.Unlock()
+	if !hasLabel(ra.pr, label) {
+		// The label was removed without "/unprotect".
+		delete(h.protections.byKey, ra.storeKey())
+		return nil
+	}
+	if p, ok := h.protections.byKey[ra.storeKey()]; ok {
+		return &p
+	}
+	return &protection{Reason: fmt.Sprintf("labeled %q", label)}
+}
+
+// protect protects the review app on behalf of the given user for the given reason by labeling its
+// pull request.
+func (h *PRHandler) protect(ctx context.Context, ra *reviewApp, user, reason string) error {
+	label := ra.cfg.Protection.GetLabel()
+	if _, _, err := ra.client.Issues.AddLabelsToIssue(ctx, ra.owner, ra.name, ra.number, []string{label}); err != nil {
+		return githubError(err, "failed to add protection label")
+	}
+	if reason == "" {
+		reason = "no reason given"
+	}
+	now := time.Now().UTC()
+	h.protections.mu.Lock()
+	h.protections.byKey[ra.storeKey()] = protection{By: user, Reason: reason, Since: &now}
+	h.protections.mu.Unlock()
+
+	ra.logger.Info().Str("user", user).Str("reason", reason).Msg("protecting review app")
+	body := fmt.Sprintf("The review app was protected by @%s: %s. It isn't torn down when idle until `%s` or the %q label is removed.", user, reason, commandUnprotect, label)
+	return h.comment(ctx, ra, commentKindProtection, body)
+}
+
+// unprotect removes the protection of the review app on behalf of the given user.
+func (h *PRHandler) unprotect(ctx context.Context, ra *reviewApp, user string) error {
+	resp, err := ra.client.Issues.RemoveLabelForIssue(ctx, ra.owner, ra.name, ra.number, ra.cfg.Protection.GetLabel())
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return githubError(err, "failed to remove protection label")
+	}
+	h.protections.mu.Lock()
+	delete(h.protections.byKey, ra.storeKey())
+	h.protections.mu.Unlock()
+
+	ra.logger.Info().Str("user", user).Msg("unprotecting review app")
+	return h.comment(ctx, ra, commentKindProtection, fmt.Sprintf("The review app is no longer protected, as requested by @%s.", user))
+}
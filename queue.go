@@ -0,0 +1,193 @@
+package reviewapps
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/palantir/go-githubapp/githubapp"
+)
+
+// queuedEvent is a webhook event that is being handled.
+type queuedEvent struct {
+	TrackingID  string    `json:"tracking_id"`
+	Event       string    `json:"event"`
+	Repo        string    `json:"repo,omitempty"`
+	PullRequest int       `json:"pull_request,omitempty"`
+	Since       time.Time `json:"since"`
+}
+
+// queuedDeployment is a deployment of a review app that is being waited for.
+type queuedDeployment struct {
+	Repo         string    `json:"repo"`
+	PullRequest  int       `json:"pull_request,omitempty"`
+	AppName      string    `json:"app_name"`
+	AppID        string    `json:"app_id"`
+	DeploymentID string    `json:"deployment_id"`
+	Since        time.Time `json:"since"`
+}
+
+// queuedRun is the next run of a scheduled job.
+type queuedRun struct {
+	Job  string    `json:"job"`
+	Next time.Time `json:"next"`
+}
+
+// queueResponse is the body of responses of the "/admin/queue" endpoint.
+type queueResponse struct {
+	GeneratedAt time.Time          `json:"generated_at"`
+	Events      []queuedEvent      `json:"events"`
+	Deployments []queuedDeployment `json:"deployments"`
+	Scheduled   []queuedRun        `json:"scheduled"`
+}
+
+// queue tracks the work in progress, i.e. the events being handled, the deployments being waited
+// for and the next runs of scheduled jobs, so operators can tell whether a review app is stuck or
+// just hasn't been gotten to yet without reading logs.
+type queue struct {
+	adminToken string
+
+	mu          sync.Mutex
+	nextID      int
+	events      map[int]queuedEvent
+	deployments map[int]queuedDeployment
+	schedules   map[string]*cronSchedule
+}
+
+func newQueue() *queue {
+	return &queue{
+		events:      make(map[int]queuedEvent),
+		deployments: make(map[int]queuedDeployment),
+		schedules:   make(map[string]*cronSchedule),
+	}
+}
+
+// startEvent records the given event as being handled until the returned function is called.
+func (q *queue) startEvent(event queuedEvent) func() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nextID++
+	id := q.nextID
+	q.events[id] = event
+	return func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		delete(q.events, id)
+	}
+}
+
+// startDeployment records the given deployment as being waited for until the returned function is
+// called.
+func (q *queue) startDeployment(deployment queuedDeployment) func() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nextID++
+	id := q.nextID
+	q.deployments[id] = deployment
+	return func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		delete(q.deployments, id)
+	}
+}
+
+// schedule records the schedule of the given job. Nil schedules are ignored.
+func (q *queue) schedule(job string, s *cronSchedule) {
+	if s == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.schedules[job] = s
+}
+
+// snapshot returns the work in progress at the given time, oldest first.
+func (q *queue) snapshot(now time.Time) queueResponse {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	resp := queueResponse{
+		GeneratedAt: now,
+		Events:      make([]queuedEvent, 0, len(q.events)),
+		Deployments: make([]queuedDeployment, 0, len(q.deployments)),
+		Scheduled:   make([]queuedRun, 0, len(q.schedules)),
+	}
+	for _, e := range q.events {
+		resp.Events = append(resp.Events, e)
+	}
+	sort.Slice(resp.Events, func(i, j int) bool { return resp.Events[i].Since.Before(resp.Events[j].Since) })
+	for _, d := range q.deployments {
+		resp.Deployments = append(resp.Deployments, d)
+	}
+	sort.Slice(resp.Deployments, func(i, j int) bool { return resp.Deployments[i].Since.Before(resp.Deployments[j].Since) })
+	for job, s := range q.schedules {
+		resp.Scheduled = append(resp.Scheduled, queuedRun{Job: job, Next: s.Next(now)})
+	}
+	sort.Slice(resp.Scheduled, func(i, j int) bool { return resp.Scheduled[i].Next.Before(resp.Scheduled[j].Next) })
+	return resp
+}
+
+// ServeHTTP responds with the work in progress as JSON. Requests must be GETs authorized with the
+// admin token as bearer token.
+func (q *queue) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, q.adminToken) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(q.snapshot(time.Now().UTC()))
+}
+
+// track wraps the given handlers to record the events they handle in the queue.
+func (q *queue) track(handlers []githubapp.EventHandler) []githubapp.EventHandler {
+	tracked := make([]githubapp.EventHandler, 0, len(handlers))
+	for _, h := range handlers {
+		tracked = append(tracked, &trackedHandler{EventHandler: h, queue: q})
+	}
+	return tracked
+}
+
+// trackedHandler records the events handled by the wrapped handler in the queue.
+type trackedHandler struct {
+	githubapp.EventHandler
+	queue *queue
+}
+
+// queuedPayload holds the fields of webhook payloads identifying the pull request they're about.
+type queuedPayload struct {
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Number int `json:"number"`
+	Issue  struct {
+		Number int `json:"number"`
+	} `json:"issue"`
+}
+
+func (h *trackedHandler) Handle(ctx context.Context, eventType, deliveryID string, payload []byte) error {
+	var p queuedPayload
+	// Events that can't be parsed are still tracked, just without their pull request.
+	_ = json.Unmarshal(payload, &p)
+	number := p.Number
+	if number == 0 {
+		number = p.Issue.Number
+	}
+
+	done := h.queue.startEvent(queuedEvent{
+		TrackingID:  deliveryID,
+		Event:       eventType,
+		Repo:        p.Repository.FullName,
+		PullRequest: number,
+		Since:       time.Now().UTC(),
+	})
+	defer done()
+	return h.EventHandler.Handle(ctx, eventType, deliveryID, payload)
+}
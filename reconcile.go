@@ -0,0 +1,146 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/rs/zerolog"
+)
+
+// Reconciler periodically propagates the status of detached deployments of review apps once they
+// finished, so slow builds don't tie up a goroutine each while they're waited for. It also sweeps
+// the DNS records and certificates left behind by review apps and reverts expired scale-ups.
+type Reconciler struct {
+	prs      *PRHandler
+	schedule *cronSchedule
+}
+
+// NewReconciler returns a new Reconciler for the given schedule.
+func NewReconciler(prs *PRHandler, schedule string) (*Reconciler, error) {
+	s, err := parseCron(schedule)
+	if err != nil {
+		return nil, err
+	}
+	return &Reconciler{prs: prs, schedule: s}, nil
+}
+
+// Run reconciles all review apps on the schedule until the context is done.
+func (rc *Reconciler) Run(ctx context.Context) {
+	logger := zerolog.Ctx(ctx).With().Str("component", "reconciler").Logger()
+	ctx = logger.WithContext(ctx)
+
+	for {
+		next := rc.schedule.Next(time.Now().UTC())
+		if next.IsZero() {
+			logger.Error().Msg("reconcile schedule never matches")
+			return
+		}
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		if !rc.prs.throttle.wait(ctx, "reconcile") {
+			return
+		}
+		if err := rc.reconcile(ctx); err != nil {
+			logger.Error().Err(err).Msg("failed to reconcile review apps")
+		}
+	}
+}
+
+// reconcile reconciles all review apps whose repository detaches from deployments, sweeps the DNS
+// records and certificates left behind by torn down review apps and reverts expired scale-ups.
+func (rc *Reconciler) reconcile(ctx context.Context) error {
+	ras, err := rc.prs.openReviewApps(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	if err := rc.sweepDomains(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to sweep domains: %w", err))
+	}
+	now := time.Now()
+	for _, ra := range ras {
+		if err := rc.revertScaleUp(ctx, ra, now); err != nil {
+			ra.logger.Error().Err(err).Msg("failed to revert scale-up of review app")
+			errs = append(errs, err)
+		}
+		if ra.cfg.GetWait() != waitDetach || ra.cfg.Task {
+			continue
+		}
+		if err := rc.reconcileOne(ctx, ra); err != nil {
+			ra.logger.Error().Err(err).Msg("failed to reconcile review app")
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reconcileOne propagates the status of the latest deployment of the given review app if it's still
+// in progress on GitHub but finished on App Platform.
+func (rc *Reconciler) reconcileOne(ctx context.Context, ra *reviewApp) error {
+	ghDeployment, payload, err := rc.prs.latestDeployment(ctx, ra)
+	if err != nil || ghDeployment == nil {
+		return err
+	}
+	status, err := latestDeploymentStatus(ctx, ra.client, ra.owner, ra.name, ghDeployment.GetID())
+	if err != nil {
+		return err
+	}
+	if status.GetState() != deploymentStateInProgress {
+		return nil
+	}
+
+	ds, resp, err := rc.prs.do.Apps.ListDeployments(ctx, payload.AppID, &godo.ListOptions{})
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			// Torn down while deploying.
+			return nil
+		}
+		return doError(err, "failed to list deployments")
+	}
+	if len(ds) == 0 {
+		return nil
+	}
+	d, _, err := rc.prs.do.Apps.GetDeployment(ctx, payload.AppID, ds[0].GetID())
+	if err != nil {
+		return doError(err, "failed to get deployment")
+	}
+	if !isInTerminalPhase(d) {
+		if elapsed := time.Since(d.GetCreatedAt()); elapsed > rc.prs.config.Polling.GetDeploymentTimeout() {
+			return rc.prs.timedOut(ctx, ra, payload.AppID, d.GetID(), ghDeployment.GetID(), elapsed, errorf(ErrorKindDeployTimeout, "detached deployment %s didn't finish within %s", d.GetID(), elapsed.Round(time.Second)))
+		}
+		return nil
+	}
+
+	var app *godo.App
+	if d.Phase == godo.DeploymentPhase_Active {
+		app, _, err = rc.prs.do.Apps.Get(ctx, payload.AppID)
+		if err != nil {
+			return doError(err, "failed to get app")
+		}
+		if app.GetLiveURL() == "" {
+			if timeout := rc.prs.config.Polling.GetLiveURLTimeout(); time.Since(d.GetUpdatedAt()) > timeout {
+				return rc.prs.failDeployment(ctx, ra, payload.AppID, d, ghDeployment.GetID(), fmt.Sprintf("App got no live URL within %s", timeout), errorf(ErrorKindDeployTimeout, "app got no live URL within %s", timeout))
+			}
+			// Picked up again on the next run.
+			return nil
+		}
+		if pendingPostDeployJobs(d) && time.Since(d.GetUpdatedAt()) <= rc.prs.config.Polling.GetJobsTimeout() {
+			// Picked up again on the next run. Jobs that didn't finish in time are reported as such.
+			return nil
+		}
+	}
+	ra.logger.Info().Str("app_id", payload.AppID).Str("deployment_id", d.GetID()).Msg("propagating status of detached deployment")
+	return rc.prs.propagate(ctx, ra, payload.AppID, d, app, ghDeployment.GetID())
+}
@@ -0,0 +1,211 @@
+package reviewapps
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/digitalocean/godo"
+	"github.com/palantir/go-githubapp/githubapp"
+)
+
+const (
+	// minSecretLength is the minimum length of redacted values, so short values like "true" don't
+	// get redacted everywhere.
+	minSecretLength = 8
+	// maxSpecSecrets is the amount of secrets of app specs remembered for redaction. Once exceeded,
+	// they're forgotten and re-learned as app specs are prepared again.
+	maxSpecSecrets = 4096
+)
+
+// secrets are the values redacted from logs and from everything posted to GitHub.
+var secrets = &secretSet{}
+
+// secretSet is a set of secret values to redact. Secrets of the configuration are kept forever,
+// secrets of app specs only up to a limit.
+type secretSet struct {
+	mu     sync.RWMutex
+	config map[string]bool
+	specs  map[string]bool
+	// replacer replaces all secrets, longest first, or is nil if it needs to be rebuilt.
+	replacer *strings.Replacer
+}
+
+// addConfig remembers the given secrets of the configuration.
+func (s *secretSet) addConfig(values ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.config == nil {
+		s.config = make(map[string]bool)
+	}
+	s.add(s.config, values)
+}
+
+// addSpec remembers the given secrets of an app spec.
+func (s *secretSet) addSpec(values ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.specs == nil || len(s.specs) >= maxSpecSecrets {
+		s.specs = make(map[string]bool)
+		s.replacer = nil
+	}
+	s.add(s.specs, values)
+}
+
+// add adds the given values to the given set, including their JSON encoding, as that's how they
+// show up in logs and request bodies. It must be called with the mutex held.
+func (s *secretSet) add(set map[string]bool, values []string) {
+	for _, v := range values {
+		if len(v) < minSecretLength || set[v] {
+			continue
+		}
+		set[v] = true
+		if encoded := jsonString(v); encoded != v {
+			set[encoded] = true
+		}
+		s.replacer = nil
+	}
+}
+
+// redact returns the given string with all secrets replaced.
+func (s *secretSet) redact(str string) string {
+	s.mu.RLock()
+	r := s.replacer
+	s.mu.RUnlock()
+	if r == nil {
+		s.mu.Lock()
+		if s.replacer == nil {
+			s.replacer = s.newReplacer()
+		}
+		r = s.replacer
+		s.mu.Unlock()
+	}
+	return r.Replace(str)
+}
+
+// newReplacer returns a replacer of all secrets. It must be called with the mutex held.
+func (s *secretSet) newReplacer() *strings.Replacer {
+	values := make([]string, 0, len(s.config)+len(s.specs))
+	for v := range s.config {
+		values = append(values, v)
+	}
+	for v := range s.specs {
+		values = append(values, v)
+	}
+	// The replacer prefers earlier matches, so longer secrets containing shorter ones win.
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	pairs := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		pairs = append(pairs, v, redacted)
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// jsonString returns the given string as encoded in a JSON string, without the quotes.
+func jsonString(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return s
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSuffix(b.String(), "\n"), `"`), `"`)
+}
+
+// addConfigSecrets remembers the secrets of the given configuration for redaction.
+func addConfigSecrets(c *Config) {
+	values := []string{
+		c.DigitalOcean.Token,
+		c.Github.App.WebhookSecret,
+		c.Github.App.PrivateKey,
+		c.Github.OAuth.ClientSecret,
+		c.Server.AdminToken,
+		c.Server.APIToken,
+		c.Encryption.Key,
+		c.DOWebhooks.Secret,
+		c.StateStore.Spaces.SecretKey,
+		c.Backups.Spaces.SecretKey,
+	}
+	for _, v := range c.Secrets {
+		values = append(values, v)
+	}
+	for _, e := range c.Invalidation.Endpoints {
+		for _, v := range e.Headers {
+			values = append(values, v)
+		}
+	}
+	// Private keys are also logged and posted line by line, for example in stack traces.
+	for _, line := range strings.Split(c.Github.App.PrivateKey, "\n") {
+		if !strings.HasPrefix(line, "-----") {
+			values = append(values, strings.TrimSpace(line))
+		}
+	}
+	secrets.addConfig(values...)
+}
+
+// addSpecSecrets remembers the values of the secret environment variables of the given app spec
+// for redaction.
+func addSpecSecrets(spec *godo.AppSpec) {
+	var values []string
+	add := func(envs []*godo.AppVariableDefinition) {
+		for _, env := range envs {
+			if env.Type == godo.AppVariableType_Secret {
+				values = append(values, env.Value)
+			}
+		}
+	}
+	add(spec.GetEnvs())
+	godo.ForEachAppSpecComponent(spec, func(c godo.AppBuildableComponentSpec) error {
+		add(c.GetEnvs())
+		return nil
+	})
+	secrets.addSpec(values...)
+}
+
+// redactingWriter redacts all secrets from what's written to the underlying writer.
+type redactingWriter struct {
+	w io.Writer
+}
+
+// RedactingWriter returns a writer redacting the DigitalOcean token, the other secrets of the
+// configuration and the values of secret environment variables of app specs from what's written
+// to the given writer, meant for wrapping the output of loggers. Loggers write whole lines at once,
+// so secrets aren't split across writes.
+func RedactingWriter(w io.Writer) io.Writer {
+	return &redactingWriter{w: w}
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, secrets.redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// githubRedaction returns a middleware redacting all secrets from the bodies of requests to the
+// GitHub API, so error messages and app specs posted in comments, check runs and deployment
+// statuses never leak them.
+func githubRedaction() githubapp.ClientMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Body == nil || req.Body == http.NoBody {
+				return next.RoundTrip(req)
+			}
+			b, err := io.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			body := []byte(secrets.redact(string(b)))
+			req = req.Clone(req.Context())
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+			req.ContentLength = int64(len(body))
+			return next.RoundTrip(req)
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v60/github"
+	"github.com/rs/zerolog"
+)
+
+// Refresher redeploys long-lived review apps on a schedule, optionally after merging the base
+// branch into the pull request's branch, so previews don't drift away from the base branch.
+type Refresher struct {
+	prs      *PRHandler
+	schedule *cronSchedule
+	// workers is the maximum amount of review apps refreshed concurrently.
+	workers int
+}
+
+// NewRefresher returns a new Refresher for the given schedule, refreshing up to the given amount
+// of review apps concurrently.
+func NewRefresher(prs *PRHandler, schedule string, workers int) (*Refresher, error) {
+	s, err := parseCron(schedule)
+	if err != nil {
+		return nil, err
+	}
+	if workers < 1 {
+		return nil, fmt.Errorf("refreshes need at least one worker, got %d", workers)
+	}
+	return &Refresher{prs: prs, schedule: s, workers: workers}, nil
+}
+
+// Run refreshes all review apps on the schedule until the context is done.
+func (r *Refresher) Run(ctx context.Context) {
+	logger := zerolog.Ctx(ctx).With().Str("component", "refresher").Logger()
+	ctx = logger.WithContext(ctx)
+
+	for {
+		next := r.schedule.Next(time.Now().UTC())
+		if next.IsZero() {
+			logger.Error().Msg("refresh schedule never matches")
+			return
+		}
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		if !r.prs.throttle.wait(ctx, "refresh") {
+			return
+		}
+		if err := r.refresh(ctx, next.Unix()); err != nil {
+			logger.Error().Err(err).Msg("failed to refresh review apps")
+		}
+	}
+}
+
+// refresh refreshes all review apps whose repository has refreshes enabled, up to the configured
+// amount of them at a time. The attempt distinguishes the redeploys of different runs of the same
+// commit.
+func (r *Refresher) refresh(ctx context.Context, attempt int64) error {
+	ras, err := r.prs.openReviewApps(ctx)
+	if err != nil {
+		return err
+	}
+
+	todo := make(chan *reviewApp)
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for i := 0; i < r.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ra := range todo {
+				if err := r.refreshOne(ctx, ra, attempt); err != nil {
+					ra.logger.Error().Err(err).Msg("failed to refresh review app")
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, ra := range ras {
+		if !ra.cfg.Refresh.Enabled || ra.cfg.Task || hasAnyLabel(ra.pr, ra.cfg.TeardownLabels) {
+			continue
+		}
+		todo <- ra
+	}
+	close(todo)
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// refreshOne refreshes the given review app if its latest deployment is old enough.
+func (r *Refresher) refreshOne(ctx context.Context, ra *reviewApp, attempt int64) error {
+	deployment, _, err := r.prs.latestDeployment(ctx, ra)
+	if err != nil {
+		return err
+	}
+	if deployment == nil || time.Since(deployment.GetCreatedAt().Time) < ra.cfg.Refresh.GetMinAge() {
+		return nil
+	}
+
+	if ra.cfg.Refresh.UpdateBranch {
+		comparison, _, err := ra.client.Repositories.CompareCommits(ctx, ra.owner, ra.name, ra.pr.GetBase().GetRef(), ra.pr.GetHead().GetSHA(), nil)
+		if err != nil {
+			return githubError(err, "failed to compare pull request with its base")
+		}
+		if comparison.GetBehindBy() > 0 {
+			ra.logger.Info().Msg("merging base branch into pull request to refresh app")
+			_, _, err := ra.client.PullRequests.UpdateBranch(ctx, ra.owner, ra.name, ra.number, &github.PullRequestBranchUpdateOptions{
+				ExpectedHeadSHA: ptr(ra.pr.GetHead().GetSHA()),
+			})
+			var accepted *github.AcceptedError
+			if err != nil && !errors.As(err, &accepted) {
+				return githubError(err, "failed to update pull request branch")
+			}
+			// The resulting push redeploys the review app.
+			return nil
+		}
+	}
+	ctx, done, _, err := r.prs.turns.take(ctx, ra.repo.GetFullName(), ra.number, turnQueued)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return r.prs.redeploy(ctx, ra, attempt, "it's refreshed on schedule")
+}
@@ -0,0 +1,59 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/digitalocean/godo"
+)
+
+// createApp creates the app of the given spec. If the spec's region lacks capacity, the app is
+// created in the first fallback region that has capacity instead, which is noted on the pull
+// request.
+func (h *PRHandler) createApp(ctx context.Context, ra *reviewApp, spec *godo.AppSpec) (*godo.App, error) {
+	requested := spec.Region
+	app, _, err := h.do.Apps.Create(ctx, &godo.AppCreateRequest{Spec: spec})
+	if err == nil {
+		return app, nil
+	}
+	err = doSpecError(err, "failed to create app")
+
+	for _, region := range ra.cfg.FallbackRegions {
+		if !errors.Is(err, ErrDORegionUnavailable) {
+			return nil, err
+		}
+		if region == spec.Region {
+			continue
+		}
+		ra.logger.Warn().Err(err).Str("region", region).Msg("retrying app creation in fallback region")
+		spec.Region = region
+		app, _, err = h.do.Apps.Create(ctx, &godo.AppCreateRequest{Spec: spec})
+		if err != nil {
+			err = doSpecError(err, "failed to create app")
+			continue
+		}
+
+		regionFallbacksTotal.Add(region, 1)
+		from := "the default region"
+		if requested != "" {
+			from = fmt.Sprintf("region `%s`", requested)
+		}
+		body := fmt.Sprintf("The review app is deployed in region `%s`, as %s lacks capacity.", region, from)
+		if err := h.comment(ctx, ra, commentKindRegion, body); err != nil {
+			// The app exists, so failing to explain its region mustn't fail its creation.
+			ra.logger.Error().Err(err).Msg("failed to comment on fallback region")
+		}
+		return app, nil
+	}
+	return nil, err
+}
+
+// keepFallbackRegion keeps the given existing app in the fallback region it was created in, so
+// updating it with the given spec doesn't move it back to the region that lacked capacity.
+func keepFallbackRegion(spec, existing *godo.AppSpec, fallbacks []string) {
+	if slices.Contains(fallbacks, existing.GetRegion()) {
+		spec.Region = existing.GetRegion()
+	}
+}
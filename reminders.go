@@ -0,0 +1,170 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// reminderCommentKind returns the kind of the given reminder about a pull request waiting for
+// approval since the given time. Every reminder is a comment of its own, as updated comments don't
+// notify anyone.
+func reminderCommentKind(since time.Time, n int) commentKind {
+	return commentKind(fmt.Sprintf("reminder-%d-%d", since.Unix(), n))
+}
+
+// escalationCommentKind returns the kind of the escalation of a pull request waiting for approval
+// since the given time.
+func escalationCommentKind(since time.Time) commentKind {
+	return commentKind(fmt.Sprintf("escalation-%d", since.Unix()))
+}
+
+// Reminder reminds about pull requests waiting for approval on a schedule and escalates them to the
+// configured users and teams if they wait for too long, so they don't silently stall without a
+// review app.
+type Reminder struct {
+	prs      *PRHandler
+	schedule *cronSchedule
+
+	// reminded are the reminders of the pull requests by skip key. They're only tracked in memory,
+	// like the skips they're about.
+	reminded map[string]reminderState
+}
+
+// reminderState records the reminders about a pull request waiting for approval.
+type reminderState struct {
+	// since is when the pull request started waiting for approval.
+	since     time.Time
+	reminders int
+	escalated bool
+}
+
+// NewReminder returns a new Reminder for the given schedule.
+func NewReminder(prs *PRHandler, schedule string) (*Reminder, error) {
+	s, err := parseCron(schedule)
+	if err != nil {
+		return nil, err
+	}
+	return &Reminder{prs: prs, schedule: s, reminded: make(map[string]reminderState)}, nil
+}
+
+// Run reminds about pull requests waiting for approval on the schedule until the context is done.
+func (r *Reminder) Run(ctx context.Context) {
+	logger := zerolog.Ctx(ctx).With().Str("component", "reminder").Logger()
+	ctx = logger.WithContext(ctx)
+
+	for {
+		next := r.schedule.Next(time.Now().UTC())
+		if next.IsZero() {
+			logger.Error().Msg("reminder schedule never matches")
+			return
+		}
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		if err := r.remind(ctx, time.Now()); err != nil {
+			logger.Error().Err(err).Msg("failed to remind about pull requests waiting for approval")
+		}
+	}
+}
+
+// remind reminds about and escalates all pull requests that are due at the given time.
+func (r *Reminder) remind(ctx context.Context, now time.Time) error {
+	skips := r.prs.skips.awaitingApproval()
+
+	waiting := make(map[string]bool, len(skips))
+	for _, skip := range skips {
+		waiting[skipKey(skip.Repo, skip.PullRequest)] = true
+	}
+	for key := range r.reminded {
+		// Pull requests that were approved or closed in the meantime are forgotten.
+		if !waiting[key] {
+			delete(r.reminded, key)
+		}
+	}
+
+	var errs []error
+	for _, skip := range skips {
+		if err := r.remindOne(ctx, skip, now); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Str("repo", skip.Repo).Int("pull_request", skip.PullRequest).Msg("failed to remind about pull request waiting for approval")
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// remindOne reminds about or escalates the given pull request waiting for approval, if it's due at
+// the given time.
+func (r *Reminder) remindOne(ctx context.Context, skip skippedPR, now time.Time) error {
+	cfg, err := r.prs.config.ForRepo(skip.Repo)
+	if err != nil {
+		return fmt.Errorf("failed to get review app configuration: %w", err)
+	}
+	c := cfg.Forks.Reminders
+
+	key := skipKey(skip.Repo, skip.PullRequest)
+	state := r.reminded[key]
+	if !state.since.Equal(skip.since) {
+		state = reminderState{since: skip.since}
+	}
+
+	waited := now.Sub(skip.since)
+	escalate := c.EscalateAfter > 0 && waited >= c.EscalateAfter && !state.escalated
+	remind := c.Interval > 0 && int(waited/c.Interval) > state.reminders
+	if !escalate && !remind {
+		return nil
+	}
+
+	client, err := r.prs.cc.NewInstallationClient(skip.installationID)
+	if err != nil {
+		return githubError(err, "failed to create installation client")
+	}
+	owner, name, _ := strings.Cut(skip.Repo, "/")
+	pr, _, err := client.PullRequests.Get(ctx, owner, name, skip.PullRequest)
+	if err != nil {
+		return githubError(err, "failed to get pull request")
+	}
+	if pr.GetState() != "open" {
+		r.prs.skips.clear(skip.Repo, skip.PullRequest)
+		delete(r.reminded, key)
+		return nil
+	}
+
+	logger := zerolog.Ctx(ctx).With().Str("repo", skip.Repo).Int("pull_request", skip.PullRequest).Dur("waited", waited).Logger()
+	target := commentTarget{owner: owner, name: name, number: skip.PullRequest}
+	waiting := fmt.Sprintf("The review app has been waiting for approval for %s, as %s.", waited.Round(time.Minute), skip.Reason)
+	if escalate {
+		mentions := make([]string, 0, len(c.EscalateTo))
+		for _, m := range c.EscalateTo {
+			mentions = append(mentions, "@"+m)
+		}
+		logger.Info().Strs("escalate_to", c.EscalateTo).Msg("escalating pull request waiting for approval")
+		body := fmt.Sprintf("%s %s, please review the pull request.", waiting, strings.Join(mentions, " "))
+		if err := r.prs.comments.comment(ctx, client, target, escalationCommentKind(state.since), body); err != nil {
+			return err
+		}
+		state.escalated = true
+	} else {
+		logger.Info().Msg("reminding about pull request waiting for approval")
+		if err := r.prs.comments.comment(ctx, client, target, reminderCommentKind(state.since, state.reminders+1), waiting); err != nil {
+			return err
+		}
+	}
+	// Escalations count as reminders, so they aren't followed by one right away.
+	if c.Interval > 0 {
+		state.reminders = int(waited / c.Interval)
+	}
+
+	r.reminded[key] = state
+	return nil
+}
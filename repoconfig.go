@@ -0,0 +1,225 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// repoConfigLocation is the location of the file configuring review apps from within the
+	// repository.
+	repoConfigLocation = ".do/reviewapps.yaml"
+
+	// orgConfigRepo and orgConfigLocation are the repository of an organization and the location
+	// within it of the file providing the defaults of the configurations of all of the
+	// organization's repositories.
+	orgConfigRepo     = ".github"
+	orgConfigLocation = ".github/reviewapps.yml"
+)
+
+// repoConfigDisabledReason is why pull requests of repositories whose configuration disables review
+// apps are skipped.
+var repoConfigDisabledReason = fmt.Sprintf("review apps are disabled by %s", repoConfigLocation)
+
+// repoConfig configures the review apps of a repository from within the repository itself, so teams
+// can tune them without changing the service's configuration. It's read from the pull request's
+// branch, like the app spec.
+type repoConfig struct {
+	// Enabled disables review apps of the repository if set to false.
+	Enabled *bool `yaml:"enabled"`
+	// Spec is the location of the app spec in the repository. Defaults to the configured spec
+	// locations.
+	Spec string `yaml:"spec"`
+	// Env overrides environment variables of the app and all of its components. Directives in
+	// pull request descriptions take precedence.
+	Env map[string]string `yaml:"env"`
+	// MaxInstanceSizeSlug caps the instance size of all components. Components with more
+	// expensive sizes are downsized to it.
+	MaxInstanceSizeSlug string `yaml:"max_instance_size_slug"`
+	// MaxInstanceCount caps the instance count of all components.
+	MaxInstanceCount int64 `yaml:"max_instance_count"`
+	// Notifications are the names of the service's notification destinations the notifications
+	// about the repository's review apps are routed to.
+	Notifications []string `yaml:"notifications"`
+	// TTL tears down review apps that haven't been deployed for this long, e.g. "72h". Expired
+	// review apps are only torn down if the service has an expiry schedule.
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// disabled returns whether or not the configuration disables review apps.
+func (c *repoConfig) disabled() bool {
+	return c.Enabled != nil && !*c.Enabled
+}
+
+// parseRepoConfig parses the given content of the configuration file at the given location.
+func parseRepoConfig(content []byte, location string) (*repoConfig, error) {
+	var c repoConfig
+	if err := yaml.UnmarshalStrict(content, &c); err != nil {
+		return nil, errorf(ErrorKindSpecInvalid, "failed to parse %s: %w", location, err)
+	}
+	if c.Spec != "" && (path.IsAbs(c.Spec) || strings.HasPrefix(path.Clean(c.Spec), "..")) {
+		return nil, errorf(ErrorKindSpecInvalid, "invalid spec location %q in %s", c.Spec, location)
+	}
+	if c.MaxInstanceCount < 0 {
+		return nil, errorf(ErrorKindSpecInvalid, "max_instance_count in %s must not be negative", location)
+	}
+	if c.TTL < 0 {
+		return nil, errorf(ErrorKindSpecInvalid, "ttl in %s must not be negative", location)
+	}
+	return &c, nil
+}
+
+// inherit fills the fields the configuration doesn't set with the given defaults. Environment
+// variables are merged by key, with the configuration's own values taking precedence.
+func (c *repoConfig) inherit(defaults *repoConfig) {
+	if c.Enabled == nil {
+		c.Enabled = defaults.Enabled
+	}
+	if c.Spec == "" {
+		c.Spec = defaults.Spec
+	}
+	if len(defaults.Env) > 0 {
+		env := make(map[string]string, len(defaults.Env)+len(c.Env))
+		for k, v := range defaults.Env {
+			env[k] = v
+		}
+		for k, v := range c.Env {
+			env[k] = v
+		}
+		c.Env = env
+	}
+	if c.MaxInstanceSizeSlug == "" {
+		c.MaxInstanceSizeSlug = defaults.MaxInstanceSizeSlug
+	}
+	if c.MaxInstanceCount == 0 {
+		c.MaxInstanceCount = defaults.MaxInstanceCount
+	}
+	if c.Notifications == nil {
+		c.Notifications = defaults.Notifications
+	}
+	if c.TTL == 0 {
+		c.TTL = defaults.TTL
+	}
+}
+
+// repoConfig returns the configuration of the review app's repository at the pull request's
+// branch, which inherits the defaults of the organization's configuration. Repositories without a
+// configuration file or the feature rolled out get just the defaults or an empty one. So do pull
+// requests of forked repositories, whose configuration is as untrusted as their code.
+func (h *PRHandler) repoConfig(ctx context.Context, ra *reviewApp) (*repoConfig, error) {
+	if ra.repoCfg != nil {
+		return ra.repoCfg, nil
+	}
+	if !ra.cfg.rolledOut(featureRepoConfig) {
+		ra.repoCfg = &repoConfig{}
+		return ra.repoCfg, nil
+	}
+
+	defaults, err := h.orgConfig(ctx, ra)
+	if err != nil {
+		return nil, err
+	}
+	c := &repoConfig{}
+	if !ra.fork {
+		content, err := fileContent(ctx, ra.client, ra.owner, ra.name, repoConfigLocation, ra.ref)
+		if err != nil && !errors.Is(err, ErrSpecNotFound) {
+			return nil, err
+		} else if err == nil {
+			if c, err = parseRepoConfig(content, repoConfigLocation); err != nil {
+				return nil, err
+			}
+		}
+	}
+	c.inherit(defaults)
+	ra.repoCfg = c
+	return c, nil
+}
+
+// orgConfig returns the defaults of the configurations of the review app's organization, read from
+// the default branch of its ".github" repository, so large organizations don't have to duplicate
+// them across all repositories. Organizations without the file, or whose repository the GitHub
+// App can't access, have no defaults.
+func (h *PRHandler) orgConfig(ctx context.Context, ra *reviewApp) (*repoConfig, error) {
+	if ra.name == orgConfigRepo {
+		// The organization's repository is configured by its own file only.
+		return &repoConfig{}, nil
+	}
+	content, err := fileContent(ctx, ra.client, ra.owner, orgConfigRepo, orgConfigLocation, "")
+	if errors.Is(err, ErrSpecNotFound) {
+		return &repoConfig{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return parseRepoConfig(content, ra.owner+"/"+orgConfigRepo+"/"+orgConfigLocation)
+}
+
+// capInstances caps the instance sizes and counts of all components of the given spec as
+// configured. Instance sizes are compared by their price.
+func (h *PRHandler) capInstances(ctx context.Context, spec *godo.AppSpec, c *repoConfig) error {
+	if c.MaxInstanceSizeSlug == "" && c.MaxInstanceCount == 0 {
+		return nil
+	}
+
+	var prices map[string]float64
+	if c.MaxInstanceSizeSlug != "" {
+		sizes, _, err := h.do.Apps.ListInstanceSizes(ctx)
+		if err != nil {
+			return doError(err, "failed to list instance sizes")
+		}
+		prices = make(map[string]float64, len(sizes))
+		for _, size := range sizes {
+			price, err := strconv.ParseFloat(size.USDPerMonth, 64)
+			if err != nil {
+				return errorf(ErrorKindDOAPI, "invalid price %q of instance size %s: %w", size.USDPerMonth, size.Slug, err)
+			}
+			prices[size.Slug] = price
+		}
+		if _, ok := prices[c.MaxInstanceSizeSlug]; !ok {
+			return errorf(ErrorKindSpecInvalid, "unknown max_instance_size_slug %q in %s", c.MaxInstanceSizeSlug, repoConfigLocation)
+		}
+	}
+
+	capSize := func(slug *string) {
+		if c.MaxInstanceSizeSlug == "" {
+			return
+		}
+		// Components without a size get the cheapest one, which is never above the cap.
+		if price, ok := prices[*slug]; *slug != "" && (!ok || price > prices[c.MaxInstanceSizeSlug]) {
+			*slug = c.MaxInstanceSizeSlug
+		}
+	}
+	capCount := func(count *int64) {
+		if c.MaxInstanceCount > 0 && *count > c.MaxInstanceCount {
+			*count = c.MaxInstanceCount
+		}
+	}
+	for _, svc := range spec.Services {
+		capSize(&svc.InstanceSizeSlug)
+		capCount(&svc.InstanceCount)
+		if svc.Autoscaling != nil {
+			capCount(&svc.Autoscaling.MaxInstanceCount)
+			capCount(&svc.Autoscaling.MinInstanceCount)
+		}
+	}
+	for _, worker := range spec.Workers {
+		capSize(&worker.InstanceSizeSlug)
+		capCount(&worker.InstanceCount)
+		if worker.Autoscaling != nil {
+			capCount(&worker.Autoscaling.MaxInstanceCount)
+			capCount(&worker.Autoscaling.MinInstanceCount)
+		}
+	}
+	for _, job := range spec.Jobs {
+		capSize(&job.InstanceSizeSlug)
+		capCount(&job.InstanceCount)
+	}
+	return nil
+}
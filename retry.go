@@ -0,0 +1,159 @@
+package reviewapps
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/rs/zerolog"
+)
+
+// retryableStatuses are the statuses of transient failures of the GitHub and DigitalOcean APIs.
+var retryableStatuses = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// retryTransport retries requests to the GitHub and DigitalOcean APIs that failed transiently with
+// exponential backoff and jitter, waiting for rate limits to reset if they're due soon enough.
+// Requests that might have been processed, like a POST answered with a 502, are only retried if
+// they're idempotent, so retries never create duplicates.
+type retryTransport struct {
+	next   http.RoundTripper
+	config RetryConfig
+	// api names the API in logs and metrics, i.e. "github" or "digitalocean".
+	api string
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newRetryTransport(next http.RoundTripper, config RetryConfig, api string) *retryTransport {
+	return &retryTransport{next: next, config: config, api: api, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// githubRetries returns a middleware retrying transient failures of requests to the GitHub API.
+func githubRetries(c RetryConfig) githubapp.ClientMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return newRetryTransport(next, c, "github")
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := t.config.GetMaxAttempts()
+	if req.Body != nil && req.GetBody == nil {
+		// The body can't be sent again.
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		r := req
+		if attempt > 1 {
+			r = req.Clone(req.Context())
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				r.Body = body
+			}
+		}
+
+		resp, err := t.next.RoundTrip(r)
+		if attempt == attempts {
+			return resp, err
+		}
+		wait, reason, ok := t.retryable(req, resp, err, attempt)
+		if !ok {
+			return resp, err
+		}
+		if resp != nil {
+			// Drain the body so the connection can be reused.
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		apiRetriesTotal.Add(t.api+": "+reason, 1)
+		zerolog.Ctx(req.Context()).Debug().Str("api", t.api).Str("method", req.Method).Str("path", req.URL.Path).Str("reason", reason).Int("attempt", attempt).Dur("wait", wait).Msg("retrying request")
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// retryable returns how long to wait before retrying the given request after the given attempt
+// finished with the given response or error and why, or false if it mustn't be retried.
+func (t *retryTransport) retryable(req *http.Request, resp *http.Response, err error, attempt int) (time.Duration, string, bool) {
+	idempotent := req.Method == http.MethodGet || req.Method == http.MethodHead || req.Method == http.MethodPut || req.Method == http.MethodDelete || req.Method == http.MethodOptions ||
+		// Proposing app specs only validates them.
+		(req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/apps/propose"))
+
+	if err != nil {
+		if req.Context().Err() != nil || !idempotent {
+			return 0, "", false
+		}
+		return t.backoff(attempt), "error", true
+	}
+
+	if wait, limited := rateLimitWait(resp); limited {
+		// Rate limited requests haven't been processed, so they're retried regardless of their
+		// method. Limits resetting past the maximum backoff fail right away instead of stalling.
+		if wait > t.config.GetMaxBackoff() {
+			return 0, "", false
+		}
+		return max(wait, t.backoff(attempt)), "rate_limit", true
+	}
+	if !retryableStatuses[resp.StatusCode] || !idempotent {
+		return 0, "", false
+	}
+	return t.backoff(attempt), strconv.Itoa(resp.StatusCode), true
+}
+
+// backoff returns the jittered exponential backoff after the given attempt.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	d := t.config.GetInitialBackoff() << (attempt - 1)
+	if d <= 0 || d > t.config.GetMaxBackoff() {
+		d = t.config.GetMaxBackoff()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// Spread retries of requests failing at the same time by up to the jitter in both directions.
+	return time.Duration(float64(d) * (1 + t.config.GetJitter()*(2*t.rand.Float64()-1)))
+}
+
+// rateLimitWait returns how long the given response asks to wait before sending the request again,
+// if it's a rate limited one. Both APIs answer with a Retry-After header for secondary limits and
+// with the reset of the exhausted limit otherwise.
+func rateLimitWait(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusForbidden {
+		return 0, false
+	}
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if at, err := http.ParseTime(v); err == nil {
+			return time.Until(at), true
+		}
+	}
+	for _, prefix := range []string{"X-RateLimit", "Ratelimit"} {
+		if resp.Header.Get(prefix+"-Remaining") != "0" {
+			continue
+		}
+		if reset, err := strconv.ParseInt(resp.Header.Get(prefix+"-Reset"), 10, 64); err == nil {
+			return time.Until(time.Unix(reset, 0)), true
+		}
+	}
+	// A 403 that isn't rate limited is a permission error. Other 429s are retried with backoff.
+	return 0, resp.StatusCode == http.StatusTooManyRequests
+}
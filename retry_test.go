@@ -0,0 +1,266 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTransport answers requests with the given responses in order and records the requests and
+// their bodies.
+type fakeTransport struct {
+	responses []*http.Response
+	requests  []*http.Request
+	bodies    []string
+}
+
+func (f *fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.requests = append(f.requests, req)
+	if req.Body != nil {
+		b, _ := io.ReadAll(req.Body)
+		f.bodies = append(f.bodies, string(b))
+	}
+	if len(f.responses) == 0 {
+		return nil, errors.New("no more responses")
+	}
+	resp := f.responses[0]
+	f.responses = f.responses[1:]
+	return resp, nil
+}
+
+// response returns a response with the given status and headers, given as key and value pairs.
+func response(status int, headers ...string) *http.Response {
+	resp := &http.Response{StatusCode: status, Header: make(http.Header), Body: io.NopCloser(strings.NewReader(""))}
+	for i := 0; i+1 < len(headers); i += 2 {
+		resp.Header.Set(headers[i], headers[i+1])
+	}
+	return resp
+}
+
+// testRetryTransport returns a retry transport sending requests through the given one with short
+// backoffs and no jitter.
+func testRetryTransport(next http.RoundTripper, maxAttempts int) *retryTransport {
+	return newRetryTransport(next, RetryConfig{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Jitter:         ptr(0.0),
+	}, "test")
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		responses []*http.Response
+		// wantAttempts is the amount of requests sent.
+		wantAttempts int
+		wantStatus   int
+	}{{
+		name:         "success",
+		method:       http.MethodGet,
+		responses:    []*http.Response{response(http.StatusOK)},
+		wantAttempts: 1,
+		wantStatus:   http.StatusOK,
+	}, {
+		name:         "GET answered with a 502",
+		method:       http.MethodGet,
+		responses:    []*http.Response{response(http.StatusBadGateway), response(http.StatusServiceUnavailable), response(http.StatusOK)},
+		wantAttempts: 3,
+		wantStatus:   http.StatusOK,
+	}, {
+		name:         "POST answered with a 502",
+		method:       http.MethodPost,
+		responses:    []*http.Response{response(http.StatusBadGateway), response(http.StatusOK)},
+		wantAttempts: 1,
+		wantStatus:   http.StatusBadGateway,
+	}, {
+		name:         "proposal answered with a 502",
+		method:       http.MethodPost,
+		responses:    []*http.Response{response(http.StatusBadGateway), response(http.StatusOK)},
+		wantAttempts: 2,
+		wantStatus:   http.StatusOK,
+	}, {
+		name:         "POST rate limited",
+		method:       http.MethodPost,
+		responses:    []*http.Response{response(http.StatusTooManyRequests), response(http.StatusCreated)},
+		wantAttempts: 2,
+		wantStatus:   http.StatusCreated,
+	}, {
+		name:         "rate limit exhausted",
+		method:       http.MethodGet,
+		responses:    []*http.Response{response(http.StatusForbidden, "X-RateLimit-Remaining", "0", "X-RateLimit-Reset", strconv.FormatInt(time.Now().Unix(), 10)), response(http.StatusOK)},
+		wantAttempts: 2,
+		wantStatus:   http.StatusOK,
+	}, {
+		name:         "403 without rate limit",
+		method:       http.MethodGet,
+		responses:    []*http.Response{response(http.StatusForbidden, "X-RateLimit-Remaining", "42"), response(http.StatusOK)},
+		wantAttempts: 1,
+		wantStatus:   http.StatusForbidden,
+	}, {
+		name:         "404",
+		method:       http.MethodGet,
+		responses:    []*http.Response{response(http.StatusNotFound), response(http.StatusOK)},
+		wantAttempts: 1,
+		wantStatus:   http.StatusNotFound,
+	}, {
+		name:         "out of attempts",
+		method:       http.MethodGet,
+		responses:    []*http.Response{response(http.StatusBadGateway), response(http.StatusBadGateway), response(http.StatusBadGateway), response(http.StatusOK)},
+		wantAttempts: 3,
+		wantStatus:   http.StatusBadGateway,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeTransport{responses: tt.responses}
+			path := "/v2/apps"
+			if strings.HasPrefix(tt.name, "proposal") {
+				path = "/v2/apps/propose"
+			}
+			req, _ := http.NewRequest(tt.method, "https://api.example.com"+path, nil)
+
+			resp, err := testRetryTransport(fake, 3).RoundTrip(req)
+			if err != nil {
+				t.Fatalf("RoundTrip() = %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("RoundTrip() = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if len(fake.requests) != tt.wantAttempts {
+				t.Errorf("sent %d requests, want %d", len(fake.requests), tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryTransportRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+	}{{
+		name:   "seconds",
+		header: "5",
+		want:   5 * time.Second,
+	}, {
+		name:   "HTTP date",
+		header: time.Now().Add(8 * time.Second).UTC().Format(http.TimeFormat),
+		want:   8 * time.Second,
+	}, {
+		name:   "past HTTP date",
+		header: time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat),
+		// The backoff of the first attempt.
+		want: time.Millisecond,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := testRetryTransport(nil, 3)
+			req, _ := http.NewRequest(http.MethodGet, "https://api.example.com/v2/apps", nil)
+			wait, reason, ok := rt.retryable(req, response(http.StatusTooManyRequests, "Retry-After", tt.header), nil, 1)
+			if !ok || reason != "rate_limit" {
+				t.Fatalf("retryable() = %s, %t, want rate_limit, true", reason, ok)
+			}
+			// HTTP dates only have a resolution of seconds.
+			if wait < tt.want-time.Second || wait > tt.want {
+				t.Errorf("retryable() waits %s, want %s", wait, tt.want)
+			}
+		})
+	}
+
+	t.Run("honored", func(t *testing.T) {
+		fake := &fakeTransport{responses: []*http.Response{response(http.StatusTooManyRequests, "Retry-After", "1"), response(http.StatusOK)}}
+		req, _ := http.NewRequest(http.MethodGet, "https://api.example.com/v2/apps", nil)
+		start := time.Now()
+		resp, err := testRetryTransport(fake, 3).RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("RoundTrip() = %v, %v", resp, err)
+		}
+		if elapsed := time.Since(start); elapsed < time.Second {
+			t.Errorf("retried after %s, want at least 1s", elapsed)
+		}
+	})
+
+	t.Run("too far away", func(t *testing.T) {
+		fake := &fakeTransport{responses: []*http.Response{response(http.StatusTooManyRequests, "Retry-After", "60"), response(http.StatusOK)}}
+		req, _ := http.NewRequest(http.MethodGet, "https://api.example.com/v2/apps", nil)
+		resp, err := testRetryTransport(fake, 3).RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || len(fake.requests) != 1 {
+			t.Errorf("RoundTrip() = %v, %v after %d requests, want a 429 after 1", resp, err, len(fake.requests))
+		}
+	})
+}
+
+func TestRetryTransportRateLimitReset(t *testing.T) {
+	for _, prefix := range []string{"X-RateLimit", "Ratelimit"} {
+		t.Run(prefix, func(t *testing.T) {
+			rt := testRetryTransport(nil, 3)
+			req, _ := http.NewRequest(http.MethodGet, "https://api.example.com/v2/apps", nil)
+			reset := strconv.FormatInt(time.Now().Add(5*time.Second).Unix(), 10)
+			wait, _, ok := rt.retryable(req, response(http.StatusForbidden, prefix+"-Remaining", "0", prefix+"-Reset", reset), nil, 1)
+			if !ok || wait < 4*time.Second || wait > 5*time.Second {
+				t.Errorf("retryable() = %s, %t, want about 5s, true", wait, ok)
+			}
+		})
+	}
+}
+
+func TestRetryTransportReplaysBody(t *testing.T) {
+	fake := &fakeTransport{responses: []*http.Response{response(http.StatusBadGateway), response(http.StatusOK)}}
+	req, _ := http.NewRequest(http.MethodPut, "https://api.example.com/v2/apps/1", strings.NewReader(`{"spec":{}}`))
+
+	resp, err := testRetryTransport(fake, 3).RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("RoundTrip() = %v, %v", resp, err)
+	}
+	if len(fake.bodies) != 2 || fake.bodies[0] != `{"spec":{}}` || fake.bodies[1] != fake.bodies[0] {
+		t.Errorf("sent bodies %q, want the body twice", fake.bodies)
+	}
+
+	t.Run("without GetBody", func(t *testing.T) {
+		fake := &fakeTransport{responses: []*http.Response{response(http.StatusBadGateway), response(http.StatusOK)}}
+		req, _ := http.NewRequest(http.MethodPut, "https://api.example.com/v2/apps/1", strings.NewReader(`{}`))
+		req.GetBody = nil
+
+		resp, err := testRetryTransport(fake, 3).RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusBadGateway || len(fake.requests) != 1 {
+			t.Errorf("RoundTrip() = %v, %v after %d requests, want a 502 after 1", resp, err, len(fake.requests))
+		}
+	})
+}
+
+func TestRetryTransportCanceled(t *testing.T) {
+	fake := &fakeTransport{responses: []*http.Response{response(http.StatusBadGateway), response(http.StatusOK)}}
+	rt := newRetryTransport(fake, RetryConfig{InitialBackoff: time.Minute, MaxBackoff: time.Minute, Jitter: ptr(0.0)}, "test")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.example.com/v2/apps", nil)
+
+	start := time.Now()
+	_, err := rt.RoundTrip(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RoundTrip() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("RoundTrip() returned after %s, want it to stop backing off once canceled", elapsed)
+	}
+	if len(fake.requests) != 1 {
+		t.Errorf("sent %d requests, want 1", len(fake.requests))
+	}
+}
+
+func TestRetryTransportBackoff(t *testing.T) {
+	rt := newRetryTransport(nil, RetryConfig{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Jitter: ptr(0.0)}, "test")
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 64: 5 * time.Second} {
+		if got := rt.backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package reviewapps
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// Features that can be rolled out gradually.
+const (
+	// featureForks is review apps of pull requests from forked repositories.
+	featureForks = "forks"
+	// featureRegionFallbacks is creating apps in fallback regions.
+	featureRegionFallbacks = "region_fallbacks"
+	// featureRepoConfig is reading the repository's configuration file.
+	featureRepoConfig = "repo_config"
+	// featureExpiry is tearing down review apps once their TTL passed.
+	featureExpiry = "expiry"
+)
+
+// rolloutFeatures are all features that can be rolled out gradually.
+var rolloutFeatures = []string{featureForks, featureRegionFallbacks, featureRepoConfig, featureExpiry}
+
+// RolloutConfig limits a feature to a subset of repositories, so it can be tried on some of them
+// before it's enabled for all. Features without rollout are enabled for all repositories that
+// configure them.
+type RolloutConfig struct {
+	// Percentage is the percentage of repositories the feature is enabled for. Repositories are
+	// picked by a stable hash of their name, so raising the percentage only adds repositories.
+	Percentage int `yaml:"percentage"`
+	// Repos are the full names of repositories the feature is enabled for, regardless of the
+	// percentage.
+	Repos []string `yaml:"repos"`
+}
+
+// includes returns whether or not the rollout of the given feature includes the given repository.
+func (c RolloutConfig) includes(feature, repo string) bool {
+	if contains(c.Repos, repo) {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(feature + "/" + repo))
+	return int(h.Sum32()%100) < c.Percentage
+}
+
+// validateRollouts validates the given rollouts.
+func validateRollouts(rollouts map[string]RolloutConfig) error {
+	for feature, rollout := range rollouts {
+		if !contains(rolloutFeatures, feature) {
+			return fmt.Errorf("unknown feature %q, must be one of %v", feature, rolloutFeatures)
+		}
+		if rollout.Percentage < 0 || rollout.Percentage > 100 {
+			return fmt.Errorf("rollout percentage of feature %q must be between 0 and 100", feature)
+		}
+	}
+	return nil
+}
+
+// applyRollouts disables all features of the given configuration of the given repository that
+// aren't rolled out to it.
+func applyRollouts(cfg *ReviewAppConfig, rollouts map[string]RolloutConfig, repo string) {
+	for feature, rollout := range rollouts {
+		if rollout.includes(feature, repo) {
+			continue
+		}
+		switch feature {
+		case featureForks:
+			cfg.Forks.Enabled = false
+		case featureRegionFallbacks:
+			cfg.FallbackRegions = nil
+		}
+		if cfg.excluded == nil {
+			cfg.excluded = make(map[string]bool)
+		}
+		cfg.excluded[feature] = true
+	}
+}
+
+// rolledOut returns whether or not the given feature is rolled out to the configuration's
+// repository.
+func (c ReviewAppConfig) rolledOut(feature string) bool {
+	return !c.excluded[feature]
+}
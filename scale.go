@@ -0,0 +1,181 @@
+package reviewapps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.internal.digitalocean.com/mthoemmes/reviewapps/store"
+)
+
+const (
+	// scaleUpArg and scaleDownArg are the arguments of "/scale".
+	scaleUpArg   = "up"
+	scaleDownArg = "down"
+
+	// scaleKeySuffix suffixes the app names of the state store keys scale-ups are recorded under.
+	// App names can't contain slashes, so the keys never collide with those of apps.
+	scaleKeySuffix = "/scale"
+)
+
+// scaleUp is a temporary scale-up of the services and workers of a review app.
+type scaleUp struct {
+	// By is the user who scaled up the review app.
+	By               string    `json:"by"`
+	InstanceSizeSlug string    `json:"instance_size_slug"`
+	InstanceCount    int       `json:"instance_count"`
+	Until            time.Time `json:"until"`
+}
+
+// scaleKey returns the key the scale-up of the review app is recorded under in the state store.
+func (ra *reviewApp) scaleKey() store.Key {
+	return store.Key{Repo: ra.repo.GetFullName(), App: ra.appName + scaleKeySuffix}
+}
+
+// parseScaleUp parses the arguments following "/scale up", an instance size, an instance count and
+// a duration in any order, all of them optional, and checks them against the given limits. Its
+// errors are meant for the user.
+func parseScaleUp(args []string, cfg ScaleConfig, now time.Time) (*scaleUp, error) {
+	s := &scaleUp{InstanceSizeSlug: cfg.InstanceSizes[0], InstanceCount: 1}
+	duration := cfg.GetDuration()
+	for _, arg := range args {
+		if n, err := strconv.Atoi(arg); err == nil {
+			if n < 1 || n > cfg.GetMaxInstanceCount() {
+				return nil, fmt.Errorf("the instance count must be between 1 and %d", cfg.GetMaxInstanceCount())
+			}
+			s.InstanceCount = n
+			continue
+		}
+		if d, err := time.ParseDuration(arg); err == nil {
+			if d <= 0 || d > cfg.GetMaxDuration() {
+				return nil, fmt.Errorf("the duration must be positive and at most %s", cfg.GetMaxDuration())
+			}
+			duration = d
+			continue
+		}
+		if !slices.Contains(cfg.InstanceSizes, arg) {
+			return nil, fmt.Errorf("instance size %q is not allowed, use one of %s", arg, strings.Join(cfg.InstanceSizes, ", "))
+		}
+		s.InstanceSizeSlug = arg
+	}
+	s.Until = now.Add(duration).UTC()
+	return s, nil
+}
+
+// scaleUpOf returns the scale-up of the review app as recorded in the state store, or nil if there
+// is none. Scale-ups that failed to be looked up are logged and treated as missing, so review apps
+// remain deployable.
+func (h *PRHandler) scaleUpOf(ctx context.Context, ra *reviewApp) *scaleUp {
+	if !ra.cfg.Scale.Enabled {
+		return nil
+	}
+	value, err := h.store.Get(ctx, ra.scaleKey())
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			ra.logger.Warn().Err(err).Msg("failed to look up scale-up in state store")
+		}
+		return nil
+	}
+	var s scaleUp
+	if err := json.Unmarshal([]byte(value), &s); err != nil {
+		ra.logger.Warn().Err(err).Msg("ignoring invalid scale-up recorded in state store")
+		return nil
+	}
+	return &s
+}
+
+// applyScaleUp scales up the services and workers of the given spec according to the review app's
+// scale-up, unless it expired.
+func (h *PRHandler) applyScaleUp(ctx context.Context, spec *godo.AppSpec, ra *reviewApp) {
+	s := h.scaleUpOf(ctx, ra)
+	if s == nil || !time.Now().Before(s.Until) {
+		return
+	}
+	ra.logger.Info().Str("instance_size", s.InstanceSizeSlug).Int("instance_count", s.InstanceCount).Time("until", s.Until).Msg("applying scale-up to app spec")
+	for _, svc := range spec.GetServices() {
+		svc.InstanceSizeSlug = s.InstanceSizeSlug
+		svc.InstanceCount = int64(s.InstanceCount)
+		svc.Autoscaling = nil
+	}
+	for _, worker := range spec.GetWorkers() {
+		worker.InstanceSizeSlug = s.InstanceSizeSlug
+		worker.InstanceCount = int64(s.InstanceCount)
+		worker.Autoscaling = nil
+	}
+}
+
+// scale records the given scale-up of the review app and updates it to the scaled up spec.
+func (h *PRHandler) scale(ctx context.Context, ra *reviewApp, s *scaleUp, attempt int64) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to encode scale-up: %w", err)
+	}
+	if err := h.store.Put(ctx, ra.scaleKey(), string(b)); err != nil {
+		return fmt.Errorf("failed to record scale-up in state store: %w", err)
+	}
+
+	ra.logger.Info().Str("user", s.By).Str("instance_size", s.InstanceSizeSlug).Int("instance_count", s.InstanceCount).Time("until", s.Until).Msg("scaling up review app")
+	// Creating updates the existing app to the scaled up spec.
+	if err := h.create(ctx, ra, attempt); err != nil {
+		return err
+	}
+	body := fmt.Sprintf("The review app was scaled up to %d instance(s) of size `%s` per service and worker by @%s. It's scaled down again after %s, or with `%s %s`.",
+		s.InstanceCount, s.InstanceSizeSlug, s.By, s.Until.Format(time.RFC1123), commandScale, scaleDownArg)
+	return h.comment(ctx, ra, commentKindScale, body)
+}
+
+// scaleDown reverts the scale-up of the review app, if any, for the given reason and updates it to
+// its configured size.
+func (h *PRHandler) scaleDown(ctx context.Context, ra *reviewApp, attempt int64, reason string) error {
+	if h.scaleUpOf(ctx, ra) == nil {
+		ra.logger.Info().Msg("not scaling down review app that isn't scaled up")
+		return nil
+	}
+	if err := h.store.Delete(ctx, ra.scaleKey()); err != nil {
+		return fmt.Errorf("failed to remove scale-up from state store: %w", err)
+	}
+
+	ra.logger.Info().Msgf("scaling down review app as %s", reason)
+	if err := h.create(ctx, ra, attempt); err != nil {
+		return err
+	}
+	return h.comment(ctx, ra, commentKindScale, fmt.Sprintf("The review app was scaled down again as %s.", reason))
+}
+
+// forgetScaleUp removes the scale-up of the torn down review app from the state store.
+func (h *PRHandler) forgetScaleUp(ctx context.Context, ra *reviewApp) {
+	if err := h.store.Delete(ctx, ra.scaleKey()); err != nil {
+		ra.logger.Warn().Err(err).Msg("failed to remove scale-up from state store")
+	}
+}
+
+// scaleDownAttempt returns the attempt to revert the given scale-up with, so retried reverts of the
+// same scale-up are idempotent.
+func scaleDownAttempt(s *scaleUp) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("scale-down:" + s.Until.Format(time.RFC3339Nano)))
+	// Attempt 0 is used by pushes, so keep clear of it.
+	return int64(h.Sum64()>>1) | 1
+}
+
+// revertScaleUp scales down the given review app if its scale-up expired at the given time.
+func (rc *Reconciler) revertScaleUp(ctx context.Context, ra *reviewApp, now time.Time) error {
+	s := rc.prs.scaleUpOf(ctx, ra)
+	if s == nil || now.Before(s.Until) {
+		return nil
+	}
+	// Reverting redeploys the review app, so it waits for its turn like commands do.
+	ctx, done, _, err := rc.prs.turns.take(ctx, ra.repo.GetFullName(), ra.number, turnQueued)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return rc.prs.scaleDown(ctx, ra, scaleDownAttempt(s), fmt.Sprintf("its scale-up by %s expired", s.By))
+}
@@ -0,0 +1,479 @@
+package reviewapps
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.com/rs/zerolog"
+	"github.internal.digitalocean.com/mthoemmes/reviewapps/store"
+)
+
+// Version is the version of the service, as reported to GitHub and in telemetry.
+const Version = "1.0.0"
+
+// Builder assembles a review apps Server. Embedders can register additional event handlers and
+// lifecycle listeners alongside the builtin PRHandler to extend its behavior without forking.
+type Builder struct {
+	config    *Config
+	handlers  []githubapp.EventHandler
+	listeners []LifecycleListener
+	mutators  []SpecMutator
+	deciders  []PolicyDecider
+	namers    map[string]Namer
+	store     store.Store
+}
+
+// NewBuilder returns a new Builder for the given configuration.
+func NewBuilder(config *Config) *Builder {
+	return &Builder{config: config}
+}
+
+// WithEventHandler registers an additional handler for GitHub webhook events.
+func (b *Builder) WithEventHandler(h githubapp.EventHandler) *Builder {
+	b.handlers = append(b.handlers, h)
+	return b
+}
+
+// WithLifecycleListener registers a listener that's notified about lifecycle events of all review
+// apps.
+func (b *Builder) WithLifecycleListener(l LifecycleListener) *Builder {
+	b.listeners = append(b.listeners, l)
+	return b
+}
+
+// WithSpecMutator registers a mutator that's applied to the app spec of every review app before
+// it's created.
+func (b *Builder) WithSpecMutator(m SpecMutator) *Builder {
+	b.mutators = append(b.mutators, m)
+	return b
+}
+
+// WithPolicyDecider registers a decider that's consulted before a review app is deployed. All
+// deciders must allow a pull request for it to get a review app.
+func (b *Builder) WithPolicyDecider(d PolicyDecider) *Builder {
+	b.deciders = append(b.deciders, d)
+	return b
+}
+
+// WithNamer registers a naming strategy under the given name, which repositories select with
+// ReviewAppConfig.Naming.Strategy. It takes precedence over a built-in strategy of the same name.
+func (b *Builder) WithNamer(strategy string, n Namer) *Builder {
+	if b.namers == nil {
+		b.namers = make(map[string]Namer)
+	}
+	b.namers[strategy] = n
+	return b
+}
+
+// WithStateStore records the apps of review apps in the given store instead of the configured one,
+// e.g. in a database whose driver the embedder registered.
+func (b *Builder) WithStateStore(s store.Store) *Builder {
+	b.store = s
+	return b
+}
+
+// extensions are the extensions registered with the Builder and provided by plugins.
+type extensions struct {
+	plugins   []*Plugin
+	listeners []LifecycleListener
+	mutators  []SpecMutator
+	deciders  []PolicyDecider
+}
+
+// close stops all plugins.
+func (e *extensions) close() {
+	for _, p := range e.plugins {
+		p.Close()
+	}
+}
+
+// startPlugins starts all configured plugins and returns them alongside the registered extensions.
+func (b *Builder) startPlugins() (*extensions, error) {
+	ext := &extensions{
+		listeners: b.listeners,
+		mutators:  b.mutators,
+		deciders:  b.deciders,
+	}
+	for _, pc := range b.config.Plugins {
+		ctx, cancel := context.WithTimeout(context.Background(), pluginStartTimeout)
+		p, err := StartPlugin(ctx, pc.Name, pc.Command)
+		cancel()
+		if err != nil {
+			ext.close()
+			return nil, err
+		}
+		ext.plugins = append(ext.plugins, p)
+
+		if p.Has(pluginCapabilitySpecMutator) {
+			ext.mutators = append(ext.mutators, p)
+		}
+		if p.Has(pluginCapabilityPolicyDecider) {
+			ext.deciders = append(ext.deciders, p)
+		}
+		if p.Has(pluginCapabilityNotifier) {
+			ext.listeners = append(ext.listeners, notifierListener{notifier: p})
+		}
+	}
+	return ext, nil
+}
+
+// newPRHandler returns the PRHandler using the given clients and extensions.
+func (b *Builder) newPRHandler(cc githubapp.ClientCreator, do *godo.Client, ext *extensions) *PRHandler {
+	prHandler := NewPRHandler(cc, do, b.config)
+	prHandler.listeners = ext.listeners
+	prHandler.mutators = ext.mutators
+	prHandler.deciders = ext.deciders
+	prHandler.namers = b.namers
+	return prHandler
+}
+
+// eventHandlers returns all handlers of GitHub webhook events.
+func (b *Builder) eventHandlers(prHandler *PRHandler) []githubapp.EventHandler {
+	handlers := append([]githubapp.EventHandler{prHandler, NewCommandHandler(prHandler), NewBranchHandler(prHandler), NewCheckSuiteHandler(prHandler)}, b.handlers...)
+	if !b.config.Access.configured() {
+		return handlers
+	}
+	for i, h := range handlers {
+		handlers[i] = &accessHandler{EventHandler: h, access: b.config.Access}
+	}
+	return handlers
+}
+
+// checkNaming checks that all repositories select a built-in or registered naming strategy.
+func (b *Builder) checkNaming() error {
+	check := func(rc ReviewAppConfig) error {
+		switch strategy := rc.Naming.GetStrategy(); strategy {
+		case namingSlug, namingHash, namingSequential:
+		default:
+			if _, ok := b.namers[strategy]; !ok {
+				return fmt.Errorf("unknown naming strategy %q", strategy)
+			}
+		}
+		return nil
+	}
+	if err := check(b.config.ReviewApps); err != nil {
+		return err
+	}
+	for repo := range b.config.Repos {
+		rc, err := b.config.ForRepo(repo)
+		if err != nil {
+			return fmt.Errorf("invalid review app configuration for repo %s: %w", repo, err)
+		}
+		if err := check(rc); err != nil {
+			return fmt.Errorf("invalid review app configuration for repo %s: %w", repo, err)
+		}
+	}
+	return nil
+}
+
+// Build creates the Server and starts all configured plugins.
+func (b *Builder) Build() (*Server, error) {
+	if err := b.checkNaming(); err != nil {
+		return nil, err
+	}
+	ext, err := b.startPlugins()
+	if err != nil {
+		return nil, err
+	}
+
+	addConfigSecrets(b.config)
+	cc, err := githubapp.NewDefaultCachingClientCreator(
+		b.config.Github,
+		githubapp.WithClientUserAgent("app-platform-review-apps/"+Version),
+		// Attempts get their deadlines from the middleware, so the client only bounds the longest
+		// request including its retries.
+		githubapp.WithClientTimeout(b.config.Retry.maxDuration(b.config.GithubClient.maxTimeout())),
+		githubapp.WithClientMiddleware(githubRedaction(), githubRetries(b.config.Retry), githubDeadlines(b.config.GithubClient)),
+	)
+	if err != nil {
+		ext.close()
+		return nil, fmt.Errorf("failed to create client creator: %w", err)
+	}
+
+	do := godo.NewFromToken(b.config.DigitalOcean.Token)
+
+	var pool *WarmPool
+	if b.config.WarmPool.Size > 0 {
+		pool = NewWarmPool(do, b.config.WarmPool)
+	}
+
+	var sealer *sealer
+	if b.config.Encryption.Key != "" {
+		sealer, err = newSealer(b.config.Encryption.Key)
+		if err != nil {
+			ext.close()
+			return nil, fmt.Errorf("failed to create sealer: %w", err)
+		}
+	}
+
+	var backups *DatabaseBackups
+	if b.config.Backups.Spaces.Bucket != "" {
+		backups = NewDatabaseBackups(do, b.config.Backups)
+		backups.sealer = sealer
+	}
+
+	st := b.store
+	if st == nil {
+		st = newStateStore(b.config.StateStore)
+	}
+	if _, inMemory := st.(*store.Memory); sealer != nil && !inMemory {
+		st = sealStore(st, sealer)
+	}
+
+	stream := newEventStream(b.config.Server.APIToken, b.config.Server.AdminToken)
+	ext.listeners = append(ext.listeners, stream)
+
+	prHandler := b.newPRHandler(cc, do, ext)
+	// Every attempt of retried requests is captured and counts towards the error rate.
+	do.HTTPClient.Transport = newRetryTransport(prHandler.throttle.transport(prHandler.captures.transport(do.HTTPClient.Transport)), b.config.Retry, "digitalocean")
+	prHandler.pool = pool
+	prHandler.backups = backups
+	prHandler.store = st
+
+	var refresher *Refresher
+	if b.config.RefreshSchedule != "" {
+		refresher, err = NewRefresher(prHandler, b.config.RefreshSchedule, b.config.GetRefreshWorkers())
+		if err != nil {
+			ext.close()
+			return nil, fmt.Errorf("failed to create refresher: %w", err)
+		}
+	}
+
+	var drift *DriftDetector
+	if b.config.DriftSchedule != "" {
+		drift, err = NewDriftDetector(prHandler, b.config.DriftSchedule)
+		if err != nil {
+			ext.close()
+			return nil, fmt.Errorf("failed to create drift detector: %w", err)
+		}
+	}
+
+	var reconciler *Reconciler
+	if b.config.ReconcileSchedule != "" {
+		reconciler, err = NewReconciler(prHandler, b.config.ReconcileSchedule)
+		if err != nil {
+			ext.close()
+			return nil, fmt.Errorf("failed to create reconciler: %w", err)
+		}
+	}
+
+	var expirer *Expirer
+	if b.config.ExpirySchedule != "" {
+		expirer, err = NewExpirer(prHandler, b.config.ExpirySchedule)
+		if err != nil {
+			ext.close()
+			return nil, fmt.Errorf("failed to create expirer: %w", err)
+		}
+	}
+
+	var reminder *Reminder
+	if b.config.ReminderSchedule != "" {
+		reminder, err = NewReminder(prHandler, b.config.ReminderSchedule)
+		if err != nil {
+			ext.close()
+			return nil, fmt.Errorf("failed to create reminder: %w", err)
+		}
+	}
+
+	var orphans *OrphanCollector
+	if b.config.OrphanSchedule != "" {
+		orphans, err = NewOrphanCollector(prHandler, b.config.OrphanSchedule)
+		if err != nil {
+			ext.close()
+			return nil, fmt.Errorf("failed to create orphan collector: %w", err)
+		}
+	}
+
+	var canary *Canary
+	if b.config.Canary.Schedule != "" {
+		canary, err = NewCanary(prHandler, b.config.Canary)
+		if err != nil {
+			ext.close()
+			return nil, fmt.Errorf("failed to create canary: %w", err)
+		}
+	}
+
+	gc, err := NewGarbageCollector(prHandler, b.config.GCSchedule, b.config.Server.AdminToken)
+	if err != nil {
+		ext.close()
+		return nil, fmt.Errorf("failed to create garbage collector: %w", err)
+	}
+
+	var telemetry *Telemetry
+	if b.config.Telemetry.Enabled {
+		telemetry = NewTelemetry(b.config.Telemetry)
+	}
+
+	q := prHandler.queue
+	q.schedule("gc", gc.schedule)
+	if refresher != nil {
+		q.schedule("refresh", refresher.schedule)
+	}
+	if drift != nil {
+		q.schedule("drift", drift.schedule)
+	}
+	if reconciler != nil {
+		q.schedule("reconcile", reconciler.schedule)
+	}
+	if expirer != nil {
+		q.schedule("expiry", expirer.schedule)
+	}
+	if reminder != nil {
+		q.schedule("reminders", reminder.schedule)
+	}
+	if orphans != nil {
+		q.schedule("orphans", orphans.schedule)
+	}
+	if canary != nil {
+		q.schedule("canary", canary.schedule)
+	}
+
+	handlers := b.eventHandlers(prHandler)
+	tracked := prHandler.queue.track(handlers)
+	var deliveries *deliveryScheduler
+	scheduler := githubapp.AsyncScheduler()
+	if b.config.Deliveries.Persist {
+		if deliveries, err = newDeliveryScheduler(st, b.config.Deliveries, tracked); err != nil {
+			ext.close()
+			return nil, err
+		}
+		scheduler = deliveries
+	}
+	webhookHandler := githubapp.NewEventDispatcher(tracked, b.config.Github.App.WebhookSecret, githubapp.WithScheduler(scheduler))
+
+	mux := http.NewServeMux()
+	mux.Handle("/", webhookResponder(handlers, webhookHandler))
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/openapi.yaml", serveOpenAPISpec)
+	mux.Handle("/readyz", &readiness{prs: prHandler})
+	mux.Handle("/status", prHandler.skips)
+	mux.Handle("/events", stream)
+	mux.Handle("/admin/gc", gc)
+	mux.Handle("/admin/adopt", &adopter{prs: prHandler, adminToken: b.config.Server.AdminToken})
+	mux.Handle("/admin/inventory", &inventory{prs: prHandler, adminToken: b.config.Server.AdminToken})
+	mux.Handle("/admin/queue", prHandler.queue)
+	mux.Handle("/admin/apps/{action}", &bulkOperations{prs: prHandler, adminToken: b.config.Server.AdminToken})
+	mux.Handle("/admin/debug/{owner}/{repo}/{number}", prHandler.captures)
+	if prHandler.doWebhooks != nil {
+		mux.Handle("/do-webhook", prHandler.doWebhooks)
+	}
+	if prHandler.gateway != nil {
+		mux.Handle(gatewayPath, prHandler.gateway)
+	}
+	mux.Handle("/api/v1/repos/{owner}/{repo}/pulls/{number}/preview", newPreviewAPI(prHandler, b.config.Server))
+
+	return &Server{
+		addr:       fmt.Sprintf("%s:%d", b.config.Server.Address, b.config.Server.Port),
+		handler:    mux,
+		plugins:    ext.plugins,
+		pool:       pool,
+		backups:    backups,
+		refresher:  refresher,
+		drift:      drift,
+		reconciler: reconciler,
+		expirer:    expirer,
+		reminder:   reminder,
+		deliveries: deliveries,
+		orphans:    orphans,
+		canary:     canary,
+		gc:         gc,
+		telemetry:  telemetry,
+	}, nil
+}
+
+// Server serves the GitHub webhooks driving the review apps.
+type Server struct {
+	addr       string
+	handler    http.Handler
+	plugins    []*Plugin
+	pool       *WarmPool
+	backups    *DatabaseBackups
+	refresher  *Refresher
+	drift      *DriftDetector
+	reconciler *Reconciler
+	expirer    *Expirer
+	reminder   *Reminder
+	deliveries *deliveryScheduler
+	orphans    *OrphanCollector
+	canary     *Canary
+	gc         *GarbageCollector
+	telemetry  *Telemetry
+}
+
+// Handler returns the HTTP handler of the server, for embedders that run their own HTTP server.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// Run runs the server until the given context is done. Plugins are stopped once it returns.
+func (s *Server) Run(ctx context.Context) error {
+	defer func() {
+		for _, p := range s.plugins {
+			if err := p.Close(); err != nil {
+				zerolog.Ctx(ctx).Error().Err(err).Str("plugin", p.Name()).Msg("failed to stop plugin")
+			}
+		}
+	}()
+
+	if s.deliveries != nil {
+		go s.deliveries.replay(ctx)
+	}
+	if s.pool != nil {
+		go s.pool.Run(ctx)
+	}
+	if s.backups != nil {
+		go s.backups.Run(ctx)
+	}
+	if s.refresher != nil {
+		go s.refresher.Run(ctx)
+	}
+	if s.drift != nil {
+		go s.drift.Run(ctx)
+	}
+	if s.reconciler != nil {
+		go s.reconciler.Run(ctx)
+	}
+	if s.expirer != nil {
+		go s.expirer.Run(ctx)
+	}
+	if s.reminder != nil {
+		go s.reminder.Run(ctx)
+	}
+	if s.orphans != nil {
+		go s.orphans.Run(ctx)
+	}
+	if s.canary != nil {
+		go s.canary.Run(ctx)
+	}
+	go s.gc.Run(ctx)
+	if s.telemetry != nil {
+		go s.telemetry.Run(ctx)
+	}
+
+	srv := &http.Server{Addr: s.addr, Handler: s.handler}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	zerolog.Ctx(ctx).Info().Msgf("Starting server on %s...", s.addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// authorized returns whether the given request presents the given token as bearer token. Requests
+// are never authorized if the token is empty.
+func authorized(r *http.Request, token string) bool {
+	presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && ok && subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1
+}
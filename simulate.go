@@ -0,0 +1,340 @@
+package reviewapps
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-github/v60/github"
+	"github.com/palantir/go-githubapp/githubapp"
+	"github.internal.digitalocean.com/mthoemmes/reviewapps/dofake"
+	"github.internal.digitalocean.com/mthoemmes/reviewapps/githubfake"
+)
+
+const (
+	ScenarioPROpened       = "pr-opened"
+	ScenarioPRReopened     = "pr-reopened"
+	ScenarioPRSynchronized = "pr-synchronized"
+	ScenarioPRLabeled      = "pr-labeled"
+	ScenarioPRUnlabeled    = "pr-unlabeled"
+	ScenarioPRClosed       = "pr-closed"
+	ScenarioComment        = "comment"
+	ScenarioPush           = "push"
+	ScenarioChecksRerun    = "checks-rerun"
+)
+
+// Scenario describes a synthetic event of a pull request, or of a push to its branch, to simulate.
+type Scenario struct {
+	// Name is one of the Scenario* constants.
+	Name string
+	// Repo is the full name of the repository, i.e. "owner/name".
+	Repo        string
+	PullRequest int
+	Author      string
+	Branch      string
+	Labels      []string
+	// Label is the label that's added in the "pr-labeled" and removed in the "pr-unlabeled"
+	// scenario.
+	Label string
+	// Comment is the body of the comment in the "comment" scenario. The author of the pull
+	// request comments it with admin access.
+	Comment string
+	// Files are the files changed by the pull request.
+	Files []string
+	// Spec is the app spec on the pull request's branch.
+	Spec []byte
+	// Faults are injected while the event is handled.
+	Faults Faults
+}
+
+// Simulate drives the handlers with the synthetic event of the given scenario against in-memory
+// fakes of GitHub and App Platform and writes all resulting actions to the given writer. Plugins
+// and registered extensions take part in the simulation, so configuration and policy changes
+// can be validated before rolling them out. Scenarios other than "pr-opened" and "push" are
+// preceded by the pull request being opened, which isn't reported.
+func (b *Builder) Simulate(ctx context.Context, scenario Scenario, out io.Writer) error {
+	owner, name, ok := strings.Cut(scenario.Repo, "/")
+	if !ok {
+		return fmt.Errorf("repo %q must be of the form owner/name", scenario.Repo)
+	}
+	if err := scenario.Faults.validate(); err != nil {
+		return err
+	}
+
+	ext, err := b.startPlugins()
+	if err != nil {
+		return err
+	}
+	defer ext.close()
+
+	gh := githubfake.New()
+	defer gh.Close()
+	fake := dofake.New()
+	defer fake.Close()
+	// Don't wait needlessly long for the polling of the deployment to finish.
+	fake.SetPhases(godo.DeploymentPhase_Deploying, godo.DeploymentPhase_Active)
+
+	rec := &actionRecorder{out: out}
+	faults := newFaultInjector(scenario.Faults, rec)
+	cc, err := gh.ClientCreator(githubapp.WithClientMiddleware(githubRetries(b.config.Retry), faults.github, rec.middleware("github")))
+	if err != nil {
+		return err
+	}
+	do, err := godo.New(&http.Client{Transport: newRetryTransport(faults.do(rec.middleware("do")(http.DefaultTransport)), b.config.Retry, "digitalocean")}, godo.SetBaseURL(fake.URL))
+	if err != nil {
+		return fmt.Errorf("failed to create DigitalOcean client: %w", err)
+	}
+	handlers := b.eventHandlers(b.newPRHandler(cc, do, ext))
+
+	repo := gh.AddRepo(owner, name)
+	gh.SetFile(scenario.Repo, scenario.Branch, canonicalAppSpecLocation, string(scenario.Spec))
+	gh.SetPermission(scenario.Repo, scenario.Author, "admin")
+	pr := &github.PullRequest{
+		Number: ptr(scenario.PullRequest),
+		State:  ptr("open"),
+		User:   &github.User{Login: ptr(scenario.Author)},
+		Head:   &github.PullRequestBranch{Ref: ptr(scenario.Branch), SHA: ptr("0000000000000000000000000000000000000001")},
+	}
+	for _, l := range scenario.Labels {
+		pr.Labels = append(pr.Labels, &github.Label{Name: ptr(l)})
+	}
+	gh.SetPullRequest(scenario.Repo, pr, scenario.Files...)
+
+	if scenario.Name != ScenarioPROpened && scenario.Name != ScenarioPush {
+		// The review app usually exists before all other scenarios.
+		if err := dispatch(ctx, handlers, "pull_request", prEvent(actionOpened, repo, pr, nil)); err != nil {
+			return fmt.Errorf("failed to open pull request: %w", err)
+		}
+	}
+	rec.enable()
+	fake.SetDeploymentDelay(scenario.Faults.DeploymentDelay)
+
+	var (
+		eventType = "pull_request"
+		event     interface{}
+	)
+	switch scenario.Name {
+	case ScenarioPROpened:
+		event = prEvent(actionOpened, repo, pr, nil)
+	case ScenarioPRReopened:
+		event = prEvent(actionReopened, repo, pr, nil)
+	case ScenarioPRSynchronized:
+		pr.Head.SHA = ptr("0000000000000000000000000000000000000002")
+		gh.SetPullRequest(scenario.Repo, pr, scenario.Files...)
+		event = prEvent(actionSynchronize, repo, pr, nil)
+	case ScenarioPRLabeled:
+		label := &github.Label{Name: ptr(scenario.Label)}
+		pr.Labels = append(pr.Labels, label)
+		gh.SetPullRequest(scenario.Repo, pr, scenario.Files...)
+		event = prEvent(actionLabeled, repo, pr, label)
+	case ScenarioPRUnlabeled:
+		label := &github.Label{Name: ptr(scenario.Label)}
+		pr.Labels = slices.DeleteFunc(pr.Labels, func(l *github.Label) bool { return l.GetName() == scenario.Label })
+		gh.SetPullRequest(scenario.Repo, pr, scenario.Files...)
+		event = prEvent(actionUnlabeled, repo, pr, label)
+	case ScenarioPRClosed:
+		pr.State = ptr("closed")
+		gh.SetPullRequest(scenario.Repo, pr, scenario.Files...)
+		event = prEvent(actionClosed, repo, pr, nil)
+	case ScenarioComment:
+		eventType = "issue_comment"
+		event = &github.IssueCommentEvent{
+			Action: ptr("created"),
+			Issue: &github.Issue{
+				Number:           pr.Number,
+				PullRequestLinks: &github.PullRequestLinks{URL: ptr(fmt.Sprintf("%s/repos/%s/pulls/%d", gh.URL, scenario.Repo, scenario.PullRequest))},
+			},
+			Comment: &github.IssueComment{
+				ID:   ptr(int64(1)),
+				Body: ptr(scenario.Comment),
+				User: &github.User{Login: ptr(scenario.Author)},
+			},
+			Repo:         repo,
+			Installation: &github.Installation{ID: ptr(githubfake.InstallationID)},
+		}
+	case ScenarioPush:
+		eventType = "push"
+		event = &github.PushEvent{
+			Ref:   ptr(branchRefPrefix + scenario.Branch),
+			After: pr.Head.SHA,
+			Repo: &github.PushEventRepository{
+				ID:       repo.ID,
+				Name:     repo.Name,
+				FullName: repo.FullName,
+				Owner:    repo.Owner,
+			},
+			Installation: &github.Installation{ID: ptr(githubfake.InstallationID)},
+		}
+	case ScenarioChecksRerun:
+		eventType = "check_suite"
+		event = &github.CheckSuiteEvent{
+			Action: ptr(actionRerequested),
+			CheckSuite: &github.CheckSuite{
+				ID:           ptr(int64(1)),
+				HeadSHA:      pr.Head.SHA,
+				UpdatedAt:    &github.Timestamp{Time: time.Now()},
+				PullRequests: []*github.PullRequest{{Number: pr.Number}},
+			},
+			Repo:         repo,
+			Installation: &github.Installation{ID: ptr(githubfake.InstallationID)},
+		}
+	default:
+		return fmt.Errorf("unknown scenario %q", scenario.Name)
+	}
+
+	err = dispatch(ctx, handlers, eventType, event)
+	if rec.count() == 0 {
+		fmt.Fprintln(out, "no actions")
+	}
+	if err != nil {
+		kind, _ := KindOf(err)
+		fmt.Fprintf(out, "failed (%s): %v\n", kind, err)
+	}
+	return nil
+}
+
+// prEvent returns a pull request event of the given action.
+func prEvent(action string, repo *github.Repository, pr *github.PullRequest, label *github.Label) *github.PullRequestEvent {
+	return &github.PullRequestEvent{
+		Action:       ptr(action),
+		Number:       pr.Number,
+		PullRequest:  pr,
+		Repo:         repo,
+		Label:        label,
+		Installation: &github.Installation{ID: ptr(githubfake.InstallationID)},
+	}
+}
+
+// dispatch synchronously dispatches the given event to all handlers handling its type.
+func dispatch(ctx context.Context, handlers []githubapp.EventHandler, eventType string, event interface{}) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	var errs []error
+	for _, h := range handlers {
+		if contains(h.Handles(), eventType) {
+			if err := h.Handle(ctx, eventType, "simulated", payload); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// actionRecorder writes all mutating API requests as actions once enabled.
+type actionRecorder struct {
+	out io.Writer
+
+	mu      sync.Mutex
+	enabled bool
+	actions int
+}
+
+// summaryKeys are the fields of request bodies that are included in the actions.
+var summaryKeys = []string{"state", "description", "environment", "ref", "environment_url", "body", "content", "sha"}
+
+// middleware returns a middleware recording the requests to the given API.
+func (r *actionRecorder) middleware(api string) githubapp.ClientMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Method == http.MethodGet || strings.HasSuffix(req.URL.Path, "/access_tokens") {
+				return next.RoundTrip(req)
+			}
+
+			var body []byte
+			if req.Body != nil {
+				var err error
+				body, err = io.ReadAll(req.Body)
+				if err != nil {
+					return nil, err
+				}
+				req.Body = io.NopCloser(bytes.NewReader(body))
+			}
+
+			resp, err := next.RoundTrip(req)
+			status := "error"
+			if err == nil {
+				status = fmt.Sprint(resp.StatusCode)
+			}
+			r.record(fmt.Sprintf("%-6s %-6s %s -> %s%s", api, req.Method, req.URL.Path, status, summarize(body)))
+			return resp, err
+		})
+	}
+}
+
+// summarize returns the interesting fields of the given JSON request body.
+func summarize(body []byte) string {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return ""
+	}
+	if spec, ok := fields["spec"].(map[string]interface{}); ok {
+		fields["app"] = spec["name"]
+	}
+
+	var parts []string
+	for _, k := range append([]string{"app"}, summaryKeys...) {
+		v, ok := fields[k]
+		if !ok || v == nil || v == "" {
+			continue
+		}
+		s := strings.ReplaceAll(fmt.Sprint(v), "\n", " ")
+		if len(s) > 60 {
+			s = s[:57] + "..."
+		}
+		parts = append(parts, fmt.Sprintf("%s=%q", k, s))
+	}
+	sort.Strings(parts)
+	if len(parts) == 0 {
+		return ""
+	}
+	return " " + strings.Join(parts, " ")
+}
+
+func (r *actionRecorder) enable() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enabled = true
+}
+
+func (r *actionRecorder) isEnabled() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enabled
+}
+
+func (r *actionRecorder) record(action string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.enabled {
+		return
+	}
+	r.actions++
+	fmt.Fprintln(r.out, action)
+}
+
+func (r *actionRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.actions
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
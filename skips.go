@@ -0,0 +1,153 @@
+package reviewapps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// skippedPR records why the latest event of a pull request was skipped.
+type skippedPR struct {
+	Repo        string    `json:"repo"`
+	PullRequest int       `json:"pull_request"`
+	Action      string    `json:"action"`
+	Reason      string    `json:"reason"`
+	TrackingID  string    `json:"tracking_id"`
+	Time        time.Time `json:"time"`
+
+	// installationID is the installation of the GitHub App the pull request belongs to.
+	installationID int64
+	// approval is whether or not the pull request is skipped until its head is approved.
+	approval bool
+	// since is when the pull request was first skipped for the reason.
+	since time.Time
+}
+
+// skipStore keeps the latest skip reason per pull request in memory, until the pull request is
+// acted upon again.
+type skipStore struct {
+	// adminToken authorizes requests to the skips, as they reveal repositories.
+	adminToken string
+
+	mu    sync.Mutex
+	skips map[string]skippedPR
+}
+
+func newSkipStore() *skipStore {
+	return &skipStore{skips: make(map[string]skippedPR)}
+}
+
+func skipKey(repo string, number int) string {
+	return fmt.Sprintf("%s#%d", repo, number)
+}
+
+// record records the given skip and returns whether or not its reason differs from the previously
+// recorded one.
+func (s *skipStore) record(skip skippedPR) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := skipKey(skip.Repo, skip.PullRequest)
+	prev, ok := s.skips[key]
+	changed := !ok || prev.Reason != skip.Reason
+	skip.since = skip.Time
+	if !changed {
+		skip.since = prev.since
+	}
+	s.skips[key] = skip
+	return changed
+}
+
+// clear forgets the skip of the given pull request, if any.
+func (s *skipStore) clear(repo string, number int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.skips, skipKey(repo, number))
+}
+
+// list returns all recorded skips, optionally filtered by repository and pull request, newest
+// first.
+func (s *skipStore) list(repo string, number int) []skippedPR {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	skips := make([]skippedPR, 0, len(s.skips))
+	for _, skip := range s.skips {
+		if (repo != "" && skip.Repo != repo) || (number != 0 && skip.PullRequest != number) {
+			continue
+		}
+		skips = append(skips, skip)
+	}
+	sort.Slice(skips, func(i, j int) bool { return skips[i].Time.After(skips[j].Time) })
+	return skips
+}
+
+// awaitingApproval returns the recorded skips of pull requests waiting for approval.
+func (s *skipStore) awaitingApproval() []skippedPR {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var skips []skippedPR
+	for _, skip := range s.skips {
+		if skip.approval {
+			skips = append(skips, skip)
+		}
+	}
+	sort.Slice(skips, func(i, j int) bool { return skips[i].since.Before(skips[j].since) })
+	return skips
+}
+
+// statusResponse is the body of responses of the "/status" endpoint.
+type statusResponse struct {
+	Skipped []skippedPR `json:"skipped"`
+}
+
+// ServeHTTP serves the recorded skips as JSON. They can be filtered with the "repo" and "pr" query
+// parameters. Requests must be authorized with the admin token as bearer token.
+func (s *skipStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, s.adminToken) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var number int
+	if pr := r.URL.Query().Get("pr"); pr != "" {
+		var err error
+		if number, err = strconv.Atoi(pr); err != nil {
+			http.Error(w, fmt.Sprintf("invalid pull request number %q", pr), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(statusResponse{Skipped: s.list(r.URL.Query().Get("repo"), number)})
+}
+
+// skip logs and records why an event of a pull request is skipped. If configured, the reason is
+// also commented on the pull request, once per distinct reason.
+func (h *PRHandler) skip(ctx context.Context, installationID int64, cfg ReviewAppConfig, skip skippedPR, level zerolog.Level, msg string) error {
+	zerolog.Ctx(ctx).WithLevel(level).Str("reason", skip.Reason).Msg(msg)
+
+	skip.Time = time.Now()
+	if !h.skips.record(skip) || cfg.SkipComments == "" {
+		return nil
+	}
+	if threshold, err := zerolog.ParseLevel(cfg.SkipComments); err != nil || level < threshold {
+		return nil
+	}
+
+	client, err := h.cc.NewInstallationClient(installationID)
+	if err != nil {
+		return githubError(err, "failed to create installation client")
+	}
+	owner, name, _ := strings.Cut(skip.Repo, "/")
+	target := commentTarget{owner: owner, name: name, number: skip.PullRequest}
+	return h.comments.comment(ctx, client, target, commentKindSkip, fmt.Sprintf("Review app skipped: %s.", skip.Reason))
+}
@@ -0,0 +1,47 @@
+package reviewapps
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestCanonicalQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query url.Values
+		want  string
+	}{{
+		name:  "sorted",
+		query: url.Values{"prefix": {"backups/"}, "list-type": {"2"}},
+		want:  "list-type=2&prefix=backups%2F",
+	}, {
+		name:  "continuation token",
+		query: url.Values{"continuation-token": {"1ueGcxLPRx1Tr/XYExHnhbYLgveDs2J/wm36Hy4vbOwM="}},
+		want:  "continuation-token=1ueGcxLPRx1Tr%2FXYExHnhbYLgveDs2J%2Fwm36Hy4vbOwM%3D",
+	}, {
+		name:  "reserved characters",
+		query: url.Values{"prefix": {"a b+c$d:e@f~g"}},
+		want:  "prefix=a%20b%2Bc%24d%3Ae%40f~g",
+	}, {
+		name:  "empty value",
+		query: url.Values{"uploads": {""}},
+		want:  "uploads=",
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canonicalQuery(tt.query); got != tt.want {
+				t.Errorf("canonicalQuery() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURIEncode(t *testing.T) {
+	if got, want := uriEncode("/bucket/reviewapps-state/acme/web/acme-web-1$", false), "/bucket/reviewapps-state/acme/web/acme-web-1%24"; got != want {
+		t.Errorf("uriEncode() = %q, want %q", got, want)
+	}
+	if got, want := uriEncode("a/b", true), "a%2Fb"; got != want {
+		t.Errorf("uriEncode() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,229 @@
+package reviewapps
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"github.com/rs/zerolog"
+)
+
+// rewriteGitHubSources points all GitHub sourced components of the given spec that are sourced
+// from the given repository to the given branch. Components matching any of the given sources
+// are rewritten accordingly instead. All other components are left untouched.
+func rewriteGitHubSources(spec *godo.AppSpec, repo, branch string, sources []SourceConfig, logger zerolog.Logger) {
+	godo.ForEachAppSpecComponent(spec, func(c godo.AppBuildableComponentSpec) error {
+		ref := c.GetGitHub()
+		if ref == nil {
+			return nil
+		}
+
+		// We manually kick new deployments so we can watch their status better.
+		ref.DeployOnPush = false
+
+		if src, ok := findSource(sources, c.GetName(), ref.Repo); ok {
+			if src.Component != "" && src.Repo != "" {
+				ref.Repo = src.Repo
+			}
+			ref.Branch = src.Branch
+			return nil
+		}
+
+		if ref.Repo != repo {
+			logger.Info().Msgf("leaving component %q sourced from %s@%s untouched", c.GetName(), ref.Repo, ref.Branch)
+			return nil
+		}
+		ref.Branch = branch
+		return nil
+	})
+}
+
+// findSource finds the source for the given component. Sources matching the component's name take
+// precedence over ones matching the component's repository.
+func findSource(sources []SourceConfig, component, repo string) (SourceConfig, bool) {
+	for _, src := range sources {
+		if src.Component == component {
+			return src, true
+		}
+	}
+	for _, src := range sources {
+		if src.Component == "" && src.Repo == repo {
+			return src, true
+		}
+	}
+	return SourceConfig{}, false
+}
+
+// downsizeSpec sets all sized components of the given spec to a single instance of the given size.
+func downsizeSpec(spec *godo.AppSpec, instanceSizeSlug string) {
+	for _, svc := range spec.GetServices() {
+		svc.InstanceSizeSlug = instanceSizeSlug
+		svc.InstanceCount = 1
+		svc.Autoscaling = nil
+	}
+	for _, worker := range spec.GetWorkers() {
+		worker.InstanceSizeSlug = instanceSizeSlug
+		worker.InstanceCount = 1
+		worker.Autoscaling = nil
+	}
+	for _, job := range spec.GetJobs() {
+		job.InstanceSizeSlug = instanceSizeSlug
+		job.InstanceCount = 1
+	}
+}
+
+// downscaleSpec runs a single instance of every service, worker and job of the given spec
+// without autoscaling and downgrades their instance sizes according to the given configuration.
+func downscaleSpec(spec *godo.AppSpec, cfg DownscaleConfig) {
+	if !cfg.Enabled {
+		return
+	}
+	downgrade := func(slug *string) {
+		if to, ok := cfg.InstanceSizes[*slug]; ok {
+			*slug = to
+		}
+	}
+	for _, svc := range spec.GetServices() {
+		downgrade(&svc.InstanceSizeSlug)
+		svc.InstanceCount = 1
+		svc.Autoscaling = nil
+	}
+	for _, worker := range spec.GetWorkers() {
+		downgrade(&worker.InstanceSizeSlug)
+		worker.InstanceCount = 1
+		worker.Autoscaling = nil
+	}
+	for _, job := range spec.GetJobs() {
+		downgrade(&job.InstanceSizeSlug)
+		job.InstanceCount = 1
+	}
+}
+
+// applyFeatures restricts the features of the given spec to the allowed and forced ones of the
+// given configuration and adds the missing forced ones. It returns the stripped features.
+func applyFeatures(spec *godo.AppSpec, cfg FeaturesConfig) []string {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	var (
+		kept     []string
+		stripped []string
+	)
+	for _, f := range spec.Features {
+		if slices.Contains(cfg.Allowed, f) || slices.Contains(cfg.Forced, f) {
+			kept = append(kept, f)
+		} else {
+			stripped = append(stripped, f)
+		}
+	}
+	for _, f := range cfg.Forced {
+		if !slices.Contains(kept, f) {
+			kept = append(kept, f)
+		}
+	}
+	spec.Features = kept
+	return stripped
+}
+
+// annotateSpec sets app-wide environment variables linking the app back to the pull request or
+// branch it's deployed for, so it can be traced from the DigitalOcean console.
+func annotateSpec(spec *godo.AppSpec, ra *reviewApp) {
+	annotations := []struct{ key, value string }{
+		{"REVIEW_APP_REPO_URL", ra.repo.GetHTMLURL()},
+		{"REVIEW_APP_BRANCH", ra.branch},
+		{"REVIEW_APP_SHA", ra.pr.GetHead().GetSHA()},
+		{"REVIEW_APP_PR_URL", ra.pr.GetHTMLURL()},
+	}
+	for _, a := range annotations {
+		if a.value == "" {
+			// Apps of branches have no pull request, for example.
+			continue
+		}
+		setAppEnv(spec, &godo.AppVariableDefinition{
+			Key:   a.key,
+			Value: a.value,
+			// The SHA changes with every push, which mustn't invalidate build caches.
+			Scope: godo.AppVariableScope_RunTime,
+			Type:  godo.AppVariableType_General,
+		})
+	}
+}
+
+// setAppEnv sets the given app-wide environment variable of the given spec, replacing any existing
+// variable of the same key.
+func setAppEnv(spec *godo.AppSpec, env *godo.AppVariableDefinition) {
+	replaced := false
+	for i, existing := range spec.Envs {
+		if existing.Key == env.Key {
+			spec.Envs[i] = env
+			replaced = true
+		}
+	}
+	if !replaced {
+		spec.Envs = append(spec.Envs, env)
+	}
+}
+
+const (
+	// ownershipMarkerKey is the app-wide environment variable marking apps as managed by review
+	// apps. Apps without it are never deleted.
+	ownershipMarkerKey   = "REVIEW_APP_MANAGED_BY"
+	ownershipMarkerValue = "app-platform-review-apps"
+)
+
+// markOwned marks the given spec as the spec of an app managed by review apps.
+func markOwned(spec *godo.AppSpec) {
+	setAppEnv(spec, &godo.AppVariableDefinition{
+		Key:   ownershipMarkerKey,
+		Value: ownershipMarkerValue,
+		Scope: godo.AppVariableScope_RunTime,
+		Type:  godo.AppVariableType_General,
+	})
+}
+
+// unmarkOwned removes the ownership marker from the given spec.
+func unmarkOwned(spec *godo.AppSpec) {
+	envs := make([]*godo.AppVariableDefinition, 0, len(spec.Envs))
+	for _, env := range spec.Envs {
+		if env.Key != ownershipMarkerKey {
+			envs = append(envs, env)
+		}
+	}
+	spec.Envs = envs
+}
+
+// isOwned returns whether or not the given spec carries the ownership marker.
+func isOwned(spec *godo.AppSpec) bool {
+	for _, env := range spec.GetEnvs() {
+		if env.Key == ownershipMarkerKey && env.Value == ownershipMarkerValue {
+			return true
+		}
+	}
+	return false
+}
+
+// hasMarkers returns whether or not the given spec carries the ownership marker or the pull request
+// marker, with any value. Apps created before the markers existed carry neither.
+func hasMarkers(spec *godo.AppSpec) bool {
+	for _, env := range spec.GetEnvs() {
+		if env.Key == ownershipMarkerKey || env.Key == pullRequestMarkerKey {
+			return true
+		}
+	}
+	return false
+}
+
+// isReviewAppOf returns whether or not the given spec is owned and records the pull request of the
+// given review app, or no pull request for review apps of branches. Apps named like the review app
+// might belong to another repository whose name slugs the same, or not be a review app at all.
+func isReviewAppOf(spec *godo.AppSpec, ra *reviewApp) bool {
+	if !isOwned(spec) {
+		return false
+	}
+	repo, number, ok := pullRequestOf(spec)
+	if ra.number == 0 {
+		return !ok
+	}
+	return ok && strings.EqualFold(repo, ra.repo.GetFullName()) && number == ra.number
+}
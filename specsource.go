@@ -0,0 +1,227 @@
+package reviewapps
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v60/github"
+)
+
+// artifactClient downloads the workflow artifacts app specs are read from.
+var artifactClient = &http.Client{Timeout: 5 * time.Minute}
+
+const (
+	// maxArtifactBytes caps how much of an artifact is downloaded.
+	maxArtifactBytes = 64 << 20
+	// maxSpecBytes caps how much of an app spec is read from an artifact.
+	maxSpecBytes = 1 << 20
+)
+
+// generateSpec runs the configured spec generation command and returns its output. The command is
+// passed the pull request's details via the environment, and only the PATH and HOME of the
+// service's, so it never sees the service's credentials.
+func generateSpec(ctx context.Context, ra *reviewApp) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, ra.cfg.Spec.GetTimeout())
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ra.cfg.Spec.Command[0], ra.cfg.Spec.Command[1:]...)
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + os.Getenv("HOME"),
+		"REVIEWAPPS_REPO=" + ra.repo.GetFullName(),
+		"REVIEWAPPS_PR=" + strconv.Itoa(ra.number),
+		"REVIEWAPPS_BRANCH=" + ra.branch,
+		"REVIEWAPPS_SHA=" + ra.pr.GetHead().GetSHA(),
+		"REVIEWAPPS_APP_NAME=" + ra.appName,
+		"REVIEWAPPS_VARIANT=" + ra.directives.Variant,
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errorf(ErrorKindSpecNotFound, "failed to generate app spec: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// artifactSpec waits for the configured workflow to finish for the pull request's head and returns
+// the app spec from the configured artifact of that workflow run.
+func artifactSpec(ctx context.Context, ra *reviewApp) ([]byte, error) {
+	cfg := ra.cfg.Spec.Artifact
+	run, err := waitForWorkflowRun(ctx, ra, cfg.Workflow, ra.cfg.Spec.GetTimeout())
+	if err != nil {
+		return nil, err
+	}
+	if run.GetConclusion() != "success" {
+		return nil, errorf(ErrorKindSpecNotFound, "workflow run %s of %s concluded with %s", run.GetHTMLURL(), cfg.Workflow, run.GetConclusion())
+	}
+
+	artifacts, _, err := ra.client.Actions.ListWorkflowRunArtifacts(ctx, ra.owner, ra.name, run.GetID(), &github.ListOptions{PerPage: 100})
+	if err != nil {
+		return nil, githubError(err, "failed to list workflow run artifacts")
+	}
+	var artifact *github.Artifact
+	for _, a := range artifacts.Artifacts {
+		if a.GetName() == cfg.Name {
+			artifact = a
+			break
+		}
+	}
+	if artifact == nil {
+		return nil, errorf(ErrorKindSpecNotFound, "workflow run %s has no artifact %q", run.GetHTMLURL(), cfg.Name)
+	}
+
+	url, _, err := ra.client.Actions.DownloadArtifact(ctx, ra.owner, ra.name, artifact.GetID(), 1)
+	if err != nil {
+		return nil, githubError(err, "failed to get artifact download URL")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create artifact request: %w", err)
+	}
+	resp, err := artifactClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download artifact: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download artifact: unexpected status %d", resp.StatusCode)
+	}
+	archive, err := io.ReadAll(io.LimitReader(resp.Body, maxArtifactBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download artifact: %w", err)
+	}
+	if len(archive) > maxArtifactBytes {
+		return nil, errorf(ErrorKindSpecInvalid, "artifact %q is larger than %d bytes", cfg.Name, maxArtifactBytes)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, errorf(ErrorKindSpecInvalid, "failed to open artifact %q: %w", cfg.Name, err)
+	}
+	f, err := zr.Open(cfg.GetFile())
+	if err != nil {
+		return nil, errorf(ErrorKindSpecNotFound, "no app spec found at %s in artifact %q: %w", cfg.GetFile(), cfg.Name, err)
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Size() > maxSpecBytes {
+		return nil, errorf(ErrorKindSpecInvalid, "app spec %s in artifact %q is larger than %d bytes", cfg.GetFile(), cfg.Name, maxSpecBytes)
+	}
+	// The sizes recorded in the archive can lie, so the read is limited as well.
+	spec, err := io.ReadAll(io.LimitReader(f, maxSpecBytes+1))
+	if err != nil {
+		return nil, errorf(ErrorKindSpecInvalid, "failed to read app spec %s in artifact %q: %w", cfg.GetFile(), cfg.Name, err)
+	}
+	if len(spec) > maxSpecBytes {
+		return nil, errorf(ErrorKindSpecInvalid, "app spec %s in artifact %q is larger than %d bytes", cfg.GetFile(), cfg.Name, maxSpecBytes)
+	}
+	return spec, nil
+}
+
+// waitForWorkflowRun waits for the latest run of the given workflow for the pull request's head to
+// complete.
+func waitForWorkflowRun(ctx context.Context, ra *reviewApp, workflow string, timeout time.Duration) (*github.WorkflowRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	t := time.NewTicker(10 * time.Second)
+	defer t.Stop()
+	for {
+		runs, _, err := ra.client.Actions.ListWorkflowRunsByFileName(ctx, ra.owner, ra.name, workflow, &github.ListWorkflowRunsOptions{
+			HeadSHA: ra.pr.GetHead().GetSHA(),
+		})
+		if err != nil {
+			return nil, githubError(err, "failed to list workflow runs")
+		}
+		if len(runs.WorkflowRuns) > 0 && runs.WorkflowRuns[0].GetStatus() == "completed" {
+			return runs.WorkflowRuns[0], nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errorf(ErrorKindSpecNotFound, "failed to wait for workflow %s to complete: %w", workflow, ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+// specLocations returns the candidate locations of the app spec in the repository, in order. A
+// variant selected by the directives takes precedence over the repository's configuration, which
+// takes precedence over the configured locations.
+func specLocations(d prDirectives, c *repoConfig, cfg SpecConfig) []string {
+	switch {
+	case d.Variant != "":
+		return []string{d.specLocation()}
+	case c.Spec != "":
+		return []string{c.Spec}
+	}
+	return cfg.GetLocations()
+}
+
+// committedSpec fetches the app spec from the first of its candidate locations that exists on the
+// pull request's branch. If none does, that's explained on the pull request.
+func (h *PRHandler) committedSpec(ctx context.Context, ra *reviewApp) ([]byte, error) {
+	repoCfg, err := h.repoConfig(ctx, ra)
+	if err != nil {
+		return nil, err
+	}
+	locations := specLocations(ra.directives, repoCfg, ra.cfg.Spec)
+	if ra.spec != "" {
+		locations = []string{ra.specLocation()}
+	}
+	for _, location := range locations {
+		content, err := fileContent(ctx, ra.client, ra.owner, ra.name, location, ra.ref)
+		if errors.Is(err, ErrSpecNotFound) {
+			continue
+		} else if err == nil {
+			ra.specPath = location
+		}
+		return content, err
+	}
+
+	// Apps of branches have no pull request to comment on.
+	if ra.number != 0 {
+		body := fmt.Sprintf("### No app spec found\n\nThe review app can't be deployed as none of the following files exist on `%s`:\n\n- `%s`", ra.branch, strings.Join(locations, "`\n- `"))
+		if err := h.comment(ctx, ra, commentKindSpec, body); err != nil {
+			return nil, err
+		}
+	}
+	return nil, errorf(ErrorKindSpecNotFound, "no app spec found at %s", strings.Join(locations, ", "))
+}
+
+// githubRepoURL matches the owner and name of GitHub repositories in git URLs.
+var githubRepoURL = regexp.MustCompile(`github\.com[:/]([^/]+)/([^/]+?)(?:\.git)?$`)
+
+// submoduleSpec fetches the app spec from the configured submodule, at the commit the pull
+// request's branch points the submodule to.
+func submoduleSpec(ctx context.Context, ra *reviewApp) ([]byte, error) {
+	submodule := ra.cfg.Spec.Submodule
+	content, _, _, err := ra.client.Repositories.GetContents(ctx, ra.owner, ra.name, submodule, &github.RepositoryContentGetOptions{
+		Ref: ra.ref,
+	})
+	if isGitHubNotFound(err) {
+		return nil, errorf(ErrorKindSpecNotFound, "no submodule found at %s: %w", submodule, err)
+	} else if err != nil {
+		return nil, githubError(err, "failed to fetch submodule")
+	}
+	if content == nil || content.GetType() != "submodule" {
+		return nil, errorf(ErrorKindSpecNotFound, "%s is not a submodule", submodule)
+	}
+
+	match := githubRepoURL.FindStringSubmatch(content.GetSubmoduleGitURL())
+	if match == nil {
+		return nil, errorf(ErrorKindSpecNotFound, "submodule %s is not hosted on GitHub: %s", submodule, content.GetSubmoduleGitURL())
+	}
+	return fileContent(ctx, ra.client, match[1], match[2], ra.directives.specLocation(), content.GetSHA())
+}
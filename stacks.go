@@ -0,0 +1,200 @@
+package reviewapps
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v60/github"
+)
+
+const (
+	// stackModeTop only deploys the top of stacks of pull requests.
+	stackModeTop = "top"
+	// stackModeLink deploys all pull requests of stacks and links their previews.
+	stackModeLink = "link"
+
+	// maxStackDepth is the maximum amount of pull requests below a pull request that are followed,
+	// guarding against cycles of retargeted pull requests.
+	maxStackDepth = 10
+)
+
+// stackedOn returns the open pull request the review app's pull request is stacked on, i.e. whose
+// head branch is its base branch, if any.
+func stackedOn(ctx context.Context, ra *reviewApp) (*github.PullRequest, error) {
+	base := ra.pr.GetBase().GetRef()
+	if base == "" || base == ra.repo.GetDefaultBranch() {
+		return nil, nil
+	}
+	prs, _, err := ra.client.PullRequests.List(ctx, ra.owner, ra.name, &github.PullRequestListOptions{
+		State: "open",
+		Head:  ra.owner + ":" + base,
+	})
+	if err != nil {
+		return nil, githubError(err, "failed to list pull requests below")
+	}
+	for _, pr := range prs {
+		if !isFork(ra.repo, pr) && pr.GetHead().GetRef() == base && pr.GetNumber() != ra.number {
+			return pr, nil
+		}
+	}
+	return nil, nil
+}
+
+// stackedAbove returns the open pull requests stacked on the review app's pull request, i.e. whose
+// base branch is its head branch.
+func stackedAbove(ctx context.Context, ra *reviewApp) ([]*github.PullRequest, error) {
+	if ra.fork {
+		// Branches of forks can't be the base of pull requests.
+		return nil, nil
+	}
+	prs, _, err := ra.client.PullRequests.List(ctx, ra.owner, ra.name, &github.PullRequestListOptions{
+		State:       "open",
+		Base:        ra.branch,
+		ListOptions: github.ListOptions{PerPage: 100},
+	})
+	if err != nil {
+		return nil, githubError(err, "failed to list pull requests above")
+	}
+	var above []*github.PullRequest
+	for _, pr := range prs {
+		if pr.GetBase().GetRef() == ra.branch && pr.GetNumber() != ra.number {
+			above = append(above, pr)
+		}
+	}
+	return above, nil
+}
+
+// stackedReviewApp returns the reviewApp of the given pull request that is stacked with the given
+// review app's one.
+func stackedReviewApp(ra *reviewApp, pr *github.PullRequest) *reviewApp {
+	return &reviewApp{
+		client:       ra.client,
+		cfg:          ra.cfg,
+		logger:       ra.logger.With().Int("stacked_pr_num", pr.GetNumber()).Logger(),
+		repo:         ra.repo,
+		pr:           pr,
+		owner:        ra.owner,
+		name:         ra.name,
+		number:       pr.GetNumber(),
+		branch:       pr.GetHead().GetRef(),
+		ref:          pr.GetHead().GetRef(),
+		sourceBranch: pr.GetHead().GetRef(),
+		fork:         isFork(ra.repo, pr),
+		appName:      ra.namer.PullRequestAppName(ra.owner, ra.name, pr.GetNumber()),
+		namer:        ra.namer,
+	}
+}
+
+// deployTopOfStack returns why the review app isn't deployed if its pull request is below the top
+// of its stack. Otherwise, it tears down the review apps of the pull requests below it, whose
+// changes it includes.
+func (h *PRHandler) deployTopOfStack(ctx context.Context, ra *reviewApp) (string, error) {
+	above, err := stackedAbove(ctx, ra)
+	if err != nil {
+		return "", err
+	}
+	if len(above) > 0 {
+		reason := fmt.Sprintf("only the top of its stack of pull requests, #%d, is deployed", above[0].GetNumber())
+		if err := h.teardown(ctx, ra, reason); err != nil {
+			return "", err
+		}
+		return reason, nil
+	}
+
+	below := ra
+	for i := 0; i < maxStackDepth; i++ {
+		pr, err := stackedOn(ctx, below)
+		if err != nil || pr == nil {
+			return "", err
+		}
+		below = stackedReviewApp(ra, pr)
+		if err := h.teardown(ctx, below, fmt.Sprintf("it's below the top of its stack of pull requests, #%d", ra.number)); err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// linkStack comments the previews of all pull requests of the review app's stack on each of them.
+// Pull requests that aren't stacked aren't commented on.
+func (h *PRHandler) linkStack(ctx context.Context, ra *reviewApp) error {
+	stack := []*reviewApp{ra}
+	for i := 0; i < maxStackDepth; i++ {
+		pr, err := stackedOn(ctx, stack[0])
+		if err != nil {
+			return err
+		}
+		if pr == nil {
+			break
+		}
+		stack = append([]*reviewApp{stackedReviewApp(ra, pr)}, stack...)
+	}
+	above, err := stackedAbove(ctx, ra)
+	if err != nil {
+		return err
+	}
+	for _, pr := range above {
+		stack = append(stack, stackedReviewApp(ra, pr))
+	}
+	if len(stack) == 1 {
+		return nil
+	}
+
+	previews := make([]string, len(stack))
+	for i, member := range stack {
+		previews[i], err = h.previewURL(ctx, member)
+		if err != nil {
+			return err
+		}
+	}
+	for _, member := range stack {
+		if member.pr.GetState() == "closed" {
+			continue
+		}
+		if err := h.comment(ctx, member, commentKindStack, stackComment(stack, previews, member.number)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// previewURL returns the live URL of the review app, or an empty string if it has none.
+func (h *PRHandler) previewURL(ctx context.Context, ra *reviewApp) (string, error) {
+	deployment, _, err := h.liveDeployment(ctx, ra)
+	if err != nil || deployment == nil {
+		return "", err
+	}
+	status, err := latestDeploymentStatus(ctx, ra.client, ra.owner, ra.name, deployment.GetID())
+	if err != nil || status.GetState() != deploymentStateSuccess {
+		return "", err
+	}
+	return status.GetEnvironmentURL(), nil
+}
+
+// stackComment returns the comment linking the previews of the given stack, from its bottom to its
+// top, on the given pull request of it.
+func stackComment(stack []*reviewApp, previews []string, number int) string {
+	var b strings.Builder
+	b.WriteString("This pull request is part of a stack of pull requests:\n\n")
+	for i, member := range stack {
+		ref := fmt.Sprintf("#%d", member.number)
+		if member.number == number {
+			ref = fmt.Sprintf("**#%d** (this pull request)", member.number)
+		}
+		preview := "no review app"
+		if previews[i] != "" {
+			preview = fmt.Sprintf("[preview](%s)", previews[i])
+		}
+		fmt.Fprintf(&b, "- %s: %s\n", ref, preview)
+	}
+	return b.String()
+}
+
+// relinkStack links the previews of the review app's stack like linkStack. Links are only a
+// convenience, so failing to is logged instead of failing the event.
+func (h *PRHandler) relinkStack(ctx context.Context, ra *reviewApp) {
+	if err := h.linkStack(ctx, ra); err != nil {
+		ra.logger.Warn().Err(err).Msg("failed to link previews of stacked pull requests")
+	}
+}
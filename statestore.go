@@ -0,0 +1,49 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+
+	"github.internal.digitalocean.com/mthoemmes/reviewapps/store"
+)
+
+// newStateStore returns the configured state store.
+func newStateStore(c StateStoreConfig) store.Store {
+	if c.GetType() == stateStoreSpaces {
+		return store.NewObjects(NewSpacesClient(c.Spaces), c.GetPrefix())
+	}
+	return store.NewMemory()
+}
+
+// storeKey returns the key of the review app in the state store.
+func (ra *reviewApp) storeKey() store.Key {
+	return store.Key{Repo: ra.repo.GetFullName(), App: ra.appName}
+}
+
+// recordApp records the given app as the review app's app. The GitHub deployments still record it,
+// so failing to is logged instead of failing the deployment.
+func (h *PRHandler) recordApp(ctx context.Context, ra *reviewApp, appID string) {
+	if err := h.store.Put(ctx, ra.storeKey(), appID); err != nil {
+		ra.logger.Warn().Err(err).Str("app_id", appID).Msg("failed to record app in state store")
+	}
+}
+
+// recordedApp returns the ID of the review app's app recorded in the state store, or an empty
+// string if there is none. Failing lookups are logged, so callers fall back to GitHub deployments.
+func (h *PRHandler) recordedApp(ctx context.Context, ra *reviewApp) string {
+	appID, err := h.store.Get(ctx, ra.storeKey())
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			ra.logger.Warn().Err(err).Msg("failed to look up app in state store")
+		}
+		return ""
+	}
+	return appID
+}
+
+// forgetApp removes the review app's app from the state store once it's deleted.
+func (h *PRHandler) forgetApp(ctx context.Context, ra *reviewApp) {
+	if err := h.store.Delete(ctx, ra.storeKey()); err != nil {
+		ra.logger.Warn().Err(err).Msg("failed to remove app from state store")
+	}
+}
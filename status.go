@@ -0,0 +1,68 @@
+package reviewapps
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// statusPhases are the phases shown by the status comment per lifecycle event. Events without a
+// phase don't change the status.
+var statusPhases = map[LifecycleEventType]string{
+	LifecycleDeploymentStarted:   "🚧 Deploying",
+	LifecycleDeploymentSucceeded: "✅ Live",
+	LifecycleDeploymentFailed:    "❌ Failed",
+	LifecycleAppDeleted:          "🗑️ Torn down",
+}
+
+// updateStatusComment updates the status comment of the review app with the given event, if
+// configured. Task previews report their outcome in their own comment and apps of branches have no
+// pull request to comment on.
+func (h *PRHandler) updateStatusComment(ctx context.Context, ra *reviewApp, event LifecycleEvent) {
+	phase, ok := statusPhases[event.Type]
+	if !ok || !ra.cfg.StatusComment || ra.cfg.Task || ra.number == 0 {
+		return
+	}
+
+	liveURL := event.LiveURL
+	if liveURL == "" && event.Type != LifecycleAppDeleted && event.AppID != "" {
+		// Redeployed apps stay reachable under their live URL while they're deploying.
+		if app, _, err := h.do.Apps.Get(ctx, event.AppID); err == nil {
+			liveURL = app.GetLiveURL()
+		}
+	}
+	liveURL = h.frontedURL(ra, liveURL)
+	if err := h.comment(ctx, ra, commentKindStatus, statusComment(ra, event, phase, liveURL, time.Now())); err != nil {
+		ra.logger.Error().Err(err).Msg("failed to update status comment")
+	}
+}
+
+// statusComment returns the body of the status comment of the review app.
+func statusComment(ra *reviewApp, event LifecycleEvent, phase, liveURL string, now time.Time) string {
+	var body strings.Builder
+	fmt.Fprintf(&body, "### Review app `%s`\n\n", ra.appName)
+	fmt.Fprintf(&body, "| | |\n|---|---|\n")
+	fmt.Fprintf(&body, "| **Status** | %s |\n", phase)
+	if liveURL != "" && event.Type != LifecycleAppDeleted {
+		fmt.Fprintf(&body, "| **Live URL** | %s |\n", liveURL)
+	}
+	if event.DeploymentID != "" {
+		fmt.Fprintf(&body, "| **Deployment** | [%s](%s) |\n", event.DeploymentID, deploymentDashboardURL(event.AppID, event.DeploymentID))
+	}
+	fmt.Fprintf(&body, "| **Commit** | %s |\n", ra.pr.GetHead().GetSHA())
+	fmt.Fprintf(&body, "| **Updated** | %s |\n", now.UTC().Format("2006-01-02 15:04:05 MST"))
+
+	if event.Type == LifecycleAppDeleted {
+		if ra.pr.GetState() != "closed" {
+			fmt.Fprintf(&body, "\nComment `%s` to deploy it again.\n", commandDeploy)
+		}
+		return body.String()
+	}
+	fmt.Fprintf(&body, "\nComment `%s` to tear the review app down", commandTeardown)
+	if len(ra.cfg.TeardownLabels) > 0 {
+		fmt.Fprintf(&body, " or add the label `%s`", ra.cfg.TeardownLabels[0])
+	}
+	body.WriteString(". It's torn down automatically once the pull request is closed.\n")
+	return body.String()
+}
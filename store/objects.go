@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ObjectStorage stores objects by key, like DigitalOcean Spaces.
+type ObjectStorage interface {
+	Put(ctx context.Context, key string, content []byte) error
+	// Get returns the content stored under the given key or an error wrapping ErrNotFound if
+	// there is none.
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	// Keys returns the keys of all objects whose key has the given prefix.
+	Keys(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Objects is a Store keeping the app of each review app in an object of its own.
+type Objects struct {
+	storage ObjectStorage
+	prefix  string
+}
+
+// NewObjects returns a new Objects store keeping its objects in the given storage under the given
+// prefix.
+func NewObjects(storage ObjectStorage, prefix string) *Objects {
+	return &Objects{storage: storage, prefix: prefix}
+}
+
+func (o *Objects) Get(ctx context.Context, key Key) (string, error) {
+	content, err := o.storage.Get(ctx, o.key(key))
+	if errors.Is(err, ErrNotFound) {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", fmt.Errorf("failed to get app: %w", err)
+	}
+	return string(content), nil
+}
+
+func (o *Objects) Put(ctx context.Context, key Key, appID string) error {
+	if err := o.storage.Put(ctx, o.key(key), []byte(appID)); err != nil {
+		return fmt.Errorf("failed to put app: %w", err)
+	}
+	return nil
+}
+
+func (o *Objects) Delete(ctx context.Context, key Key) error {
+	if err := o.storage.Delete(ctx, o.key(key)); err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to delete app: %w", err)
+	}
+	return nil
+}
+
+func (o *Objects) List(ctx context.Context, repo string) ([]Key, error) {
+	prefix := o.prefix + repo + "/"
+	objects, err := o.storage.Keys(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list apps: %w", err)
+	}
+	keys := make([]Key, 0, len(objects))
+	for _, object := range objects {
+		escaped := strings.TrimPrefix(object, prefix)
+		app, err := url.PathUnescape(escaped)
+		if err != nil || strings.Contains(escaped, "/") {
+			// Not an object of this repository's review apps.
+			continue
+		}
+		keys = append(keys, Key{Repo: repo, App: app})
+	}
+	return keys, nil
+}
+
+// key returns the key of the object of the given review app. The repository's owner and name
+// can't contain slashes, so they map to distinct objects.
+func (o *Objects) key(key Key) string {
+	return o.prefix + key.Repo + "/" + url.PathEscape(key.App)
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Dialect is the SQL dialect of a database.
+type Dialect string
+
+const (
+	DialectSQLite   Dialect = "sqlite"
+	DialectPostgres Dialect = "postgres"
+)
+
+// SQL is a Store keeping the apps in a table of a SQLite or Postgres database. The database's
+// driver has to be registered by the binary, as the service doesn't depend on any.
+type SQL struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+// NewSQL returns a new SQL store in the given database of the given dialect, creating its table
+// if it doesn't exist yet.
+func NewSQL(ctx context.Context, db *sql.DB, dialect Dialect) (*SQL, error) {
+	if dialect != DialectSQLite && dialect != DialectPostgres {
+		return nil, fmt.Errorf("unknown SQL dialect %q", dialect)
+	}
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS reviewapps_apps (
+		repo TEXT NOT NULL,
+		app TEXT NOT NULL,
+		app_id TEXT NOT NULL,
+		PRIMARY KEY (repo, app)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create table: %w", err)
+	}
+	return &SQL{db: db, dialect: dialect}, nil
+}
+
+func (s *SQL) Get(ctx context.Context, key Key) (string, error) {
+	var appID string
+	err := s.db.QueryRowContext(ctx, s.query("SELECT app_id FROM reviewapps_apps WHERE repo = ? AND app = ?"), key.Repo, key.App).Scan(&appID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", fmt.Errorf("failed to get app: %w", err)
+	}
+	return appID, nil
+}
+
+func (s *SQL) Put(ctx context.Context, key Key, appID string) error {
+	// Both dialects support upserts of this form.
+	_, err := s.db.ExecContext(ctx, s.query("INSERT INTO reviewapps_apps (repo, app, app_id) VALUES (?, ?, ?) ON CONFLICT (repo, app) DO UPDATE SET app_id = excluded.app_id"), key.Repo, key.App, appID)
+	if err != nil {
+		return fmt.Errorf("failed to put app: %w", err)
+	}
+	return nil
+}
+
+func (s *SQL) Delete(ctx context.Context, key Key) error {
+	_, err := s.db.ExecContext(ctx, s.query("DELETE FROM reviewapps_apps WHERE repo = ? AND app = ?"), key.Repo, key.App)
+	if err != nil {
+		return fmt.Errorf("failed to delete app: %w", err)
+	}
+	return nil
+}
+
+func (s *SQL) List(ctx context.Context, repo string) ([]Key, error) {
+	rows, err := s.db.QueryContext(ctx, s.query("SELECT app FROM reviewapps_apps WHERE repo = ?"), repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list apps: %w", err)
+	}
+	defer rows.Close()
+	var keys []Key
+	for rows.Next() {
+		key := Key{Repo: repo}
+		if err := rows.Scan(&key.App); err != nil {
+			return nil, fmt.Errorf("failed to list apps: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list apps: %w", err)
+	}
+	return keys, nil
+}
+
+// query returns the given query with "?" placeholders in the store's dialect.
+func (s *SQL) query(q string) string {
+	if s.dialect != DialectPostgres {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
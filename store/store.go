@@ -0,0 +1,87 @@
+// Package store persists which App Platform app belongs to which review app, so the service
+// doesn't have to rely on the payloads of GitHub deployments alone to find it. Deployments are
+// paged, can be deleted by users and can be created by other tools in the same environment, all of
+// which lose track of the app.
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrNotFound is returned if no app is recorded for a review app.
+var ErrNotFound = errors.New("not found")
+
+// Key identifies a review app by the full name of its repository, i.e. "owner/name", and its app
+// name. Review apps of pull requests are named after them, so the name identifies the pull request.
+type Key struct {
+	Repo string
+	App  string
+}
+
+// Store records the IDs of the apps of review apps. Implementations must be safe for concurrent
+// use.
+type Store interface {
+	// Get returns the ID of the app of the given review app or ErrNotFound if none is recorded.
+	Get(ctx context.Context, key Key) (string, error)
+	// Put records the ID of the app of the given review app.
+	Put(ctx context.Context, key Key, appID string) error
+	// Delete forgets the app of the given review app. Deleting a review app without an app is
+	// not an error.
+	Delete(ctx context.Context, key Key) error
+}
+
+// Lister is implemented by Stores that can list their keys.
+type Lister interface {
+	// List returns the keys of all review apps of the given repository.
+	List(ctx context.Context, repo string) ([]Key, error)
+}
+
+// Memory is a Store keeping the apps in memory. They're lost on restarts, so it only saves
+// lookups of GitHub deployments.
+type Memory struct {
+	mu   sync.Mutex
+	apps map[Key]string
+}
+
+// NewMemory returns a new, empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{apps: make(map[Key]string)}
+}
+
+func (m *Memory) Get(_ context.Context, key Key) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	appID, ok := m.apps[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return appID, nil
+}
+
+func (m *Memory) Put(_ context.Context, key Key, appID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.apps[key] = appID
+	return nil
+}
+
+func (m *Memory) Delete(_ context.Context, key Key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.apps, key)
+	return nil
+}
+
+func (m *Memory) List(_ context.Context, repo string) ([]Key, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []Key
+	for key := range m.apps {
+		if key.Repo == repo {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,149 @@
+package reviewapps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// streamBuffer is the amount of events buffered per client. Events of clients that don't keep up
+// are dropped.
+const streamBuffer = 64
+
+// streamedEvent is a lifecycle event as streamed to clients.
+type streamedEvent struct {
+	Type         LifecycleEventType `json:"type"`
+	Repo         string             `json:"repo"`
+	PullRequest  int                `json:"pull_request"`
+	AppName      string             `json:"app_name"`
+	AppID        string             `json:"app_id,omitempty"`
+	DeploymentID string             `json:"deployment_id,omitempty"`
+	LiveURL      string             `json:"live_url,omitempty"`
+	Time         time.Time          `json:"time"`
+}
+
+// eventStream streams the lifecycle events of review apps to HTTP clients as server-sent events,
+// so dashboards can show the live status of review apps without polling.
+type eventStream struct {
+	// apiToken and adminToken authorize clients, as events reveal repositories and preview URLs.
+	apiToken   string
+	adminToken string
+
+	mu      sync.Mutex
+	clients map[*streamClient]struct{}
+}
+
+// streamClient is a client of the eventStream, optionally filtered by repository and pull request.
+type streamClient struct {
+	repo   string
+	number int
+	events chan streamedEvent
+}
+
+func newEventStream(apiToken, adminToken string) *eventStream {
+	return &eventStream{apiToken: apiToken, adminToken: adminToken, clients: make(map[*streamClient]struct{})}
+}
+
+// OnLifecycleEvent implements LifecycleListener.
+func (s *eventStream) OnLifecycleEvent(ctx context.Context, event LifecycleEvent) {
+	streamed := streamedEvent{
+		Type:         event.Type,
+		Repo:         event.Repo,
+		PullRequest:  event.PullRequest,
+		AppName:      event.AppName,
+		AppID:        event.AppID,
+		DeploymentID: event.DeploymentID,
+		LiveURL:      event.LiveURL,
+		Time:         time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		if (c.repo != "" && c.repo != event.Repo) || (c.number != 0 && c.number != event.PullRequest) {
+			continue
+		}
+		select {
+		case c.events <- streamed:
+		default:
+			zerolog.Ctx(ctx).Debug().Msg("dropping lifecycle event for slow stream client")
+		}
+	}
+}
+
+// subscribe registers a new client with the given filters.
+func (s *eventStream) subscribe(repo string, number int) *streamClient {
+	c := &streamClient{repo: repo, number: number, events: make(chan streamedEvent, streamBuffer)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[c] = struct{}{}
+	return c
+}
+
+// unsubscribe removes the given client.
+func (s *eventStream) unsubscribe(c *streamClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, c)
+}
+
+// ServeHTTP streams lifecycle events as server-sent events until the client disconnects. They can
+// be filtered with the "repo" and "pr" query parameters. Requests must be authorized with the API or
+// admin token as bearer token.
+func (s *eventStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, s.apiToken) && !authorized(r, s.adminToken) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	repo := r.URL.Query().Get("repo")
+	var number int
+	if pr := r.URL.Query().Get("pr"); pr != "" {
+		var err error
+		if number, err = strconv.Atoi(pr); err != nil {
+			http.Error(w, fmt.Sprintf("invalid pull request number %q", pr), http.StatusBadRequest)
+			return
+		}
+		if repo == "" {
+			http.Error(w, "the pr query parameter requires the repo query parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	c := s.subscribe(repo, number)
+	defer s.unsubscribe(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Comments keep proxies from closing idle connections.
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case event := <-c.events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
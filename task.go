@@ -0,0 +1,77 @@
+package reviewapps
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-github/v60/github"
+)
+
+// taskLogLines is the amount of log lines of each job that are reported for task previews.
+const taskLogLines = 50
+
+// pruneToTask removes all components but jobs and databases from the given spec.
+func pruneToTask(spec *godo.AppSpec) error {
+	if len(spec.GetJobs()) == 0 {
+		return errorf(ErrorKindSpecInvalid, "task previews need at least one job in the app spec")
+	}
+	spec.Services = nil
+	spec.StaticSites = nil
+	spec.Workers = nil
+	spec.Functions = nil
+	spec.Ingress = nil
+	return nil
+}
+
+// runTask waits for the given deployment of a task preview to finish, reports the outcome and the
+// logs of its jobs on the pull request and deletes the app afterwards.
+func (h *PRHandler) runTask(ctx context.Context, ra *reviewApp, appID, deploymentID string, ghDeploymentID int64) error {
+	h.notify(ctx, ra, ra.lifecycleEvent(LifecycleDeploymentStarted, appID, deploymentID, ""))
+
+	d, err := h.waitForDeploymentTerminal(ctx, appID, deploymentID)
+	if err != nil {
+		return fmt.Errorf("failed to wait deployment to finish: %w", err)
+	}
+	recordDeployment(ra.repo.GetFullName(), d)
+	succeeded := d.GetPhase() == godo.DeploymentPhase_Active
+
+	outcome, state, typ := "succeeded", deploymentStateSuccess, LifecycleDeploymentSucceeded
+	if !succeeded {
+		outcome, state, typ = "failed", deploymentStateError, LifecycleDeploymentFailed
+	}
+	ra.logger.Info().Msgf("task %s", outcome)
+	h.notify(ctx, ra, ra.lifecycleEvent(typ, appID, deploymentID, ""))
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "### Task preview `%s` %s\n", ra.appName, outcome)
+	for _, job := range d.GetSpec().GetJobs() {
+		logs, err := fetchLogTail(ctx, h.do, appID, deploymentID, job.GetName(), godo.AppLogTypeDeploy, taskLogLines)
+		if err != nil {
+			ra.logger.Error().Err(err).Str("job", job.GetName()).Msg("failed to fetch job logs")
+			logs = "Failed to fetch logs."
+		}
+		fmt.Fprintf(&body, "\n<details><summary>Logs of job <code>%s</code></summary>\n\n```\n%s\n```\n</details>\n", job.GetName(), logs)
+	}
+
+	if err := h.comment(ctx, ra, commentKindTask, body.String()); err != nil {
+		return err
+	}
+
+	_, _, err = ra.client.Repositories.CreateDeploymentStatus(ctx, ra.owner, ra.name, ghDeploymentID, &github.DeploymentStatusRequest{
+		State:        ptr(state),
+		Description:  ptr(fmt.Sprintf("Task %s", outcome)),
+		AutoInactive: ptr(true),
+	})
+	if err != nil {
+		return githubError(err, "failed to update deployment")
+	}
+
+	ra.logger.Info().Msg("deleting app as the task finished")
+	if err := h.deleteApp(ctx, ra, appID); err != nil {
+		return err
+	}
+	h.notify(ctx, ra, ra.lifecycleEvent(LifecycleAppDeleted, appID, "", ""))
+	return nil
+}
@@ -0,0 +1,112 @@
+package reviewapps
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// telemetryReport is the anonymous usage report sent to the telemetry endpoint. It only contains
+// aggregate counts since the service started, never names of repositories, apps or users.
+type telemetryReport struct {
+	// InstanceID identifies the running instance across reports. It's random and regenerated
+	// whenever the service restarts.
+	InstanceID string `json:"instance_id"`
+	Version    string `json:"version"`
+	// UptimeSeconds is how long the instance has been running.
+	UptimeSeconds int64 `json:"uptime_seconds"`
+	// Events is the amount of webhook events received per event type.
+	Events map[string]int64 `json:"events"`
+	// Deployments is the amount of finished deployments per terminal phase.
+	Deployments map[string]int64 `json:"deployments"`
+}
+
+// Telemetry periodically reports anonymous usage statistics to the configured endpoint, to help
+// maintainers prioritize. It's opt-in.
+type Telemetry struct {
+	config     TelemetryConfig
+	client     *http.Client
+	instanceID string
+	started    time.Time
+}
+
+// NewTelemetry returns a new Telemetry reporting as configured.
+func NewTelemetry(config TelemetryConfig) *Telemetry {
+	id := make([]byte, 16)
+	// A failure leaves the ID zeroed, which is just as anonymous.
+	_, _ = rand.Read(id)
+	return &Telemetry{
+		config:     config,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		instanceID: hex.EncodeToString(id),
+		started:    time.Now(),
+	}
+}
+
+// Run reports usage on the configured interval until the context is done.
+func (t *Telemetry) Run(ctx context.Context) {
+	logger := zerolog.Ctx(ctx).With().Str("component", "telemetry").Logger()
+
+	ticker := time.NewTicker(t.config.GetInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Telemetry is best effort and mustn't be noisy.
+		if err := t.report(ctx); err != nil {
+			logger.Debug().Err(err).Msg("failed to report telemetry")
+		}
+	}
+}
+
+// report sends a report of the current usage.
+func (t *Telemetry) report(ctx context.Context) error {
+	body, err := json.Marshal(telemetryReport{
+		InstanceID:    t.instanceID,
+		Version:       Version,
+		UptimeSeconds: int64(time.Since(t.started).Seconds()),
+		Events:        counts(eventsTotal),
+		Deployments:   counts(deploymentsTotal),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send report: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("failed to send report: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// counts returns the integer values of the given metric.
+func counts(m *expvar.Map) map[string]int64 {
+	values := make(map[string]int64)
+	m.Do(func(kv expvar.KeyValue) {
+		if v, ok := kv.Value.(*expvar.Int); ok {
+			values[kv.Key] = v.Value()
+		}
+	})
+	return values
+}
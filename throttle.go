@@ -0,0 +1,141 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// throttlePollInterval is how often throttled background work checks whether the error rate of the
+// DigitalOcean API recovered.
+const throttlePollInterval = 30 * time.Second
+
+// throttle tracks the error rate of requests to the DigitalOcean API and holds back background
+// work, like reconciler sweeps and scheduled redeploys, while it's high, so the remaining capacity
+// goes to the deploys of pull requests. A nil throttle never throttles.
+type throttle struct {
+	config ThrottlingConfig
+
+	mu sync.Mutex
+	// buckets count the requests within the window per second, oldest first.
+	buckets []throttleBucket
+	// throttled is whether or not background work is held back. It's only lifted once the error
+	// rate dropped to the recovery rate, so it doesn't flap around the threshold.
+	throttled bool
+}
+
+// throttleBucket counts the requests of a second.
+type throttleBucket struct {
+	second   int64
+	requests int
+	failures int
+}
+
+func newThrottle(config ThrottlingConfig) *throttle {
+	return &throttle{config: config}
+}
+
+// transport returns a transport recording the outcome of every request sent through the given one.
+func (t *throttle) transport(next http.RoundTripper) http.RoundTripper {
+	if t == nil {
+		return next
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(req)
+		if errors.Is(err, context.Canceled) {
+			// Canceled requests say nothing about the API.
+			return resp, err
+		}
+		t.record(time.Now(), err != nil || resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests)
+		return resp, err
+	})
+}
+
+// record records the outcome of a request at the given time.
+func (t *throttle) record(now time.Time, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.trim(now)
+
+	second := now.Unix()
+	if n := len(t.buckets); n == 0 || t.buckets[n-1].second != second {
+		t.buckets = append(t.buckets, throttleBucket{second: second})
+	}
+	b := &t.buckets[len(t.buckets)-1]
+	b.requests++
+	if failed {
+		b.failures++
+	}
+}
+
+// trim forgets the requests that left the window at the given time. It must be called with the
+// mutex held.
+func (t *throttle) trim(now time.Time) {
+	oldest := now.Add(-t.config.GetWindow()).Unix()
+	i := 0
+	for i < len(t.buckets) && t.buckets[i].second <= oldest {
+		i++
+	}
+	t.buckets = t.buckets[i:]
+}
+
+// check returns whether or not background work is held back at the given time, and the error
+// rate within the window. Too few requests within the window lift the throttling, as the error rate
+// isn't meaningful then.
+func (t *throttle) check(now time.Time) (bool, float64) {
+	if t == nil {
+		return false, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.trim(now)
+
+	var requests, failures int
+	for _, b := range t.buckets {
+		requests += b.requests
+		failures += b.failures
+	}
+	var rate float64
+	if requests > 0 {
+		rate = float64(failures) / float64(requests)
+	}
+
+	switch {
+	case requests < t.config.GetMinRequests():
+		t.throttled = false
+	case rate >= t.config.ErrorRate:
+		t.throttled = true
+	case rate <= t.config.GetRecoveryRate():
+		t.throttled = false
+	}
+	return t.throttled, rate
+}
+
+// wait waits until the given background job may run, i.e. until the error rate of the DigitalOcean
+// API recovered, and returns false if the context is done first.
+func (t *throttle) wait(ctx context.Context, job string) bool {
+	throttled, rate := t.check(time.Now())
+	if !throttled {
+		return true
+	}
+	zerolog.Ctx(ctx).Warn().Str("job", job).Float64("error_rate", rate).Msg("deferring background work until the DigitalOcean API error rate recovers")
+	throttledRunsTotal.Add(job, 1)
+
+	tick := time.NewTicker(throttlePollInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-tick.C:
+		}
+		if throttled, _ := t.check(time.Now()); !throttled {
+			zerolog.Ctx(ctx).Info().Str("job", job).Msg("resuming deferred background work")
+			return true
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package reviewapps
+
+import (
+	"hash/fnv"
+	"slices"
+	"strings"
+
+	"github.com/digitalocean/godo"
+)
+
+// tierLabelPrefix prefixes the labels selecting the tier of a pull request's review app, like
+// "preview:full".
+const tierLabelPrefix = "preview:"
+
+// isTierLabel returns whether or not the given label selects a tier.
+func (h *PRHandler) isTierLabel(label string) bool {
+	_, ok := h.config.Tiers[strings.TrimPrefix(label, tierLabelPrefix)]
+	return ok && strings.HasPrefix(label, tierLabelPrefix)
+}
+
+// tierOf returns the name of the tier of the review app, which is the one selected by a label of
+// its pull request or the configured one otherwise. It returns an empty string if there is none.
+func (h *PRHandler) tierOf(ra *reviewApp) string {
+	if ra.pr != nil && !ra.fork {
+		// Anyone with triage access can label pull requests of forks.
+		for _, l := range ra.pr.Labels {
+			if h.isTierLabel(l.GetName()) {
+				return strings.TrimPrefix(l.GetName(), tierLabelPrefix)
+			}
+		}
+	}
+	return ra.cfg.Tier
+}
+
+// tierAttempt returns the attempt to apply the given tier with, so retried deliveries of the same
+// label change are idempotent while every distinct tier is applied.
+func tierAttempt(tier string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("tier:" + tier))
+	// Attempt 0 is used by pushes, so keep clear of it.
+	return int64(h.Sum64()>>1) | 1
+}
+
+// applyTier sizes the given spec and prunes its components according to the tier of the review
+// app. Its database policy is applied by applyDatabases.
+func (h *PRHandler) applyTier(spec *godo.AppSpec, ra *reviewApp) {
+	name := h.tierOf(ra)
+	if name == "" {
+		return
+	}
+	tier := h.config.Tiers[name]
+	ra.logger.Info().Str("tier", name).Msg("applying tier to app spec")
+
+	if len(tier.Components) > 0 {
+		pruneComponents(spec, tier.Components)
+	}
+	if tier.InstanceSizeSlug != "" {
+		downsizeSpec(spec, tier.InstanceSizeSlug)
+	}
+}
+
+// pruneComponents removes all components but the given ones from the given spec, alongside the
+// ingress rules routing to them.
+func pruneComponents(spec *godo.AppSpec, keep []string) {
+	kept := func(name string) bool { return slices.Contains(keep, name) }
+	spec.Services = slices.DeleteFunc(spec.Services, func(c *godo.AppServiceSpec) bool { return !kept(c.Name) })
+	spec.StaticSites = slices.DeleteFunc(spec.StaticSites, func(c *godo.AppStaticSiteSpec) bool { return !kept(c.Name) })
+	spec.Workers = slices.DeleteFunc(spec.Workers, func(c *godo.AppWorkerSpec) bool { return !kept(c.Name) })
+	spec.Jobs = slices.DeleteFunc(spec.Jobs, func(c *godo.AppJobSpec) bool { return !kept(c.Name) })
+	spec.Functions = slices.DeleteFunc(spec.Functions, func(c *godo.AppFunctionsSpec) bool { return !kept(c.Name) })
+	if spec.Ingress != nil {
+		spec.Ingress.Rules = slices.DeleteFunc(spec.Ingress.Rules, func(r *godo.AppIngressSpecRule) bool {
+			return r.Component != nil && !kept(r.Component.Name)
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package reviewapps
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errSuperseded is the cause of canceled waits for deployments that a newer event superseded.
+var errSuperseded = errors.New("superseded by a newer event")
+
+// turns serializes acting upon pull requests, so events and commands of the same pull request
+// don't race each other over its review app and the App Platform operations of its app never
+// overlap. Pushes coalesce: a push waiting for its turn is dropped once a newer push arrives, and
+// the wait for the deployment in flight is canceled, as the newer push supersedes it. Teardowns
+// supersede the deployment in flight as well.
+type turns struct {
+	mu    sync.Mutex
+	slots map[turnKey]*turnSlot
+}
+
+// turnKind is how a turn relates to the turns before it.
+type turnKind int
+
+const (
+	// turnQueued waits for the turns before it.
+	turnQueued turnKind = iota
+	// turnSuperseding supersedes the waits for deployments of the current turn, like teardowns.
+	turnSuperseding
+	// turnPush supersedes like turnSuperseding and drops the pushes waiting for their turn.
+	turnPush
+)
+
+// turnKey identifies a pull request.
+type turnKey struct {
+	repo   string
+	number int
+}
+
+// turnSlot holds the turns of a single pull request.
+type turnSlot struct {
+	// turn is held by whoever's turn it is.
+	turn chan struct{}
+	// waiting is the amount of turns held or waited for, so slots are dropped once unused.
+	waiting int
+	// push is the latest push that arrived.
+	push int
+	// supersede cancels the waits for deployments of the current turn.
+	supersede context.CancelCauseFunc
+}
+
+// turnWaitsKey is the context key of the context whose cancellation supersedes the waits for
+// deployments of a turn.
+type turnWaitsKey struct{}
+
+func newTurns() *turns {
+	return &turns{slots: make(map[turnKey]*turnSlot)}
+}
+
+// take waits for the turn to act upon the given pull request. It returns a context of the turn and
+// a function ending it, or false if the turn of a push was superseded by a newer push while waiting
+// for it.
+func (t *turns) take(ctx context.Context, repo string, number int, kind turnKind) (context.Context, func(), bool, error) {
+	key := turnKey{repo: repo, number: number}
+	t.mu.Lock()
+	s, ok := t.slots[key]
+	if !ok {
+		s = &turnSlot{turn: make(chan struct{}, 1)}
+		t.slots[key] = s
+	}
+	s.waiting++
+	var push int
+	if kind == turnPush {
+		s.push++
+		push = s.push
+	}
+	if kind != turnQueued && s.supersede != nil {
+		s.supersede(errSuperseded)
+	}
+	t.mu.Unlock()
+
+	select {
+	case s.turn <- struct{}{}:
+	case <-ctx.Done():
+		t.leave(key, s)
+		return nil, nil, false, ctx.Err()
+	}
+
+	t.mu.Lock()
+	if kind == turnPush && push != s.push {
+		t.mu.Unlock()
+		<-s.turn
+		t.leave(key, s)
+		return nil, nil, false, nil
+	}
+	waits, supersede := context.WithCancelCause(context.Background())
+	s.supersede = supersede
+	t.mu.Unlock()
+
+	done := func() {
+		t.mu.Lock()
+		s.supersede = nil
+		t.mu.Unlock()
+		supersede(nil)
+		<-s.turn
+		t.leave(key, s)
+	}
+	return context.WithValue(ctx, turnWaitsKey{}, waits), done, true, nil
+}
+
+// leave drops the given slot once no turn is held or waited for anymore.
+func (t *turns) leave(key turnKey, s *turnSlot) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s.waiting--
+	if s.waiting == 0 {
+		delete(t.slots, key)
+	}
+}
+
+// supersedable returns a context of the given turn's context that is canceled with errSuperseded
+// once a newer event supersedes the turn's deployment.
+func supersedable(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	waits, ok := ctx.Value(turnWaitsKey{}).(context.Context)
+	if !ok {
+		return ctx, func() { cancel(nil) }
+	}
+	stop := context.AfterFunc(waits, func() { cancel(context.Cause(waits)) })
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// superseded stops waiting for the given deployment, which a newer event superseded. Its GitHub
+// deployment is left as is, as it still records the review app's app until the newer deployment
+// marks it inactive.
+func (h *PRHandler) superseded(ctx context.Context, ra *reviewApp, checkRunID int64) {
+	ra.logger.Info().Msg("no longer waiting for deployment superseded by a newer event")
+	h.supersedeCheckRun(ctx, ra, checkRunID)
+}
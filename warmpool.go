@@ -0,0 +1,149 @@
+package reviewapps
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/digitalocean/godo"
+	"github.com/rs/zerolog"
+)
+
+// WarmPool maintains a pool of pre-created minimal apps. Updating an existing app to a pull
+// request's spec is a lot faster than creating a new app from scratch, at the cost of the pool's
+// apps idling around.
+type WarmPool struct {
+	do     *godo.Client
+	config WarmPoolConfig
+
+	mu       sync.Mutex
+	appIDs   []string
+	refillCh chan struct{}
+}
+
+// NewWarmPool returns a new WarmPool. It has to be run to be filled.
+func NewWarmPool(do *godo.Client, config WarmPoolConfig) *WarmPool {
+	return &WarmPool{
+		do:       do,
+		config:   config,
+		refillCh: make(chan struct{}, 1),
+	}
+}
+
+// Run fills the pool and refills it after apps have been taken until the context is done.
+func (p *WarmPool) Run(ctx context.Context) {
+	logger := zerolog.Ctx(ctx).With().Str("component", "warm_pool").Logger()
+
+	if err := p.adopt(ctx); err != nil {
+		logger.Error().Err(err).Msg("failed to adopt existing pool apps")
+	}
+
+	p.refill()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.refillCh:
+		}
+
+		if err := p.fill(ctx); err != nil {
+			logger.Error().Err(err).Msg("failed to fill warm pool")
+		}
+	}
+}
+
+// Take takes an app out of the pool, if the pool has an app compatible with the given spec. A nil
+// pool never has any apps.
+func (p *WarmPool) Take(spec *godo.AppSpec) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	if spec.Region != "" && spec.Region != p.config.Region {
+		return "", false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.appIDs) == 0 {
+		return "", false
+	}
+	appID := p.appIDs[0]
+	p.appIDs = p.appIDs[1:]
+	p.refill()
+	return appID, true
+}
+
+// refill triggers a refill of the pool.
+func (p *WarmPool) refill() {
+	select {
+	case p.refillCh <- struct{}{}:
+	default:
+		// A refill is already pending.
+	}
+}
+
+// adopt adds apps that were created by a previous run to the pool.
+func (p *WarmPool) adopt(ctx context.Context) error {
+	apps, err := listApps(ctx, p.do)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, app := range apps {
+		if strings.HasPrefix(app.GetSpec().GetName(), p.config.GetNamePrefix()+"-") {
+			p.appIDs = append(p.appIDs, app.GetID())
+		}
+	}
+	return nil
+}
+
+// fill creates apps until the pool has its configured size.
+func (p *WarmPool) fill(ctx context.Context) error {
+	for {
+		p.mu.Lock()
+		missing := p.config.Size - len(p.appIDs)
+		p.mu.Unlock()
+		if missing <= 0 {
+			return nil
+		}
+
+		app, _, err := p.do.Apps.Create(ctx, &godo.AppCreateRequest{Spec: p.poolAppSpec()})
+		if err != nil {
+			return doError(err, "failed to create pool app")
+		}
+
+		p.mu.Lock()
+		p.appIDs = append(p.appIDs, app.GetID())
+		p.mu.Unlock()
+	}
+}
+
+// poolAppSpec returns the spec of a minimal app that's kept in the pool.
+func (p *WarmPool) poolAppSpec() *godo.AppSpec {
+	suffix := make([]byte, 4)
+	// Read never returns an error.
+	rand.Read(suffix)
+
+	return &godo.AppSpec{
+		Name:   fmt.Sprintf("%s-%s", p.config.GetNamePrefix(), hex.EncodeToString(suffix)),
+		Region: p.config.Region,
+		Services: []*godo.AppServiceSpec{{
+			Name: "placeholder",
+			Image: &godo.ImageSourceSpec{
+				RegistryType: godo.ImageSourceSpecRegistryType_DockerHub,
+				Registry:     "library",
+				Repository:   "nginx",
+				Tag:          "alpine",
+			},
+			HTTPPort:         80,
+			InstanceSizeSlug: "apps-s-1vcpu-0.5gb",
+			InstanceCount:    1,
+		}},
+	}
+}
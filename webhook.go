@@ -0,0 +1,137 @@
+package reviewapps
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/palantir/go-githubapp/githubapp"
+)
+
+const (
+	webhookStatusAccepted = "accepted"
+	webhookStatusSkipped  = "skipped"
+)
+
+// triager is implemented by event handlers that can tell whether or not they act upon an event
+// without calling any APIs. It returns why the event is skipped, or an empty string if it's acted
+// upon.
+type triager interface {
+	triage(eventType string, payload []byte) (string, error)
+}
+
+// webhookResult is the body of responses to webhook deliveries. It shows up in GitHub's delivery
+// UI, which makes it obvious why an event was ignored.
+type webhookResult struct {
+	TrackingID string   `json:"tracking_id"`
+	Status     string   `json:"status"`
+	Reasons    []string `json:"reasons,omitempty"`
+}
+
+// webhookResponder wraps the given dispatcher of webhook events to respond with the triage result of
+// the given handlers. Events are handled asynchronously, so the response can only reflect decisions
+// that don't need any API calls.
+func webhookResponder(handlers []githubapp.EventHandler, dispatcher http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(payload))
+
+		// The dispatcher validates the signature and schedules the event, so its errors take
+		// precedence. Its status is kept, as it distinguishes handled (200) and unhandled (202)
+		// event types.
+		rec := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+		dispatcher.ServeHTTP(rec, r)
+		if rec.status >= http.StatusMultipleChoices {
+			rec.flush(w)
+			return
+		}
+
+		eventsTotal.Add(r.Header.Get("X-GitHub-Event"), 1)
+		result := triageWebhook(handlers, r.Header.Get("X-GitHub-Event"), payload)
+		result.TrackingID = r.Header.Get("X-GitHub-Delivery")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(rec.status)
+		_ = json.NewEncoder(w).Encode(result)
+	})
+}
+
+// triageWebhook triages the given event with all handlers handling its type. The event is accepted if
+// any of them acts upon it. Handlers that can't triage events are assumed to act upon all of them.
+func triageWebhook(handlers []githubapp.EventHandler, eventType string, payload []byte) webhookResult {
+	var (
+		handled bool
+		reasons []string
+	)
+	for _, h := range handlers {
+		if !handles(h, eventType) {
+			continue
+		}
+		handled = true
+
+		t, ok := h.(triager)
+		if !ok {
+			return webhookResult{Status: webhookStatusAccepted}
+		}
+		skip, err := t.triage(eventType, payload)
+		var notActionable *notActionableError
+		switch {
+		case errors.As(err, &notActionable):
+			reasons = append(reasons, notActionable.reasons...)
+		case err != nil:
+			reasons = append(reasons, err.Error())
+		case skip != "":
+			reasons = append(reasons, skip)
+		default:
+			return webhookResult{Status: webhookStatusAccepted}
+		}
+	}
+	if !handled {
+		reasons = append(reasons, fmt.Sprintf("event type %q is not handled", eventType))
+	}
+	return webhookResult{Status: webhookStatusSkipped, Reasons: reasons}
+}
+
+// handles returns whether or not the given handler handles events of the given type.
+func handles(h githubapp.EventHandler, eventType string) bool {
+	for _, t := range h.Handles() {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedResponse buffers a response so it can be replaced before being written.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *bufferedResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *bufferedResponse) WriteHeader(status int) {
+	r.status = status
+}
+
+func (r *bufferedResponse) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
+
+// flush writes the buffered response to the given writer.
+func (r *bufferedResponse) flush(w http.ResponseWriter) {
+	for k, v := range r.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(r.status)
+	_, _ = w.Write(r.body.Bytes())
+}